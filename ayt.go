@@ -0,0 +1,76 @@
+package telnet
+
+import (
+	"fmt"
+	"time"
+)
+
+// AYTResponder decides how the terminal answers IAC AYT (Are You There). It returns the data to
+// send to the remote, which is sent ahead of waiting text as with TelnetKeyboard.WriteUrgent, or
+// nil to send nothing. See TerminalConfig.AYTResponse.
+type AYTResponder func(t *Terminal) TerminalData
+
+// AYTRespondNOP answers IAC AYT with IAC NOP, which proves the connection is alive without
+// printing anything on the remote's screen. This is the default.
+func AYTRespondNOP(t *Terminal) TerminalData {
+	return CommandData{Command{OpCode: NOP}}
+}
+
+// AYTRespondText returns an AYTResponder that answers IAC AYT with the provided text, such as
+// "[yes]\r\n" in the manner of BSD telnetd, so that a human at the remote can see the answer
+func AYTRespondText(text string) AYTResponder {
+	return func(t *Terminal) TerminalData {
+		return TextData(text)
+	}
+}
+
+// AYTReceivedEvent is delivered to AYTReceived hooks when the remote sends IAC AYT, after the
+// terminal has answered it. Servers can use it to log liveness probes.
+type AYTReceivedEvent struct {
+	Time time.Time
+	// Response is the data that was sent in answer, or nil if nothing was sent
+	Response TerminalData
+}
+
+func (e AYTReceivedEvent) String() string {
+	switch response := e.Response.(type) {
+	case nil:
+		return "AYT received, no response sent"
+	case CommandData:
+		return fmt.Sprintf("AYT received, answered with %s", commandCodes[response.OpCode])
+	default:
+		return fmt.Sprintf("AYT received, answered with %q", response.String())
+	}
+}
+
+// answerAYT sends the configured response to IAC AYT and raises AYTReceivedEvent
+func (t *Terminal) answerAYT() error {
+	response, err := t.callAYTResponder()
+	if err != nil {
+		return err
+	}
+
+	if response != nil {
+		err = t.keyboard.WriteUrgent(response)
+	}
+
+	t.aytReceivedHooks.Fire(t, AYTReceivedEvent{
+		Time:     time.Now(),
+		Response: response,
+	})
+
+	return err
+}
+
+func (t *Terminal) callAYTResponder() (response TerminalData, err error) {
+	if t.aytResponse == nil {
+		return AYTRespondNOP(t), nil
+	}
+
+	err = t.callRecovering(func() error {
+		response = t.aytResponse(t)
+		return nil
+	})
+
+	return response, err
+}
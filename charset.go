@@ -1,13 +1,14 @@
 package telnet
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"strings"
 	"sync/atomic"
 	"unicode/utf8"
 
 	"golang.org/x/text/encoding"
-	"golang.org/x/text/encoding/ianaindex"
 	"golang.org/x/text/transform"
 
 	"github.com/moodclient/telnet/charset"
@@ -15,6 +16,8 @@ import (
 
 type currentCharset struct {
 	name string
+	// utf8 indicates that the decoder passes UTF-8 through, so InvalidUTF8Policy applies to it
+	utf8 bool
 
 	encoder *encoding.Encoder
 	decoder transform.Transformer
@@ -53,6 +56,24 @@ type Charset struct {
 	negotiatedEncoding atomic.Pointer[currentCharset]
 	negotiatedDecoding atomic.Pointer[currentCharset]
 	fallback           atomic.Pointer[currentCharset]
+
+	cp437Controls atomic.Int32
+
+	fallbackMode     atomic.Int32
+	decodingFallback atomic.Bool
+
+	bomPolicy         atomic.Int32
+	invalidUTF8Policy atomic.Int32
+	// midLine indicates that the most recent text decoded did not end with a line feed
+	midLine atomic.Bool
+	// decodedText indicates that some text has been decoded, so the stream has begun
+	decodedText atomic.Bool
+
+	utf16Detection atomic.Bool
+	// utf16 is the charset used for decoding once the remote has been detected sending UTF-16
+	utf16 atomic.Pointer[currentCharset]
+	// utf16Detected, if set, is called when the remote is detected sending UTF-16
+	utf16Detected func(event UTF16DetectedEvent)
 }
 
 // NewCharset creates a new charset with a default charset, an optional fallback charset,
@@ -120,7 +141,11 @@ func (c *Charset) loadEncodingCharset() *currentCharset {
 }
 
 func (c *Charset) loadDecodingCharset() *currentCharset {
-	var charset *currentCharset
+	charset := c.utf16.Load()
+	if charset != nil {
+		return charset
+	}
+
 	if c.usage == CharsetUsageAlways || c.binaryDecode.Load() {
 		charset = c.negotiatedDecoding.Load()
 	}
@@ -154,7 +179,15 @@ func (c *Charset) DecodingName() string {
 // Encode accepts a string of UTF-8 text and returns a byte slice that is encoded
 // in the keyboard's current encoding
 func (c *Charset) Encode(utf8Text string) ([]byte, error) {
-	return c.loadEncodingCharset().encoder.Bytes([]byte(utf8Text))
+	encoded, err := c.loadEncodingCharset().encoder.Bytes([]byte(utf8Text))
+	return encoded, categorize(ErrCharset, err)
+}
+
+// encodeWith accepts a string of UTF-8 text and returns a byte slice that is encoded in a
+// charset built with buildCharset, rather than the keyboard's current encoding
+func (c *Charset) encodeWith(charset *currentCharset, utf8Text string) ([]byte, error) {
+	encoded, err := charset.encoder.Bytes([]byte(utf8Text))
+	return encoded, categorize(ErrCharset, err)
 }
 
 func validEncoding(charset *currentCharset, incomingText []byte) EncodingState {
@@ -193,6 +226,65 @@ const (
 	EncodingValid
 )
 
+// FallbackMode decides when the printer decodes with the fallback charset
+type FallbackMode int
+
+const (
+	// FallbackAutomatic switches to the fallback charset when the text can't be decoded with the
+	// current charset, as described on TerminalConfig.FallbackCharsetName
+	FallbackAutomatic FallbackMode = iota
+	// FallbackForced always decodes with the fallback charset
+	FallbackForced
+	// FallbackDisabled never decodes with the fallback charset
+	FallbackDisabled
+)
+
+func (m FallbackMode) String() string {
+	switch m {
+	case FallbackAutomatic:
+		return "Automatic"
+	case FallbackForced:
+		return "Forced"
+	case FallbackDisabled:
+		return "Disabled"
+	default:
+		return fmt.Sprintf("FallbackMode(%d)", int(m))
+	}
+}
+
+// FallbackMode returns when the printer decodes with the fallback charset
+func (c *Charset) FallbackMode() FallbackMode {
+	return FallbackMode(c.fallbackMode.Load())
+}
+
+// SetFallbackMode changes when the printer decodes with the fallback charset. This can be used
+// to let the user decide that a server is using the fallback charset, rather than relying on
+// detection. An error is returned if the mode is FallbackForced and there is no fallback charset.
+func (c *Charset) SetFallbackMode(mode FallbackMode) error {
+	if mode == FallbackForced && c.fallback.Load() == nil {
+		return categorize(ErrCharset, errors.New("cannot force fallback decoding without a fallback charset"))
+	}
+
+	c.fallbackMode.Store(int32(mode))
+	return nil
+}
+
+// FallbackCharsetName returns the name of the fallback charset, or an empty string if there isn't one
+func (c *Charset) FallbackCharsetName() string {
+	fallback := c.fallback.Load()
+	if fallback == nil {
+		return ""
+	}
+
+	return fallback.name
+}
+
+// DecodingFallback returns true if the most recent text received by the printer was decoded
+// with the fallback charset
+func (c *Charset) DecodingFallback() bool {
+	return c.decodingFallback.Load()
+}
+
 // Decode accepts a byte slice that is encoded in the printer's current encoding as well as a
 // destination buffer for decoded bytes.  Additionally, it accepts a bool indicating whether
 // the decode process should skip the default/negotiated charset and immediately use the fallback
@@ -200,16 +292,53 @@ const (
 //
 // The method returns how many bytes were consumed from the incoming text, how many bytes were
 // written to the buffer, whether the charset had to move to fallback mode due to decoding failure,
-// and potentially an error.
+// and potentially an error. FallbackForced and FallbackDisabled override the detection. Byte order
+// marks and malformed UTF-8 are handled according to BOMPolicy and InvalidUTF8Policy. If UTF-16
+// detection is on and the remote has been detected sending UTF-16, it is decoded as UTF-16 instead
+// of with any of the other charsets.
 func (c *Charset) Decode(buffer []byte, incomingText []byte, fallback EncodingState) (consumed int, buffered int, fellback EncodingState, err error) {
 	if len(incomingText) == 0 {
 		return 0, 0, fallback, nil
 	}
 
+	if c.UTF16Detection() {
+		consumed, handled, err := c.detectUTF16(incomingText)
+		if handled {
+			return consumed, 0, fallback, err
+		}
+	}
+
+	decodingUTF16 := c.utf16.Load() != nil
+	if c.BOMPolicy() != BOMDecode && !decodingUTF16 {
+		consumed, buffered, handled, err := c.decodeBOM(buffer, incomingText)
+		if handled {
+			return consumed, buffered, fallback, err
+		}
+
+		// Stop at the end of the line, so that the next call can look for a byte order mark
+		lineEnd := bytes.IndexByte(incomingText, '\n')
+		if lineEnd >= 0 {
+			incomingText = incomingText[:lineEnd+1]
+		}
+	}
+
 	charset := c.loadDecodingCharset()
 	fallbackCharset := c.fallback.Load()
 	charsetToUse := charset
 
+	switch c.FallbackMode() {
+	case FallbackForced:
+		if fallbackCharset != nil {
+			fallback = EncodingInvalid
+		}
+	case FallbackDisabled:
+		fallbackCharset = nil
+	}
+
+	if decodingUTF16 {
+		fallbackCharset = nil
+	}
+
 	if fallbackCharset != nil && fallback == EncodingUnsure {
 		fallback = validEncoding(charset, incomingText)
 
@@ -224,13 +353,29 @@ func (c *Charset) Decode(buffer []byte, incomingText []byte, fallback EncodingSt
 	if fallbackCharset != nil && fallback == EncodingInvalid {
 		charsetToUse = fallbackCharset
 	}
+	c.decodingFallback.Store(charsetToUse == fallbackCharset)
+
+	policy := c.InvalidUTF8Policy()
+	if charsetToUse.utf8 && policy != InvalidUTF8Replace {
+		buffered, consumed, err = sanitizeUTF8(buffer, incomingText, policy)
+	} else {
+		buffered, consumed, err = charsetToUse.decoder.Transform(buffer, incomingText, false)
+	}
 
-	buffered, consumed, err = charsetToUse.decoder.Transform(buffer, incomingText, false)
+	if consumed > 0 {
+		c.midLine.Store(incomingText[consumed-1] != '\n')
+		c.decodedText.Store(true)
+	}
 
 	return consumed, buffered, fallback, err
 }
 
 func (c *Charset) buildCharset(codePage string) (*currentCharset, error) {
+	charset, err := c.loadCharset(codePage)
+	return charset, categorize(ErrCharset, err)
+}
+
+func (c *Charset) loadCharset(codePage string) (*currentCharset, error) {
 	if strings.ToLower(codePage) == "utf-8" {
 		// A utf-8 character set will replace bad runes with the replacement character
 		// but otherwise not touch the text
@@ -240,11 +385,12 @@ func (c *Charset) buildCharset(codePage string) (*currentCharset, error) {
 			// see the difference between the decoder & encoder behaviors
 			decoder: encoding.Replacement.NewEncoder(),
 			name:    "UTF-8",
+			utf8:    true,
 		}, nil
 	}
 
 	if strings.ToLower(codePage) == "cp437-full" {
-		encoding := charset.CP437Full{}
+		encoding := charset.CP437Full{Controls: c.CP437ControlMode()}
 		return &currentCharset{
 			encoder: encoding.NewEncoder(),
 			decoder: encoding.NewDecoder(),
@@ -252,25 +398,20 @@ func (c *Charset) buildCharset(codePage string) (*currentCharset, error) {
 		}, nil
 	}
 
-	charset, err := ianaindex.IANA.Encoding(codePage)
-	if err != nil {
-		return nil, err
-	}
-	if charset == nil {
-		return nil, errors.New("ianaindex: unsupported encoding")
-	}
-	name, err := ianaindex.IANA.Name(charset)
+	charset, name, err := lookupEncoding(codePage)
 	if err != nil {
 		return nil, err
 	}
 
 	encoder := charset.NewEncoder()
 	var decoder transform.Transformer
+	isUTF8 := false
 
 	if strings.ToLower(codePage) == "us-ascii" {
 		// Allow the remote to send us UTF-8 even if we think we're ascii. We'll be good citizens
 		// and only send ASCII.
 		decoder = encoding.Replacement.NewEncoder()
+		isUTF8 = true
 	} else {
 		decoder = charset.NewDecoder()
 	}
@@ -279,6 +420,7 @@ func (c *Charset) buildCharset(codePage string) (*currentCharset, error) {
 		encoder: encoder,
 		decoder: decoder,
 		name:    name,
+		utf8:    isUTF8,
 	}, nil
 }
 
@@ -306,6 +448,37 @@ func (c *Charset) PromoteDefaultCharset(oldCodePage string, newCodePage string)
 	return c.defaultCharset.CompareAndSwap(defaultCharset, charset), nil
 }
 
+// CP437ControlMode returns how the CP437-FULL charset decodes bytes in the control range
+func (c *Charset) CP437ControlMode() charset.CP437ControlMode {
+	return charset.CP437ControlMode(c.cp437Controls.Load())
+}
+
+// SetCP437ControlMode changes how the CP437-FULL charset decodes bytes in the control range. Use
+// CP437ControlGlyphs while the remote is sending art, and CP437ControlCodes while it is sending
+// text that uses control codes such as tab. Any default, negotiated, or fallback charset that is
+// currently CP437-FULL is updated immediately.
+func (c *Charset) SetCP437ControlMode(mode charset.CP437ControlMode) error {
+	c.cp437Controls.Store(int32(mode))
+
+	for _, current := range []*atomic.Pointer[currentCharset]{
+		&c.defaultCharset, &c.negotiatedEncoding, &c.negotiatedDecoding, &c.fallback,
+	} {
+		oldCharset := current.Load()
+		if oldCharset == nil || oldCharset.name != "CP437-FULL" {
+			continue
+		}
+
+		newCharset, err := c.buildCharset(oldCharset.name)
+		if err != nil {
+			return err
+		}
+
+		current.CompareAndSwap(oldCharset, newCharset)
+	}
+
+	return nil
+}
+
 // SetNegotiatedEncodingCharset modifies the negotiated keyboard charset to the requested
 // character set
 func (c *Charset) SetNegotiatedEncodingCharset(codePage string) error {
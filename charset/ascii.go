@@ -0,0 +1,80 @@
+package charset
+
+import (
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/transform"
+)
+
+// USASCII is the US-ASCII encoding. Encoding fails for runes outside of ASCII, and decoding
+// replaces bytes outside of ASCII with the unicode replacement character. It is used in place of
+// the golang.org/x/text encodings in telnet_minimal builds.
+type USASCII struct{}
+
+var _ encoding.Encoding = USASCII{}
+
+func (a USASCII) NewDecoder() *encoding.Decoder {
+	return &encoding.Decoder{Transformer: USASCIIDecodeTransformer{}}
+}
+
+func (a USASCII) NewEncoder() *encoding.Encoder {
+	return &encoding.Encoder{Transformer: USASCIIEncodeTransformer{}}
+}
+
+type USASCIIDecodeTransformer struct {
+	transform.NopResetter
+}
+
+func (t USASCIIDecodeTransformer) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	for i, c := range src {
+		if c < utf8.RuneSelf {
+			if nDst >= len(dst) {
+				err = transform.ErrShortDst
+				break
+			}
+
+			dst[nDst] = c
+			nDst++
+		} else {
+			if nDst+utf8.RuneLen(utf8.RuneError) > len(dst) {
+				err = transform.ErrShortDst
+				break
+			}
+
+			nDst += utf8.EncodeRune(dst[nDst:], utf8.RuneError)
+		}
+		nSrc = i + 1
+	}
+
+	return nDst, nSrc, err
+}
+
+type USASCIIEncodeTransformer struct {
+	transform.NopResetter
+}
+
+func (t USASCIIEncodeTransformer) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	for nSrc < len(src) {
+		if nDst >= len(dst) {
+			err = transform.ErrShortDst
+			break
+		}
+
+		c := src[nSrc]
+		if c >= utf8.RuneSelf {
+			if !atEOF && !utf8.FullRune(src[nSrc:]) {
+				err = transform.ErrShortSrc
+			} else {
+				err = RepertoireError(0x1a)
+			}
+			break
+		}
+
+		dst[nDst] = c
+		nDst++
+		nSrc++
+	}
+
+	return nDst, nSrc, err
+}
@@ -186,12 +186,82 @@ var cp437Encode = [256]uint32{
 	0xdc002584, 0xdb002588, 0xdd00258c, 0xde002590, 0xb0002591, 0xb1002592, 0xb2002593, 0xfe0025a0,
 }
 
-type CP437Full struct{}
+// CP437ControlMode decides how CP437Full decodes bytes in the control range (0x00-0x1F and 0x7F).
+// CP437 assigns glyphs such as smiley faces and card suits to these bytes, which ANSI art uses,
+// but text from the same servers uses them as control codes such as tab and form feed.
+type CP437ControlMode int
+
+const (
+	// CP437ControlGlyphs is art mode: the control range decodes as glyphs, except for NUL, BEL,
+	// BS, LF, CR, and ESC, which are needed to lay out text and introduce escape sequences
+	CP437ControlGlyphs CP437ControlMode = iota
+	// CP437ControlCodes decodes the whole control range as control codes
+	CP437ControlCodes
+)
+
+func (m CP437ControlMode) String() string {
+	if m == CP437ControlCodes {
+		return "Codes"
+	}
+
+	return "Glyphs"
+}
+
+// cp437ControlGlyphs holds the glyph for each byte in the control range, including those that
+// CP437ControlGlyphs still decodes as control codes
+var cp437ControlGlyphs = [32]rune{
+	' ', '☺', '☻', '♥', '♦', '♣', '♠', '•', '◘', '○', '◙', '♂', '♀', '♪', '♫', '☼',
+	'►', '◄', '↕', '‼', '¶', '§', '▬', '↨', '↑', '↓', '→', '←', '∟', '↔', '▲', '▼',
+}
+
+const cp437DeleteGlyph = '⌂'
+
+// CP437Glyph returns the glyph that CP437 assigns to a byte, including the bytes in the
+// control range. This can be used to render art that was decoded in CP437ControlCodes mode.
+func CP437Glyph(b byte) rune {
+	if b < 0x20 {
+		return cp437ControlGlyphs[b]
+	}
+	if b == 0x7f {
+		return cp437DeleteGlyph
+	}
+
+	decode := cp437Decode[b]
+	r, _ := utf8.DecodeRune(decode.data[:decode.len])
+	return r
+}
+
+// CP437ControlByte returns the control range byte whose glyph is the provided rune. ok is false
+// if the rune is not one of the control range glyphs.
+func CP437ControlByte(glyph rune) (b byte, ok bool) {
+	if glyph == cp437DeleteGlyph {
+		return 0x7f, true
+	}
+
+	for index, controlGlyph := range cp437ControlGlyphs {
+		if index > 0 && controlGlyph == glyph {
+			return byte(index), true
+		}
+	}
+
+	return 0, false
+}
+
+// IsCP437ControlByte returns true if the byte is in the control range, where the glyph and the
+// control code are ambiguous
+func IsCP437ControlByte(b byte) bool {
+	return b < 0x20 || b == 0x7f
+}
+
+type CP437Full struct {
+	// Controls decides how the control range is decoded. The zero value is CP437ControlGlyphs.
+	Controls CP437ControlMode
+}
 
 var _ encoding.Encoding = CP437Full{}
 
 func (c CP437Full) NewDecoder() *encoding.Decoder {
-	return &encoding.Decoder{Transformer: CP437FullDecodeTransformer{}}
+	return &encoding.Decoder{Transformer: CP437FullDecodeTransformer{Controls: c.Controls}}
 }
 
 func (c CP437Full) NewEncoder() *encoding.Encoder {
@@ -200,11 +270,15 @@ func (c CP437Full) NewEncoder() *encoding.Encoder {
 
 type CP437FullDecodeTransformer struct {
 	transform.NopResetter
+	Controls CP437ControlMode
 }
 
 func (t CP437FullDecodeTransformer) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
 	for i, c := range src {
 		decode := cp437Decode[c]
+		if t.Controls == CP437ControlCodes && IsCP437ControlByte(c) {
+			decode.len, decode.data = 1, [3]byte{c}
+		}
 		n := int(decode.len)
 		if nDst+n > len(dst) {
 			err = transform.ErrShortDst
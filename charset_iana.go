@@ -0,0 +1,28 @@
+//go:build !telnet_minimal
+
+package telnet
+
+import (
+	"errors"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/ianaindex"
+)
+
+// lookupEncoding finds the encoding for an IANA charset name, and returns it along with its
+// canonical name
+func lookupEncoding(codePage string) (encoding.Encoding, string, error) {
+	charset, err := ianaindex.IANA.Encoding(codePage)
+	if err != nil {
+		return nil, "", err
+	}
+	if charset == nil {
+		return nil, "", errors.New("ianaindex: unsupported encoding")
+	}
+	name, err := ianaindex.IANA.Name(charset)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return charset, name, nil
+}
@@ -0,0 +1,25 @@
+//go:build telnet_minimal
+
+package telnet
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/encoding"
+
+	"github.com/moodclient/telnet/charset"
+)
+
+// lookupEncoding finds the encoding for an IANA charset name, and returns it along with its
+// canonical name. telnet_minimal builds leave out golang.org/x/text's table of encodings, so
+// only US-ASCII is available here, alongside the UTF-8 and CP437-FULL charsets that every build
+// supports.
+func lookupEncoding(codePage string) (encoding.Encoding, string, error) {
+	switch strings.ToUpper(codePage) {
+	case "US-ASCII", "ASCII", "ANSI_X3.4-1968", "US", "CSASCII":
+		return charset.USASCII{}, "US-ASCII", nil
+	default:
+		return nil, "", fmt.Errorf("unsupported encoding %s: telnet_minimal builds only support UTF-8, US-ASCII, and CP437-FULL", codePage)
+	}
+}
@@ -0,0 +1,232 @@
+package telnet
+
+import (
+	"bytes"
+	"fmt"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"golang.org/x/text/transform"
+)
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// BOMPolicy decides what the printer does with a UTF-8 byte order mark that arrives at the start
+// of the stream or the start of a line. Some servers written for Windows send one before their
+// text, which decodes to garbage in any charset other than UTF-8.
+type BOMPolicy int
+
+const (
+	// BOMDecode decodes byte order marks with the current charset like any other text
+	BOMDecode BOMPolicy = iota
+	// BOMStrip removes byte order marks from the text
+	BOMStrip
+	// BOMSurface delivers byte order marks as the rune U+FEFF, whatever the current charset is,
+	// so that consumers can tell that the remote sent one
+	BOMSurface
+)
+
+func (p BOMPolicy) String() string {
+	switch p {
+	case BOMDecode:
+		return "Decode"
+	case BOMStrip:
+		return "Strip"
+	case BOMSurface:
+		return "Surface"
+	default:
+		return fmt.Sprintf("BOMPolicy(%d)", int(p))
+	}
+}
+
+// InvalidUTF8Policy decides what the printer does with overlong encodings, encoded surrogates,
+// and other malformed sequences when it is decoding UTF-8. US-ASCII is decoded as UTF-8, so the
+// policy applies to it as well.
+type InvalidUTF8Policy int
+
+const (
+	// InvalidUTF8Replace replaces each byte of a malformed sequence with the unicode
+	// replacement character
+	InvalidUTF8Replace InvalidUTF8Policy = iota
+	// InvalidUTF8Normalize decodes overlong encodings to the character they encode, and surrogate
+	// pairs encoded as two sequences (CESU-8) to the character the pair represents. Lone
+	// surrogates, overlong encodings of control characters, and other malformed sequences are
+	// replaced with a single unicode replacement character.
+	InvalidUTF8Normalize
+	// InvalidUTF8Reject removes malformed sequences from the text
+	InvalidUTF8Reject
+)
+
+func (p InvalidUTF8Policy) String() string {
+	switch p {
+	case InvalidUTF8Replace:
+		return "Replace"
+	case InvalidUTF8Normalize:
+		return "Normalize"
+	case InvalidUTF8Reject:
+		return "Reject"
+	default:
+		return fmt.Sprintf("InvalidUTF8Policy(%d)", int(p))
+	}
+}
+
+// BOMPolicy returns what the printer does with byte order marks at the start of the stream or a line
+func (c *Charset) BOMPolicy() BOMPolicy {
+	return BOMPolicy(c.bomPolicy.Load())
+}
+
+// SetBOMPolicy changes what the printer does with byte order marks at the start of the stream or a line
+func (c *Charset) SetBOMPolicy(policy BOMPolicy) {
+	c.bomPolicy.Store(int32(policy))
+}
+
+// InvalidUTF8Policy returns what the printer does with malformed UTF-8
+func (c *Charset) InvalidUTF8Policy() InvalidUTF8Policy {
+	return InvalidUTF8Policy(c.invalidUTF8Policy.Load())
+}
+
+// SetInvalidUTF8Policy changes what the printer does with malformed UTF-8
+func (c *Charset) SetInvalidUTF8Policy(policy InvalidUTF8Policy) {
+	c.invalidUTF8Policy.Store(int32(policy))
+}
+
+// decodeBOM applies the BOMPolicy to a byte order mark at the start of incomingText, if the
+// printer is at the start of the stream or a line. handled is false if there is no byte order
+// mark to deal with, in which case the text should be decoded as usual. Text that ends partway
+// through a byte order mark is only held back when decoding UTF-8: in other charsets, those bytes
+// are characters of their own, and the rest of the mark may never arrive.
+func (c *Charset) decodeBOM(buffer []byte, incomingText []byte) (consumed int, buffered int, handled bool, err error) {
+	policy := c.BOMPolicy()
+	if c.midLine.Load() {
+		return 0, 0, false, nil
+	}
+
+	if len(incomingText) < len(utf8BOM) && bytes.HasPrefix(utf8BOM, incomingText) {
+		if !c.loadDecodingCharset().utf8 {
+			return 0, 0, false, nil
+		}
+
+		return 0, 0, true, transform.ErrShortSrc
+	}
+
+	if !bytes.HasPrefix(incomingText, utf8BOM) {
+		return 0, 0, false, nil
+	}
+
+	if policy == BOMStrip {
+		return len(utf8BOM), 0, true, nil
+	}
+
+	if len(buffer) < len(utf8BOM) {
+		return 0, 0, true, transform.ErrShortDst
+	}
+
+	// U+FEFF encodes to the same bytes as the byte order mark
+	return len(utf8BOM), copy(buffer, utf8BOM), true, nil
+}
+
+// sanitizeUTF8 copies the UTF-8 text in src to dst, normalizing or removing malformed sequences
+// depending on policy. It returns errors in the same way as a transform.Transformer.
+func sanitizeUTF8(dst []byte, src []byte, policy InvalidUTF8Policy) (nDst int, nSrc int, err error) {
+	for nSrc < len(src) {
+		decoded, size := utf8.DecodeRune(src[nSrc:])
+		if decoded != utf8.RuneError || size > 1 {
+			if nDst+size > len(dst) {
+				return nDst, nSrc, transform.ErrShortDst
+			}
+
+			nDst += copy(dst[nDst:], src[nSrc:nSrc+size])
+			nSrc += size
+			continue
+		}
+
+		decoded, size = normalizeUTF8(src[nSrc:])
+		if size == 0 {
+			return nDst, nSrc, transform.ErrShortSrc
+		}
+
+		if policy != InvalidUTF8Reject {
+			if nDst+utf8.RuneLen(decoded) > len(dst) {
+				return nDst, nSrc, transform.ErrShortDst
+			}
+
+			nDst += utf8.EncodeRune(dst[nDst:], decoded)
+		}
+
+		nSrc += size
+	}
+
+	return nDst, nSrc, nil
+}
+
+// normalizeUTF8 decodes the malformed sequence at the start of src, returning the character it
+// should be normalized to and the sequence's size. size is 0 if src ends before the sequence does.
+func normalizeUTF8(src []byte) (rune, int) {
+	decoded, size := looseDecodeRune(src)
+	if size <= 1 {
+		return utf8.RuneError, size
+	}
+
+	if utf16.IsSurrogate(decoded) {
+		if decoded >= 0xDC00 {
+			// A low surrogate with no high surrogate before it
+			return utf8.RuneError, size
+		}
+
+		low, lowSize := looseDecodeRune(src[size:])
+		if lowSize == 0 {
+			return utf8.RuneError, 0
+		}
+
+		pair := utf16.DecodeRune(decoded, low)
+		if pair == unicode.ReplacementChar {
+			return utf8.RuneError, size
+		}
+
+		return pair, size + lowSize
+	}
+
+	// Overlong encodings of control characters are a classic way of sneaking escape sequences
+	// past filters, so they are not normalized
+	if decoded > unicode.MaxRune || unicode.IsControl(decoded) {
+		return utf8.RuneError, size
+	}
+
+	return decoded, size
+}
+
+// looseDecodeRune decodes a sequence that is shaped like UTF-8, without checking for overlong
+// encodings, surrogates, or characters past unicode.MaxRune. size is 1 if src does not begin with
+// such a sequence, and 0 if src ends before the sequence does.
+func looseDecodeRune(src []byte) (decoded rune, size int) {
+	if len(src) == 0 {
+		return utf8.RuneError, 0
+	}
+
+	lead := src[0]
+	switch {
+	case lead&0xE0 == 0xC0:
+		decoded, size = rune(lead&0x1F), 2
+	case lead&0xF0 == 0xE0:
+		decoded, size = rune(lead&0x0F), 3
+	case lead&0xF8 == 0xF0:
+		decoded, size = rune(lead&0x07), 4
+	default:
+		return utf8.RuneError, 1
+	}
+
+	for i := 1; i < size; i++ {
+		if i >= len(src) {
+			return utf8.RuneError, 0
+		}
+
+		if src[i]&0xC0 != 0x80 {
+			return utf8.RuneError, 1
+		}
+
+		decoded = decoded<<6 | rune(src[i]&0x3F)
+	}
+
+	return decoded, size
+}
@@ -0,0 +1,182 @@
+package telnet
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+var (
+	utf16LEBOM = []byte{0xFF, 0xFE}
+	utf16BEBOM = []byte{0xFE, 0xFF}
+)
+
+// utf16MinPairs is the number of ASCII characters interleaved with NULs that the printer must
+// see before it decides that the remote is sending UTF-16 without a byte order mark
+const utf16MinPairs = 4
+
+// utf16SamplePairs is the number of two-byte pairs that are examined when guessing whether text
+// is UTF-16
+const utf16SamplePairs = 32
+
+// UTF16DetectedEvent is delivered to UTF16Detected hooks when the printer decides that the remote
+// is sending UTF-16 and begins decoding it as such. See TerminalConfig.DetectUTF16.
+type UTF16DetectedEvent struct {
+	Time time.Time
+	// Name is the charset the printer is now decoding with, UTF-16LE or UTF-16BE
+	Name string
+	// ByteOrderMark indicates that the remote began the stream with a UTF-16 byte order mark.
+	// Otherwise, the printer noticed ASCII characters interleaved with NULs.
+	ByteOrderMark bool
+}
+
+func (e UTF16DetectedEvent) String() string {
+	if e.ByteOrderMark {
+		return fmt.Sprintf("Detected %s from byte order mark", e.Name)
+	}
+
+	return fmt.Sprintf("Detected %s from text", e.Name)
+}
+
+// UTF16Detection indicates whether the printer watches for UTF-16 text from the remote
+func (c *Charset) UTF16Detection() bool {
+	return c.utf16Detection.Load()
+}
+
+// SetUTF16Detection changes whether the printer watches for UTF-16 text from the remote. Turning
+// detection off also returns the printer to its usual charsets if UTF-16 had been detected. See
+// TerminalConfig.DetectUTF16.
+func (c *Charset) SetUTF16Detection(detect bool) {
+	c.utf16Detection.Store(detect)
+	if !detect {
+		c.utf16.Store(nil)
+	}
+}
+
+// DetectedUTF16 returns UTF-16LE or UTF-16BE if the printer has detected that the remote is
+// sending UTF-16, or an empty string if it hasn't
+func (c *Charset) DetectedUTF16() string {
+	detected := c.utf16.Load()
+	if detected == nil {
+		return ""
+	}
+
+	return detected.name
+}
+
+// detectUTF16 looks for signs that incomingText is UTF-16, and begins decoding UTF-16 if there
+// are. handled is true if the text began with a byte order mark, which is consumed.
+func (c *Charset) detectUTF16(incomingText []byte) (consumed int, handled bool, err error) {
+	if c.utf16.Load() != nil {
+		return 0, false, nil
+	}
+
+	if !c.decodedText.Load() {
+		if len(incomingText) < 2 && (incomingText[0] == utf16LEBOM[0] || incomingText[0] == utf16BEBOM[0]) {
+			return 0, true, transform.ErrShortSrc
+		}
+
+		if bytes.HasPrefix(incomingText, utf16LEBOM) {
+			c.beginUTF16(unicode.LittleEndian, true)
+			return len(utf16LEBOM), true, nil
+		} else if bytes.HasPrefix(incomingText, utf16BEBOM) {
+			c.beginUTF16(unicode.BigEndian, true)
+			return len(utf16BEBOM), true, nil
+		}
+	}
+
+	endianness, isUTF16 := guessUTF16(incomingText)
+	if isUTF16 {
+		c.beginUTF16(endianness, false)
+	}
+
+	return 0, false, nil
+}
+
+// beginUTF16 switches the printer to decoding UTF-16 with the provided byte order
+func (c *Charset) beginUTF16(endianness unicode.Endianness, byteOrderMark bool) {
+	name := "UTF-16LE"
+	if endianness == unicode.BigEndian {
+		name = "UTF-16BE"
+	}
+
+	// Byte order marks after the start of the stream are zero-width no-break spaces
+	encoding := unicode.UTF16(endianness, unicode.IgnoreBOM)
+	detected := &currentCharset{
+		name:    name,
+		encoder: encoding.NewEncoder(),
+		decoder: encoding.NewDecoder(),
+	}
+
+	if !c.utf16.CompareAndSwap(nil, detected) {
+		return
+	}
+
+	if c.utf16Detected != nil {
+		c.utf16Detected(UTF16DetectedEvent{
+			Time:          time.Now(),
+			Name:          name,
+			ByteOrderMark: byteOrderMark,
+		})
+	}
+}
+
+// guessUTF16 decides whether text is UTF-16 without a byte order mark, by looking for printable
+// ASCII characters interleaved with NULs. Telnet sends a bare CR as CR NUL, so those pairs
+// don't count.
+func guessUTF16(text []byte) (unicode.Endianness, bool) {
+	pairs := min(len(text)/2, utf16SamplePairs)
+	if pairs < utf16MinPairs {
+		return unicode.LittleEndian, false
+	}
+
+	var littleEndian, bigEndian int
+	for i := range pairs {
+		first, second := text[2*i], text[2*i+1]
+		if second == 0 && first >= 0x20 && first < 0x7F {
+			littleEndian++
+		} else if first == 0 && second >= 0x20 && second < 0x7F {
+			bigEndian++
+		}
+	}
+
+	// Most of the text must fit the pattern, which leaves room for line endings and characters
+	// outside of ASCII
+	switch {
+	case littleEndian >= utf16MinPairs && littleEndian*4 >= pairs*3:
+		return unicode.LittleEndian, true
+	case bigEndian >= utf16MinPairs && bigEndian*4 >= pairs*3:
+		return unicode.BigEndian, true
+	default:
+		return unicode.LittleEndian, false
+	}
+}
+
+// scanUTF16BOM is used by the scanner at the start of the stream while UTF-16 detection is on.
+// A UTF-16LE byte order mark is the same as IAC DONT, so it is only accepted if the text that
+// arrived with it looks like UTF-16LE. Otherwise, it is left to be read as a command. It returns
+// the size of the byte order mark at the start of data, or wait if data is too short to tell.
+func scanUTF16BOM(data []byte, atEOF bool) (advance int, wait bool) {
+	if len(data) < 2 {
+		return 0, !atEOF && len(data) == 1 && (data[0] == utf16LEBOM[0] || data[0] == utf16BEBOM[0])
+	}
+
+	if bytes.HasPrefix(data, utf16LEBOM) {
+		// Waiting for more text could stall a remote that is waiting for an answer to its DONT
+		endianness, isUTF16 := guessUTF16(data[len(utf16LEBOM):])
+		if !isUTF16 || endianness != unicode.LittleEndian {
+			return 0, false
+		}
+
+		return len(utf16LEBOM), false
+	}
+
+	if bytes.HasPrefix(data, utf16BEBOM) {
+		return len(utf16BEBOM), false
+	}
+
+	return 0, false
+}
@@ -0,0 +1,329 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/moodclient/telnet"
+	"github.com/moodclient/telnet/telopts"
+	"github.com/moodclient/telnet/utils"
+)
+
+// RawModeFunc puts the client's input into raw mode, so that keys are delivered as they are
+// pressed instead of a line at a time. It returns a function that restores the previous mode.
+// Packages such as github.com/charmbracelet/x/term provide this for the local console.
+type RawModeFunc func() (restore func() error, err error)
+
+// Config determines how a Client is built. Every field can be left empty.
+type Config struct {
+	// ClientName and ClientVersion are reported to the remote with TTYPE and MNES. ClientName
+	// defaults to "MOODCLIENT".
+	ClientName    string
+	ClientVersion string
+
+	// Input is read for keys to send to the remote. It defaults to os.Stdin.
+	Input io.Reader
+	// Output receives the text printed by the remote, along with the local echo of the line
+	// being typed. It defaults to os.Stdout.
+	Output io.Writer
+	// RawMode is called by Run to put Input into raw mode. If it is nil, Input is read as-is.
+	RawMode RawModeFunc
+
+	// LocalTerminal describes the terminal the client is running in. If it is nil, it is detected
+	// with utils.DetectLocalTerminal. Its capabilities decide whether the client asks the remote for
+	// UTF-8, and are reported to the remote with TTYPE.
+	LocalTerminal *utils.LocalTerminal
+	// SizeSource keeps NAWS up to date with the size of the local terminal. If it is nil and
+	// Output is an *os.File, the size of that file's terminal is used.
+	SizeSource utils.TerminalSizeSource
+	// Profile, if populated, provides charset preferences, keymap, and aliases
+	Profile *utils.Profile
+	// LineFeed configures local line editing
+	LineFeed utils.LineFeedConfig
+
+	// DebugLogger, if populated, receives a utils.DebugLog of the session, using the levels in
+	// DebugLog
+	DebugLogger *slog.Logger
+	DebugLog    utils.DebugLogConfig
+
+	// TelOpts, if populated, replaces the telopts from DefaultTelOpts
+	TelOpts []telnet.TelnetOption
+	// PrinterOutput, if populated, receives printer output instead of Output
+	PrinterOutput telnet.TerminalDataHandler
+	// EncounteredError, if populated, receives terminal errors instead of Output
+	EncounteredError telnet.ErrorHandler
+	// ConfigureTerminal, if populated, is called with the TerminalConfig after the client has
+	// filled it in, and may change anything about it
+	ConfigureTerminal func(config *telnet.TerminalConfig)
+}
+
+func (c Config) withDefaults() Config {
+	if c.ClientName == "" {
+		c.ClientName = "MOODCLIENT"
+	}
+
+	if c.Input == nil {
+		c.Input = os.Stdin
+	}
+
+	if c.Output == nil {
+		c.Output = os.Stdout
+	}
+
+	if c.LocalTerminal == nil {
+		local := utils.DetectLocalTerminal(c.ClientName)
+		c.LocalTerminal = &local
+	}
+
+	if c.SizeSource == nil {
+		file, isFile := c.Output.(*os.File)
+		if isFile {
+			c.SizeSource = utils.NewFileSizeSource(file)
+		}
+	}
+
+	if c.PrinterOutput == nil {
+		output := c.Output
+		c.PrinterOutput = func(_ *telnet.Terminal, data telnet.TerminalData) {
+			_, _ = io.WriteString(output, data.String())
+		}
+	}
+
+	if c.EncounteredError == nil {
+		output := c.Output
+		c.EncounteredError = func(_ *telnet.Terminal, err error) {
+			_, _ = fmt.Fprintf(output, "%s\r\n", err)
+		}
+	}
+
+	return c
+}
+
+// supportsUTF8 returns true if the local terminal can display UTF-8
+func (c Config) supportsUTF8() bool {
+	return c.LocalTerminal.Capabilities&telopts.MTTSUTF8 != 0
+}
+
+// DefaultTelOpts returns the telopts a Client registers when Config.TelOpts is empty: CHARSET,
+// TRANSMIT-BINARY, EOR, ECHO, SUPPRESS-GO-AHEAD, NAWS, TTYPE, and MNES
+func DefaultTelOpts(config Config) []telnet.TelnetOption {
+	config = config.withDefaults()
+
+	preferredCharsets := []string{"US-ASCII"}
+	charsetName := "US-ASCII"
+	if config.supportsUTF8() {
+		preferredCharsets = []string{"UTF-8", "US-ASCII"}
+		charsetName = "UTF-8"
+	}
+
+	return []telnet.TelnetOption{
+		telopts.RegisterCHARSET(telnet.TelOptAllowLocal|telnet.TelOptAllowRemote, telopts.CHARSETConfig{
+			AllowAnyCharset:   true,
+			PreferredCharsets: preferredCharsets,
+		}),
+		telopts.RegisterTRANSMITBINARY(telnet.TelOptAllowLocal | telnet.TelOptAllowRemote),
+		telopts.RegisterEOR(telnet.TelOptAllowRemote | telnet.TelOptAllowLocal),
+		telopts.RegisterECHO(telnet.TelOptAllowRemote),
+		telopts.RegisterSUPPRESSGOAHEAD(telnet.TelOptAllowLocal | telnet.TelOptAllowRemote),
+		telopts.RegisterNAWS(telnet.TelOptAllowLocal),
+		telopts.RegisterTTYPE(telnet.TelOptAllowLocal, config.LocalTerminal.TTYPEList()),
+		telopts.RegisterMNES(telnet.TelOptAllowLocal, telopts.MNESInfo{
+			ClientName:    strings.ToUpper(config.ClientName),
+			ClientVersion: config.ClientVersion,
+			Charset:       charsetName,
+			TerminalType:  config.LocalTerminal.TerminalType,
+			MTTS:          config.LocalTerminal.Capabilities,
+		}),
+	}
+}
+
+// TerminalConfig builds the TerminalConfig that Dial uses to create a Client's terminal. This is
+// useful for creating the terminal some other way, such as over TLS, and passing it to New.
+func (c Config) TerminalConfig() telnet.TerminalConfig {
+	c = c.withDefaults()
+
+	telOpts := c.TelOpts
+	if len(telOpts) == 0 {
+		telOpts = DefaultTelOpts(c)
+	}
+
+	config := telnet.TerminalConfig{
+		Side: telnet.SideClient,
+		// The US-ASCII decoder accepts UTF-8, and is promoted to UTF-8 if the remote sends any
+		DefaultCharsetName:  "US-ASCII",
+		FallbackCharsetName: "CP437-FULL",
+		TelOpts:             telOpts,
+		EventHooks: telnet.EventHooks{
+			PrinterOutput:    []telnet.TerminalDataHandler{c.PrinterOutput},
+			EncounteredError: []telnet.ErrorHandler{c.EncounteredError},
+		},
+	}
+
+	if c.Profile != nil {
+		c.Profile.ApplyToConfig(&config)
+	}
+
+	if c.ConfigureTerminal != nil {
+		c.ConfigureTerminal(&config)
+	}
+
+	return config
+}
+
+// Client is a complete terminal-based telnet client: it feeds keys from its input to the remote
+// through a LineFeed, prints what the remote sends to its output, keeps NAWS in sync with the
+// size of the local terminal, and optionally writes a debug log. It is built from the utils
+// package, and each of the parts can be retrieved to customize them further.
+type Client struct {
+	config   Config
+	terminal *telnet.Terminal
+
+	characterMode *utils.CharacterModeTracker
+	lineFeed      *utils.LineFeed
+	keyboardFeed  *utils.KeyboardFeed
+	nawsSync      *utils.NAWSSync
+	debugLog      *utils.DebugLog
+}
+
+// Dial connects to a telnet server and builds a client for the connection. address may be a
+// host:port pair, which is passed to telnet.Dial, or a telnet URL, which is passed to
+// telnet.DialURL.
+func Dial(ctx context.Context, address string, config Config) (*Client, error) {
+	config = config.withDefaults()
+	terminalConfig := config.TerminalConfig()
+
+	var terminal *telnet.Terminal
+	var err error
+	if strings.HasPrefix(strings.ToLower(address), "telnet:") {
+		terminal, err = telnet.DialURL(ctx, address, terminalConfig)
+	} else {
+		terminal, err = telnet.Dial(ctx, address, terminalConfig)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := New(terminal, config)
+	if err != nil {
+		_ = terminal.Close()
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// New builds a client around a terminal that has already been created, usually with
+// Config.TerminalConfig. config should be the same config the terminal was created from.
+func New(terminal *telnet.Terminal, config Config) (*Client, error) {
+	if terminal.Side() != telnet.SideClient {
+		return nil, errors.New("client requires a client terminal")
+	}
+
+	config = config.withDefaults()
+	client := &Client{
+		config:        config,
+		terminal:      terminal,
+		characterMode: utils.NewCharacterModeTracker(terminal),
+	}
+
+	client.lineFeed = utils.NewLineFeed(terminal, terminal.Keyboard().LineOut, config.PrinterOutput, config.LineFeed)
+
+	var err error
+	client.keyboardFeed, err = utils.NewKeyboardFeed(terminal, config.Input, client.lineFeed, client.characterMode)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Profile != nil {
+		client.lineFeed.SetProfile(config.Profile)
+		client.keyboardFeed.SetProfile(config.Profile)
+	}
+
+	if config.SizeSource != nil {
+		// NAWS may not be registered if the consumer provided their own telopts
+		client.nawsSync, _ = utils.NewNAWSSync(terminal, config.SizeSource)
+	}
+
+	if config.DebugLogger != nil {
+		client.debugLog = utils.NewDebugLog(terminal, config.DebugLogger, config.DebugLog)
+	}
+
+	return client, nil
+}
+
+// Terminal returns the client's terminal
+func (c *Client) Terminal() *telnet.Terminal {
+	return c.terminal
+}
+
+// LineFeed returns the LineFeed that edits the line being typed
+func (c *Client) LineFeed() *utils.LineFeed {
+	return c.lineFeed
+}
+
+// KeyboardFeed returns the KeyboardFeed that reads keys from the client's input
+func (c *Client) KeyboardFeed() *utils.KeyboardFeed {
+	return c.keyboardFeed
+}
+
+// CharacterMode returns the tracker that decides whether keys are sent as they are typed
+func (c *Client) CharacterMode() *utils.CharacterModeTracker {
+	return c.characterMode
+}
+
+// DebugLog returns the client's debug log, or nil if Config.DebugLogger was not populated
+func (c *Client) DebugLog() *utils.DebugLog {
+	return c.debugLog
+}
+
+// SetProfile provides a new profile to the LineFeed and KeyboardFeed. The profile's charset
+// preferences only take effect for new connections.
+func (c *Client) SetProfile(profile *utils.Profile) {
+	c.lineFeed.SetProfile(profile)
+	c.keyboardFeed.SetProfile(profile)
+}
+
+// Run puts the input into raw mode (if Config.RawMode is populated) and feeds it to the terminal
+// until the terminal shuts down, returning the error from Terminal.WaitForExit. The terminal is
+// closed if the input ends first.
+func (c *Client) Run() error {
+	if c.config.RawMode != nil {
+		restore, err := c.config.RawMode()
+		if err != nil {
+			_ = c.terminal.Close()
+			return err
+		}
+
+		defer func() {
+			_ = restore()
+		}()
+	}
+
+	go func() {
+		err := c.keyboardFeed.FeedLoop()
+		if err != nil {
+			c.config.EncounteredError(c.terminal, err)
+		}
+
+		_ = c.terminal.Close()
+	}()
+
+	err := c.terminal.WaitForExit()
+
+	if c.nawsSync != nil {
+		c.nawsSync.Stop()
+	}
+	c.lineFeed.Stop()
+
+	return err
+}
+
+// Close closes the client's terminal, which causes Run to return
+func (c *Client) Close() error {
+	return c.terminal.Close()
+}
@@ -0,0 +1,118 @@
+package telnet
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// DefaultCloseTimeout is the amount of time Terminal.Close will wait for queued keyboard
+// output to be written before closing the connection anyway.
+const DefaultCloseTimeout = 5 * time.Second
+
+// ErrCloseTimeout is returned from Terminal.CloseWithTimeout when queued keyboard output
+// could not be written before the timeout expired. The connection is still closed.
+var ErrCloseTimeout = errors.New("telnet: timed out waiting for keyboard to flush before close")
+
+func streamClosers(reader io.Reader, writer io.Writer) []io.Closer {
+	var closers []io.Closer
+
+	readCloser, isReadCloser := reader.(io.Closer)
+	if isReadCloser {
+		closers = append(closers, readCloser)
+	}
+
+	writeCloser, isWriteCloser := writer.(io.Closer)
+	if isWriteCloser && (!isReadCloser || writeCloser != readCloser) {
+		closers = append(closers, writeCloser)
+	}
+
+	return closers
+}
+
+// Close gracefully shuts down the terminal, waiting up to DefaultCloseTimeout for queued
+// keyboard output to be written. See CloseWithTimeout for more information.
+func (t *Terminal) Close() error {
+	return t.CloseWithTimeout(DefaultCloseTimeout)
+}
+
+// CloseWithTimeout gracefully shuts down the terminal. The keyboard stops accepting new
+// writes, all keyboard locks are cleared, and any output queued on the keyboard is written
+// to the remote. If TerminalConfig.DisableTelOptsOnClose was set, WONT/DONT commands are sent
+// for all active telopts before the keyboard stops accepting writes.
+//
+// Once the keyboard has been flushed, or the timeout has expired, the underlying connection
+// is closed (if the reader and writer provided to the terminal implement io.Closer). WaitForExit
+// will not return until the connection is closed, and will not return an error due to the
+// connection being closed.
+//
+// If the timeout expires before the keyboard has been flushed, ErrCloseTimeout is returned.
+// Calling this method more than once has no effect- subsequent calls will return nil.
+func (t *Terminal) CloseWithTimeout(timeout time.Duration) error {
+	var closeErr error
+
+	t.closeOnce.Do(func() {
+		closeErr = t.close(timeout)
+	})
+
+	return closeErr
+}
+
+// disableTelOptsForClose deactivates every telopt, so that WONT/DONT commands are queued on the
+// keyboard before it stops accepting writes. Like other negotiation changes made from outside
+// the printer loop, the deactivation is serialized with the printer loop. If the printer loop
+// doesn't get to it within timeout, such as when Close is called from a printer hook, the terminal
+// closes without waiting for it.
+func (t *Terminal) disableTelOptsForClose(timeout time.Duration) error {
+	var errs []error
+	done := make(chan struct{})
+
+	t.printer.pause.run(func() {
+		defer close(done)
+
+		for _, option := range t.telOptList() {
+			errs = append(errs,
+				t.deactivateTelOpt(option, TelOptSideLocal, TelOptChangeConnectionClose),
+				t.deactivateTelOpt(option, TelOptSideRemote, TelOptChangeConnectionClose))
+		}
+	})
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		return errors.Join(errs...)
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (t *Terminal) close(timeout time.Duration) error {
+	var errs []error
+
+	t.beginClosing(CloseRequested)
+	deadline := time.Now().Add(timeout)
+
+	if t.disableTelOptsOnClose {
+		errs = append(errs, t.disableTelOptsForClose(timeout))
+	}
+
+	t.keyboard.stopAccepting()
+	t.keyboard.lock.ClearAllLocks()
+	t.printer.closing.Store(true)
+	t.stopKeyboard()
+
+	if !t.keyboard.waitForExitTimeout(time.Until(deadline)) {
+		errs = append(errs, ErrCloseTimeout)
+	}
+
+	for _, closer := range t.closers {
+		errs = append(errs, closer.Close())
+	}
+
+	// If the streams couldn't be closed, the printer may still be blocked on them
+	t.stopConn()
+
+	return errors.Join(errs...)
+}
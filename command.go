@@ -1,8 +1,6 @@
 package telnet
 
 import (
-	"errors"
-	"fmt"
 	"strconv"
 	"strings"
 )
@@ -17,19 +15,23 @@ const (
 	SE byte = 240
 	// NOP - No-Op. IAC NOP doesn't indicate anything at all, and this library ignores it.
 	NOP byte = 241
-	// DATAMARK - not in use by this library
+	// DATAMARK - Data Mark. IAC DM marks the end of a Synch, which the remote sends to have
+	// everything before it discarded. If the printer's synch discard is enabled, text that has
+	// already been received ahead of an IAC DM is dropped. See TerminalConfig.SynchDiscard.
 	DATAMARK byte = 242
-	// BRK - not in use by this library
+	// BRK - Break. IAC BRK indicates that the user pressed the Break or Attention key.
 	BRK byte = 243
-	// IP - Not in use by this library
+	// IP - Interrupt Process. IAC IP asks the remote to interrupt the running process, like Ctrl+C.
 	IP byte = 244
-	// AO - Not in use by this library
+	// AO - Abort Output. IAC AO asks the remote to stop sending the output it is producing. It is
+	// usually followed by a Synch so that output already on its way is discarded as well.
 	AO byte = 245
-	// AYT - If received, an IAC NOP will be sent in response
+	// AYT - Are You There. If received, an IAC NOP will be sent in response, unless
+	// TerminalConfig.AYTResponse says otherwise
 	AYT byte = 246
-	// EC - Not in use by this library
+	// EC - Erase Character. IAC EC asks the remote to delete the last character that was typed.
 	EC byte = 247
-	// EL - Not in use by this library
+	// EL - Erase Line. IAC EL asks the remote to delete the line that is being typed.
 	EL byte = 248
 	// GA - Go Ahead. IAC GA is often used to indicate the end of a prompt line, so
 	// that clients know where to place a cursor. However, it was originally used for
@@ -53,16 +55,29 @@ const (
 )
 
 var commandCodes = map[byte]string{
-	EOR:  "EOR",
-	SE:   "SE",
-	NOP:  "NOP",
-	GA:   "GA",
-	SB:   "SB",
-	WILL: "WILL",
-	WONT: "WONT",
-	DO:   "DO",
-	DONT: "DONT",
-	IAC:  "IAC",
+	EOR:      "EOR",
+	SE:       "SE",
+	NOP:      "NOP",
+	DATAMARK: "DATAMARK",
+	BRK:      "BRK",
+	IP:       "IP",
+	AO:       "AO",
+	AYT:      "AYT",
+	EC:       "EC",
+	EL:       "EL",
+	GA:       "GA",
+	SB:       "SB",
+	WILL:     "WILL",
+	WONT:     "WONT",
+	DO:       "DO",
+	DONT:     "DONT",
+	IAC:      "IAC",
+}
+
+// hasOption indicates whether commands with the provided opcode are followed by a telopt code.
+// All other commands are just IAC and the opcode.
+func hasOption(opCode byte) bool {
+	return opCode == SB || opCode == WILL || opCode == WONT || opCode == DO || opCode == DONT
 }
 
 // Command is a struct that indicates some sort of IAC command either received from
@@ -81,6 +96,39 @@ type Command struct {
 	Subnegotiation []byte
 }
 
+// encodeCommand produces the bytes that represent c on the wire
+func encodeCommand(c Command) []byte {
+	size := 2
+	if hasOption(c.OpCode) {
+		size++
+	}
+
+	if c.OpCode == SB {
+		size += len(c.Subnegotiation)
+		size += 2
+	}
+
+	b := make([]byte, 0, size)
+	b = append(b, IAC, c.OpCode)
+
+	if size > 2 {
+		b = append(b, byte(c.Option))
+	}
+
+	if size > 3 {
+		// IAC bytes in the subnegotiation need to be doubled so they aren't mistaken for IAC SE
+		for _, subnegotiationByte := range c.Subnegotiation {
+			b = append(b, subnegotiationByte)
+			if subnegotiationByte == IAC {
+				b = append(b, IAC)
+			}
+		}
+		b = append(b, IAC, SE)
+	}
+
+	return b
+}
+
 // isActivateNegotiation indicates whether this command is a negotiation requesting activation
 // of a telopt (DO/WILL).
 func (c Command) isActivateNegotiation() bool {
@@ -130,27 +178,31 @@ func (c Command) agree() Command {
 }
 
 func parseCommand(data []byte) (Command, error) {
+	if len(data) == 0 {
+		return Command{}, ProtocolErrorf("command was empty")
+	}
+
 	if data[0] != IAC {
-		return Command{}, fmt.Errorf("command did not begin with IAC: %q", commandStream(data))
+		return Command{}, ProtocolErrorf("command did not begin with IAC: %q", commandStream(data))
 	}
 
 	if len(data) < 2 {
-		return Command{}, errors.New("command was just a standalone IAC with no opcode")
+		return Command{}, ProtocolErrorf("command was just a standalone IAC with no opcode")
 	}
 
 	_, validOpcode := commandCodes[data[1]]
 	if !validOpcode {
-		return Command{}, fmt.Errorf("command did not have valid opcode: %q", commandStream(data))
+		return Command{}, ProtocolErrorf("command did not have valid opcode: %q", commandStream(data))
 	}
 
-	if data[1] == NOP || data[1] == GA || data[1] == EOR {
+	if !hasOption(data[1]) {
 		return Command{
 			OpCode: data[1],
 		}, nil
 	}
 
 	if len(data) < 3 {
-		return Command{}, fmt.Errorf("command did not contain parameters: %q", commandStream(data))
+		return Command{}, ProtocolErrorf("command did not contain parameters: %q", commandStream(data))
 	}
 
 	if data[1] != SB {
@@ -161,7 +213,7 @@ func parseCommand(data []byte) (Command, error) {
 	}
 
 	if len(data) < 5 || data[len(data)-2] != IAC || data[len(data)-1] != SE {
-		return Command{}, fmt.Errorf("subnegotiation command did not end with IAC SE: %q", commandStream(data))
+		return Command{}, ProtocolErrorf("subnegotiation command did not end with IAC SE: %q", commandStream(data))
 	}
 
 	// doubled 255s in the subnegotiation data need to be pared down to a single 255 just like in the main
@@ -173,7 +225,7 @@ func parseCommand(data []byte) (Command, error) {
 	for ; dataIndex < len(subnegotiationData); bufferIndex++ {
 		finalBuffer[bufferIndex] = subnegotiationData[dataIndex]
 		dataIndex++
-		if subnegotiationData[bufferIndex] == IAC && dataIndex < len(subnegotiationData) && subnegotiationData[dataIndex] == IAC {
+		if finalBuffer[bufferIndex] == IAC && dataIndex < len(subnegotiationData) && subnegotiationData[dataIndex] == IAC {
 			dataIndex++
 		}
 	}
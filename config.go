@@ -1,5 +1,13 @@
 package telnet
 
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/moodclient/telnet/charset"
+)
+
 // TerminalSide indicates whether this terminal represents a client or server. Technically
 // speaking, telnet is a peer-to-peer protocol, more concerned with "local and remote"
 // than "client and server". Some RFCs (mainly CHARSET) have distinct behavior
@@ -28,6 +36,69 @@ const (
 	CharsetUsageAlways
 )
 
+// CharacterModePolicy decides how the states of ECHO and SUPPRESS-GO-AHEAD are interpreted.
+// RFC 858 describes "kludge line mode", in which the connection is line-at-a-time when exactly
+// one of the two telopts is active, but MUDs and BBSs each settled on their own conventions, so
+// no single interpretation works for every remote.
+type CharacterModePolicy byte
+
+const (
+	// CharacterModePolicyMUD treats the connection as character mode only when the remote has
+	// activated both ECHO and SUPPRESS-GO-AHEAD. MUDs usually leave both inactive for
+	// line-at-a-time operation and use IAC GA to mark prompts, so IAC GA is sent whenever
+	// SUPPRESS-GO-AHEAD is inactive locally.
+	CharacterModePolicyMUD CharacterModePolicy = iota
+	// CharacterModePolicyStrict follows RFC 858: the connection is in character mode when ECHO
+	// and SUPPRESS-GO-AHEAD are both active or both inactive on the remote, and in kludge line
+	// mode otherwise. IAC GA is sent whenever SUPPRESS-GO-AHEAD is inactive locally.
+	CharacterModePolicyStrict
+	// CharacterModePolicyBBS treats the connection as character mode when the remote has
+	// activated either ECHO or SUPPRESS-GO-AHEAD, since BBSs operate a character at a time and
+	// don't always negotiate both. IAC GA is never sent, because BBS clients don't expect it.
+	CharacterModePolicyBBS
+)
+
+func (p CharacterModePolicy) String() string {
+	switch p {
+	case CharacterModePolicyMUD:
+		return "MUD"
+	case CharacterModePolicyStrict:
+		return "Strict"
+	case CharacterModePolicyBBS:
+		return "BBS"
+	default:
+		return fmt.Sprintf("CharacterModePolicy(%d)", int(p))
+	}
+}
+
+// IsCharacterMode returns true if the provided remote states of ECHO and SUPPRESS-GO-AHEAD
+// indicate character mode under this policy
+func (p CharacterModePolicy) IsCharacterMode(remoteEcho bool, remoteSuppressGoAhead bool) bool {
+	switch p {
+	case CharacterModePolicyStrict:
+		return remoteEcho == remoteSuppressGoAhead
+	case CharacterModePolicyBBS:
+		return remoteEcho || remoteSuppressGoAhead
+	default:
+		return remoteEcho && remoteSuppressGoAhead
+	}
+}
+
+// SendsGoAhead returns true if the keyboard should send IAC GA as a prompt hint, given the local
+// state of SUPPRESS-GO-AHEAD
+func (p CharacterModePolicy) SendsGoAhead(localSuppressGoAhead bool) bool {
+	return p != CharacterModePolicyBBS && !localSuppressGoAhead
+}
+
+// NegotiationPolicyFunc decides whether the terminal agrees to a request from the remote to
+// activate one side of a telopt. It is only consulted for requests that the telopt's usage
+// permits; returning false rejects the request with WONT/DONT. requestedState is the state the
+// side would move to, which is currently always TelOptActive.
+//
+// The function is called while the terminal is negotiating the telopt, so it must not enable or
+// disable telopts itself. It may inspect the states of any telopt.
+type NegotiationPolicyFunc func(terminal *Terminal, option TelnetOption, side TelOptSide, requestedState TelOptState) bool
+
 type TerminalConfig struct {
 	// DefaultCharsetName is the registered IANA name of the character set to use for all communications not
 	// sent via a negotiated charset (via the CHARSET telopt). RFC 854 (Telnet Protocol) specifies that by
@@ -77,6 +148,37 @@ type TerminalConfig struct {
 	// Text sent in telopt subnegotiations will always use UTF-8 regardless of this setting.
 	CharsetUsage CharsetUsage
 
+	// CP437ControlMode decides whether the CP437-FULL charset decodes bytes in the control range as
+	// glyphs (the default, suitable for ANSI art) or as control codes. It can be changed during the
+	// session with Charset.SetCP437ControlMode.
+	CP437ControlMode charset.CP437ControlMode
+
+	// BOMPolicy decides what the printer does with a UTF-8 byte order mark at the start of the
+	// stream or a line. By default, it is decoded like any other text. It can be changed during
+	// the session with Charset.SetBOMPolicy.
+	BOMPolicy BOMPolicy
+
+	// InvalidUTF8Policy decides what the printer does with overlong encodings, encoded
+	// surrogates, and other malformed UTF-8. By default, each malformed byte is replaced with
+	// the unicode replacement character. It can be changed during the session with
+	// Charset.SetInvalidUTF8Policy.
+	InvalidUTF8Policy InvalidUTF8Policy
+
+	// DetectUTF16 indicates that the printer should watch for a remote that sends UTF-16, as a
+	// few legacy Windows services do, and decode it as such instead of delivering alternating
+	// NULs and garbage. The remote is detected by a UTF-16 byte order mark at the start of the
+	// stream, or by printable ASCII characters interleaved with NULs. Once it is detected, all
+	// text from the remote is decoded as UTF-16, whatever the default, negotiated, and fallback
+	// charsets are, and a UTF16DetectedEvent is delivered to UTF16Detected hooks. It can be
+	// changed during the session with Charset.SetUTF16Detection. A UTF-16LE byte order mark is
+	// the same as IAC DONT, so it is only recognized when it arrives along with UTF-16LE text.
+	DetectUTF16 bool
+
+	// CharacterModePolicy decides how ECHO and SUPPRESS-GO-AHEAD are interpreted when deciding
+	// whether the connection is in character mode, and whether the keyboard sends IAC GA. The
+	// default is CharacterModePolicyMUD.
+	CharacterModePolicy CharacterModePolicy
+
 	// Side indicates whether this terminal is intended to be the client or server. Even though RFC 854
 	// (Telnet Protocol) does not have the concept of a client or server, just local and remote, some TelOpts,
 	// such as CHARSET, indicate different behaviors for clients and servers.
@@ -86,6 +188,18 @@ type TerminalConfig struct {
 	// should be permitted to request from us.
 	TelOpts []TelnetOption
 
+	// NegotiationPolicy can be left nil. If populated, it is consulted whenever the remote asks to
+	// activate a telopt that TelOpts permits, and can reject the request. This allows requests to
+	// be decided dynamically, such as only allowing TRANSMIT-BINARY once the user has logged in, or
+	// capping the number of telopts that can be active at once.
+	NegotiationPolicy NegotiationPolicyFunc
+
+	// TelOptNames can be left empty. If populated, it is a list of names of telopts registered with
+	// RegisterTelOptConstructor, which will be created for this terminal's Side and added to TelOpts.
+	// This allows the telopts to be chosen at runtime, such as from a configuration file. Names of
+	// telopts whose code is already used by a telopt in TelOpts are ignored.
+	TelOptNames []string
+
 	// EventHooks is a set of callbacks that the terminal will call when the relevant
 	// event occurs.  You can register additional callbacks after creation with
 	// Terminal.Register* methods.
@@ -95,7 +209,163 @@ type TerminalConfig struct {
 	// before it is sent to registered hooks
 	PrinterMiddlewares []Middleware
 
+	// PromptFilter can be left at zero. If populated, it indicates which prompt signals the printer
+	// should drop instead of delivering as PromptData, for servers that send GA after every line.
+	// It can be changed later with TelnetPrinter.SetPromptFilter.
+	PromptFilter PromptFilter
+
+	// SynchDiscard indicates that the printer should honor the remote's Synch signals by
+	// discarding text, but not commands, that it has received ahead of an IAC DM. Synch is meant to
+	// flush everything the remote sent before the Data Mark, such as output the user aborted with
+	// IAC AO, but the TCP urgent notification that announces it isn't available to this library,
+	// so only text that has already been read from the connection along with the Data Mark is
+	// discarded. It can be changed later with TelnetPrinter.SetSynchDiscard.
+	SynchDiscard bool
+
+	// AYTResponse can be left nil. If populated, it decides what the terminal sends when the
+	// remote sends IAC AYT (Are You There), such as AYTRespondText("[yes]\r\n"). If nil, the
+	// terminal answers with IAC NOP, as with AYTRespondNOP.
+	AYTResponse AYTResponder
+
+	// LineTerminators decides how the keyboard rewrites CR and LF in outbound text while
+	// TRANSMIT-BINARY is not active. By default, lines are sent as CR LF and a bare CR is sent as
+	// CR NUL, as RFC 854 requires. LineTerminatorsBareCR can be used for legacy servers that choke
+	// on CR NUL.
+	LineTerminators LineTerminatorMode
+
+	// KeyboardDirectWrites indicates that once the initial negotiation has completed, text should be
+	// written to the connection by the goroutine that writes it, rather than handed to the keyboard
+	// loop, whenever the keyboard is idle and unlocked. This saves a goroutine hop for every line on
+	// busy servers that don't negotiate after startup. Text falls back to the keyboard's queue
+	// whenever a keyboard lock, rate limit, or other waiting data could need it to wait, so ordering
+	// is unaffected, but methods such as WriteString block while the text is being written.
+	KeyboardDirectWrites bool
+
+	// KeyboardBatchSize can be left at zero. If populated, the keyboard collects the data it writes
+	// into a single buffer of up to this many bytes, which is written to the connection all at once
+	// when the keyboard runs out of data to write, rather than writing each piece of data on its
+	// own. This saves a great deal of overhead over TLS and high-latency links.
+	KeyboardBatchSize int
+
+	// KeyboardBatchInterval can be left at zero. If populated along with KeyboardBatchSize, it is
+	// how long data may wait in the keyboard's buffer for more data to join it before it is
+	// written, even when the keyboard has nothing else to write. Bear in mind that this adds up to
+	// this much latency to every write.
+	KeyboardBatchInterval time.Duration
+
 	// KeyboardMiddlewares is a set of middlewares that should process data sent
 	// to the keyboard before it is sent to the network connection
 	KeyboardMiddlewares []Middleware
+
+	// PartialSequenceTimeout can be left at zero. If populated, it is the amount of time the
+	// printer will wait for the remote to complete a partially-received escape sequence or
+	// character before giving up and emitting what has been received so far. The partial
+	// escape sequence's introducer will be emitted as ControlCodeData, and the remainder as
+	// TextData.  Partial characters will be emitted as the unicode replacement character.
+	//
+	// This prevents peers that send half an escape sequence and then go quiet from holding
+	// prompts hostage indefinitely.
+	PartialSequenceTimeout time.Duration
+
+	// EventQueueSize can be left at zero. If populated, it is the number of events (errors,
+	// printer output, outbound data) that can be queued for delivery to hooks by the terminal
+	// loop before the printer and keyboard will block waiting for hooks to catch up. The default
+	// is 100.
+	EventQueueSize int
+
+	// PrinterDispatchQueueSize can be left at zero. If populated, printer output will be delivered
+	// to printer middlewares and PrinterOutput hooks on a dedicated goroutine with its own queue
+	// of this size, rather than on the terminal loop alongside errors and outbound data. This
+	// isolates slow PrinterOutput hooks from the rest of the terminal's events. Bear in mind that
+	// when this is active, printer output is no longer ordered relative to other events.
+	PrinterDispatchQueueSize int
+
+	// DisableTelOptsOnClose indicates that when Terminal.Close is called, WONT/DONT commands should
+	// be sent to the remote for all active telopts before the connection is closed.
+	DisableTelOptsOnClose bool
+
+	// NegotiationQuietPeriod can be left at zero. If populated, it is the amount of time without
+	// any negotiation traffic from the remote after which the initial telopt negotiation is considered
+	// complete. See Terminal.WaitForNegotiation. The default is DefaultNegotiationQuietPeriod.
+	NegotiationQuietPeriod time.Duration
+
+	// CompleteNegotiationWhenResolved indicates that the initial telopt negotiation should also be
+	// considered complete as soon as the remote has responded to every telopt that this terminal
+	// requested, without waiting for the quiet period. Bear in mind that remotes may still be sending
+	// requests of their own at that point.
+	CompleteNegotiationWhenResolved bool
+
+	// TelOptNegotiationTimeout can be left at zero. If populated, it is the amount of time this
+	// terminal will wait for the remote to answer a WILL/DO request before giving up on it. The
+	// side of the telopt is moved back to TelOptInactive, which releases any keyboard lock the
+	// telopt was holding, and a TelOptNegotiationTimeoutEvent is raised. If the remote answers
+	// after all, the telopt is activated as though the remote had requested it.
+	TelOptNegotiationTimeout time.Duration
+
+	// TelOptNegotiationTimeouts can be left empty. If populated, it overrides
+	// TelOptNegotiationTimeout for the telopts with the provided codes. A zero or negative
+	// timeout means that requests for that telopt never time out.
+	TelOptNegotiationTimeouts map[TelOptCode]time.Duration
+
+	// RetryTelOptNegotiation indicates that a WILL/DO request that timed out should be sent once
+	// more, after waiting as long as the timeout. The retry waits twice as long for an answer.
+	RetryTelOptNegotiation bool
+
+	// KeepaliveInterval can be left at zero. If populated, the keyboard will send KeepaliveCommand
+	// to the remote whenever nothing has been sent for this long. This prevents NAT gateways and
+	// stateful firewalls from dropping idle connections.
+	KeepaliveInterval time.Duration
+
+	// KeepaliveJitter can be left at zero. If populated, a random amount of time up to this
+	// value will be added to each keepalive wait.
+	KeepaliveJitter time.Duration
+
+	// KeepaliveCommand is the opcode sent to keep the connection alive. It may be NOP or AYT,
+	// and defaults to NOP. AYT will cause most remotes to respond, which keeps the connection
+	// active in both directions, but some remotes respond with visible text.
+	KeepaliveCommand byte
+
+	// DeduplicateTelOptEvents can be left empty. If populated, TelOptEvents of the same types as
+	// the events in this slice will not be delivered to hooks when they are identical to the previous
+	// event of that type raised by the same telopt. See Terminal.DeduplicateTelOptEvents.
+	DeduplicateTelOptEvents []TelOptEvent
+
+	// FailFastOnPanic indicates that panics in event hooks, middlewares, and telopts should not be
+	// recovered. By default, the terminal recovers from these panics and delivers them to EncounteredError
+	// hooks as a *PanicError, so that a single misbehaving hook can't kill the terminal's goroutines and
+	// leave WaitForExit blocked forever.
+	FailFastOnPanic bool
+
+	// IdleBufferRelease can be left at zero. If populated, the printer and keyboard will release
+	// their internal parsing buffers after this long without any traffic, and recreate them when
+	// traffic resumes. This reduces the steady-state memory use of servers hosting large numbers of
+	// mostly-idle connections, at the cost of some allocations when those connections wake up.
+	IdleBufferRelease time.Duration
+
+	// KeyboardQueueCapacity can be left at zero. It is the number of text writes that can wait
+	// to be sent by the keyboard, including text held by a keyboard lock, and defaults to
+	// DefaultKeyboardQueueCapacity. Commands and prompt hints don't count against it.
+	KeyboardQueueCapacity int
+
+	// KeyboardOverflowPolicy decides what happens to text written to the keyboard while its
+	// queue is full. By default, writes block until there is room.
+	KeyboardOverflowPolicy KeyboardOverflowPolicy
+
+	// KeyboardRateLimit can be left empty. If populated, the keyboard holds text that would exceed
+	// the provided rate. It can be changed later with TelnetKeyboard.SetRateLimit.
+	KeyboardRateLimit KeyboardRateLimit
+
+	// PrinterRawTap can be left nil. If populated, it receives a copy of every byte that arrives
+	// on the connection, before decompression, decoding, or parsing, so that protocol bugs can be
+	// captured without wrapping the connection. Wrap it with hex.Dumper for a readable dump. It is
+	// written to on the printer's reading goroutine, and errors it returns are ignored. It can be
+	// changed later with TelnetPrinter.SetRawTap.
+	PrinterRawTap io.Writer
+
+	// KeyboardRawTap can be left nil. If populated, it receives a copy of every byte the keyboard
+	// writes to the connection, after encoding and compression. It is written to while the
+	// keyboard is writing, and errors it returns are ignored. It can be changed later with
+	// TelnetKeyboard.SetRawTap. If the same writer is used as PrinterRawTap, it must be safe for
+	// concurrent use.
+	KeyboardRawTap io.Writer
 }
@@ -0,0 +1,322 @@
+package telnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultConformanceTimeout is how long each conformance check waits for the remote to respond
+// if ConformanceConfig.Timeout is not set
+const DefaultConformanceTimeout = 5 * time.Second
+
+const (
+	// The option used to probe subnegotiation framing. It isn't assigned to anything, so the
+	// remote should ignore it.
+	conformanceProbeOption TelOptCode = 174
+	timingMarkOption       TelOptCode = 6
+	echoOption             TelOptCode = 1
+)
+
+// ConformanceResult is the outcome of a single conformance check
+type ConformanceResult byte
+
+const (
+	ConformancePassed ConformanceResult = iota
+	ConformanceFailed
+	// ConformanceSkipped indicates that the check couldn't be run against this remote, such as
+	// an echo check against a remote that doesn't echo
+	ConformanceSkipped
+)
+
+func (r ConformanceResult) String() string {
+	switch r {
+	case ConformancePassed:
+		return "Passed"
+	case ConformanceFailed:
+		return "Failed"
+	case ConformanceSkipped:
+		return "Skipped"
+	default:
+		return "Unknown"
+	}
+}
+
+// ConformanceCheck is the result of one of the checks run by Terminal.CheckConformance
+type ConformanceCheck struct {
+	Name   string
+	Result ConformanceResult
+	// Detail describes what the remote did
+	Detail string
+	// Elapsed is how long the check took to run
+	Elapsed time.Duration
+}
+
+func (c ConformanceCheck) String() string {
+	return fmt.Sprintf("%s: %s (%s)", c.Name, c.Result, c.Detail)
+}
+
+// ConformanceReport is the structured result of Terminal.CheckConformance
+type ConformanceReport struct {
+	Checks []ConformanceCheck
+}
+
+// Passed returns true if no checks failed. Skipped checks don't count as failures.
+func (r ConformanceReport) Passed() bool {
+	for _, check := range r.Checks {
+		if check.Result == ConformanceFailed {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (r ConformanceReport) String() string {
+	lines := make([]string, 0, len(r.Checks))
+	for _, check := range r.Checks {
+		lines = append(lines, check.String())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+type ConformanceConfig struct {
+	// Timeout is how long each check waits for the remote to respond. The default is
+	// DefaultConformanceTimeout.
+	Timeout time.Duration
+}
+
+// conformanceProbe collects the printer's output while checks are running
+type conformanceProbe struct {
+	terminal *Terminal
+	timeout  time.Duration
+	output   chan TerminalData
+}
+
+// errConformanceTimeout is returned from conformanceProbe.await when the remote didn't respond
+var errConformanceTimeout = errors.New("timed out")
+
+// await waits for printer output that match returns true for. Output that doesn't match is
+// passed to skipped, if it isn't nil.
+func (p *conformanceProbe) await(ctx context.Context, match func(data TerminalData) bool, skipped func(data TerminalData)) (TerminalData, error) {
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case data := <-p.output:
+			if match(data) {
+				return data, nil
+			}
+
+			if skipped != nil {
+				skipped(data)
+			}
+		case <-timer.C:
+			return nil, errConformanceTimeout
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-p.terminal.ctx.Done():
+			return nil, p.terminal.ctx.Err()
+		}
+	}
+}
+
+// sendTimingMark sends DO TIMING-MARK and waits for the remote's WILL or WONT
+func (p *conformanceProbe) sendTimingMark(ctx context.Context, skipped func(data TerminalData)) (Command, error) {
+	p.terminal.keyboard.WriteCommand(Command{OpCode: DO, Option: timingMarkOption}, nil)
+
+	data, err := p.await(ctx, func(data TerminalData) bool {
+		command, isCommand := data.(CommandData)
+		return isCommand && command.Option == timingMarkOption &&
+			(command.OpCode == WILL || command.OpCode == WONT)
+	}, skipped)
+	if err != nil {
+		return Command{}, err
+	}
+
+	return data.(CommandData).Command, nil
+}
+
+// CheckConformance runs a battery of live checks against the remote and reports how it
+// behaved. This is useful for validating that a server or client stack handles the parts
+// of the protocol that are easy to get wrong. The checks are:
+//
+//   - TIMING-MARK: the remote answers DO TIMING-MARK with WILL or WONT (RFC 860)
+//   - SB framing: after a subnegotiation for an unknown option containing IAC IAC and SE bytes,
+//     the remote still answers DO TIMING-MARK, and none of the subnegotiation leaks out as text
+//   - IAC IAC echo: when the remote is echoing, text containing the byte 255 (sent as IAC IAC)
+//     is echoed back as that character. This is skipped if the remote isn't echoing, or if the
+//     keyboard's charset can't produce the byte 255.
+//
+// The checks send data to the remote, including some text if the remote is echoing, so they
+// shouldn't be run in the middle of a session. The checks run one at a time, and each waits
+// up to ConformanceConfig.Timeout for the remote to respond. An error is only returned if ctx
+// is cancelled or the terminal shuts down, along with the checks completed so far.
+func (t *Terminal) CheckConformance(ctx context.Context, config ConformanceConfig) (ConformanceReport, error) {
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = DefaultConformanceTimeout
+	}
+
+	probe := &conformanceProbe{
+		terminal: t,
+		timeout:  timeout,
+		output:   make(chan TerminalData, 256),
+	}
+
+	subscription := t.RegisterPrinterOutputHook(func(t *Terminal, output TerminalData) {
+		select {
+		case probe.output <- output:
+		default:
+			// The checks only care about a few pieces of output, so dropping some when the
+			// remote is chatty is fine
+		}
+	})
+	defer subscription.Unregister()
+
+	var report ConformanceReport
+	checks := []func(ctx context.Context, probe *conformanceProbe) (ConformanceCheck, error){
+		checkTimingMark,
+		checkSubnegotiationFraming,
+		checkIACEcho,
+	}
+
+	for _, check := range checks {
+		start := time.Now()
+		result, err := check(ctx, probe)
+		if err != nil {
+			return report, err
+		}
+
+		result.Elapsed = time.Since(start)
+		report.Checks = append(report.Checks, result)
+	}
+
+	return report, nil
+}
+
+// conformanceFailure builds a failed check from an await error, or returns the error if it
+// means the checks can't continue
+func conformanceFailure(name string, err error, detail string) (ConformanceCheck, error) {
+	if !errors.Is(err, errConformanceTimeout) {
+		return ConformanceCheck{}, err
+	}
+
+	return ConformanceCheck{Name: name, Result: ConformanceFailed, Detail: detail}, nil
+}
+
+func checkTimingMark(ctx context.Context, probe *conformanceProbe) (ConformanceCheck, error) {
+	const name = "TIMING-MARK"
+
+	command, err := probe.sendTimingMark(ctx, nil)
+	if err != nil {
+		return conformanceFailure(name, err, "no response to DO TIMING-MARK")
+	}
+
+	return ConformanceCheck{
+		Name:   name,
+		Result: ConformancePassed,
+		Detail: "answered DO TIMING-MARK with " + commandCodes[command.OpCode],
+	}, nil
+}
+
+func checkSubnegotiationFraming(ctx context.Context, probe *conformanceProbe) (ConformanceCheck, error) {
+	const name = "SB framing"
+
+	// If the remote doesn't treat IAC IAC as data, it will see IAC SE early, and either
+	// treat the rest as text or choke on the stray SE
+	probe.terminal.keyboard.WriteCommand(Command{
+		OpCode:         SB,
+		Option:         conformanceProbeOption,
+		Subnegotiation: []byte{'s', 'b', IAC, SE, 'l', 'e', 'a', 'k', SE, IAC},
+	}, nil)
+
+	var leaked bool
+	_, err := probe.sendTimingMark(ctx, func(data TerminalData) {
+		text, isText := data.(TextData)
+		if isText && strings.Contains(string(text), "leak") {
+			leaked = true
+		}
+	})
+	if err != nil {
+		return conformanceFailure(name, err, "no response to DO TIMING-MARK after subnegotiation")
+	}
+
+	if leaked {
+		return ConformanceCheck{
+			Name:   name,
+			Result: ConformanceFailed,
+			Detail: "subnegotiation contents were echoed back as text",
+		}, nil
+	}
+
+	return ConformanceCheck{
+		Name:   name,
+		Result: ConformancePassed,
+		Detail: "subnegotiation was ignored",
+	}, nil
+}
+
+// iacText returns text that the keyboard's charset encodes as the byte 255, if there is any
+func iacText(charset *Charset) (string, bool) {
+	// ÿ in the Latin charsets, non-breaking space in CP437
+	for _, candidate := range []string{"\u00ff", "\u00a0"} {
+		encoded, err := charset.Encode(candidate)
+		if err == nil && len(encoded) == 1 && encoded[0] == IAC {
+			return candidate, true
+		}
+	}
+
+	return "", false
+}
+
+func checkIACEcho(ctx context.Context, probe *conformanceProbe) (ConformanceCheck, error) {
+	const name = "IAC IAC echo"
+
+	echo, hasEcho := probe.terminal.telOpt(echoOption)
+	if !hasEcho || echo.RemoteState() != TelOptActive {
+		return ConformanceCheck{Name: name, Result: ConformanceSkipped, Detail: "remote is not echoing"}, nil
+	}
+
+	text, canEncode := iacText(probe.terminal.Charset())
+	if !canEncode {
+		return ConformanceCheck{
+			Name:   name,
+			Result: ConformanceSkipped,
+			Detail: fmt.Sprintf("charset %s can't produce the byte 255", probe.terminal.Charset().EncodingName()),
+		}, nil
+	}
+
+	probe.terminal.keyboard.WriteString(text)
+
+	var received strings.Builder
+	_, err := probe.await(ctx, func(data TerminalData) bool {
+		echoed, isText := data.(TextData)
+		if !isText {
+			return false
+		}
+
+		received.WriteString(string(echoed))
+		return strings.Contains(received.String(), text)
+	}, nil)
+	if err != nil {
+		if received.Len() > 0 {
+			return conformanceFailure(name, err, fmt.Sprintf("echoed %q instead of %q", received.String(), text))
+		}
+
+		return conformanceFailure(name, err, "nothing was echoed")
+	}
+
+	// Erase the probe from the remote's input
+	probe.terminal.keyboard.WriteString("\b")
+
+	return ConformanceCheck{
+		Name:   name,
+		Result: ConformancePassed,
+		Detail: "byte 255 was echoed correctly",
+	}, nil
+}
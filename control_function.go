@@ -0,0 +1,63 @@
+package telnet
+
+import "fmt"
+
+// ControlFunction is one of the standard control functions from RFC 854, which either side
+// can send as a two-byte command (IAC IP, IAC AO, and so on), or the Data Mark that ends a
+// Synch. They are received as CommandData, and CommandData.ControlFunction identifies them.
+type ControlFunction byte
+
+const (
+	// ControlFunctionDataMark is IAC DM, which marks the end of a Synch
+	ControlFunctionDataMark = ControlFunction(DATAMARK)
+	// ControlFunctionBreak is IAC BRK, the Break or Attention key
+	ControlFunctionBreak = ControlFunction(BRK)
+	// ControlFunctionInterruptProcess is IAC IP, a request to interrupt the running process
+	ControlFunctionInterruptProcess = ControlFunction(IP)
+	// ControlFunctionAbortOutput is IAC AO, a request to stop sending the output being produced
+	ControlFunctionAbortOutput = ControlFunction(AO)
+	// ControlFunctionAreYouThere is IAC AYT, a request for some visible sign that the remote is
+	// still there. The terminal answers it automatically.
+	ControlFunctionAreYouThere = ControlFunction(AYT)
+	// ControlFunctionEraseCharacter is IAC EC, a request to delete the last character typed
+	ControlFunctionEraseCharacter = ControlFunction(EC)
+	// ControlFunctionEraseLine is IAC EL, a request to delete the line being typed
+	ControlFunctionEraseLine = ControlFunction(EL)
+)
+
+func (f ControlFunction) String() string {
+	switch f {
+	case ControlFunctionDataMark:
+		return "DataMark"
+	case ControlFunctionBreak:
+		return "Break"
+	case ControlFunctionInterruptProcess:
+		return "InterruptProcess"
+	case ControlFunctionAbortOutput:
+		return "AbortOutput"
+	case ControlFunctionAreYouThere:
+		return "AreYouThere"
+	case ControlFunctionEraseCharacter:
+		return "EraseCharacter"
+	case ControlFunctionEraseLine:
+		return "EraseLine"
+	default:
+		return fmt.Sprintf("ControlFunction(%d)", byte(f))
+	}
+}
+
+// Command returns the command that sends this control function, which can be written with
+// TelnetKeyboard.WriteCommand
+func (f ControlFunction) Command() Command {
+	return Command{OpCode: byte(f)}
+}
+
+// ControlFunction returns the control function this command represents, if it is IAC DM, BRK,
+// IP, AO, AYT, EC, or EL
+func (o CommandData) ControlFunction() (ControlFunction, bool) {
+	if o.OpCode < DATAMARK || o.OpCode > EL {
+		return 0, false
+	}
+
+	return ControlFunction(o.OpCode), true
+}
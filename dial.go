@@ -0,0 +1,145 @@
+package telnet
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// DefaultPort is the port used by DialURL when the URL doesn't specify one
+const DefaultPort = 23
+
+// Dial connects to the telnet server at address, a host:port pair, and creates a client terminal
+// for the connection. Before the terminal is created, config is adjusted with any quirks that have
+// been registered for the address in DefaultQuirks. If config.Side is not set, it is set to SideClient.
+func Dial(ctx context.Context, address string, config TerminalConfig) (*Terminal, error) {
+	return dial(ctx, address, DefaultQuirks.Apply(address, config))
+}
+
+// DialURL works like Dial, but accepts a telnet URL as described in RFC 4248, such as
+// "telnet://example.com:4000". If the URL has no port, DefaultPort is used.
+//
+// The URL's query can provide hints that adjust config, which are applied after quirks:
+//
+//   - charset: replaces TerminalConfig.DefaultCharsetName
+//   - fallback: replaces TerminalConfig.FallbackCharsetName
+//   - charsetusage: "binary" or "always", replaces TerminalConfig.CharsetUsage
+//   - disable: a comma-separated list of telopts to remove from TerminalConfig.TelOpts, by code
+//     or by name (such as "3" or "SUPPRESS-GO-AHEAD")
+//
+// For instance, "telnet://example.com?charset=Big5&disable=EOR".
+func DialURL(ctx context.Context, rawURL string, config TerminalConfig) (*Terminal, error) {
+	address, hints, err := parseTelnetURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	config = DefaultQuirks.Apply(address, config)
+	return dial(ctx, address, hints.apply(config))
+}
+
+func dial(ctx context.Context, address string, config TerminalConfig) (*Terminal, error) {
+	if config.Side == SideUnknown {
+		config.Side = SideClient
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	terminal, err := NewTerminal(ctx, conn, config)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return terminal, nil
+}
+
+// parseTelnetURL returns the host:port address of a telnet URL and the quirks described by
+// its query hints
+func parseTelnetURL(rawURL string) (string, HostQuirks, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "", HostQuirks{}, err
+	}
+
+	if !strings.EqualFold(parsed.Scheme, "telnet") {
+		return "", HostQuirks{}, fmt.Errorf("telnet url has unsupported scheme %q", parsed.Scheme)
+	}
+
+	if parsed.Hostname() == "" {
+		return "", HostQuirks{}, fmt.Errorf("telnet url %q has no host", rawURL)
+	}
+
+	port := parsed.Port()
+	if port == "" {
+		port = strconv.Itoa(DefaultPort)
+	}
+
+	query := parsed.Query()
+	hints := HostQuirks{
+		DefaultCharsetName:  query.Get("charset"),
+		FallbackCharsetName: query.Get("fallback"),
+	}
+
+	usage := query.Get("charsetusage")
+	var disabled []string
+	for _, disable := range query["disable"] {
+		disabled = append(disabled, strings.Split(disable, ",")...)
+	}
+
+	if usage != "" || len(disabled) > 0 {
+		var charsetUsage CharsetUsage
+		switch strings.ToLower(usage) {
+		case "":
+		case "binary":
+			charsetUsage = CharsetUsageBinary
+		case "always":
+			charsetUsage = CharsetUsageAlways
+		default:
+			return "", HostQuirks{}, fmt.Errorf("telnet url has unknown charsetusage %q", usage)
+		}
+
+		hints.Modify = func(config *TerminalConfig) {
+			if usage != "" {
+				config.CharsetUsage = charsetUsage
+			}
+
+			config.TelOpts = disableTelOptsByName(config.TelOpts, disabled)
+		}
+	}
+
+	return net.JoinHostPort(parsed.Hostname(), port), hints, nil
+}
+
+// disableTelOptsByName returns the telopts that don't match any of the provided codes or names
+func disableTelOptsByName(options []TelnetOption, disabled []string) []TelnetOption {
+	if len(disabled) == 0 {
+		return options
+	}
+
+	var remaining []TelnetOption
+	for _, option := range options {
+		keep := true
+		for _, disable := range disabled {
+			disable = strings.TrimSpace(disable)
+			code, err := strconv.Atoi(disable)
+			if (err == nil && code == int(option.Code())) || strings.EqualFold(disable, option.String()) {
+				keep = false
+				break
+			}
+		}
+
+		if keep {
+			remaining = append(remaining, option)
+		}
+	}
+
+	return remaining
+}
@@ -0,0 +1,114 @@
+package telnet
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+)
+
+// Errors delivered via the EncounteredError hook (and returned by the terminal's methods) can be
+// categorized with errors.Is using the following values. The original error is preserved, so
+// errors.Is and errors.As continue to work for underlying errors as well.
+var (
+	// ErrProtocol indicates that the remote sent data that violates the telnet protocol or the
+	// rules of a telopt, such as a malformed command or subnegotiation
+	ErrProtocol = errors.New("telnet protocol violation")
+	// ErrCharset indicates that text could not be encoded or decoded, or that a character set
+	// could not be loaded
+	ErrCharset = errors.New("charset failure")
+	// ErrPeerClosed indicates that the remote closed or reset the connection
+	ErrPeerClosed = errors.New("peer closed the connection")
+)
+
+// categorizedError attaches one of the above categories to an error without changing its message
+type categorizedError struct {
+	category error
+	err      error
+}
+
+func (e *categorizedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *categorizedError) Unwrap() []error {
+	return []error{e.category, e.err}
+}
+
+func categorize(category error, err error) error {
+	if err == nil || errors.Is(err, category) {
+		return err
+	}
+
+	return &categorizedError{category: category, err: err}
+}
+
+// ProtocolErrorf creates an error that matches ErrProtocol with errors.Is. Telopts should use this
+// when the remote sends a malformed subnegotiation.
+func ProtocolErrorf(format string, args ...any) error {
+	return categorize(ErrProtocol, fmt.Errorf(format, args...))
+}
+
+// connectionError categorizes errors from reading or writing the connection that indicate the remote
+// has gone away
+func connectionError(err error) error {
+	if errors.Is(err, io.ErrClosedPipe) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return categorize(ErrPeerClosed, err)
+	}
+
+	return err
+}
+
+// TelOptError wraps an error produced by a telopt, whether while processing a subnegotiation or
+// changing state. errors.Is can be used to match a TelOptError for a particular telopt:
+//
+//	errors.Is(err, &telnet.TelOptError{Code: 31})
+type TelOptError struct {
+	Code TelOptCode
+	Err  error
+}
+
+func (e *TelOptError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TelOptError) Unwrap() error {
+	return e.Err
+}
+
+func (e *TelOptError) Is(target error) bool {
+	telOptErr, isTelOptErr := target.(*TelOptError)
+	return isTelOptErr && telOptErr.Code == e.Code
+}
+
+func telOptError(code TelOptCode, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var telOptErr *TelOptError
+	if errors.As(err, &telOptErr) && telOptErr.Code == code {
+		return err
+	}
+
+	return &TelOptError{Code: code, Err: err}
+}
+
+// SubnegotiationRejectedError is delivered via the EncounteredError hook when a telopt's
+// SubnegotiationValidator rejects a subnegotiation sent by the remote. The subnegotiation is
+// not passed to the telopt. It matches ErrProtocol with errors.Is, as well as the error returned
+// by the validator.
+type SubnegotiationRejectedError struct {
+	Option         TelnetOption
+	Subnegotiation []byte
+	Err            error
+}
+
+func (e *SubnegotiationRejectedError) Error() string {
+	return fmt.Sprintf("telopt %s: rejected subnegotiation %+v: %s", e.Option, e.Subnegotiation, e.Err)
+}
+
+func (e *SubnegotiationRejectedError) Unwrap() []error {
+	return []error{ErrProtocol, e.Err}
+}
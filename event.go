@@ -11,24 +11,50 @@ const (
 	eventError
 	eventPrinterOutput
 	eventOutboundData
+	eventTelOpt
+	eventNegotiationComplete
 )
 
 type eventsTransport struct {
-	eventType eventType
-	err       error
-	output    TerminalData
+	eventType           eventType
+	err                 error
+	output              TerminalData
+	telOpt              TelOptEvent
+	negotiationComplete NegotiationCompleteEvent
 }
 
 type terminalEventPump struct {
 	events   chan eventsTransport
 	complete chan bool
+	// stopped is closed once the terminal loop begins to shut down. The event channels are
+	// never closed, because telopt events can be raised from any goroutine, at any time.
+	stopped chan struct{}
+
+	// printerEvents is only populated when printer output is being dispatched on
+	// its own goroutine
+	printerEvents   chan eventsTransport
+	printerComplete chan struct{}
 }
 
-func newEventPump() *terminalEventPump {
-	return &terminalEventPump{
-		events:   make(chan eventsTransport, 100),
+const defaultEventQueueSize = 100
+
+func newEventPump(queueSize int, printerQueueSize int) *terminalEventPump {
+	if queueSize <= 0 {
+		queueSize = defaultEventQueueSize
+	}
+
+	pump := &terminalEventPump{
+		events:   make(chan eventsTransport, queueSize),
 		complete: make(chan bool, 1),
+		stopped:  make(chan struct{}),
 	}
+
+	if printerQueueSize > 0 {
+		pump.printerEvents = make(chan eventsTransport, printerQueueSize)
+		pump.printerComplete = make(chan struct{})
+	}
+
+	return pump
 }
 
 func (p *terminalEventPump) processEvent(terminal *Terminal, event eventsTransport) {
@@ -36,27 +62,78 @@ func (p *terminalEventPump) processEvent(terminal *Terminal, event eventsTranspo
 	case eventError:
 		terminal.encounteredError(event.err)
 	case eventPrinterOutput:
-		terminal.encounteredPrinterOutput(event.output)
+		// Hooks recover from their own panics, but middlewares run outside of them
+		err := terminal.callRecovering(func() error {
+			terminal.encounteredPrinterOutput(event.output)
+			return nil
+		})
+		if err != nil {
+			terminal.encounteredError(err)
+		}
 	case eventOutboundData:
 		terminal.encounteredOutboundData(event.output)
+	case eventTelOpt:
+		terminal.queuedTelOptEventHooks.Fire(terminal, event.telOpt)
+	case eventNegotiationComplete:
+		terminal.negotiationCompleteHooks.Fire(terminal, event.negotiationComplete)
+		terminal.raiseLifecycleEvent(LifecycleEvent{
+			Phase:             LifecycleNegotiationSettled,
+			NegotiationReason: event.negotiationComplete.Reason,
+		})
 	default:
 		panic("invalid event")
 	}
 }
 
 func (p *terminalEventPump) loopCleanup(terminal *Terminal) {
-	close(p.events)
+	close(p.stopped)
+
+	p.drain(terminal, p.events)
 
-	for ev := range p.events {
-		p.processEvent(terminal, ev)
+	if p.printerEvents != nil {
+		<-p.printerComplete
 	}
 
+	terminal.closed()
 	p.complete <- true
 }
 
+// drain processes whatever events are waiting in the channel without waiting for more
+func (p *terminalEventPump) drain(terminal *Terminal, events chan eventsTransport) {
+	for {
+		select {
+		case ev := <-events:
+			p.processEvent(terminal, ev)
+		default:
+			return
+		}
+	}
+}
+
+// printerLoop is used to dispatch printer output when the terminal has been configured to
+// deliver printer output on its own goroutine. It runs until the terminal loop stops, and then
+// drains the printerEvents channel.
+func (p *terminalEventPump) printerLoop(terminal *Terminal) {
+	defer close(p.printerComplete)
+
+	for {
+		select {
+		case ev := <-p.printerEvents:
+			p.processEvent(terminal, ev)
+		case <-p.stopped:
+			p.drain(terminal, p.printerEvents)
+			return
+		}
+	}
+}
+
 func (p *terminalEventPump) TerminalLoop(ctx context.Context, terminal *Terminal) {
 	defer p.loopCleanup(terminal)
 
+	if p.printerEvents != nil {
+		go p.printerLoop(terminal)
+	}
+
 	for {
 		select {
 		case ev := <-p.events:
@@ -72,23 +149,70 @@ func (p *terminalEventPump) WaitForExit() {
 	p.complete <- true
 }
 
+// send queues an event, unless the terminal loop has stopped, in which case the event is dropped
+func (p *terminalEventPump) send(events chan eventsTransport, event eventsTransport) {
+	select {
+	case <-p.stopped:
+		return
+	default:
+	}
+
+	select {
+	case events <- event:
+	case <-p.stopped:
+	}
+}
+
 func (p *terminalEventPump) EncounteredError(err error) {
-	p.events <- eventsTransport{
+	p.send(p.events, eventsTransport{
 		eventType: eventError,
 		err:       err,
-	}
+	})
 }
 
 func (p *terminalEventPump) EncounteredPrinterOutput(output TerminalData) {
-	p.events <- eventsTransport{
+	event := eventsTransport{
 		eventType: eventPrinterOutput,
 		output:    output,
 	}
+
+	if p.printerEvents != nil {
+		p.send(p.printerEvents, event)
+		return
+	}
+
+	p.send(p.events, event)
 }
 
 func (p *terminalEventPump) EncounteredOutboundData(output TerminalData) {
-	p.events <- eventsTransport{
+	p.send(p.events, eventsTransport{
 		eventType: eventOutboundData,
 		output:    output,
+	})
+}
+
+// EncounteredNegotiationComplete queues a NegotiationCompleteEvent, so that NegotiationComplete
+// hooks run on the terminal loop rather than on whichever goroutine noticed that negotiation
+// completed
+func (p *terminalEventPump) EncounteredNegotiationComplete(event NegotiationCompleteEvent) {
+	p.send(p.events, eventsTransport{
+		eventType:           eventNegotiationComplete,
+		negotiationComplete: event,
+	})
+}
+
+// EncounteredTelOptEvent queues a telopt event for the queued telopt event hooks. It travels the
+// same channel as printer output, so that it is delivered in order with it.
+func (p *terminalEventPump) EncounteredTelOptEvent(event TelOptEvent) {
+	transport := eventsTransport{
+		eventType: eventTelOpt,
+		telOpt:    event,
 	}
+
+	if p.printerEvents != nil {
+		p.send(p.printerEvents, transport)
+		return
+	}
+
+	p.send(p.events, transport)
 }
@@ -0,0 +1,237 @@
+package telnet
+
+import (
+	"context"
+	"io"
+	"testing"
+)
+
+// The fuzz targets in this file feed the parsers in this package data that arrives across several
+// reads. They fail when the code under test hands back data it wasn't given or stops making
+// progress. Inputs larger than fuzzMaxInputSize are skipped, so memory use is bounded.
+
+// fuzzMaxInputSize is the largest input that the fuzz targets will accept
+const fuzzMaxInputSize = 64 * 1024
+
+// fuzzCorpus is the seed corpus for the fuzz targets, covering text, commands, and escape
+// sequences, along with the awkward cases in between, such as commands and characters that are
+// cut off at the end of the input
+var fuzzCorpus = [][]byte{
+	[]byte("hello world\r\n"),
+	[]byte("line one\r\nline two\r\n> "),
+	{IAC, IAC},
+	[]byte("text\xff\xfftext"),
+	{IAC, GA},
+	{IAC, EOR},
+	{IAC, NOP},
+	{IAC, AYT},
+	{IAC, BRK},
+	{IAC},
+	[]byte("prompt> \xff"),
+	{IAC, WILL, 1},
+	{IAC, DO, 24},
+	{IAC, DONT, 31},
+	{IAC, WONT, 3},
+	{IAC, DO},
+	{IAC, SB, 24, 1, IAC, SE},
+	{IAC, SB, 24, 0, 'x', 't', 'e', 'r', 'm', IAC, SE},
+	{IAC, SB, 31, 0, IAC, IAC, 0, 24, IAC, SE},
+	{IAC, SB, 24, 1},
+	{IAC, SB, 24, 1, IAC},
+	{IAC, SB, IAC, SE},
+	{IAC, SE},
+	[]byte("\x1b[31mred\x1b[0m\r\n"),
+	[]byte("\x1b[1;38;5;208mcolor\x1b[m"),
+	[]byte("\x1b[38:2::255:0:0mtruecolor"),
+	[]byte("\x1b[2J\x1b[H"),
+	[]byte("\x1b["),
+	[]byte("\x1b[12;"),
+	[]byte("\x1b]0;title\x07"),
+	[]byte("\x1b]8;;http://example.com\x1b\\link\x1b]8;;\x1b\\"),
+	[]byte("\x1bP1$r0m\x1b\\"),
+	[]byte("\x1b_apc\x1b\\"),
+	[]byte("\x1b^pm\x1b\\"),
+	[]byte("\x1bXsos\x1b\\"),
+	[]byte("\x1b7\x1b8\x1bc"),
+	[]byte("\x9b31m\x9c"),
+	[]byte("\x07\x08\t\x0b\x0c"),
+	[]byte("caf\xc3\xa9 \xe2\x94\x80\xe2\x94\x80 \xf0\x9f\x98\x80"),
+	[]byte("\xe2\x94"),
+	[]byte("\xdb\xb1\xb0 cp437"),
+	[]byte("\xc3\xff\xf9\xa9"),
+	[]byte("\x1b[3\xff\xf91m"),
+	[]byte("text\xff\xfd\x18more\xff\xfa\x18\x01\xff\xf0\x1b[0m\xff\xf9"),
+}
+
+func addFuzzCorpus(f *testing.F) {
+	for _, seed := range fuzzCorpus {
+		f.Add(seed)
+	}
+}
+
+// fuzzChunkSize uses the first byte of an input to decide how the input should be split up
+// when it is fed to a parser, so that the fuzzer can explore data arriving across several reads
+func fuzzChunkSize(data []byte) int {
+	if len(data) == 0 {
+		return 1
+	}
+
+	return 1 + int(data[0]%16)
+}
+
+// fuzzOutputLimit is the most output a parser could legitimately produce from the provided
+// input. Producing more than this means the parser is stuck.
+func fuzzOutputLimit(data []byte) int {
+	return 4*len(data) + 16
+}
+
+// FuzzParseCommand feeds data to the parser used for commands received from the remote
+func FuzzParseCommand(f *testing.F) {
+	addFuzzCorpus(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if len(data) > fuzzMaxInputSize {
+			t.Skip()
+		}
+
+		command, err := parseCommand(data)
+		if err != nil {
+			return
+		}
+
+		if len(command.Subnegotiation) > len(data) {
+			t.Fatalf("parsed subnegotiation of %d bytes from %d byte command", len(command.Subnegotiation), len(data))
+		}
+	})
+}
+
+// FuzzScanTelnet feeds data to ScanTelnet the way bufio.Scanner would, checking that every token
+// comes from the data it was given and that the split function always makes progress at EOF
+func FuzzScanTelnet(f *testing.F) {
+	addFuzzCorpus(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if len(data) > fuzzMaxInputSize {
+			t.Skip()
+		}
+
+		scanner := NewTelnetScanner(nil, nil)
+		chunkSize := fuzzChunkSize(data)
+		available := 0
+		start := 0
+
+		for iterations := 0; start < len(data); iterations++ {
+			if iterations > fuzzOutputLimit(data) {
+				t.Fatal("ScanTelnet did not make progress")
+			}
+
+			atEOF := available == len(data)
+			advance, token, err := scanner.ScanTelnet(data[start:available], atEOF)
+			if err != nil {
+				return
+			}
+
+			if advance < 0 || start+advance > available || len(token) > available-start {
+				t.Fatalf("ScanTelnet advanced %d with a %d byte token, but only %d bytes were available",
+					advance, len(token), available-start)
+			}
+
+			if advance == 0 && token == nil {
+				if atEOF {
+					t.Fatal("ScanTelnet requested more data at EOF")
+				}
+
+				// Deliver the next chunk
+				available = min(available+chunkSize, len(data))
+				continue
+			}
+
+			start += advance
+		}
+	})
+}
+
+// fuzzReader delivers data in chunks of a fixed size
+type fuzzReader struct {
+	data      []byte
+	chunkSize int
+}
+
+func (r *fuzzReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+
+	n := copy(p, r.data[:min(r.chunkSize, len(r.data))])
+	r.data = r.data[n:]
+
+	return n, nil
+}
+
+// FuzzTelnetScanner feeds data to a TelnetScanner through a reader that delivers it in small chunks,
+// and reads output until the scanner finishes
+func FuzzTelnetScanner(f *testing.F) {
+	addFuzzCorpus(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if len(data) > fuzzMaxInputSize {
+			t.Skip()
+		}
+
+		charset, err := NewCharset("UTF-8", "CP437-FULL", CharsetUsageAlways)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		scanner := NewTelnetScanner(charset, &fuzzReader{data: data, chunkSize: fuzzChunkSize(data)})
+
+		for outputs := 0; scanner.Scan(context.Background()); outputs++ {
+			if outputs > fuzzOutputLimit(data) {
+				t.Fatal("TelnetScanner did not make progress")
+			}
+		}
+	})
+}
+
+// FuzzTerminalDataParser feeds data to a TerminalDataParser in small chunks, reading all output after
+// each chunk, and then flushes any partial sequence that is left over
+func FuzzTerminalDataParser(f *testing.F) {
+	addFuzzCorpus(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if len(data) > fuzzMaxInputSize {
+			t.Skip()
+		}
+
+		parser := NewTerminalDataParser()
+		chunkSize := fuzzChunkSize(data)
+		outputs := 0
+
+		countOutput := func() {
+			outputs++
+			if outputs > fuzzOutputLimit(data) {
+				t.Fatal("TerminalDataParser did not make progress")
+			}
+		}
+
+		for start := 0; start < len(data); start += chunkSize {
+			chunk := data[start:min(start+chunkSize, len(data))]
+
+			for output := NextOutput(parser, chunk); output != nil; output = NextOutput(parser, "") {
+				countOutput()
+			}
+		}
+
+		for output := parser.FlushPartial(); output != nil; output = NextOutput(parser, "") {
+			countOutput()
+		}
+
+		for output := parser.Flush(); output != nil; output = parser.Flush() {
+			countOutput()
+		}
+
+		if parser.HasPartialSequence() {
+			t.Fatal("TerminalDataParser still had a partial sequence after being flushed")
+		}
+	})
+}
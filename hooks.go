@@ -1,48 +1,131 @@
 package telnet
 
-import "sync"
+import (
+	"context"
+	"slices"
+	"sync"
+)
 
 // EventHook is a type for function pointers that are registered to receive events
 type EventHook[T any] func(terminal *Terminal, data T)
 
+type registeredHook[U any] struct {
+	id   uint64
+	hook EventHook[U]
+}
+
 // EventPublisher is a type used to register and fire arbitrary events
 type EventPublisher[U any] struct {
 	lock sync.Mutex
 
-	registeredHooks []EventHook[U]
+	nextID uint64
+	// registeredHooks is replaced rather than modified whenever a hook is registered or
+	// unregistered, so that Fire can call hooks without holding the lock
+	registeredHooks []registeredHook[U]
+}
+
+// Subscription is returned when registering an event hook, and can be used to
+// unregister the hook when it is no longer needed
+type Subscription struct {
+	once       sync.Once
+	unregister func()
+}
+
+// Unregister removes the hook from its publisher, so that it will not receive any
+// events that are fired after this method returns. Calling this method more than
+// once has no effect. It is safe to call this method from within the hook itself.
+func (s *Subscription) Unregister() {
+	s.once.Do(s.unregister)
 }
 
 // NewPublisher creates a new EventPublisher for a particular EventHook. A slice of
 // hooks can be passed in- in which case the hooks will be registered to receive events
 // from the publisher.  Otherwise, nil can be passed in.
 func NewPublisher[U any, T ~func(terminal *Terminal, data U)](hooks []T) *EventPublisher[U] {
-	var convertedHooks []EventHook[U]
+	publisher := &EventPublisher[U]{}
 
 	for _, hook := range hooks {
-		convertedHooks = append(convertedHooks, EventHook[U](hook))
+		publisher.Register(EventHook[U](hook))
+	}
+
+	return publisher
+}
+
+// Register registers a single EventHook to receive events from this publisher. The returned
+// Subscription can be used to unregister the hook.
+func (e *EventPublisher[U]) Register(hook EventHook[U]) *Subscription {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	id := e.nextID
+	e.nextID++
+
+	hooks := make([]registeredHook[U], 0, len(e.registeredHooks)+1)
+	hooks = append(hooks, e.registeredHooks...)
+	e.registeredHooks = append(hooks, registeredHook[U]{id: id, hook: hook})
+
+	return &Subscription{
+		unregister: func() {
+			e.unregister(id)
+		},
 	}
+}
 
-	return &EventPublisher[U]{
-		registeredHooks: convertedHooks,
+func (e *EventPublisher[U]) unregister(id uint64) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	index := slices.IndexFunc(e.registeredHooks, func(registered registeredHook[U]) bool {
+		return registered.id == id
+	})
+	if index < 0 {
+		return
 	}
+
+	e.registeredHooks = slices.Delete(slices.Clone(e.registeredHooks), index, index+1)
 }
 
-// Register registers a single EventHook to receive events from this publisher.
-func (e *EventPublisher[U]) Register(hook EventHook[U]) {
+func (e *EventPublisher[U]) empty() bool {
 	e.lock.Lock()
 	defer e.lock.Unlock()
 
-	e.registeredHooks = append(e.registeredHooks, hook)
+	return len(e.registeredHooks) == 0
 }
 
 // Fire calls the event for all EventHook instances registered to this publisher with
-// the provided parameters
+// the provided parameters. Hooks are called in the order they were registered.
+//
+// Fire does not serialize calls: if it is called from several goroutines at once, the same
+// hook may run concurrently with itself, so hooks that keep state must synchronize it. Hooks
+// may fire events, including on this publisher, and register or unregister hooks.
+//
+// If a hook panics, the panic is recovered and delivered to the terminal's EncounteredError
+// hooks as a *PanicError, and the remaining hooks are still called. This does not happen if
+// the terminal was configured with TerminalConfig.FailFastOnPanic, or if terminal is nil.
 func (e *EventPublisher[U]) Fire(terminal *Terminal, eventData U) {
 	e.lock.Lock()
-	defer e.lock.Unlock()
+	hooks := e.registeredHooks
+	e.lock.Unlock()
+
+	for _, registered := range hooks {
+		err := terminal.callRecovering(func() error {
+			registered.hook(terminal, eventData)
+			return nil
+		})
+
+		if err != nil {
+			terminal.hookPanicked(err, eventData)
+		}
+	}
+}
+
+// ContextEventHook is a type for function pointers that are registered to receive events
+// along with the terminal's context, which is cancelled when the terminal shuts down.
+type ContextEventHook[T any] func(ctx context.Context, terminal *Terminal, data T)
 
-	for _, hook := range e.registeredHooks {
-		hook(terminal, eventData)
+func withContext[U any, T ~func(ctx context.Context, terminal *Terminal, data U)](terminal *Terminal, hook T) EventHook[U] {
+	return func(t *Terminal, data U) {
+		hook(terminal.Context(), t, data)
 	}
 }
 
@@ -56,6 +139,72 @@ type TerminalDataHandler func(t *Terminal, output TerminalData)
 // with Terminal.RaiseTelOptEvent
 type TelOptEventHandler func(t *Terminal, event TelOptEvent)
 
+// TelOptEventDelivery indicates when a telopt event hook is called, relative to the printer
+type TelOptEventDelivery int
+
+const (
+	// TelOptEventSynchronous hooks are called on the goroutine that raised the event, before
+	// RaiseTelOptEvent returns. Most telopt events are raised by the printer while it processes a
+	// command, so the printer will not read any further input until these hooks return. Hooks that
+	// must take effect before the next text is processed, such as applying a charset change, should
+	// use this delivery. Slow hooks will hold up the printer.
+	TelOptEventSynchronous TelOptEventDelivery = iota
+	// TelOptEventQueued hooks are called on the goroutine that delivers printer output, in order
+	// with it: printer output that was received before the event is delivered first. The printer
+	// does not wait for these hooks. Events raised after the terminal has shut down are not delivered.
+	TelOptEventQueued
+)
+
+// NegotiationCompleteHandler is an event hook type that is called once the initial telopt
+// negotiation with the remote has settled
+type NegotiationCompleteHandler func(t *Terminal, event NegotiationCompleteEvent)
+
+// PromptCommandsChangedHandler is an event hook type that is called when the prompt commands used by
+// the keyboard or printer change
+type PromptCommandsChangedHandler func(t *Terminal, event PromptCommandsChangedEvent)
+
+// LifecycleHandler is an event hook type that is called when the terminal enters a new phase
+// of its session
+type LifecycleHandler func(t *Terminal, event LifecycleEvent)
+
+// AYTReceivedHandler is an event hook type that is called when the remote sends IAC AYT
+type AYTReceivedHandler func(t *Terminal, event AYTReceivedEvent)
+
+// UTF16DetectedHandler is an event hook type that is called when the printer detects that the
+// remote is sending UTF-16
+type UTF16DetectedHandler func(t *Terminal, event UTF16DetectedEvent)
+
+// ContextErrorHandler is an event hook type that receives errors along with the terminal's context
+type ContextErrorHandler func(ctx context.Context, t *Terminal, err error)
+
+// ContextTerminalDataHandler is an event hook type that receives text, control codes, escape sequences,
+// and commands from the printer along with the terminal's context
+type ContextTerminalDataHandler func(ctx context.Context, t *Terminal, output TerminalData)
+
+// ContextTelOptEventHandler is an event hook type that receives arbitrary events raised by telopts
+// along with the terminal's context
+type ContextTelOptEventHandler func(ctx context.Context, t *Terminal, event TelOptEvent)
+
+// ContextNegotiationCompleteHandler is an event hook type that is called once the initial telopt
+// negotiation with the remote has settled, along with the terminal's context
+type ContextNegotiationCompleteHandler func(ctx context.Context, t *Terminal, event NegotiationCompleteEvent)
+
+// ContextPromptCommandsChangedHandler is an event hook type that is called when the prompt commands
+// used by the keyboard or printer change, along with the terminal's context
+type ContextPromptCommandsChangedHandler func(ctx context.Context, t *Terminal, event PromptCommandsChangedEvent)
+
+// ContextLifecycleHandler is an event hook type that is called when the terminal enters a new
+// phase of its session, along with the terminal's context
+type ContextLifecycleHandler func(ctx context.Context, t *Terminal, event LifecycleEvent)
+
+// ContextAYTReceivedHandler is an event hook type that is called when the remote sends IAC AYT,
+// along with the terminal's context
+type ContextAYTReceivedHandler func(ctx context.Context, t *Terminal, event AYTReceivedEvent)
+
+// ContextUTF16DetectedHandler is an event hook type that is called when the printer detects that
+// the remote is sending UTF-16, along with the terminal's context
+type ContextUTF16DetectedHandler func(ctx context.Context, t *Terminal, event UTF16DetectedEvent)
+
 // EventHooks is used to pass in a set of pre-registered event hooks to a Terminal
 // when calling NewTerminal.  See TerminalConfig for more info.
 type EventHooks struct {
@@ -64,4 +213,18 @@ type EventHooks struct {
 	OutboundData     []TerminalDataHandler
 
 	TelOptEvent []TelOptEventHandler
+	// QueuedTelOptEvent hooks are registered with TelOptEventQueued delivery
+	QueuedTelOptEvent []TelOptEventHandler
+
+	NegotiationComplete []NegotiationCompleteHandler
+
+	PromptCommandsChanged []PromptCommandsChangedHandler
+
+	// Lifecycle hooks are the only way to receive LifecycleConnected and
+	// LifecycleNegotiationStarted, which are raised before NewTerminal returns
+	Lifecycle []LifecycleHandler
+
+	AYTReceived []AYTReceivedHandler
+
+	UTF16Detected []UTF16DetectedHandler
 }
@@ -1,46 +1,131 @@
 package telnet
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io"
+	"math/rand/v2"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 )
 
 type keyboardTransport struct {
 	unparsedString string
 	data           TerminalData
 	postSend       func() error
+	// charset, if not nil, is used to encode the text instead of the keyboard's current charset
+	charset *currentCharset
+	// afterText indicates a command written with WriteCommandAfterText
+	afterText bool
 }
 
 // TelnetKeyboard is a Terminal subsidiary that is in charge of sending outbound data
 // to the remote peer.
+//
+// The keyboard has two lanes. Text written with LineOut, WriteString, Write, and the like travels
+// in the text lane along with prompt hints, and is sent in the order it was written. Keyboard
+// locks and the rate limit hold text, and text written while earlier text is held is held behind
+// it. Prompt hints are not held by locks or the rate limit, but they are held behind held text,
+// since a prompt hint marks the end of the text written before it. Commands written with
+// WriteCommand, and data written with WriteUrgent, travel in the command lane: they are sent in
+// the order they were written, ahead of any text or prompt hints that are waiting, and are never
+// held. A command that must follow the text written before it can be written with
+// WriteCommandAfterText, which queues it in the text lane.
 type TelnetKeyboard struct {
 	terminal       *Terminal
 	charset        *Charset
 	baseStream     io.Writer
 	outputStream   io.Writer
-	input          chan keyboardTransport
+	commands       chan keyboardTransport
+	queue          *keyboardQueue
 	complete       chan bool
 	eventPump      *terminalEventPump
 	lock           *keyboardLock
+	rateLimiter    keyboardRateLimiter
 	promptCommands atomicPromptCommands
 	decoder        *keyboardDecoder
+	closed         atomic.Bool
+	// settled indicates that the initial negotiation has completed
+	settled      atomic.Bool
+	directWrites atomic.Bool
+	// directWriteFailed indicates that a direct write found the connection closed, so the loop
+	// should shut down as though it had failed the write itself
+	directWriteFailed atomic.Bool
+
+	lineTerminators atomic.Uint32
+	// pendingCR indicates that the most recent text written ended with a CR that has not yet been
+	// completed with LF or NUL. It and terminators are only used by the keyboard loop.
+	pendingCR   bool
+	terminators []TerminalData
+
+	// batch holds encoded data that has not yet been written to the output stream, and batchData
+	// holds the data it was encoded from. They are only used by the keyboard loop.
+	batch         []byte
+	batchData     []TerminalData
+	batchSize     int
+	batchInterval time.Duration
+
+	keepaliveInterval time.Duration
+	keepaliveJitter   time.Duration
+	keepaliveCommand  byte
+	idleRelease       time.Duration
+	// lastWrite is the UnixNano time of the most recent write to the output stream
+	lastWrite atomic.Int64
+	// stats.bytes counts the bytes written to the underlying connection, and streamBytes counts
+	// the bytes written to the output stream before any wrapper installed with WrapWriter
+	stats       directionStats
+	streamBytes atomic.Uint64
+	rawTap      rawTap
+
+	// pause is held by the keyboard loop at all times except while it is waiting for
+	// input, so holding it freezes the loop
+	pause sync.Mutex
+
+	// partialWrite is the end of the most recent Write, if it ended partway through a character
+	writeLock    sync.Mutex
+	partialWrite []byte
 }
 
-func newTelnetKeyboard(charset *Charset, output io.Writer, eventPump *terminalEventPump, middlewares ...Middleware) (*TelnetKeyboard, error) {
+func newTelnetKeyboard(charset *Charset, output io.Writer, eventPump *terminalEventPump, config TerminalConfig) (*TelnetKeyboard, error) {
+	keepaliveCommand := config.KeepaliveCommand
+	if keepaliveCommand == 0 {
+		keepaliveCommand = NOP
+	} else if keepaliveCommand != NOP && keepaliveCommand != AYT {
+		return nil, fmt.Errorf("keepalive command must be NOP or AYT, got %d", keepaliveCommand)
+	}
+
 	keyboard := &TelnetKeyboard{
-		charset:      charset,
-		baseStream:   output,
-		outputStream: output,
-		input:        make(chan keyboardTransport, 100),
-		complete:     make(chan bool, 1),
-		eventPump:    eventPump,
-		lock:         newKeyboardLock(),
-		decoder:      newKeyboardDecoder(middlewares...),
+		charset:   charset,
+		commands:  make(chan keyboardTransport, 100),
+		queue:     newKeyboardQueue(config.KeyboardQueueCapacity, config.KeyboardOverflowPolicy),
+		complete:  make(chan bool, 1),
+		eventPump: eventPump,
+		lock:      newKeyboardLock(),
+		decoder:   newKeyboardDecoder(config.KeyboardMiddlewares...),
+
+		keepaliveInterval: config.KeepaliveInterval,
+		keepaliveJitter:   config.KeepaliveJitter,
+		keepaliveCommand:  keepaliveCommand,
+		idleRelease:       config.IdleBufferRelease,
+		batchSize:         config.KeyboardBatchSize,
+		batchInterval:     config.KeyboardBatchInterval,
 	}
+	keyboard.baseStream = &countingWriter{
+		writer: &tapWriter{writer: output, tap: &keyboard.rawTap},
+		count:  &keyboard.stats.bytes,
+	}
+	keyboard.outputStream = keyboard.baseStream
+	keyboard.lastWrite.Store(time.Now().UnixNano())
 	keyboard.promptCommands.Init()
+	keyboard.rateLimiter.set(config.KeyboardRateLimit)
+	keyboard.lineTerminators.Store(uint32(config.LineTerminators))
+	keyboard.directWrites.Store(config.KeyboardDirectWrites)
+	keyboard.rawTap.set(config.KeyboardRawTap)
 
 	return keyboard, nil
 }
@@ -56,6 +141,14 @@ func (k *TelnetKeyboard) SetLock(lockName string, duration time.Duration) {
 	k.lock.SetLock(lockName, duration)
 }
 
+// SetLockCtx works like SetLock, but the lock is cleared as soon as ctx is done, so that locks
+// held for operations that are abandoned don't stall output until they expire. If ctx has a
+// deadline, the lock expires then. Otherwise, the lock lasts until ctx is done or the lock is
+// cleared with ClearLock. If ctx is already done, no lock is set.
+func (k *TelnetKeyboard) SetLockCtx(lockName string, ctx context.Context) {
+	k.lock.SetLockCtx(lockName, ctx)
+}
+
 // ClearLock will clear a named lock in order to end buffering (assuming there are no
 // other active locks) and immediately write buffered text.
 func (k *TelnetKeyboard) ClearLock(lockName string) {
@@ -69,102 +162,183 @@ func (k *TelnetKeyboard) HasActiveLock(lockName string) bool {
 
 func (k *TelnetKeyboard) writeOutput(b []byte) error {
 	for {
-		_, err := k.outputStream.Write(b)
+		n, err := k.outputStream.Write(b)
+		k.lastWrite.Store(time.Now().UnixNano())
+		if n > 0 {
+			k.streamBytes.Add(uint64(n))
+			k.stats.recordActivity()
+		}
 
-		// Retry when error is temporary
+		// Retry when error is temporary, picking up where the failed write left off
 		var netError net.Error
 		if errors.As(err, &netError) {
 			if netError.Temporary() {
+				b = b[n:]
 				continue
 			}
 		}
 
-		return err
+		return connectionError(err)
 	}
 }
 
-func (k *TelnetKeyboard) writeCommand(c Command) error {
-	// Don't send prompt commands that are being suppressed
-	promptCommands := k.promptCommands.Get()
-	if c.OpCode == GA && promptCommands&PromptCommandGA == 0 {
-		return nil
-	} else if c.OpCode == EOR && promptCommands&PromptCommandEOR == 0 {
+// flushOutput flushes the output stream if it is a WriteFlusher, so that everything written so far
+// has reached the underlying connection
+func (k *TelnetKeyboard) flushOutput() error {
+	flusher, isFlusher := k.outputStream.(WriteFlusher)
+	if !isFlusher {
 		return nil
 	}
 
-	size := 2
-	if c.OpCode != GA && c.OpCode != NOP && c.OpCode != EOR {
-		size++
+	return connectionError(flusher.Flush())
+}
+
+// isSuppressedPromptCommand indicates whether c is a prompt command that the keyboard won't send
+func (k *TelnetKeyboard) isSuppressedPromptCommand(c Command) bool {
+	promptCommands := k.promptCommands.Get()
+	return (c.OpCode == GA && promptCommands&PromptCommandGA == 0) ||
+		(c.OpCode == EOR && promptCommands&PromptCommandEOR == 0)
+}
+
+func (k *TelnetKeyboard) batchCommand(c Command) {
+	// Don't send prompt commands that are being suppressed
+	if k.isSuppressedPromptCommand(c) {
+		return
 	}
 
-	if c.OpCode == SB {
-		size += len(c.Subnegotiation)
-		size += 2
+	k.stats.recordCommand(c)
+	k.batch = append(k.batch, encodeCommand(c)...)
+}
+
+func (k *TelnetKeyboard) batchText(data TerminalData, charset *currentCharset) error {
+	b, err := k.encodeTextWith(data, charset)
+	if err != nil {
+		return err
 	}
 
-	b := make([]byte, 0, size)
-	b = append(b, IAC, c.OpCode)
+	k.batch = append(k.batch, b...)
+	return nil
+}
+
+func (k *TelnetKeyboard) encodeText(data TerminalData) ([]byte, error) {
+	return k.encodeTextWith(data, nil)
+}
 
-	if size > 2 {
-		b = append(b, byte(c.Option))
+// encodeTextWith encodes text with charset, or with the keyboard's current charset if charset is nil
+func (k *TelnetKeyboard) encodeTextWith(data TerminalData, charset *currentCharset) ([]byte, error) {
+	var b []byte
+	var err error
+	if charset != nil {
+		b, err = k.charset.encodeWith(charset, data.String())
+	} else {
+		b, err = k.charset.Encode(data.String())
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	if size > 3 {
-		b = append(b, c.Subnegotiation...)
-		b = append(b, IAC, SE)
+	// Some charsets (and binary mode) produce 0xFF, which must be doubled so that it isn't
+	// mistaken for IAC
+	if bytes.IndexByte(b, IAC) >= 0 {
+		escaped := make([]byte, 0, len(b)+bytes.Count(b, []byte{IAC}))
+		for _, textByte := range b {
+			escaped = append(escaped, textByte)
+			if textByte == IAC {
+				escaped = append(escaped, IAC)
+			}
+		}
+		b = escaped
 	}
 
-	return k.writeOutput(b)
+	return b, nil
 }
 
-func (k *TelnetKeyboard) writeText(data TerminalData) error {
-	b, err := k.charset.Encode(data.String())
-	if err != nil {
-		return err
+// promptCommand returns the command that the keyboard sends for a prompt hint, if any
+func (k *TelnetKeyboard) promptCommand() (Command, bool) {
+	prompts := k.promptCommands.Get()
+	if prompts&PromptCommandEOR != 0 {
+		return Command{OpCode: EOR}, true
+	} else if prompts&PromptCommandGA != 0 {
+		return Command{OpCode: GA}, true
 	}
 
-	return k.writeOutput(b)
+	return Command{}, false
+}
+
+// Encode returns the bytes that the keyboard would write to the connection for a single piece of
+// data, using the charset and prompt commands currently in effect. This is useful for recorders
+// and diffing tools that need to know exactly what was sent. Keyboard middlewares are not applied,
+// line terminators are not rewritten according to LineTerminators, and data that the keyboard
+// would not send, such as a suppressed IAC GA, encodes to nil. As with WriteString, 255 bytes in
+// encoded text are doubled.
+func (k *TelnetKeyboard) Encode(data TerminalData) ([]byte, error) {
+	switch d := data.(type) {
+	case CommandData:
+		if k.isSuppressedPromptCommand(d.Command) {
+			return nil, nil
+		}
+
+		return encodeCommand(d.Command), nil
+	case PromptData:
+		command, hasPrompt := k.promptCommand()
+		if !hasPrompt {
+			return nil, nil
+		}
+
+		return encodeCommand(command), nil
+	default:
+		return k.encodeText(d)
+	}
 }
 
 func (k *TelnetKeyboard) write(transport keyboardTransport) bool {
 	var err error
 
+	// Transports with nothing to write, such as Flush markers, only run their postSend
+	var decoded []TerminalData
 	if transport.data != nil {
 		k.decoder.Decode(k.terminal, transport.data)
+		decoded = k.normalizeLineTerminators(k.decoder.Decoded())
 	} else if transport.unparsedString != "" {
 		k.decoder.DecodeString(k.terminal, transport.unparsedString)
+		decoded = k.normalizeLineTerminators(k.decoder.Decoded())
 	}
 
-	decoded := k.decoder.Decoded()
 	for _, data := range decoded {
 		switch d := data.(type) {
 		case CommandData:
-			err = k.writeCommand(d.Command)
+			k.batchCommand(d.Command)
 		case PromptData:
-			prompts := k.promptCommands.Get()
-
-			if prompts&PromptCommandEOR != 0 {
-				transport.data = PromptData(PromptCommandEOR)
-				err = k.writeCommand(Command{
-					OpCode: EOR,
-				})
-			} else if prompts&PromptCommandGA != 0 {
-				transport.data = PromptData(PromptCommandGA)
-				err = k.writeCommand(Command{
-					OpCode: GA,
-				})
-			} else {
+			command, hasPrompt := k.promptCommand()
+			if !hasPrompt {
 				continue
 			}
+
+			k.batchCommand(command)
 		default:
-			err = k.writeText(d)
+			err = k.batchText(d, transport.charset)
 		}
 
 		if err != nil {
-			return k.handleError(err)
+			break
 		}
 
-		k.eventPump.EncounteredOutboundData(data)
+		k.batchData = append(k.batchData, data)
+	}
+
+	// The post-send event may change how future writes are encoded, or wrap the output stream,
+	// so everything before it must be written first
+	var flushErr error
+	if transport.postSend != nil || len(k.batch) >= k.batchSize {
+		flushErr = k.flushBatch()
+	}
+
+	if err == nil {
+		err = flushErr
+	}
+
+	if err != nil {
+		return k.handleError(err)
 	}
 
 	if transport.postSend != nil {
@@ -187,79 +361,291 @@ func (k *TelnetKeyboard) handleError(err error) bool {
 	return true
 }
 
+// keepaliveWait adds a random amount of jitter to the provided wait time, so that many
+// connections started at once don't all send keepalives in lockstep
+func (k *TelnetKeyboard) keepaliveWait(wait time.Duration) time.Duration {
+	if k.keepaliveJitter <= 0 {
+		return wait
+	}
+
+	return wait + rand.N(k.keepaliveJitter)
+}
+
+// keyboardCommandBurst is the number of commands that the keyboard will write in a row
+// while text is waiting before it gives text a turn
+const keyboardCommandBurst = 16
+
+// The keyboard has two lanes of input: commands and text.  Commands (which are mostly telopt
+// negotiations) have priority over text, so that negotiation replies don't get stuck behind
+// a large burst of queued text.  Text is only sent when no commands are waiting, except that
+// text is guaranteed a turn after every keyboardCommandBurst commands, so that a flood of
+// commands cannot starve it. Urgent data from WriteUrgent shares the command lane, so that it
+// can jump ahead of queued text and keyboard locks.
+//
+// Prompt hints and commands written with WriteCommandAfterText share the text lane, because
+// they mark a position relative to the text that was sent before them- it would be meaningless
+// if they could jump ahead of that text. Neither is held by locks or the rate limit. Prompt
+// hints wait behind held text, and commands written with WriteCommandAfterText wait behind held
+// text once the keyboard has unlocked, so that the text is released before them.
 func (k *TelnetKeyboard) keyboardLoop(ctx context.Context) {
-	queuedWrites := make([]keyboardTransport, 0, 50)
+	commandStreak := 0
+
+	// keepalive stays nil (and so never fires) when keepalives are disabled
+	var keepaliveTimer *time.Timer
+	var keepalive <-chan time.Time
+	if k.keepaliveInterval > 0 {
+		keepaliveTimer = time.NewTimer(k.keepaliveWait(k.keepaliveInterval))
+		defer keepaliveTimer.Stop()
+		keepalive = keepaliveTimer.C
+	}
+
+	var idleTimer *time.Timer
+	var idle <-chan time.Time
+	if k.idleRelease > 0 {
+		idleTimer = time.NewTimer(k.idleRelease)
+		defer idleTimer.Stop()
+		idle = idleTimer.C
+	}
+
+	crTimer := time.NewTimer(keyboardCRGrace)
+	crTimer.Stop()
+	defer crTimer.Stop()
+	crTimerRunning := false
+
+	batchTimer := time.NewTimer(time.Hour)
+	batchTimer.Stop()
+	defer batchTimer.Stop()
+	batchTimerRunning := false
+
+	k.pause.Lock()
+	defer k.pause.Unlock()
 
 keyboardLoop:
 	for {
+		// crGrace stays nil unless the last text written ended with a CR
+		var crGrace <-chan time.Time
+		if k.pendingCR {
+			if !crTimerRunning {
+				crTimer.Reset(keyboardCRGrace)
+				crTimerRunning = true
+			}
+			crGrace = crTimer.C
+		} else if crTimerRunning {
+			crTimer.Stop()
+			crTimerRunning = false
+		}
+
+		if k.directWriteFailed.Load() {
+			break keyboardLoop
+		}
+
+		// batchFlush stays nil unless KeyboardBatchInterval is holding data in the batch
+		var batchFlush <-chan time.Time
+		if k.batchInterval > 0 && k.batchPending() {
+			if !batchTimerRunning {
+				batchTimer.Reset(k.batchInterval)
+				batchTimerRunning = true
+			}
+			batchFlush = batchTimer.C
+		} else if batchTimerRunning {
+			batchTimer.Stop()
+			batchTimerRunning = false
+		}
+
+		if commandStreak < keyboardCommandBurst {
+			select {
+			case command := <-k.commands:
+				commandStreak++
+				if !k.write(command) {
+					break keyboardLoop
+				}
+
+				continue
+			default:
+			}
+		}
+
+		if !k.flushIdleBatch() {
+			break keyboardLoop
+		}
+
+		k.pause.Unlock()
 		select {
 		case <-ctx.Done():
+			k.pause.Lock()
 			break keyboardLoop
-		case input := <-k.input:
-			_, isCommand := input.data.(CommandData)
-			if isCommand {
-				if !k.write(input) {
+		case command := <-k.commands:
+			k.pause.Lock()
+			commandStreak++
+			if !k.write(command) {
+				break keyboardLoop
+			}
+		case <-k.queue.ready:
+			k.pause.Lock()
+			commandStreak = 0
+
+			input, hasInput := k.queue.next()
+			if !hasInput {
+				continue
+			}
+
+			switch input.data.(type) {
+			case CommandData:
+				if input.afterText && k.queue.hasHeld() && !k.lock.IsLocked() {
+					// The held text is about to be released, and it was written before the command
+					k.queue.hold(input)
+					continue
+				}
+
+				if !k.writeQueued(input) {
+					break keyboardLoop
+				}
+
+				continue
+			case PromptData:
+				if k.queue.hasHeld() {
+					k.queue.hold(input)
+					continue
+				}
+
+				if !k.writeQueued(input) {
 					break keyboardLoop
 				}
 
 				continue
 			}
 
-			if len(queuedWrites) > 0 || k.lock.IsLocked() {
+			if k.queue.hasHeld() || k.lock.IsLocked() || k.throttle(input) {
 				// We may have unlocked but the unlock handler hasn't actually
 				// run yet- we don't want this random bit of text to write out of
 				// order, so place it at the end of the queue if one exists
-				queuedWrites = append(queuedWrites, input)
+				k.queue.hold(input)
 				continue
 			}
 
-			if !k.write(input) {
+			// A command may have arrived while we were picking up this text
+			if !k.writeWaitingCommands(keyboardCommandBurst) || !k.writeQueued(input) {
 				break keyboardLoop
 			}
 
 		case <-k.lock.C:
+			k.pause.Lock()
+			commandStreak = 0
+
 			// Make sure the lock hasn't unlocked & relocked in the time we've been away
 			if !k.lock.IsLocked() {
-				// Write all queued text
-				for _, singleWrite := range queuedWrites {
-					if !k.write(singleWrite) {
+				// Commands that arrived alongside the unlock still go first
+				if !k.writeWaitingCommands(keyboardCommandBurst) {
+					break keyboardLoop
+				}
+
+				// Write all queued text, until the rate limit is reached
+				for {
+					singleWrite, hasHeld := k.queue.peekHeld()
+					if !hasHeld || k.throttle(singleWrite) {
+						break
+					}
+
+					// Text may have been dropped from the queue since we looked
+					singleWrite, _ = k.queue.nextHeld()
+
+					if !k.writeQueued(singleWrite) {
 						break keyboardLoop
 					}
 				}
+			}
+		case <-keepalive:
+			k.pause.Lock()
+
+			// The keepalive bypasses the keyboard lock, since it's a command
+			idle := time.Since(time.Unix(0, k.lastWrite.Load()))
+			if idle < k.keepaliveInterval {
+				keepaliveTimer.Reset(k.keepaliveWait(k.keepaliveInterval - idle))
+				continue
+			}
 
-				queuedWrites = queuedWrites[:0]
+			if !k.write(keyboardTransport{data: CommandData{Command: Command{OpCode: k.keepaliveCommand}}}) {
+				break keyboardLoop
+			}
+
+			keepaliveTimer.Reset(k.keepaliveWait(k.keepaliveInterval))
+		case <-idle:
+			k.pause.Lock()
+
+			idleTime := time.Since(time.Unix(0, k.lastWrite.Load()))
+			if idleTime < k.idleRelease {
+				idleTimer.Reset(k.idleRelease - idleTime)
+				continue
+			}
+
+			k.decoder.releaseBuffers()
+			k.queue.releaseBuffers()
+			k.terminators = nil
+			if !k.batchPending() {
+				k.batch = nil
+				k.batchData = nil
+			}
+
+			idleTimer.Reset(k.idleRelease)
+		case <-crGrace:
+			k.pause.Lock()
+			crTimerRunning = false
+
+			if !k.completePendingCR() {
+				break keyboardLoop
+			}
+		case <-batchFlush:
+			k.pause.Lock()
+			batchTimerRunning = false
+
+			if !k.handleError(k.flushBatch()) {
+				break keyboardLoop
 			}
 		}
 	}
 
-	// Try to flush any remaining text
+	// Try to flush any remaining commands & text
 	anyWriteFailed := false
-	if len(queuedWrites) > 0 && !k.lock.IsLocked() {
-		for _, singleWrite := range queuedWrites {
-			if !k.write(singleWrite) {
-				anyWriteFailed = true
-				break
-			}
+drainCommands:
+	for !anyWriteFailed {
+		select {
+		case command := <-k.commands:
+			anyWriteFailed = !k.write(command)
+		default:
+			break drainCommands
+		}
+	}
+
+	for !anyWriteFailed && !k.lock.IsLocked() {
+		singleWrite, hasHeld := k.queue.nextHeld()
+		if !hasHeld {
+			break
 		}
+
+		anyWriteFailed = !k.writeQueued(singleWrite)
 	}
 
 	for !anyWriteFailed {
-		select {
-		case input := <-k.input:
-			_, isCommand := input.data.(CommandData)
+		input, hasInput := k.queue.next()
+		if !hasInput {
+			// If we get to the end of the queue, we're done
+			break
+		}
 
-			if !k.lock.IsLocked() || isCommand {
-				if !k.write(input) {
-					anyWriteFailed = true
-					continue
-				}
-			}
-		default:
-			// If we get to the end of the channel, we're done
-			anyWriteFailed = true
+		_, isCommand := input.data.(CommandData)
+		if !k.lock.IsLocked() || isCommand {
+			anyWriteFailed = !k.writeQueued(input)
 		}
 	}
 
+	if !anyWriteFailed {
+		k.completePendingCR()
+		_ = k.flushBatch()
+	}
+
+	// Writers blocked on a full queue would otherwise wait forever
+	k.queue.close()
+
 	if ctx.Err() != nil && !errors.Is(ctx.Err(), context.Canceled) {
 		k.encounteredError(ctx.Err())
 	}
@@ -267,6 +653,30 @@ keyboardLoop:
 	k.complete <- true
 }
 
+// writeQueued writes a transport from the queue, and frees its space in the queue
+func (k *TelnetKeyboard) writeQueued(transport keyboardTransport) bool {
+	defer k.queue.written(transport)
+
+	return k.write(transport)
+}
+
+// writeWaitingCommands writes up to limit commands that are already waiting in the
+// command lane, without blocking. It returns false if a write failed.
+func (k *TelnetKeyboard) writeWaitingCommands(limit int) bool {
+	for i := 0; i < limit; i++ {
+		select {
+		case command := <-k.commands:
+			if !k.write(command) {
+				return false
+			}
+		default:
+			return true
+		}
+	}
+
+	return true
+}
+
 func (k *TelnetKeyboard) encounteredError(err error) {
 	k.eventPump.EncounteredError(err)
 }
@@ -276,25 +686,261 @@ func (k *TelnetKeyboard) encounteredError(err error) {
 // communication semantic is changing in some way. If the postSend method is not nil, it will
 // be executed immediately after writing the command to the output stream, and can be used
 // to change the communication semantic for future writes.
+//
+// Commands are sent ahead of any text that is waiting to be sent, including text that is being
+// held by a keyboard lock. Use WriteCommandAfterText for a command that must follow the text
+// written before it.
 func (k *TelnetKeyboard) WriteCommand(c Command, postSend func() error) {
-	k.input <- keyboardTransport{
+	if k.closed.Load() {
+		return
+	}
+
+	select {
+	case k.commands <- keyboardTransport{
 		data:     CommandData{c},
-		postSend: postSend,
+		postSend: k.telOptPostSend(c, postSend),
+	}:
+	case <-k.queue.closed:
 	}
 }
 
+// WriteUrgent will queue data to be sent to the remote ahead of everything that is waiting to be
+// sent, including text that is being held by a keyboard lock or the rate limit. This is useful for
+// text that can't wait, such as an abort notice that must go out while a CHARSET negotiation holds
+// the keyboard. Urgent data travels alongside commands, so it is sent in the order it was queued
+// relative to commands, and keyboard middlewares are applied to it as usual.
+//
+// Text is encoded with the charset in effect when it is written and IAC bytes are escaped, just as
+// with WriteString. Bear in mind that a keyboard lock usually means the remote may be about to
+// change how it reads text, so urgent text sent under a CHARSET or TRANSMIT-BINARY lock should
+// stick to US-ASCII, which every charset reads the same way.
+//
+// WriteUrgent returns io.ErrClosedPipe once the terminal is closing.
+func (k *TelnetKeyboard) WriteUrgent(data TerminalData) error {
+	if k.closed.Load() {
+		return io.ErrClosedPipe
+	}
+
+	if data == nil {
+		return nil
+	}
+
+	select {
+	case k.commands <- keyboardTransport{data: data}:
+		return nil
+	case <-k.queue.closed:
+		return io.ErrClosedPipe
+	}
+}
+
+// WriteCommandAfterText works like WriteCommand, but the command is queued in the text lane
+// instead of being sent ahead of waiting text. If the keyboard has unlocked and the text it was
+// holding has not been sent yet when the command is picked up, the command waits for that text,
+// so it is sent after all of the text written before it and before the text written after it.
+// This is useful for commands that change how the remote reads text, such as a telopt's WONT,
+// when the text already written was meant for the old semantics: the postSend event runs after
+// that text has been sent.
+//
+// The command itself is not held by keyboard locks or the rate limit, since the command is often
+// what clears the lock. Text that is being held by a lock when the command is picked up is sent
+// after it, once the lock clears.
+func (k *TelnetKeyboard) WriteCommandAfterText(c Command, postSend func() error) {
+	if k.closed.Load() {
+		return
+	}
+
+	_ = k.queue.enqueue(context.Background(), keyboardTransport{
+		data:      CommandData{c},
+		postSend:  k.telOptPostSend(c, postSend),
+		afterText: true,
+	})
+}
+
+func (k *TelnetKeyboard) telOptPostSend(c Command, postSend func() error) func() error {
+	if postSend == nil {
+		return nil
+	}
+
+	// Post-send events come from telopts, so errors should be attributed to them
+	return func() error {
+		return telOptError(c.Option, k.terminal.callRecovering(postSend))
+	}
+}
+
+// LineOut queues data to be sent to the remote, just like WriteString. It is the
+// middleware-compatible counterpart of WriteString, suitable for use as a TerminalDataHandler.
 func (k *TelnetKeyboard) LineOut(t *Terminal, data TerminalData) {
-	k.input <- keyboardTransport{data: data}
+	if k.closed.Load() {
+		return
+	}
+
+	k.queueText(keyboardTransport{data: data})
+}
+
+// queueText queues text for methods that can't return an error. If the text is rejected by
+// KeyboardOverflowReject, ErrKeyboardQueueFull is delivered to EncounteredError hooks.
+func (k *TelnetKeyboard) queueText(transport keyboardTransport) {
+	err := k.enqueueText(context.Background(), transport)
+	if errors.Is(err, ErrKeyboardQueueFull) {
+		k.encounteredError(err)
+	}
 }
 
-// WriteString will queue some text to be sent to the remote
+// WriteString will queue some text to be sent to the remote. If the keyboard's queue is full,
+// what happens depends on TerminalConfig.KeyboardOverflowPolicy: WriteString may block until there
+// is room, discard the oldest queued text, or discard this text.
+//
+// The text is encoded with the charset in effect when it is written. Any 255 bytes in the encoded
+// text are doubled, as RFC 854 requires, so that the remote doesn't read them as IAC. Text should
+// not be escaped by the caller, or the remote will receive doubled 255s.
 func (k *TelnetKeyboard) WriteString(str string) {
-	if len(str) == 0 {
+	if len(str) == 0 || k.closed.Load() {
 		return
 	}
 
-	k.input <- keyboardTransport{
+	k.queueText(keyboardTransport{
 		unparsedString: str,
+	})
+}
+
+// WriteStringContext works like WriteString, but returns an error instead of queueing the text if
+// ctx is done while waiting for room in the keyboard's queue. It returns ErrKeyboardQueueFull if
+// the text was discarded by KeyboardOverflowReject, and io.ErrClosedPipe once the terminal is closing.
+func (k *TelnetKeyboard) WriteStringContext(ctx context.Context, str string) error {
+	if k.closed.Load() {
+		return io.ErrClosedPipe
+	}
+
+	if len(str) == 0 {
+		return nil
+	}
+
+	return k.enqueueText(ctx, keyboardTransport{
+		unparsedString: str,
+	})
+}
+
+// WriteStringWithCharset works like WriteString, but encodes the text with the charset named
+// by codePage instead of the charset currently in use, for the occasional payload that must be sent
+// in a specific encoding, such as a CP437 ANSI art file sent to a client that negotiated UTF-8. IAC
+// bytes in the encoded text are escaped as usual, and later writes use the current charset again.
+//
+// An error is returned if codePage is not a charset the terminal can build, ErrKeyboardQueueFull is
+// returned if the text was discarded by KeyboardOverflowReject, and io.ErrClosedPipe is returned
+// once the terminal is closing.
+func (k *TelnetKeyboard) WriteStringWithCharset(str string, codePage string) error {
+	if k.closed.Load() {
+		return io.ErrClosedPipe
+	}
+
+	charset, err := k.charset.buildCharset(codePage)
+	if err != nil {
+		return err
+	}
+
+	if len(str) == 0 {
+		return nil
+	}
+
+	return k.enqueueText(context.Background(), keyboardTransport{
+		unparsedString: str,
+		charset:        charset,
+	})
+}
+
+// QueueDepth returns the number of text writes that are waiting to be sent, including text held by
+// a keyboard lock. A depth that stays close to TerminalConfig.KeyboardQueueCapacity indicates that
+// the connection can't keep up with the text being written.
+func (k *TelnetKeyboard) QueueDepth() int {
+	return k.queue.Depth()
+}
+
+// Write queues some text to be sent to the remote, so that the keyboard can be used wherever an
+// io.Writer is expected, such as with fmt.Fprintf, text/template, or text/tabwriter. As with
+// WriteString, the text is encoded with the current charset, IAC bytes are escaped, and the text
+// is held while the keyboard is locked. A character that is split across two writes is held until
+// the rest of it arrives.
+//
+// Write returns io.ErrClosedPipe once the terminal is closing, and ErrKeyboardQueueFull if the
+// text was discarded by KeyboardOverflowReject.
+func (k *TelnetKeyboard) Write(p []byte) (int, error) {
+	if k.closed.Load() {
+		return 0, io.ErrClosedPipe
+	}
+
+	k.writeLock.Lock()
+	defer k.writeLock.Unlock()
+
+	text := append(k.partialWrite, p...)
+
+	complete := len(text)
+	for i := len(text) - 1; i >= 0 && i >= len(text)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(text[i]) {
+			if !utf8.FullRune(text[i:]) {
+				complete = i
+			}
+			break
+		}
+	}
+
+	k.partialWrite = bytes.Clone(text[complete:])
+	if complete == 0 {
+		return len(p), nil
+	}
+
+	err := k.enqueueText(context.Background(), keyboardTransport{
+		unparsedString: string(text[:complete]),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+var _ io.Writer = &TelnetKeyboard{}
+
+// Flush blocks until everything that was queued with WriteString, Write, LineOut, SendPromptHint,
+// WriteCommand, WriteCommandAfterText, or WriteUrgent before the call has been written to the
+// connection. This is useful for making sure that a goodbye message has been delivered before
+// closing the connection. Text held by a keyboard lock has not been delivered, so Flush waits
+// for the lock to clear.
+//
+// An error is returned if ctx is done, or if the keyboard shuts down, before everything has been
+// written. Flush returns io.ErrClosedPipe once the terminal is closing.
+func (k *TelnetKeyboard) Flush(ctx context.Context) error {
+	if k.closed.Load() {
+		return io.ErrClosedPipe
+	}
+
+	// The marker travels in the text lane, so it is written after all text queued before it, and
+	// the keyboard writes waiting commands before it writes text
+	flushed := make(chan struct{})
+	err := k.queue.enqueue(ctx, keyboardTransport{
+		postSend: func() error {
+			close(flushed)
+			return nil
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-k.complete:
+		k.complete <- true
+	}
+
+	// The keyboard may have written the marker while it was shutting down
+	select {
+	case <-flushed:
+		return nil
+	default:
+		return io.ErrClosedPipe
 	}
 }
 
@@ -304,6 +950,27 @@ func (k *TelnetKeyboard) waitForExit() {
 	k.complete <- true
 }
 
+// waitForExitTimeout will block until the keyboard has been disposed of or until the timeout
+// expires.  It returns true if the keyboard was disposed of.
+func (k *TelnetKeyboard) waitForExitTimeout(timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-k.complete:
+		k.complete <- true
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// stopAccepting causes all future writes to the keyboard to be silently dropped. It is used
+// when the terminal is closing.
+func (k *TelnetKeyboard) stopAccepting() {
+	k.closed.Store(true)
+}
+
 // SetPromptCommand will activate a particular prompt command and permit
 // it to be sent by the keyboard.  Prompt commands are IAC GA/IAC EOR, commands
 // that indicate to the remote where to place a prompt
@@ -318,6 +985,11 @@ func (k *TelnetKeyboard) ClearPromptCommand(flag PromptCommands) {
 	k.promptCommands.ClearPromptCommand(flag)
 }
 
+// PromptCommands returns the prompt commands that the keyboard is permitted to send
+func (k *TelnetKeyboard) PromptCommands() PromptCommands {
+	return k.promptCommands.Get()
+}
+
 // SendPromptHint will send a IAC GA or IAC EOR if possible, indicating
 // to the remote to place a prompt after the most-recently-sent text.
 //
@@ -330,11 +1002,27 @@ func (k *TelnetKeyboard) ClearPromptCommand(flag PromptCommands) {
 // when the keyboard is under a lock, so prompt hints sent via WriteCommand will arrive
 // before the prompt text when a keyboard lock is active.
 func (k *TelnetKeyboard) SendPromptHint() {
-	k.input <- keyboardTransport{
-		data: PromptData(0),
+	if k.closed.Load() {
+		return
 	}
+
+	_ = k.queue.enqueue(context.Background(), keyboardTransport{
+		data: PromptData(0),
+	})
+}
+
+// WriteFlusher is implemented by writers that hold on to written data until they are flushed,
+// such as compressing writers. If a writer installed with TelnetKeyboard.WrapWriter implements
+// it, the keyboard flushes it after every write, before reporting the written data to OutboundData
+// hooks, so that hooks and statistics only reflect data that has reached the connection.
+// *zlib.Writer is a WriteFlusher.
+type WriteFlusher interface {
+	io.Writer
+	Flush() error
 }
 
+// WrapWriter replaces the writer that the keyboard writes to with one produced by wrap, which
+// receives the underlying connection. Writers that buffer data should implement WriteFlusher.
 func (k *TelnetKeyboard) WrapWriter(wrap func(io.Writer) (io.Writer, error)) error {
 	wrapped, err := wrap(k.baseStream)
 	if err != nil {
@@ -344,3 +1032,14 @@ func (k *TelnetKeyboard) WrapWriter(wrap func(io.Writer) (io.Writer, error)) err
 	k.outputStream = wrapped
 	return nil
 }
+
+// StreamStats returns statistics about the data that has been written by the keyboard. Only
+// WireBytes and StreamBytes are used: StreamBytes is the number of bytes written before they
+// pass through any writer installed with WrapWriter, and WireBytes is the number that reached
+// the underlying connection.
+func (k *TelnetKeyboard) StreamStats() StreamStats {
+	return StreamStats{
+		WireBytes:   k.stats.bytes.Load(),
+		StreamBytes: k.streamBytes.Load(),
+	}
+}
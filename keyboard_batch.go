@@ -0,0 +1,48 @@
+package telnet
+
+// batchPending indicates whether anything is waiting in the batch to be written
+func (k *TelnetKeyboard) batchPending() bool {
+	return len(k.batch) > 0 || len(k.batchData) > 0
+}
+
+// flushBatch writes the batch to the output stream in a single write, and then reports the data
+// in it to OutboundData hooks
+func (k *TelnetKeyboard) flushBatch() error {
+	if !k.batchPending() {
+		return nil
+	}
+
+	var err error
+	if len(k.batch) > 0 {
+		err = k.writeOutput(k.batch)
+	}
+
+	// Outbound data is only reported once it has actually reached the connection- a
+	// compressing writer may be holding on to it
+	if err == nil {
+		err = k.flushOutput()
+	}
+
+	if err == nil {
+		for _, data := range k.batchData {
+			k.terminal.sinks.publish(SinkSourceKeyboard, data)
+			k.eventPump.EncounteredOutboundData(data)
+		}
+	}
+
+	k.batch = k.batch[:0]
+	clear(k.batchData)
+	k.batchData = k.batchData[:0]
+
+	return err
+}
+
+// flushIdleBatch writes the batch if the loop is about to wait for input and
+// TerminalConfig.KeyboardBatchInterval is not set. It returns false if the write failed.
+func (k *TelnetKeyboard) flushIdleBatch() bool {
+	if k.batchInterval > 0 || !k.batchPending() || len(k.commands) > 0 || k.queue.waitingCount() > 0 {
+		return true
+	}
+
+	return k.handleError(k.flushBatch())
+}
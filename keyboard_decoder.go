@@ -22,6 +22,8 @@ func (d *keyboardDecoder) Decode(t *Terminal, data TerminalData) {
 }
 
 func (d *keyboardDecoder) DecodeString(t *Terminal, text string) {
+	d.decoded = d.decoded[:0]
+
 	data := NextOutput(d.parser, text)
 	for data != nil {
 		d.middlewareStack.LineIn(t, data)
@@ -41,6 +43,12 @@ func (d *keyboardDecoder) DecodeString(t *Terminal, text string) {
 	}
 }
 
+// releaseBuffers frees the decoder's buffers between writes
+func (d *keyboardDecoder) releaseBuffers() {
+	d.parser.ReleaseBuffers()
+	d.decoded = nil
+}
+
 func (d *keyboardDecoder) lineOut(t *Terminal, data TerminalData) {
 	d.decoded = append(d.decoded, data)
 }
@@ -0,0 +1,72 @@
+package telnet
+
+import (
+	"context"
+	"io"
+)
+
+// writeDirect writes text on the calling goroutine instead of handing it to the keyboard loop, if
+// direct writes are enabled and nothing could need to go out before it: the initial negotiation
+// has completed, the loop is idle, no commands or text are waiting, the keyboard is not locked,
+// and the text is within the rate limit. It returns false if the text must be queued instead.
+//
+// If the connection has closed, io.ErrClosedPipe is returned and the loop is woken so that it
+// shuts down, just as it would if it had failed to write the text itself.
+func (k *TelnetKeyboard) writeDirect(transport keyboardTransport) (bool, error) {
+	if !k.directWrites.Load() || !k.settled.Load() || k.lock.IsLocked() {
+		return false, nil
+	}
+
+	// The loop holds pause whenever it isn't waiting for input, and so does DebugSnapshot
+	if !k.pause.TryLock() {
+		return false, nil
+	}
+	defer k.pause.Unlock()
+
+	select {
+	case <-k.queue.closed:
+		// The loop has shut down
+		return false, nil
+	default:
+	}
+
+	if k.closed.Load() || len(k.commands) > 0 || !k.queue.isEmpty() || k.lock.IsLocked() ||
+		k.rateLimiter.reserve(transport) > 0 {
+		return false, nil
+	}
+
+	if !k.write(transport) || !k.handleError(k.flushBatch()) {
+		k.directWriteFailed.Store(true)
+		k.queue.wake()
+		return true, io.ErrClosedPipe
+	}
+
+	if k.pendingCR {
+		// The loop completes the CR if nothing follows it, but it needs to notice it first
+		k.queue.wake()
+	}
+
+	return true, nil
+}
+
+// enqueueText writes text directly if possible, and otherwise adds it to the queue
+func (k *TelnetKeyboard) enqueueText(ctx context.Context, transport keyboardTransport) error {
+	written, err := k.writeDirect(transport)
+	if written {
+		return err
+	}
+
+	return k.queue.enqueue(ctx, transport)
+}
+
+// SetDirectWrites changes whether text is written directly by the goroutine that writes it once
+// the initial negotiation has completed. See TerminalConfig.KeyboardDirectWrites.
+func (k *TelnetKeyboard) SetDirectWrites(direct bool) {
+	k.directWrites.Store(direct)
+}
+
+// DirectWrites indicates whether text is written directly by the goroutine that writes it once
+// the initial negotiation has completed
+func (k *TelnetKeyboard) DirectWrites() bool {
+	return k.directWrites.Load()
+}
@@ -1,7 +1,10 @@
 package telnet
 
 import (
+	"context"
+	"math"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -11,19 +14,23 @@ import (
 const DefaultKeyboardLock = 5 * time.Second
 
 type keyboardLock struct {
-	control        sync.Mutex
-	locks          map[string]time.Time
+	control sync.Mutex
+	locks   map[string]time.Time
+	// stopWatches holds the functions that stop watching the contexts of context-scoped locks
+	stopWatches    map[string]func() bool
 	nextExpiryTime time.Time
 
-	timer  *time.Timer
-	locked bool
+	timer *time.Timer
+	// locked is only changed while control is held, but can be read without it
+	locked atomic.Bool
 	C      chan struct{}
 }
 
 func newKeyboardLock() *keyboardLock {
 	lock := &keyboardLock{
-		locks: make(map[string]time.Time),
-		C:     make(chan struct{}, 1),
+		locks:       make(map[string]time.Time),
+		stopWatches: make(map[string]func() bool),
+		C:           make(chan struct{}, 1),
 	}
 
 	timer := time.AfterFunc(0, func() {
@@ -40,7 +47,7 @@ func newKeyboardLock() *keyboardLock {
 
 func (l *keyboardLock) timerExpire() {
 	// The keyboard is now unlocked
-	l.locked = false
+	l.locked.Store(false)
 
 	select {
 	case l.C <- struct{}{}:
@@ -64,7 +71,7 @@ func (l *keyboardLock) newNextExpiry(expiry time.Time) {
 	}
 
 	// We're setting an expiry- the timer may still have been live
-	l.locked = true
+	l.locked.Store(true)
 	l.nextExpiryTime = expiry
 	l.timer.Reset(time.Until(expiry))
 }
@@ -75,6 +82,11 @@ func (l *keyboardLock) SetLock(lockName string, duration time.Duration) {
 	l.control.Lock()
 	defer l.control.Unlock()
 
+	l.setLock(lockName, expiry)
+}
+
+func (l *keyboardLock) setLock(lockName string, expiry time.Time) {
+	l.stopWatch(lockName)
 	l.locks[lockName] = expiry
 
 	if expiry.After(l.nextExpiryTime) {
@@ -82,15 +94,55 @@ func (l *keyboardLock) SetLock(lockName string, duration time.Duration) {
 	}
 }
 
+// SetLockCtx sets a lock that is cleared when ctx is done. If ctx has a deadline, the lock also
+// expires then- otherwise, it lasts until ctx is done or the lock is cleared.
+func (l *keyboardLock) SetLockCtx(lockName string, ctx context.Context) {
+	if ctx.Err() != nil {
+		return
+	}
+
+	expiry, hasDeadline := ctx.Deadline()
+	if !hasDeadline {
+		expiry = time.Now().Add(math.MaxInt64)
+	}
+
+	l.control.Lock()
+	defer l.control.Unlock()
+
+	l.setLock(lockName, expiry)
+	l.stopWatches[lockName] = context.AfterFunc(ctx, func() {
+		l.control.Lock()
+		defer l.control.Unlock()
+
+		// The lock may have been replaced since this watch was set
+		if l.locks[lockName] == expiry {
+			l.clearLock(lockName)
+		}
+	})
+}
+
+func (l *keyboardLock) stopWatch(lockName string) {
+	stop, watching := l.stopWatches[lockName]
+	if watching {
+		stop()
+		delete(l.stopWatches, lockName)
+	}
+}
+
 func (l *keyboardLock) ClearLock(lockName string) {
 	l.control.Lock()
 	defer l.control.Unlock()
 
+	l.clearLock(lockName)
+}
+
+func (l *keyboardLock) clearLock(lockName string) {
 	oldExpiry, hasLock := l.locks[lockName]
 	if !hasLock {
 		return
 	}
 
+	l.stopWatch(lockName)
 	delete(l.locks, lockName)
 
 	if l.nextExpiryTime == oldExpiry {
@@ -105,6 +157,18 @@ func (l *keyboardLock) ClearLock(lockName string) {
 	}
 }
 
+func (l *keyboardLock) ClearAllLocks() {
+	l.control.Lock()
+	defer l.control.Unlock()
+
+	for lockName := range l.locks {
+		l.stopWatch(lockName)
+		delete(l.locks, lockName)
+	}
+
+	l.newNextExpiry(time.Time{})
+}
+
 func (l *keyboardLock) HasActiveLock(lockName string) bool {
 	l.control.Lock()
 	defer l.control.Unlock()
@@ -117,9 +181,22 @@ func (l *keyboardLock) HasActiveLock(lockName string) bool {
 	return expiry.After(time.Now())
 }
 
-func (l *keyboardLock) IsLocked() bool {
+// activeLocks returns the expiry time of every lock that has not yet expired
+func (l *keyboardLock) activeLocks() map[string]time.Time {
 	l.control.Lock()
 	defer l.control.Unlock()
 
-	return l.locked
+	now := time.Now()
+	locks := make(map[string]time.Time, len(l.locks))
+	for lockName, expiry := range l.locks {
+		if expiry.After(now) {
+			locks[lockName] = expiry
+		}
+	}
+
+	return locks
+}
+
+func (l *keyboardLock) IsLocked() bool {
+	return l.locked.Load()
 }
@@ -0,0 +1,301 @@
+package telnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+)
+
+// DefaultKeyboardQueueCapacity is the number of writes that can wait to be sent by the keyboard,
+// if TerminalConfig.KeyboardQueueCapacity is not set
+const DefaultKeyboardQueueCapacity = 100
+
+// ErrKeyboardQueueFull is returned, or delivered to EncounteredError hooks, when text is discarded
+// because the keyboard's queue is full and its KeyboardOverflowPolicy is KeyboardOverflowReject
+var ErrKeyboardQueueFull = errors.New("telnet: keyboard queue is full")
+
+// KeyboardOverflowPolicy decides what the keyboard does with new text when its queue is full,
+// which happens when the connection is slower than the text being written, or when a keyboard lock
+// holds text for a long time
+type KeyboardOverflowPolicy int
+
+const (
+	// KeyboardOverflowBlock blocks writes until there is room in the queue
+	KeyboardOverflowBlock KeyboardOverflowPolicy = iota
+	// KeyboardOverflowDropOldest discards the oldest text in the queue to make room for new text
+	KeyboardOverflowDropOldest
+	// KeyboardOverflowReject discards the new text. Methods that return an error return
+	// ErrKeyboardQueueFull, and the rest deliver it to EncounteredError hooks.
+	KeyboardOverflowReject
+)
+
+func (p KeyboardOverflowPolicy) String() string {
+	switch p {
+	case KeyboardOverflowBlock:
+		return "Block"
+	case KeyboardOverflowDropOldest:
+		return "DropOldest"
+	case KeyboardOverflowReject:
+		return "Reject"
+	default:
+		return fmt.Sprintf("KeyboardOverflowPolicy(%d)", int(p))
+	}
+}
+
+// keyboardQueue is the keyboard's text lane. It holds writes that are waiting for the keyboard
+// loop, as well as writes that the loop is holding because of a keyboard lock. Only text counts
+// against the queue's capacity- commands, prompt hints, and flush markers are always queued, so
+// that they can't be lost or stuck behind text.
+type keyboardQueue struct {
+	capacity int
+	policy   KeyboardOverflowPolicy
+
+	lock    sync.Mutex
+	waiting []keyboardTransport
+	held    []keyboardTransport
+	// depth is the number of text writes in waiting and held, plus the one the loop is writing
+	depth int
+	// ready receives a value whenever waiting becomes non-empty
+	ready chan struct{}
+	// space is closed, and replaced, whenever depth decreases
+	space  chan struct{}
+	closed chan struct{}
+}
+
+func newKeyboardQueue(capacity int, policy KeyboardOverflowPolicy) *keyboardQueue {
+	if capacity <= 0 {
+		capacity = DefaultKeyboardQueueCapacity
+	}
+
+	return &keyboardQueue{
+		capacity: capacity,
+		policy:   policy,
+		held:     make([]keyboardTransport, 0, 50),
+		ready:    make(chan struct{}, 1),
+		space:    make(chan struct{}),
+		closed:   make(chan struct{}),
+	}
+}
+
+// isText indicates whether a transport counts against the queue's capacity
+func (t keyboardTransport) isText() bool {
+	if t.unparsedString != "" {
+		return true
+	}
+
+	switch t.data.(type) {
+	case nil, CommandData, PromptData:
+		return false
+	default:
+		return true
+	}
+}
+
+// enqueue adds a transport to the end of the queue, applying the overflow policy if it is text
+// and the queue is full. It returns io.ErrClosedPipe if the keyboard has stopped.
+func (q *keyboardQueue) enqueue(ctx context.Context, transport keyboardTransport) error {
+	isText := transport.isText()
+
+	for {
+		select {
+		case <-q.closed:
+			return io.ErrClosedPipe
+		default:
+		}
+
+		q.lock.Lock()
+		if !isText || q.depth < q.capacity || (q.policy == KeyboardOverflowDropOldest && q.dropOldest()) {
+			q.waiting = append(q.waiting, transport)
+			if isText {
+				q.depth++
+			}
+			q.lock.Unlock()
+
+			select {
+			case q.ready <- struct{}{}:
+			default:
+			}
+
+			return nil
+		}
+
+		if q.policy == KeyboardOverflowReject {
+			q.lock.Unlock()
+			return ErrKeyboardQueueFull
+		}
+
+		space := q.space
+		q.lock.Unlock()
+
+		select {
+		case <-space:
+		case <-q.closed:
+			return io.ErrClosedPipe
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// dropOldest discards the oldest text in the queue. Held text is always older than waiting text.
+// It must be called with the queue's lock held.
+func (q *keyboardQueue) dropOldest() bool {
+	for _, lane := range []*[]keyboardTransport{&q.held, &q.waiting} {
+		for i, transport := range *lane {
+			if transport.isText() {
+				*lane = append((*lane)[:i], (*lane)[i+1:]...)
+				q.depth--
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// written records that the loop has finished writing a transport, so that it no longer counts
+// against the queue's capacity
+func (q *keyboardQueue) written(transport keyboardTransport) {
+	if !transport.isText() {
+		return
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.depth--
+	close(q.space)
+	q.space = make(chan struct{})
+}
+
+// next removes the transport at the front of the waiting writes. It counts against the queue's
+// capacity until it is passed to written.
+func (q *keyboardQueue) next() (keyboardTransport, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if len(q.waiting) == 0 {
+		return keyboardTransport{}, false
+	}
+
+	transport := q.waiting[0]
+	q.waiting[0] = keyboardTransport{}
+	q.waiting = q.waiting[1:]
+
+	if len(q.waiting) > 0 {
+		// Let the loop know there's more to pick up
+		select {
+		case q.ready <- struct{}{}:
+		default:
+		}
+	}
+
+	return transport, true
+}
+
+// hold adds a transport that the loop has picked up to the end of the held writes
+func (q *keyboardQueue) hold(transport keyboardTransport) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.held = append(q.held, transport)
+}
+
+// hasHeld indicates whether any writes are being held
+func (q *keyboardQueue) hasHeld() bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return len(q.held) > 0
+}
+
+// peekHeld returns the transport at the front of the held writes without removing it
+func (q *keyboardQueue) peekHeld() (keyboardTransport, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if len(q.held) == 0 {
+		return keyboardTransport{}, false
+	}
+
+	return q.held[0], true
+}
+
+// nextHeld removes the transport at the front of the held writes. It counts against the queue's
+// capacity until it is passed to written.
+func (q *keyboardQueue) nextHeld() (keyboardTransport, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if len(q.held) == 0 {
+		return keyboardTransport{}, false
+	}
+
+	transport := q.held[0]
+	q.held[0] = keyboardTransport{}
+	q.held = q.held[1:]
+
+	return transport, true
+}
+
+// isEmpty indicates whether no writes are waiting, being held, or being written by the loop
+func (q *keyboardQueue) isEmpty() bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.depth == 0 && len(q.waiting) == 0 && len(q.held) == 0
+}
+
+// wake causes the loop to check the queue, even if nothing has been added to it
+func (q *keyboardQueue) wake() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// waitingCount returns the number of transports waiting to be picked up by the loop
+func (q *keyboardQueue) waitingCount() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return len(q.waiting)
+}
+
+// heldTransports returns a copy of the held writes
+func (q *keyboardQueue) heldTransports() []keyboardTransport {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return slices.Clone(q.held)
+}
+
+// Depth returns the number of text writes that are waiting to be sent
+func (q *keyboardQueue) Depth() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.depth
+}
+
+// releaseBuffers frees the held buffer if nothing is being held
+func (q *keyboardQueue) releaseBuffers() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if len(q.held) == 0 {
+		q.held = nil
+	}
+	if len(q.waiting) == 0 {
+		q.waiting = nil
+	}
+}
+
+// close wakes any writers that are blocked waiting for space. It is called once the keyboard
+// loop has exited.
+func (q *keyboardQueue) close() {
+	close(q.closed)
+}
@@ -0,0 +1,157 @@
+package telnet
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+const keyboardThrottleLock string = "lock.throttle"
+
+// KeyboardRateLimit throttles the text sent by the keyboard with a pair of token buckets, one
+// counting bytes and one counting lines. This keeps bots from being disconnected for flooding a
+// MUD with commands, and lets servers trickle large amounts of output to slow clients. Text that
+// is over the limit is held, like text held by a keyboard lock, while commands continue to be
+// sent. A zero KeyboardRateLimit does not limit anything.
+type KeyboardRateLimit struct {
+	// BytesPerSecond is the rate at which text can be sent, measured before it is encoded. If
+	// it is zero, the number of bytes is not limited.
+	BytesPerSecond float64
+	// ByteBurst is the number of bytes that can be sent at once after the keyboard has been idle.
+	// It defaults to one second's worth of bytes.
+	ByteBurst int
+	// LinesPerSecond is the rate at which lines of text can be sent. A write counts as one line
+	// for each newline it contains. If it is zero, the number of lines is not limited.
+	LinesPerSecond float64
+	// LineBurst is the number of lines that can be sent at once after the keyboard has been
+	// idle. It defaults to one second's worth of lines.
+	LineBurst int
+}
+
+// tokenBucket allows an average of rate tokens per second, and up to burst tokens at once. A
+// single request larger than burst is allowed once the bucket is full, leaving it in debt.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+
+	if burst <= 0 {
+		burst = max(int(rate), 1)
+	}
+
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now,
+	}
+}
+
+// wait returns the time until n tokens can be taken from the bucket
+func (b *tokenBucket) wait(n float64, now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+
+	b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*b.rate, b.burst)
+	b.last = now
+
+	needed := min(n, b.burst)
+	if b.tokens >= needed {
+		return 0
+	}
+
+	return time.Duration((needed - b.tokens) / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) take(n float64) {
+	if b != nil {
+		b.tokens -= n
+	}
+}
+
+type keyboardRateLimiter struct {
+	lock  sync.Mutex
+	limit KeyboardRateLimit
+	bytes *tokenBucket
+	lines *tokenBucket
+}
+
+func (l *keyboardRateLimiter) set(limit KeyboardRateLimit) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := time.Now()
+	l.limit = limit
+	l.bytes = newTokenBucket(limit.BytesPerSecond, limit.ByteBurst, now)
+	l.lines = newTokenBucket(limit.LinesPerSecond, limit.LineBurst, now)
+}
+
+func (l *keyboardRateLimiter) get() KeyboardRateLimit {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.limit
+}
+
+// reserve takes the tokens needed to send a transport, returning zero, or returns the time to wait
+// before trying again without taking anything. Only text is limited.
+func (l *keyboardRateLimiter) reserve(transport keyboardTransport) time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if (l.bytes == nil && l.lines == nil) || !transport.isText() {
+		return 0
+	}
+
+	text := transport.unparsedString
+	if transport.data != nil {
+		text = transport.data.String()
+	}
+
+	bytes := float64(len(text))
+	lines := float64(strings.Count(text, "\n"))
+
+	now := time.Now()
+	wait := max(l.bytes.wait(bytes, now), l.lines.wait(lines, now))
+	if wait > 0 {
+		return wait
+	}
+
+	l.bytes.take(bytes)
+	l.lines.take(lines)
+	return 0
+}
+
+// throttle reports whether a transport is over the rate limit. If it is, the keyboard is locked
+// until the transport can be sent, so that it and the text after it are held.
+func (k *TelnetKeyboard) throttle(transport keyboardTransport) bool {
+	wait := k.rateLimiter.reserve(transport)
+	if wait <= 0 {
+		return false
+	}
+
+	k.lock.SetLock(keyboardThrottleLock, wait)
+	return true
+}
+
+// SetRateLimit changes the keyboard's rate limit. Text that is already being held by the current
+// rate limit is sent according to the new one.
+func (k *TelnetKeyboard) SetRateLimit(limit KeyboardRateLimit) {
+	k.rateLimiter.set(limit)
+
+	// Give held text a chance to go out under the new limit
+	k.lock.ClearLock(keyboardThrottleLock)
+}
+
+// RateLimit returns the keyboard's current rate limit
+func (k *TelnetKeyboard) RateLimit() KeyboardRateLimit {
+	return k.rateLimiter.get()
+}
@@ -0,0 +1,145 @@
+package telnet
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/x/ansi"
+)
+
+// LineTerminatorMode decides how the keyboard rewrites CR and LF in outbound text. RFC 854
+// requires lines to be sent as CR LF and a bare CR to be sent as CR NUL, except while
+// TRANSMIT-BINARY is active, when text is sent exactly as written.
+type LineTerminatorMode byte
+
+const (
+	// LineTerminatorsRFC854 sends a bare LF as CR LF and a bare CR as CR NUL, as RFC 854 requires
+	LineTerminatorsRFC854 LineTerminatorMode = iota
+	// LineTerminatorsBareCR sends a bare LF as CR LF, but sends a bare CR as it is, for legacy
+	// servers that choke on CR NUL
+	LineTerminatorsBareCR
+	// LineTerminatorsUnchanged sends text exactly as written
+	LineTerminatorsUnchanged
+)
+
+func (m LineTerminatorMode) String() string {
+	switch m {
+	case LineTerminatorsRFC854:
+		return "RFC854"
+	case LineTerminatorsBareCR:
+		return "BareCR"
+	case LineTerminatorsUnchanged:
+		return "Unchanged"
+	default:
+		return fmt.Sprintf("LineTerminatorMode(%d)", int(m))
+	}
+}
+
+// keyboardCRGrace is how long the keyboard waits for text to follow a CR that ended a write
+// before completing it with NUL. Character mode sends the CR and LF of a line in separate
+// writes, and they must not be sent as CR NUL LF.
+const keyboardCRGrace = 50 * time.Millisecond
+
+// normalizeLineTerminators rewrites the CRs and LFs in decoded according to the keyboard's
+// LineTerminatorMode. A CR at the end of decoded is sent as-is, and is completed by the next
+// write or by completePendingCR.
+func (k *TelnetKeyboard) normalizeLineTerminators(decoded []TerminalData) []TerminalData {
+	mode := k.LineTerminators()
+	if mode == LineTerminatorsUnchanged || k.charset.BinaryEncode() {
+		k.pendingCR = false
+		return decoded
+	}
+
+	k.terminators = k.terminators[:0]
+	for _, data := range decoded {
+		switch d := data.(type) {
+		case CommandData, PromptData:
+			// Commands aren't part of the text, so a CR on one side of them is completed on the other
+			k.terminators = append(k.terminators, data)
+		case ControlCodeData:
+			k.appendControlCode(mode, ansi.ControlCode(d))
+		case TextData:
+			k.appendText(mode, string(d))
+		default:
+			k.completeCR(mode)
+			k.terminators = append(k.terminators, data)
+		}
+	}
+
+	return k.terminators
+}
+
+func (k *TelnetKeyboard) appendControlCode(mode LineTerminatorMode, code ansi.ControlCode) {
+	switch {
+	case code == ansi.LF && !k.pendingCR:
+		k.terminators = append(k.terminators, ControlCodeData(ansi.CR), ControlCodeData(ansi.LF))
+	case code == ansi.LF || (code == ansi.NUL && k.pendingCR):
+		// The CR has been completed by the caller
+		k.pendingCR = false
+		k.terminators = append(k.terminators, ControlCodeData(code))
+	case code == ansi.CR:
+		k.completeCR(mode)
+		k.pendingCR = mode == LineTerminatorsRFC854
+		k.terminators = append(k.terminators, ControlCodeData(code))
+	default:
+		k.completeCR(mode)
+		k.terminators = append(k.terminators, ControlCodeData(code))
+	}
+}
+
+// appendText handles text data that was passed to the keyboard without being parsed, and so may
+// contain CRs and LFs
+func (k *TelnetKeyboard) appendText(mode LineTerminatorMode, text string) {
+	for len(text) > 0 {
+		index := strings.IndexAny(text, "\r\n")
+		if index < 0 {
+			k.completeCR(mode)
+			k.terminators = append(k.terminators, TextData(text))
+			return
+		}
+
+		if index > 0 {
+			k.completeCR(mode)
+			k.terminators = append(k.terminators, TextData(text[:index]))
+		}
+
+		k.appendControlCode(mode, ansi.ControlCode(text[index]))
+		text = text[index+1:]
+	}
+}
+
+// completeCR follows a pending CR with NUL
+func (k *TelnetKeyboard) completeCR(mode LineTerminatorMode) {
+	if k.pendingCR {
+		k.pendingCR = false
+		if mode == LineTerminatorsRFC854 {
+			k.terminators = append(k.terminators, ControlCodeData(ansi.NUL))
+		}
+	}
+}
+
+// completePendingCR writes the NUL for a CR that ended a write, if nothing has followed it. It
+// returns false if the write failed.
+func (k *TelnetKeyboard) completePendingCR() bool {
+	if !k.pendingCR {
+		return true
+	}
+
+	if k.LineTerminators() != LineTerminatorsRFC854 || k.charset.BinaryEncode() {
+		k.pendingCR = false
+		return true
+	}
+
+	return k.write(keyboardTransport{data: ControlCodeData(ansi.NUL)})
+}
+
+// SetLineTerminators changes how the keyboard rewrites CR and LF in outbound text
+func (k *TelnetKeyboard) SetLineTerminators(mode LineTerminatorMode) {
+	k.lineTerminators.Store(uint32(mode))
+}
+
+// LineTerminators returns the way the keyboard rewrites CR and LF in outbound text
+func (k *TelnetKeyboard) LineTerminators() LineTerminatorMode {
+	return LineTerminatorMode(k.lineTerminators.Load())
+}
@@ -0,0 +1,365 @@
+package telnet
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testRemote is the far end of a terminal's connection, which records everything the terminal's
+// keyboard writes
+type testRemote struct {
+	conn net.Conn
+
+	startOnce sync.Once
+	lock      sync.Mutex
+	received  []byte
+	arrived   chan struct{}
+}
+
+// newTestTerminal creates a terminal that is connected to a testRemote. The remote doesn't read
+// from the connection until start is called, so the terminal's keyboard blocks on its first write
+// until then.
+func newTestTerminal(t *testing.T, config TerminalConfig) (*Terminal, *testRemote) {
+	t.Helper()
+
+	local, remote := net.Pipe()
+	if config.DefaultCharsetName == "" {
+		config.DefaultCharsetName = "US-ASCII"
+	}
+
+	terminal, err := NewTerminal(context.Background(), local, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testRemote := &testRemote{
+		conn:    remote,
+		arrived: make(chan struct{}, 1),
+	}
+
+	t.Cleanup(func() {
+		_ = remote.Close()
+		_ = terminal.CloseWithTimeout(time.Second)
+		_ = terminal.WaitForExit()
+	})
+
+	return terminal, testRemote
+}
+
+// start begins reading from the connection
+func (r *testRemote) start() {
+	r.startOnce.Do(func() {
+		go func() {
+			buffer := make([]byte, 256)
+			for {
+				n, err := r.conn.Read(buffer)
+				if n > 0 {
+					r.lock.Lock()
+					r.received = append(r.received, buffer[:n]...)
+					r.lock.Unlock()
+
+					select {
+					case r.arrived <- struct{}{}:
+					default:
+					}
+				}
+
+				if err != nil {
+					return
+				}
+			}
+		}()
+	})
+}
+
+// expect starts reading if necessary, and fails the test unless the next bytes received from the
+// terminal are want
+func (r *testRemote) expect(t *testing.T, want []byte) {
+	t.Helper()
+	r.start()
+
+	timeout := time.After(2 * time.Second)
+	for {
+		r.lock.Lock()
+		if len(r.received) >= len(want) {
+			got := r.received[:len(want)]
+			r.received = r.received[len(want):]
+			r.lock.Unlock()
+
+			if !bytes.Equal(got, want) {
+				t.Fatalf("expected %q, got %q", want, got)
+			}
+			return
+		}
+		r.lock.Unlock()
+
+		select {
+		case <-r.arrived:
+		case <-timeout:
+			r.lock.Lock()
+			defer r.lock.Unlock()
+			t.Fatalf("expected %q, only received %q", want, r.received)
+		}
+	}
+}
+
+// expectNothing fails the test if the terminal writes anything within a short time
+func (r *testRemote) expectNothing(t *testing.T) {
+	t.Helper()
+	r.start()
+
+	time.Sleep(50 * time.Millisecond)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(r.received) > 0 {
+		t.Fatalf("expected nothing, received %q", r.received)
+	}
+}
+
+var (
+	testNOP  = []byte{IAC, NOP}
+	testGA   = []byte{IAC, GA}
+	testGate = []byte{IAC, AYT}
+)
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+// stopKeyboard writes an IAC AYT whose postSend stops the keyboard loop until the returned
+// function is called, so that everything written in the meantime is waiting when the loop
+// resumes. The AYT has been received when stopKeyboard returns.
+func stopKeyboard(t *testing.T, keyboard *TelnetKeyboard, remote *testRemote) func() {
+	t.Helper()
+
+	stopped := make(chan struct{})
+	resume := make(chan struct{})
+	keyboard.WriteCommandAfterText(Command{OpCode: AYT}, func() error {
+		close(stopped)
+		<-resume
+		return nil
+	})
+
+	remote.expect(t, testGate)
+	<-stopped
+
+	var resumeOnce sync.Once
+	t.Cleanup(func() {
+		resumeOnce.Do(func() { close(resume) })
+	})
+
+	return func() {
+		resumeOnce.Do(func() { close(resume) })
+	}
+}
+
+func TestKeyboardCommandsJumpAheadOfQueuedText(t *testing.T) {
+	terminal, remote := newTestTerminal(t, TerminalConfig{})
+	keyboard := terminal.Keyboard()
+
+	resume := stopKeyboard(t, keyboard, remote)
+	keyboard.WriteString("one")
+	keyboard.WriteString("two")
+	keyboard.WriteCommand(Command{OpCode: NOP}, nil)
+	keyboard.WriteString("three")
+	resume()
+
+	remote.expect(t, concat(testNOP, []byte("onetwothree")))
+}
+
+func TestKeyboardPromptHintsKeepOrderWithText(t *testing.T) {
+	terminal, remote := newTestTerminal(t, TerminalConfig{})
+	keyboard := terminal.Keyboard()
+
+	resume := stopKeyboard(t, keyboard, remote)
+	keyboard.WriteString("one")
+	keyboard.WriteString("prompt>")
+	keyboard.SendPromptHint()
+	keyboard.WriteCommand(Command{OpCode: NOP}, nil)
+	keyboard.WriteString("two")
+	resume()
+
+	remote.expect(t, concat(testNOP, []byte("oneprompt>"), testGA, []byte("two")))
+}
+
+func TestKeyboardTextGetsATurnDuringUrgentFloods(t *testing.T) {
+	terminal, remote := newTestTerminal(t, TerminalConfig{})
+	keyboard := terminal.Keyboard()
+
+	const urgentWrites = 90
+
+	resume := stopKeyboard(t, keyboard, remote)
+	keyboard.WriteString("T")
+	for range urgentWrites {
+		err := keyboard.WriteUrgent(TextData("u"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	resume()
+
+	remote.expect(t, bytes.Repeat([]byte("u"), keyboardCommandBurst))
+
+	// The text must go out before the flood is over
+	want := urgentWrites - keyboardCommandBurst + 1
+	remote.start()
+	timeout := time.After(2 * time.Second)
+	for {
+		remote.lock.Lock()
+		received := bytes.Clone(remote.received)
+		remote.lock.Unlock()
+
+		if len(received) >= want {
+			textIndex := bytes.IndexByte(received, 'T')
+			if textIndex < 0 || textIndex == len(received)-1 {
+				t.Fatalf("text did not get a turn during the flood: %q", received)
+			}
+			return
+		}
+
+		select {
+		case <-remote.arrived:
+		case <-timeout:
+			t.Fatalf("expected %d bytes, only received %q", want, received)
+		}
+	}
+}
+
+func TestKeyboardCommandsBypassHeldText(t *testing.T) {
+	terminal, remote := newTestTerminal(t, TerminalConfig{})
+	keyboard := terminal.Keyboard()
+
+	keyboard.SetLock("test", time.Minute)
+	keyboard.WriteString("held")
+	keyboard.WriteCommand(Command{OpCode: NOP}, nil)
+
+	remote.expect(t, testNOP)
+	remote.expectNothing(t)
+
+	keyboard.ClearLock("test")
+	remote.expect(t, []byte("held"))
+}
+
+func TestKeyboardPromptHintsWaitForHeldText(t *testing.T) {
+	terminal, remote := newTestTerminal(t, TerminalConfig{})
+	keyboard := terminal.Keyboard()
+
+	keyboard.SetLock("test", time.Minute)
+	keyboard.WriteString("prompt>")
+	keyboard.SendPromptHint()
+	keyboard.WriteCommand(Command{OpCode: NOP}, nil)
+
+	remote.expect(t, testNOP)
+	remote.expectNothing(t)
+
+	keyboard.ClearLock("test")
+	remote.expect(t, concat([]byte("prompt>"), testGA))
+}
+
+func TestKeyboardPromptHintsAreNotHeldByLocks(t *testing.T) {
+	terminal, remote := newTestTerminal(t, TerminalConfig{})
+	keyboard := terminal.Keyboard()
+
+	keyboard.SetLock("test", time.Minute)
+	keyboard.SendPromptHint()
+	keyboard.WriteString("held")
+
+	remote.expect(t, testGA)
+	remote.expectNothing(t)
+
+	keyboard.ClearLock("test")
+	remote.expect(t, []byte("held"))
+}
+
+func TestKeyboardUrgentDataBypassesWaitingText(t *testing.T) {
+	terminal, remote := newTestTerminal(t, TerminalConfig{})
+	keyboard := terminal.Keyboard()
+
+	keyboard.SetLock("test", time.Minute)
+	keyboard.WriteString("held")
+	err := keyboard.WriteUrgent(TextData("urgent"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remote.expect(t, []byte("urgent"))
+	remote.expectNothing(t)
+
+	keyboard.ClearLock("test")
+	remote.expect(t, []byte("held"))
+}
+
+func TestKeyboardCommandsAfterTextKeepOrderWithLineOut(t *testing.T) {
+	terminal, remote := newTestTerminal(t, TerminalConfig{})
+	keyboard := terminal.Keyboard()
+
+	resume := stopKeyboard(t, keyboard, remote)
+	keyboard.LineOut(terminal, TextData("one"))
+	keyboard.WriteCommand(Command{OpCode: NOP}, nil)
+	keyboard.LineOut(terminal, TextData("two"))
+	keyboard.WriteCommandAfterText(Command{OpCode: GA}, nil)
+	keyboard.WriteString("three")
+	resume()
+
+	remote.expect(t, concat(testNOP, []byte("onetwo"), testGA, []byte("three")))
+}
+
+func TestKeyboardCommandsAfterTextBypassHeldText(t *testing.T) {
+	terminal, remote := newTestTerminal(t, TerminalConfig{})
+	keyboard := terminal.Keyboard()
+
+	keyboard.SetLock("test", time.Minute)
+	keyboard.LineOut(terminal, TextData("held"))
+	keyboard.WriteCommandAfterText(Command{OpCode: NOP}, nil)
+
+	remote.expect(t, testNOP)
+	remote.expectNothing(t)
+
+	keyboard.ClearLock("test")
+	remote.expect(t, []byte("held"))
+}
+
+func TestKeyboardCommandsAfterTextWaitForReleasedText(t *testing.T) {
+	terminal, remote := newTestTerminal(t, TerminalConfig{})
+	keyboard := terminal.Keyboard()
+
+	// The keyboard is stopped after the text has been held, so that the lock can be cleared
+	// before the keyboard gets a chance to release the text
+	keyboard.SetLock("test", time.Minute)
+	keyboard.WriteString("held")
+	resume := stopKeyboard(t, keyboard, remote)
+
+	keyboard.ClearLock("test")
+	keyboard.WriteCommandAfterText(Command{OpCode: GA}, nil)
+	keyboard.WriteString("after")
+	resume()
+
+	remote.expect(t, concat([]byte("held"), testGA, []byte("after")))
+}
+
+func TestKeyboardCommandsAfterTextRunPostSendBeforeLaterText(t *testing.T) {
+	terminal, remote := newTestTerminal(t, TerminalConfig{})
+	keyboard := terminal.Keyboard()
+
+	written := make(chan struct{})
+	release := make(chan struct{})
+	keyboard.WriteString("before")
+	keyboard.WriteCommandAfterText(Command{OpCode: NOP}, func() error {
+		close(written)
+		<-release
+		return nil
+	})
+	keyboard.WriteString("after")
+
+	remote.expect(t, concat([]byte("before"), testNOP))
+	<-written
+	remote.expectNothing(t)
+
+	close(release)
+	remote.expect(t, []byte("after"))
+}
@@ -0,0 +1,142 @@
+package telnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// LifecyclePhase indicates which phase of its session a terminal has entered
+type LifecyclePhase byte
+
+const (
+	// LifecycleConnected indicates that the terminal has been created and is reading from and
+	// writing to the connection
+	LifecycleConnected LifecyclePhase = iota
+	// LifecycleNegotiationStarted indicates that the terminal has sent its initial telopt requests
+	LifecycleNegotiationStarted
+	// LifecycleNegotiationSettled indicates that the initial telopt negotiation has completed.
+	// It is raised alongside NegotiationComplete hooks.
+	LifecycleNegotiationSettled
+	// LifecycleClosing indicates that the terminal has begun to shut down
+	LifecycleClosing
+	// LifecycleClosed indicates that the terminal has shut down. It is the last event the terminal
+	// raises, and is delivered before WaitForExit returns.
+	LifecycleClosed
+)
+
+func (p LifecyclePhase) String() string {
+	switch p {
+	case LifecycleConnected:
+		return "Connected"
+	case LifecycleNegotiationStarted:
+		return "NegotiationStarted"
+	case LifecycleNegotiationSettled:
+		return "NegotiationSettled"
+	case LifecycleClosing:
+		return "Closing"
+	case LifecycleClosed:
+		return "Closed"
+	default:
+		return fmt.Sprintf("LifecyclePhase(%d)", int(p))
+	}
+}
+
+// CloseReason indicates why a terminal shut down
+type CloseReason byte
+
+const (
+	// CloseRequested indicates that Terminal.Close or Terminal.CloseWithTimeout was called
+	CloseRequested CloseReason = iota
+	// CloseContextCancelled indicates that the context passed to NewTerminal was cancelled
+	CloseContextCancelled
+	// ClosePeerClosed indicates that the remote closed the connection
+	ClosePeerClosed
+	// CloseConnectionError indicates that reading from the connection failed
+	CloseConnectionError
+)
+
+func (r CloseReason) String() string {
+	switch r {
+	case CloseRequested:
+		return "Requested"
+	case CloseContextCancelled:
+		return "ContextCancelled"
+	case ClosePeerClosed:
+		return "PeerClosed"
+	case CloseConnectionError:
+		return "ConnectionError"
+	default:
+		return fmt.Sprintf("CloseReason(%d)", int(r))
+	}
+}
+
+// LifecycleEvent is delivered to Lifecycle hooks whenever the terminal enters a new phase of its
+// session, so that applications and metrics can track sessions without inferring their phases
+// from other events. Each phase is raised once, in order, except that LifecycleNegotiationSettled
+// is not raised if the terminal shuts down before negotiation completes, and may follow
+// LifecycleClosing if negotiation completes while the terminal is closing.
+type LifecycleEvent struct {
+	Phase LifecyclePhase
+	Time  time.Time
+	// NegotiationReason is populated for LifecycleNegotiationSettled
+	NegotiationReason NegotiationCompleteReason
+	// CloseReason is populated for LifecycleClosing and LifecycleClosed
+	CloseReason CloseReason
+	// Err is populated for LifecycleClosed, and is the error returned by WaitForExit
+	Err error
+}
+
+func (e LifecycleEvent) String() string {
+	switch {
+	case e.Phase == LifecycleNegotiationSettled:
+		return fmt.Sprintf("%s: %s", e.Phase, e.NegotiationReason)
+	case e.Phase == LifecycleClosed && e.Err != nil:
+		return fmt.Sprintf("%s: %s: %s", e.Phase, e.CloseReason, e.Err)
+	case e.Phase == LifecycleClosing || e.Phase == LifecycleClosed:
+		return fmt.Sprintf("%s: %s", e.Phase, e.CloseReason)
+	default:
+		return e.Phase.String()
+	}
+}
+
+func (t *Terminal) raiseLifecycleEvent(event LifecycleEvent) {
+	event.Time = time.Now()
+	t.lifecycleHooks.Fire(t, event)
+}
+
+// beginClosing raises LifecycleClosing the first time it is called
+func (t *Terminal) beginClosing(reason CloseReason) {
+	t.closingOnce.Do(func() {
+		t.closeReason = reason
+		t.raiseLifecycleEvent(LifecycleEvent{
+			Phase:       LifecycleClosing,
+			CloseReason: reason,
+		})
+	})
+}
+
+// connectionEnded is called once the printer has stopped, with the error it stopped with
+func (t *Terminal) connectionEnded(ctx context.Context, err error) {
+	t.exitErr = err
+
+	switch {
+	case ctx.Err() != nil:
+		t.beginClosing(CloseContextCancelled)
+	case err == nil || errors.Is(err, ErrPeerClosed):
+		t.beginClosing(ClosePeerClosed)
+	default:
+		t.beginClosing(CloseConnectionError)
+	}
+}
+
+// closed raises LifecycleClosed. It is called by the terminal loop once every other event has
+// been delivered.
+func (t *Terminal) closed() {
+	t.raiseLifecycleEvent(LifecycleEvent{
+		Phase:       LifecycleClosed,
+		CloseReason: t.closeReason,
+		Err:         t.exitErr,
+	})
+}
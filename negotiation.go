@@ -0,0 +1,181 @@
+package telnet
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultNegotiationQuietPeriod is the amount of time without negotiation traffic after which
+// telopt negotiation is considered complete, if TerminalConfig.NegotiationQuietPeriod is not set
+const DefaultNegotiationQuietPeriod = 500 * time.Millisecond
+
+// NegotiationCompleteReason indicates why the terminal decided that the initial telopt
+// negotiation had completed
+type NegotiationCompleteReason byte
+
+const (
+	// NegotiationQuiet indicates that no negotiation traffic was received for the quiet period
+	NegotiationQuiet NegotiationCompleteReason = iota
+	// NegotiationResolved indicates that the remote responded to every telopt that this terminal
+	// requested. This is only used when TerminalConfig.CompleteNegotiationWhenResolved is set.
+	NegotiationResolved
+	// NegotiationDeadline indicates that negotiation was cut short because it did not complete
+	// before a deadline, such as ServerConfig.NegotiationDeadline
+	NegotiationDeadline
+)
+
+func (r NegotiationCompleteReason) String() string {
+	switch r {
+	case NegotiationQuiet:
+		return "Quiet"
+	case NegotiationResolved:
+		return "Resolved"
+	case NegotiationDeadline:
+		return "Deadline"
+	default:
+		return "Unknown"
+	}
+}
+
+// NegotiationCompleteEvent is delivered to NegotiationComplete hooks once the initial storm of telopt
+// negotiation at the start of a connection has settled
+type NegotiationCompleteEvent struct {
+	Reason NegotiationCompleteReason
+	// Elapsed is the amount of time between the terminal starting and negotiation completing
+	Elapsed time.Duration
+	// Unresolved contains the telopts that were still waiting on a response from the remote, on
+	// either side, when negotiation completed
+	Unresolved []TelnetOption
+}
+
+type negotiationTracker struct {
+	quietPeriod     time.Duration
+	whenResolved    bool
+	start           time.Time
+	done            chan struct{}
+	lock            sync.Mutex
+	timer           *time.Timer
+	complete        bool
+	completeHandler func(reason NegotiationCompleteReason, elapsed time.Duration)
+}
+
+func newNegotiationTracker(config TerminalConfig, completeHandler func(reason NegotiationCompleteReason, elapsed time.Duration)) *negotiationTracker {
+	quietPeriod := config.NegotiationQuietPeriod
+	if quietPeriod <= 0 {
+		quietPeriod = DefaultNegotiationQuietPeriod
+	}
+
+	tracker := &negotiationTracker{
+		quietPeriod:     quietPeriod,
+		whenResolved:    config.CompleteNegotiationWhenResolved,
+		start:           time.Now(),
+		done:            make(chan struct{}),
+		completeHandler: completeHandler,
+	}
+
+	// finish uses the timer, so it can't run until the timer has been stored
+	tracker.lock.Lock()
+	tracker.timer = time.AfterFunc(quietPeriod, func() {
+		tracker.finish(NegotiationQuiet)
+	})
+	tracker.lock.Unlock()
+
+	return tracker
+}
+
+// activity is called whenever negotiation traffic arrives from the remote, in order to
+// restart the quiet period
+func (n *negotiationTracker) activity() {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if !n.complete {
+		n.timer.Reset(n.quietPeriod)
+	}
+}
+
+// finish completes negotiation for the provided reason. It returns false if negotiation had
+// already completed.
+func (n *negotiationTracker) finish(reason NegotiationCompleteReason) bool {
+	n.lock.Lock()
+	if n.complete {
+		n.lock.Unlock()
+		return false
+	}
+
+	n.complete = true
+	n.timer.Stop()
+	close(n.done)
+	n.lock.Unlock()
+
+	n.completeHandler(reason, time.Since(n.start))
+	return true
+}
+
+// checkNegotiationResolved completes negotiation if the terminal has been configured to do
+// so once no telopts are waiting on a response from the remote
+func (t *Terminal) checkNegotiationResolved() {
+	if !t.negotiation.whenResolved {
+		return
+	}
+
+	if len(t.unresolvedTelOpts()) > 0 {
+		return
+	}
+
+	t.negotiation.finish(NegotiationResolved)
+}
+
+// unresolvedTelOpts returns the telopts that are waiting on a response from the remote
+func (t *Terminal) unresolvedTelOpts() []TelnetOption {
+	var unresolved []TelnetOption
+	for _, option := range t.telOptList() {
+		if option.LocalState() == TelOptRequested || option.RemoteState() == TelOptRequested {
+			unresolved = append(unresolved, option)
+		}
+	}
+
+	return unresolved
+}
+
+// negotiationComplete is called by the negotiation tracker on whichever goroutine completed
+// negotiation. The hooks are run by the terminal loop, like other events.
+func (t *Terminal) negotiationComplete(reason NegotiationCompleteReason, elapsed time.Duration) {
+	if t.ctx.Err() != nil {
+		return
+	}
+
+	t.keyboard.settled.Store(true)
+
+	t.eventPump.EncounteredNegotiationComplete(NegotiationCompleteEvent{
+		Reason:     reason,
+		Elapsed:    elapsed,
+		Unresolved: t.unresolvedTelOpts(),
+	})
+}
+
+// WaitForNegotiation blocks until the initial telopt negotiation with the remote has settled,
+// which is useful for clients that want to wait before printing a login banner or sending
+// credentials. Negotiation is considered complete when no negotiation traffic has been received
+// for TerminalConfig.NegotiationQuietPeriod, or (if TerminalConfig.CompleteNegotiationWhenResolved
+// is set) when the remote has responded to every telopt this terminal requested.
+//
+// An error is returned if ctx is cancelled, or if the terminal shuts down, before negotiation
+// completes.
+func (t *Terminal) WaitForNegotiation(ctx context.Context) error {
+	select {
+	case <-t.negotiation.done:
+		return nil
+	default:
+	}
+
+	select {
+	case <-t.negotiation.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.ctx.Done():
+		return t.ctx.Err()
+	}
+}
@@ -0,0 +1,140 @@
+package telnet
+
+import (
+	"fmt"
+	"time"
+)
+
+// TelOptNegotiationTimeoutEvent is a TelOptEvent raised when the remote has not answered a WILL/DO
+// request within the telopt's negotiation timeout. The side of the telopt has already been moved
+// to TelOptInactive when this event is raised. See TerminalConfig.TelOptNegotiationTimeout.
+type TelOptNegotiationTimeoutEvent struct {
+	TelnetOption TelnetOption
+	Side         TelOptSide
+	Timeout      time.Duration
+	// RetryDelay is the amount of time after which the request will be sent again, or zero if
+	// it won't be
+	RetryDelay time.Duration
+}
+
+func (e TelOptNegotiationTimeoutEvent) Option() TelnetOption {
+	return e.TelnetOption
+}
+
+func (e TelOptNegotiationTimeoutEvent) String() string {
+	if e.RetryDelay > 0 {
+		return fmt.Sprintf("%s: remote did not answer %s request within %s, retrying in %s", e.TelnetOption, e.Side, e.Timeout, e.RetryDelay)
+	}
+
+	return fmt.Sprintf("%s: remote did not answer %s request within %s", e.TelnetOption, e.Side, e.Timeout)
+}
+
+// telOptNegotiationTimeout returns the negotiation timeout for the telopt with the provided
+// code, or zero if its requests don't time out
+func (t *Terminal) telOptNegotiationTimeout(code TelOptCode) time.Duration {
+	timeout, hasTimeout := t.negotiationTimeouts[code]
+	if !hasTimeout {
+		timeout = t.negotiationTimeout
+	}
+
+	return max(timeout, 0)
+}
+
+// startNegotiationTimeout begins timing a WILL/DO request that was just sent for one side of
+// the provided option. It must be called with the negotiation's lock held, after the negotiation
+// has moved to qWantYes. retry indicates that this request is the retry of a request that timed
+// out, which waits twice as long for an answer and is not retried again.
+func (t *Terminal) startNegotiationTimeout(option TelnetOption, side TelOptSide, negotiation *telOptSideNegotiation, retry bool) {
+	negotiation.requestGeneration++
+	negotiation.retry = retry
+
+	if negotiation.timeoutTimer != nil {
+		negotiation.timeoutTimer.Stop()
+		negotiation.timeoutTimer = nil
+	}
+
+	timeout := t.telOptNegotiationTimeout(option.Code())
+	if timeout == 0 {
+		return
+	}
+
+	if retry {
+		timeout *= 2
+	}
+
+	generation := negotiation.requestGeneration
+	negotiation.timeoutTimer = time.AfterFunc(timeout, func() {
+		t.negotiationTimedOut(option, side, generation, timeout)
+	})
+}
+
+// negotiationTimedOut moves one side of the provided option to inactive if the request
+// identified by generation is still waiting for an answer from the remote
+func (t *Terminal) negotiationTimedOut(option TelnetOption, side TelOptSide, generation uint64, timeout time.Duration) {
+	if t.ctx.Err() != nil {
+		return
+	}
+
+	negotiation := t.telOptNegotiation(option.Code(), side)
+
+	negotiation.lock.Lock()
+	if negotiation.requestGeneration != generation || negotiation.state != qWantYes {
+		negotiation.lock.Unlock()
+		return
+	}
+
+	// If we changed our minds while waiting, the telopt is already inactive and there's nothing to retry
+	retry := t.retryNegotiation && !negotiation.retry && !negotiation.opposite
+	negotiation.state = qNo
+	negotiation.opposite = false
+	negotiation.timeoutTimer = nil
+	t.clearTelOptDisableLock(option, side)
+	negotiation.lock.Unlock()
+
+	err := t.applyTelOptNegotiation(option, side, TelOptInactive, false, false, TelOptChangeTimeout)
+	if err != nil {
+		t.encounteredError(err)
+	}
+
+	var retryDelay time.Duration
+	if retry {
+		retryDelay = timeout
+		time.AfterFunc(retryDelay, func() {
+			t.retryTelOptNegotiation(option, side)
+		})
+	}
+
+	t.RaiseTelOptEvent(TelOptNegotiationTimeoutEvent{
+		TelnetOption: option,
+		Side:         side,
+		Timeout:      timeout,
+		RetryDelay:   retryDelay,
+	})
+
+	t.checkNegotiationResolved()
+}
+
+// retryTelOptNegotiation sends a request that timed out a second time, as long as the side of
+// the telopt is still inactive and permitted
+func (t *Terminal) retryTelOptNegotiation(option TelnetOption, side TelOptSide) {
+	if t.ctx.Err() != nil || checkTelOptAllowed(option, side) != nil {
+		return
+	}
+
+	negotiation := t.telOptNegotiation(option.Code(), side)
+
+	negotiation.lock.Lock()
+	if negotiation.state != qNo {
+		negotiation.lock.Unlock()
+		return
+	}
+
+	negotiation.state = qWantYes
+	t.startNegotiationTimeout(option, side, negotiation, true)
+	negotiation.lock.Unlock()
+
+	err := t.applyTelOptNegotiation(option, side, TelOptRequested, true, true, TelOptChangeTimeout)
+	if err != nil {
+		t.encounteredError(err)
+	}
+}
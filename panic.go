@@ -0,0 +1,68 @@
+package telnet
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicError is delivered to EncounteredError hooks when an event hook, middleware, or telopt
+// panics. The terminal recovers from the panic and continues running, unless
+// TerminalConfig.FailFastOnPanic is set.
+type PanicError struct {
+	// Value is the value that was passed to panic
+	Value any
+	// Stack is the stack trace of the goroutine that panicked, captured at the time of the panic
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("recovered from panic: %v\n%s", e.Value, e.Stack)
+}
+
+// Unwrap returns the panic value if it was an error
+func (e *PanicError) Unwrap() error {
+	err, isErr := e.Value.(error)
+	if !isErr {
+		return nil
+	}
+
+	return err
+}
+
+func newPanicError(value any) *PanicError {
+	return &PanicError{
+		Value: value,
+		Stack: debug.Stack(),
+	}
+}
+
+// callRecovering calls f, converting a panic into a *PanicError, which is returned. If the
+// terminal has been configured to fail fast, the panic is not recovered.
+func (t *Terminal) callRecovering(f func() error) (err error) {
+	if t == nil || t.failFastOnPanic {
+		return f()
+	}
+
+	defer func() {
+		value := recover()
+		if value != nil {
+			err = newPanicError(value)
+		}
+	}()
+
+	return f()
+}
+
+// hookPanicked delivers a panic recovered from a hook to EncounteredError hooks. If the hook that
+// panicked was itself receiving a PanicError, the panic is dropped, so that an EncounteredError hook
+// that always panics can't send the terminal into a loop.
+func (t *Terminal) hookPanicked(err error, eventData any) {
+	receivedErr, isErr := eventData.(error)
+	var panicErr *PanicError
+	if isErr && errors.As(receivedErr, &panicErr) {
+		return
+	}
+
+	t.encounteredError(err)
+}
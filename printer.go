@@ -1,11 +1,14 @@
 package telnet
 
 import (
-	"bufio"
 	"context"
 	"errors"
 	"io"
 	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/charmbracelet/x/ansi"
 )
 
 // TelnetPrinter is a Terminal subsidiary that parses text sent by the remote peer.
@@ -16,18 +19,87 @@ type TelnetPrinter struct {
 	complete       chan error
 	eventPump      *terminalEventPump
 	promptCommands atomicPromptCommands
+	promptFilter   atomic.Uint32
+	prompts        promptTracker
 	middlewares    *MiddlewareStack
+	closing        atomic.Bool
+	stats          directionStats
+
+	// pause is held by the printer loop at all times except while it is waiting on the
+	// input stream, so holding it freezes the loop
+	pause printerLock
+
+	// paused is non-nil while the printer has been paused with Pause, and is closed by Resume
+	pausedLock sync.Mutex
+	paused     chan struct{}
+}
+
+// printerLock is a mutex that runs queued tasks before it is released. Negotiation changes
+// requested from outside the printer loop are queued on the printer's pause lock, so that they are
+// serialized with the negotiations that the printer loop carries out for the remote.
+type printerLock struct {
+	lock sync.Mutex
+
+	queueLock sync.Mutex
+	queue     []func()
+}
+
+var _ sync.Locker = &printerLock{}
+
+func (l *printerLock) Lock() {
+	l.lock.Lock()
+}
+
+// Unlock runs any queued tasks, including tasks they queue, and then releases the lock
+func (l *printerLock) Unlock() {
+	for {
+		l.queueLock.Lock()
+		if len(l.queue) == 0 {
+			// Releasing the lock before the queue lock means that anyone who queues a task
+			// after this point will find the lock free
+			l.lock.Unlock()
+			l.queueLock.Unlock()
+			return
+		}
+
+		tasks := l.queue
+		l.queue = nil
+		l.queueLock.Unlock()
+
+		for _, task := range tasks {
+			task()
+		}
+	}
 }
 
-func newTelnetPrinter(charset *Charset, inputStream io.Reader, eventPump *terminalEventPump) *TelnetPrinter {
+// run queues a task to be run while the lock is held. If the lock is free, the task is run before
+// run returns. Otherwise, it is run by the current holder when they release the lock.
+func (l *printerLock) run(task func()) {
+	l.queueLock.Lock()
+	l.queue = append(l.queue, task)
+	l.queueLock.Unlock()
+
+	if l.lock.TryLock() {
+		l.Unlock()
+	}
+}
+
+func newTelnetPrinter(charset *Charset, inputStream io.Reader, eventPump *terminalEventPump, config TerminalConfig) *TelnetPrinter {
 	scanner := NewTelnetScanner(charset, inputStream)
+	scanner.SetPartialSequenceTimeout(config.PartialSequenceTimeout)
+	scanner.SetIdleBufferRelease(config.IdleBufferRelease)
+	scanner.SetSynchDiscard(config.SynchDiscard)
+	scanner.SetRawTap(config.PrinterRawTap)
 
 	printer := &TelnetPrinter{
 		scanner:   scanner,
 		complete:  make(chan error, 1),
 		eventPump: eventPump,
+		prompts:   promptTracker{sincePrompt: true},
 	}
 	printer.promptCommands.Init()
+	printer.promptFilter.Store(uint32(config.PromptFilter))
+	scanner.waitLock = &printer.pause
 
 	return printer
 }
@@ -39,7 +111,10 @@ func (p *TelnetPrinter) isSuppressedPromptCommand(t PromptCommands) bool {
 }
 
 func (p *TelnetPrinter) printerLoop(ctx context.Context, terminal *Terminal) {
-	for ctx.Err() == nil && p.scanner.Scan(ctx) {
+	p.pause.Lock()
+	defer p.pause.Unlock()
+
+	for ctx.Err() == nil && p.waitWhilePaused(ctx) && p.scanner.Scan(ctx) {
 		if p.scanner.Err() != nil {
 			// Don't worry about temporary errors
 			var netErr net.Error
@@ -49,7 +124,7 @@ func (p *TelnetPrinter) printerLoop(ctx context.Context, terminal *Terminal) {
 				}
 			}
 
-			p.eventPump.EncounteredError(p.scanner.Err())
+			p.eventPump.EncounteredError(connectionError(p.scanner.Err()))
 		} else if ctx.Err() != nil {
 			break
 		}
@@ -60,9 +135,20 @@ func (p *TelnetPrinter) printerLoop(ctx context.Context, terminal *Terminal) {
 			continue
 		}
 
+		p.stats.recordActivity()
+
+		if _, isPrompt := output.(PromptData); !isPrompt {
+			p.prompts.observe(output)
+		}
+
 		switch o := output.(type) {
+		case ControlCodeData:
+			if ansi.ControlCode(o) == ansi.LF {
+				p.stats.lines.Add(1)
+			}
 		case PromptData:
-			if p.isSuppressedPromptCommand(PromptCommands(o)) {
+			if p.isSuppressedPromptCommand(PromptCommands(o)) ||
+				!p.prompts.allowPrompt(p.PromptFilter()) {
 				continue
 			}
 		case CommandData:
@@ -70,21 +156,90 @@ func (p *TelnetPrinter) printerLoop(ctx context.Context, terminal *Terminal) {
 				continue
 			}
 
-			terminal.processTelOptCommand(o.Command)
+			p.stats.recordCommand(o.Command)
+
+			err := terminal.processTelOptCommand(o.Command)
+			if err != nil {
+				p.eventPump.EncounteredError(err)
+			}
 		}
 
-		p.eventPump.EncounteredPrinterOutput(p.scanner.Output())
+		terminal.sinks.publish(SinkSourcePrinter, output)
+		p.eventPump.EncounteredPrinterOutput(output)
 	}
 
-	if ctx.Err() != nil && !errors.Is(ctx.Err(), context.Canceled) {
+	if p.closing.Load() {
+		// The terminal closed the connection out from under us, so errors are expected
+		p.complete <- nil
+	} else if ctx.Err() != nil && !errors.Is(ctx.Err(), context.Canceled) {
 		p.complete <- ctx.Err()
 	} else if p.scanner.Err() != nil && !errors.Is(p.scanner.Err(), net.ErrClosed) {
-		p.complete <- p.scanner.Err()
+		p.complete <- connectionError(p.scanner.Err())
 	} else {
 		p.complete <- nil
 	}
 }
 
+// waitWhilePaused blocks while the printer is paused. It returns false if ctx is done first.
+func (p *TelnetPrinter) waitWhilePaused(ctx context.Context) bool {
+	p.pausedLock.Lock()
+	resumed := p.paused
+	p.pausedLock.Unlock()
+
+	if resumed == nil {
+		return true
+	}
+
+	// Don't hold up DebugSnapshot while we're paused
+	p.pause.Unlock()
+	defer p.pause.Lock()
+
+	select {
+	case <-resumed:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Pause stops the printer from reading from the connection until Resume is called. Output that
+// is currently being delivered is finished and the printer's read buffer may fill, but after that
+// nothing more is read, so TCP flow control pushes back on the remote instead of output piling up
+// in hooks that can't keep up, such as a UI rendering a huge burst of text. Calling Pause while
+// the printer is paused has no effect.
+//
+// Bear in mind that telopt negotiations are not processed while the printer is paused, and the
+// remote closing the connection is not noticed until the printer is resumed. Closing the terminal
+// works as usual.
+func (p *TelnetPrinter) Pause() {
+	p.pausedLock.Lock()
+	defer p.pausedLock.Unlock()
+
+	if p.paused == nil {
+		p.paused = make(chan struct{})
+	}
+}
+
+// Resume allows the printer to read from the connection again after Pause. Calling Resume while
+// the printer is not paused has no effect.
+func (p *TelnetPrinter) Resume() {
+	p.pausedLock.Lock()
+	defer p.pausedLock.Unlock()
+
+	if p.paused != nil {
+		close(p.paused)
+		p.paused = nil
+	}
+}
+
+// Paused indicates whether the printer has been paused with Pause
+func (p *TelnetPrinter) Paused() bool {
+	p.pausedLock.Lock()
+	defer p.pausedLock.Unlock()
+
+	return p.paused != nil
+}
+
 // waitForExit will block until the printer is disposed of
 func (p *TelnetPrinter) waitForExit() error {
 	err := <-p.complete
@@ -106,20 +261,69 @@ func (p *TelnetPrinter) ClearPromptCommand(flag PromptCommands) {
 	p.promptCommands.ClearPromptCommand(flag)
 }
 
+// PromptCommands returns the prompt commands that the printer will deliver
+func (p *TelnetPrinter) PromptCommands() PromptCommands {
+	return p.promptCommands.Get()
+}
+
+// SetPromptFilter changes which prompt signals the printer drops. See PromptFilter.
+func (p *TelnetPrinter) SetPromptFilter(filter PromptFilter) {
+	p.promptFilter.Store(uint32(filter))
+}
+
+// PromptFilter returns the flags deciding which prompt signals the printer drops
+func (p *TelnetPrinter) PromptFilter() PromptFilter {
+	return PromptFilter(p.promptFilter.Load())
+}
+
+// SetSynchDiscard changes whether the printer discards text received ahead of an IAC DM. See
+// TerminalConfig.SynchDiscard.
+func (p *TelnetPrinter) SetSynchDiscard(discard bool) {
+	p.scanner.SetSynchDiscard(discard)
+}
+
+// SynchDiscard indicates whether the printer discards text received ahead of an IAC DM
+func (p *TelnetPrinter) SynchDiscard() bool {
+	return p.scanner.SynchDiscard()
+}
+
+// WrapReader replaces the reader that the printer reads from with one produced by wrap, which
+// receives the underlying connection. Data that the printer has already read from the
+// connection but not yet processed is delivered by the reader passed to wrap before any new
+// data, so a telopt that calls WrapReader while processing the command that begins a compressed
+// or encrypted stream will receive the whole stream.
 func (p *TelnetPrinter) WrapReader(wrap func(reader io.Reader) (io.Reader, error)) error {
-	wrapped, err := wrap(p.scanner.baseStream)
+	wrapped, err := wrap(p.scanner.remainingStream())
 	if err != nil {
 		return err
 	}
 
-	p.scanner.inputStream = wrapped
-	scan := bufio.NewScanner(wrapped)
-	scan.Split(p.scanner.ScanTelnet)
-	p.scanner.scanner = scan
+	p.scanner.setInputStream(wrapped)
 
 	return nil
 }
 
+// SaveState returns a snapshot of the data that the printer has received but not yet
+// processed. Like WrapReader, it is intended to be called by telopts while they process a
+// command, such as one that begins a new transport. See TelnetScanner.SaveState.
+func (p *TelnetPrinter) SaveState() (ScannerState, error) {
+	return p.scanner.SaveState()
+}
+
+// RestoreState replaces the data that the printer has received but not yet processed with a
+// state produced by SaveState, possibly by another terminal's printer. It is subject to the same
+// restrictions as SaveState. See TelnetScanner.RestoreState.
+func (p *TelnetPrinter) RestoreState(state ScannerState) error {
+	return p.scanner.RestoreState(state)
+}
+
+// StreamStats returns statistics about the data that has been read by the printer. This is
+// primarily useful for sessions that have been wrapped by a decompressing reader (such as MCCP)
+// via WrapReader, in order to display compression savings.
+func (p *TelnetPrinter) StreamStats() StreamStats {
+	return p.scanner.StreamStats()
+}
+
 func (p *TelnetPrinter) Middlewares() *MiddlewareStack {
 	return p.middlewares
 }
@@ -0,0 +1,85 @@
+package telnet
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/x/ansi"
+)
+
+// PromptFilter is a set of flags indicating which prompt signals (IAC GA/IAC EOR) the printer
+// should drop instead of delivering as PromptData. Some servers send GA after every line,
+// or several times in a row, which produces a flood of PromptData that consumers would otherwise
+// have to debounce themselves. The zero value delivers every prompt signal.
+type PromptFilter uint32
+
+const (
+	// PromptFilterConsecutive drops prompt signals that follow the last delivered prompt signal
+	// with nothing in between except other commands
+	PromptFilterConsecutive PromptFilter = 1 << iota
+	// PromptFilterEmpty drops prompt signals that carry no text of their own: that is, prompt
+	// signals that arrive before any text has been received since the last line break or the last
+	// delivered prompt signal. This suppresses servers that send GA after every line while keeping
+	// the prompts that follow text such as "Enter your name: ". Because a prompt signal that
+	// follows another one carries no text either, this also coalesces consecutive prompt signals.
+	PromptFilterEmpty
+)
+
+func (f PromptFilter) String() string {
+	var names []string
+	if f&PromptFilterConsecutive != 0 {
+		names = append(names, "Consecutive")
+	}
+	if f&PromptFilterEmpty != 0 {
+		names = append(names, "Empty")
+	}
+
+	if len(names) == 0 {
+		return "None"
+	}
+
+	return strings.Join(names, "|")
+}
+
+// promptTracker follows the printer's output to decide which prompt signals a PromptFilter
+// drops. It is only used by the printer loop.
+type promptTracker struct {
+	// sincePrompt indicates that something other than a command has been received since the
+	// last delivered prompt signal
+	sincePrompt bool
+	// lineText indicates that text has been received since the last line break or delivered
+	// prompt signal
+	lineText bool
+}
+
+// observe records output that is not a prompt signal
+func (t *promptTracker) observe(output TerminalData) {
+	switch o := output.(type) {
+	case CommandData:
+	case TextData:
+		t.sincePrompt = true
+		t.lineText = true
+	case ControlCodeData:
+		t.sincePrompt = true
+		if ansi.ControlCode(o) == ansi.LF {
+			t.lineText = false
+		}
+	default:
+		t.sincePrompt = true
+	}
+}
+
+// allowPrompt indicates whether filter permits a prompt signal to be delivered, and if so,
+// records it as the last delivered prompt signal
+func (t *promptTracker) allowPrompt(filter PromptFilter) bool {
+	if filter&PromptFilterConsecutive != 0 && !t.sincePrompt {
+		return false
+	}
+
+	if filter&PromptFilterEmpty != 0 && !t.lineText {
+		return false
+	}
+
+	t.sincePrompt = false
+	t.lineText = false
+	return true
+}
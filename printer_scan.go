@@ -6,10 +6,22 @@ import (
 	"context"
 	"errors"
 	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 
 	"golang.org/x/text/transform"
 )
 
+// MaxSubnegotiationSize is the largest subnegotiation, in bytes, that TelnetScanner will wait
+// to receive in full. This is well below bufio.MaxScanTokenSize.
+const MaxSubnegotiationSize = 32 * 1024
+
+// ErrScanInFlight is returned when a TelnetScanner's state is saved or restored while a read that
+// was abandoned by the partial sequence timeout is still waiting on the input stream
+var ErrScanInFlight = errors.New("telnet: a scan is still in flight")
+
 // TelnetScanner is used internally by TelnetPrinter to read sequences from a Reader and output
 // units of received output.  It is exported due to the object being potentially useful outside
 // the context of this library's Terminal object. If you intend to use Terminal, there is no
@@ -36,8 +48,28 @@ type TelnetScanner struct {
 	baseStream  io.Reader
 	inputStream io.Reader
 
-	scanner    *bufio.Scanner
-	scanResult chan bool
+	scanner *bufio.Scanner
+	// scanRequests and scanResult are used to run scans on the reader goroutine, which runs
+	// until the context it was started with is done, and closes readerDone when it exits
+	scanRequests chan *bufio.Scanner
+	scanResult   chan bool
+	readerDone   chan struct{}
+	scanInFlight bool
+	// unscanned is the data the scanner had buffered past the most recent token. It aliases
+	// the scanner's buffer, so it is only valid until the next scan.
+	unscanned []byte
+	// pastStreamStart indicates that a token has been scanned from the base stream
+	pastStreamStart bool
+	// textToken indicates that the most recent token is text, even if it begins with IAC
+	textToken bool
+
+	partialTimeout time.Duration
+	idleRelease    time.Duration
+	synchDiscard   atomic.Bool
+	rawTap         rawTap
+
+	// waitLock, if set, is released while the scanner is blocked waiting on the input stream
+	waitLock sync.Locker
 
 	charset       *Charset
 	parser        *TerminalDataParser
@@ -47,27 +79,84 @@ type TelnetScanner struct {
 	err        error
 	nextOutput TerminalData
 	outCommand Command
+
+	wireBytes       atomic.Uint64
+	streamBytes     atomic.Uint64
+	corruptStreams  atomic.Uint64
+	cleanStreamEnds atomic.Uint64
 }
 
 // NewTelnetScanner creates a new TelnetScanner from a Charset (used to decode bytes from
 // the stream) and an input stream
 func NewTelnetScanner(charset *Charset, inputStream io.Reader) *TelnetScanner {
-	scan := bufio.NewScanner(inputStream)
-
 	scanner := &TelnetScanner{
-		baseStream:    inputStream,
-		inputStream:   inputStream,
-		scanner:       scan,
+		scanRequests:  make(chan *bufio.Scanner),
 		scanResult:    make(chan bool, 1),
 		charset:       charset,
 		parser:        NewTerminalDataParser(),
 		bytesToDecode: make([]byte, 0, 100),
 	}
 
-	scan.Split(scanner.ScanTelnet)
+	scanner.baseStream = &countingReader{
+		reader: &tapReader{reader: &retryingReader{reader: inputStream}, tap: &scanner.rawTap},
+		count:  &scanner.wireBytes,
+	}
+	scanner.setInputStream(scanner.baseStream)
+
 	return scanner
 }
 
+// setInputStream replaces the stream that the scanner is currently reading from.  Any
+// data buffered from the previous stream that has not yet been scanned is lost- use
+// remainingStream to pick it up.
+func (s *TelnetScanner) setInputStream(inputStream io.Reader) {
+	s.inputStream = inputStream
+	s.unscanned = nil
+	s.scanner = bufio.NewScanner(&countingReader{reader: inputStream, count: &s.streamBytes})
+	s.scanner.Split(s.scanTelnetTrackingBuffer)
+}
+
+// scanTelnetTrackingBuffer is the split function used by the underlying scanner. It keeps track
+// of the data buffered past each token, so that it isn't lost when the input stream is replaced.
+func (s *TelnetScanner) scanTelnetTrackingBuffer(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	s.textToken = false
+
+	if !s.pastStreamStart && len(data) > 0 && s.charset.UTF16Detection() {
+		bomSize, wait := scanUTF16BOM(data, atEOF)
+		if wait {
+			return 0, nil, nil
+		}
+
+		if bomSize > 0 {
+			s.pastStreamStart = true
+			s.textToken = true
+			s.unscanned = data[bomSize:]
+			return bomSize, data[:bomSize], nil
+		}
+	}
+
+	advance, token, err = s.ScanTelnet(data, atEOF)
+	if token != nil {
+		s.unscanned = data[advance:]
+		s.pastStreamStart = true
+	}
+
+	return advance, token, err
+}
+
+// remainingStream returns a reader for the base stream that begins with any data that has been
+// read from the current input stream but not yet scanned. Remotes usually begin sending data that
+// needs a wrapped reader (such as compressed or encrypted data) immediately after the command that
+// announces it, so it has often been read already by the time the command is processed.
+func (s *TelnetScanner) remainingStream() io.Reader {
+	if s.scanInFlight || len(s.unscanned) == 0 {
+		return s.baseStream
+	}
+
+	unscanned := bytes.Clone(s.unscanned)
+	return io.MultiReader(bytes.NewReader(unscanned), s.baseStream)
+}
+
 // Err returns the error, if any, raised by the most recent call to Scan
 func (s *TelnetScanner) Err() error {
 	return s.err
@@ -84,20 +173,93 @@ func (s *TelnetScanner) pushError(err error) {
 	}
 }
 
+func commandOutput(command Command) TerminalData {
+	if command.OpCode == GA {
+		return PromptData(PromptCommandGA)
+	} else if command.OpCode == EOR {
+		return PromptData(PromptCommandEOR)
+	} else if command.OpCode != 0 {
+		return CommandData{Command: command}
+	}
+
+	return nil
+}
+
 func (s *TelnetScanner) pushCommand() {
 	if s.nextOutput != nil {
 		return
 	}
 
-	if s.outCommand.OpCode == GA {
-		s.nextOutput = PromptData(PromptCommandGA)
-	} else if s.outCommand.OpCode == EOR {
-		s.nextOutput = PromptData(PromptCommandEOR)
-	} else if s.outCommand.OpCode != 0 {
-		s.nextOutput = CommandData{Command: s.outCommand}
+	s.nextOutput = commandOutput(s.outCommand)
+	s.outCommand = Command{}
+}
+
+// ScannerState is a snapshot of the data that a TelnetScanner has received but not yet
+// delivered from Scan, produced by SaveState
+type ScannerState struct {
+	// Unscanned holds bytes that have been read from the input stream but not yet examined
+	Unscanned []byte
+	// Undecoded holds bytes that have been examined but could not be decoded yet, such as the
+	// first bytes of a multi-byte character
+	Undecoded []byte
+	// Parser holds decoded data that has not been delivered yet, such as the start of an
+	// escape sequence
+	Parser TerminalDataParserState
+}
+
+// SaveState returns a snapshot of the data that the scanner has received but not yet delivered.
+// Together with RestoreState, this allows a session to be moved to a new scanner, or a new
+// transport to be installed, at any point in the stream without losing partial characters,
+// partial escape sequences, or buffered data. It must not be called concurrently with Scan.
+//
+// If a read abandoned by the partial sequence timeout is still waiting on the input stream, the
+// scanner's buffer is in use and ErrScanInFlight is returned.
+func (s *TelnetScanner) SaveState() (ScannerState, error) {
+	if s.scanInFlight {
+		return ScannerState{}, ErrScanInFlight
+	}
+
+	state := ScannerState{
+		Unscanned: bytes.Clone(s.unscanned),
+		Undecoded: bytes.Clone(s.bytesToDecode),
+		Parser:    s.parser.SaveState(),
+	}
+
+	// A command that hasn't been delivered yet is delivered before anything the parser holds
+	command := commandOutput(s.outCommand)
+	if command != nil {
+		state.Parser.Pending = append([]TerminalData{command}, state.Parser.Pending...)
+	}
+
+	return state, nil
+}
+
+// RestoreState discards the data that the scanner has received but not yet delivered, and
+// replaces it with a state produced by SaveState. The state's unscanned bytes are read before
+// anything else from the scanner's input stream. It must not be called concurrently with Scan.
+//
+// If a read abandoned by the partial sequence timeout is still waiting on the input stream,
+// ErrScanInFlight is returned and the scanner is unchanged.
+func (s *TelnetScanner) RestoreState(state ScannerState) error {
+	if s.scanInFlight {
+		return ErrScanInFlight
 	}
 
 	s.outCommand = Command{}
+	s.bytesToDecode = append(s.bytesToDecode[:0], state.Undecoded...)
+	s.parser.RestoreState(state.Parser)
+
+	stream := s.inputStream
+	if len(state.Unscanned) > 0 {
+		stream = io.MultiReader(bytes.NewReader(bytes.Clone(state.Unscanned)), stream)
+	}
+
+	if s.inputStream == s.baseStream {
+		s.baseStream = stream
+	}
+	s.setInputStream(stream)
+
+	return nil
 }
 
 func (s *TelnetScanner) processDanglingBytes() TerminalData {
@@ -145,7 +307,7 @@ func (s *TelnetScanner) processDanglingBytes() TerminalData {
 
 			return nil
 		} else if err != nil {
-			s.err = err
+			s.err = categorize(ErrCharset, err)
 			return nil
 		}
 	}
@@ -179,7 +341,21 @@ func (s *TelnetScanner) Scan(ctx context.Context) bool {
 	var err error
 
 	for {
-		for ctx.Err() == nil && s.cancellableScan(ctx) {
+		for ctx.Err() == nil {
+			scanned, timedOut := s.cancellableScan(ctx)
+			if timedOut {
+				s.nextOutput = s.flushPartialData()
+				if s.nextOutput != nil {
+					return true
+				}
+
+				continue
+			}
+
+			if !scanned {
+				break
+			}
+
 			s.atEOF = false
 			s.err = s.scanner.Err()
 
@@ -188,7 +364,7 @@ func (s *TelnetScanner) Scan(ctx context.Context) bool {
 				continue
 			}
 
-			if len(bytes) > 1 && bytes[0] == IAC {
+			if len(bytes) > 1 && bytes[0] == IAC && !s.textToken {
 				s.outCommand, err = parseCommand(bytes)
 
 				if err == nil {
@@ -200,6 +376,12 @@ func (s *TelnetScanner) Scan(ctx context.Context) bool {
 				s.pushError(err)
 			}
 
+			if s.synchDiscard.Load() && dataMarkAhead(s.unscanned) {
+				// A Synch is flushing everything before the Data Mark, except for commands
+				s.bytesToDecode = s.bytesToDecode[:0]
+				continue
+			}
+
 			s.bytesToDecode = append(s.bytesToDecode, bytes...)
 			s.nextOutput = s.processDanglingBytes()
 
@@ -210,37 +392,228 @@ func (s *TelnetScanner) Scan(ctx context.Context) bool {
 
 		// Clean out the rest of the dangling bytes before continuing
 		s.atEOF = true
-		s.err = s.scanner.Err()
+		if !s.scanInFlight {
+			// A scan abandoned due to cancellation still owns the scanner
+			s.err = s.scanner.Err()
+		}
 		if len(s.bytesToDecode) > 0 {
 			return true
 		}
 
-		// If we had a wrapped input stream, give the base steam a chance if
-		// the error is EOF
-		if !errors.Is(s.err, io.EOF) || s.inputStream == s.baseStream {
+		if s.inputStream == s.baseStream || ctx.Err() != nil {
 			break
 		}
 
-		s.inputStream = s.baseStream
-		s.scanner = bufio.NewScanner(s.inputStream)
-		s.scanner.Split(s.ScanTelnet)
+		// If we had a wrapped input stream, give the base stream a chance. A wrapped stream
+		// ending cleanly (bufio hides the EOF from us) is normal- for instance, an MCCP
+		// stream ending.  A wrapped stream ending with an error means the wrapper could not make
+		// sense of the data sent by the remote, so we fall back to the base stream and report it.
+		corruptErr := s.err
+		if errors.Is(corruptErr, io.EOF) {
+			corruptErr = nil
+		}
+
+		s.setInputStream(s.baseStream)
 		s.atEOF = false
 		s.err = nil
+
+		if corruptErr != nil {
+			s.corruptStreams.Add(1)
+			s.err = &StreamCorruptionError{Err: corruptErr}
+			return true
+		}
+
+		s.cleanStreamEnds.Add(1)
 	}
 
 	return len(s.bytesToDecode) > 0
 }
 
-func (s *TelnetScanner) cancellableScan(ctx context.Context) bool {
-	go func() {
-		s.scanResult <- s.scanner.Scan()
-	}()
+// StreamStats returns statistics about the data that has been read by this scanner
+func (s *TelnetScanner) StreamStats() StreamStats {
+	return StreamStats{
+		WireBytes:       s.wireBytes.Load(),
+		StreamBytes:     s.streamBytes.Load(),
+		CorruptStreams:  s.corruptStreams.Load(),
+		CleanStreamEnds: s.cleanStreamEnds.Load(),
+	}
+}
+
+// SetPartialSequenceTimeout establishes how long the scanner will wait for the remainder of
+// an incomplete escape sequence or character before giving up and emitting what it has
+// received so far.  A timeout of 0 (the default) will cause the scanner to wait indefinitely.
+func (s *TelnetScanner) SetPartialSequenceTimeout(timeout time.Duration) {
+	s.partialTimeout = timeout
+}
+
+// SetIdleBufferRelease establishes how long the scanner must sit idle before it releases its
+// parsing buffers, which are recreated when more data arrives. A period of 0 (the default) will
+// cause the scanner to hold on to its buffers indefinitely. The buffer used to read from the
+// input stream is not released, since it is in use while the scanner waits for data.
+func (s *TelnetScanner) SetIdleBufferRelease(period time.Duration) {
+	s.idleRelease = period
+}
+
+// SetSynchDiscard establishes whether the scanner discards text that it has read ahead of an
+// IAC DM, as the remote intends when it sends a Synch. Only text that is already in the
+// scanner's buffer when it is scanned can be discarded, since the TCP urgent notification that
+// would announce the Synch in advance isn't available. Commands are never discarded.
+func (s *TelnetScanner) SetSynchDiscard(discard bool) {
+	s.synchDiscard.Store(discard)
+}
+
+// SynchDiscard indicates whether the scanner discards text that it has read ahead of an IAC DM
+func (s *TelnetScanner) SynchDiscard() bool {
+	return s.synchDiscard.Load()
+}
+
+// dataMarkAhead indicates whether data contains an IAC DM outside of a subnegotiation
+func dataMarkAhead(data []byte) bool {
+	inSubnegotiation := false
+	for i := 0; i < len(data)-1; i++ {
+		if data[i] != IAC {
+			continue
+		}
 
-	select {
-	case result := <-s.scanResult:
-		return result
-	case <-ctx.Done():
-		return false
+		i++
+		switch data[i] {
+		case SB:
+			inSubnegotiation = true
+		case SE:
+			inSubnegotiation = false
+		case DATAMARK:
+			if !inSubnegotiation {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// releaseBuffers frees the scanner's parsing buffers if they aren't holding any data
+func (s *TelnetScanner) releaseBuffers() {
+	if len(s.bytesToDecode) > 0 {
+		return
+	}
+
+	if s.parser.ReleaseBuffers() {
+		s.bytesToDecode = nil
+	}
+}
+
+func (s *TelnetScanner) hasPartialData() bool {
+	return len(s.bytesToDecode) > 0 || s.parser.HasPartialSequence()
+}
+
+// flushPartialData is used when we have given up waiting for the remote to complete
+// an escape sequence or character. The incomplete sequence is emitted as-is, and incomplete
+// characters are emitted as the unicode replacement character.
+func (s *TelnetScanner) flushPartialData() TerminalData {
+	output := s.parser.FlushPartial()
+	if output == nil && len(s.bytesToDecode) > 0 {
+		s.bytesToDecode = s.bytesToDecode[:0]
+		output = TextData(string(utf8.RuneError))
+	}
+
+	return output
+}
+
+// readLoop runs scans for cancellableScan until ctx is done or the input stream ends. Reads
+// from the input stream can't be cancelled, so a scan that is in flight when ctx is done is
+// finished first, and its result is left in scanResult.
+func (s *TelnetScanner) readLoop(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	for {
+		select {
+		case scanner := <-s.scanRequests:
+			scanned := scanner.Scan()
+			s.scanResult <- scanned
+			if !scanned {
+				// The scanner won't produce anything else, and if the input stream is replaced,
+				// another reader will be started
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// requestScan asks the reader goroutine to scan the current scanner, starting the reader if it
+// isn't running. It returns false if ctx is done first.
+func (s *TelnetScanner) requestScan(ctx context.Context) bool {
+	for ctx.Err() == nil {
+		if s.readerDone == nil {
+			s.readerDone = make(chan struct{})
+			go s.readLoop(ctx, s.readerDone)
+		}
+
+		select {
+		case s.scanRequests <- s.scanner:
+			return true
+		case <-s.readerDone:
+			// The reader exited, so start another
+			s.readerDone = nil
+		case <-ctx.Done():
+		}
+	}
+
+	return false
+}
+
+// cancellableScan returns whether the underlying scanner produced a new token, and whether
+// the scan was abandoned because the partial sequence timeout expired. If the scan is
+// abandoned, the underlying scan remains in flight and will be picked up by the next call.
+func (s *TelnetScanner) cancellableScan(ctx context.Context) (scanned bool, timedOut bool) {
+	if !s.scanInFlight {
+		if !s.requestScan(ctx) {
+			return false, false
+		}
+		s.scanInFlight = true
+	}
+
+	var timeout <-chan time.Time
+	if s.partialTimeout > 0 && s.hasPartialData() {
+		timer := time.NewTimer(s.partialTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var idle <-chan time.Time
+	if s.idleRelease > 0 && !s.hasPartialData() {
+		timer := time.NewTimer(s.idleRelease)
+		defer timer.Stop()
+		idle = timer.C
+	}
+
+	if s.waitLock != nil {
+		s.waitLock.Unlock()
+		defer s.waitLock.Lock()
+	}
+
+	for {
+		select {
+		case result := <-s.scanResult:
+			s.scanInFlight = false
+			return result, false
+		case <-timeout:
+			return false, true
+		case <-idle:
+			if s.waitLock != nil {
+				s.waitLock.Lock()
+			}
+			s.releaseBuffers()
+			if s.waitLock != nil {
+				s.waitLock.Unlock()
+			}
+
+			// Buffers stay released until data arrives, so there's no need to fire again
+			idle = nil
+		case <-ctx.Done():
+			return false, false
+		}
 	}
 }
 
@@ -265,28 +638,23 @@ func (s *TelnetScanner) scanTelnetWithoutEOF(data []byte) (advance int, err erro
 		return 0, nil
 	}
 
-	// IAC GA, IAC EOR, and IAC NOP release on their own
-	// SE should never appear here but if it does we should recover by consuming the data
-	if data[1] == GA || data[1] == NOP || data[1] == SE || data[1] == EOR ||
-		data[1] == AYT {
+	// Everything other than negotiations and subnegotiations (IAC GA, IAC EOR, IAC NOP, and
+	// exotic codes that we don't actually handle) releases on its own. SE should never appear
+	// here but if it does we should recover by consuming the data
+	if !hasOption(data[1]) {
 		return 2, nil
 	}
 
-	// All other codes require at least 3 characters
+	// Negotiations and subnegotiations require at least 3 characters
 	if len(data) < 3 {
 		return 0, nil
 	}
 
-	if data[1] == WILL || data[1] == WONT || data[1] == DO || data[1] == DONT {
+	if data[1] != SB {
 		// Negotiation commands in three code sets
 		return 3, nil
 	}
 
-	if data[1] != SB {
-		// We received some kind of exotic code that we don't actually handle.
-		return 2, nil
-	}
-
 	nextIndex := 0
 
 	for {
@@ -294,13 +662,13 @@ func (s *TelnetScanner) scanTelnetWithoutEOF(data []byte) (advance int, err erro
 
 		// No more IACs, subnegotiation end is not in buffer yet
 		if nextSpecialCharIndex < 0 {
-			return 0, nil
+			return s.incompleteSubnegotiation(data), nil
 		}
 
 		nextIndex += nextSpecialCharIndex + 1
 		if len(data) <= nextIndex+1 {
 			// IAC is last character, but we need more
-			return 0, nil
+			return s.incompleteSubnegotiation(data), nil
 		}
 
 		if data[nextIndex+1] == SE {
@@ -315,6 +683,19 @@ func (s *TelnetScanner) scanTelnetWithoutEOF(data []byte) (advance int, err erro
 	}
 }
 
+// incompleteSubnegotiation is called when the buffer contains the start of a subnegotiation
+// but not its end. Usually we wait for more data, but a subnegotiation that is never terminated
+// would eventually overflow the scanner's buffer and stop the scanner altogether, so past
+// MaxSubnegotiationSize we give up and release IAC SB <option> on its own. It will fail to
+// parse and be reported as an error, and the rest of the data will be treated as text.
+func (s *TelnetScanner) incompleteSubnegotiation(data []byte) int {
+	if len(data) > MaxSubnegotiationSize {
+		return 3
+	}
+
+	return 0
+}
+
 // ScanTelnet is a method used as the split method for io.Scanner. It will receive
 // chunks of text or commands as individual tokens.
 func (s *TelnetScanner) ScanTelnet(data []byte, atEOF bool) (advance int, token []byte, err error) {
@@ -324,10 +705,16 @@ func (s *TelnetScanner) ScanTelnet(data []byte, atEOF bool) (advance int, token
 
 	advance, err = s.scanTelnetWithoutEOF(data)
 
-	if err != nil || (advance == 0 && !atEOF) {
+	if err != nil {
 		return advance, data[:advance], err
 	}
 
+	if advance == 0 && !atEOF {
+		// Returning a nil token asks bufio.Scanner for more data- an empty token would be
+		// treated as a successful scan, and we'd never read the rest of the command
+		return 0, nil, nil
+	}
+
 	if advance == 0 && atEOF {
 		return len(data), data, nil
 	}
@@ -0,0 +1,151 @@
+package telnet
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrProbeTimeout is returned from Terminal.ProbeRemote when the remote does not answer the
+// probe before the timeout expires
+var ErrProbeTimeout = errors.New("telnet: timed out waiting for the remote to answer a probe")
+
+// remoteProbe tracks a DO sent by ProbeRemote until the remote has answered it and, if it
+// accepted, agreed to turn the option back off
+type remoteProbe struct {
+	done     chan struct{}
+	accepted bool
+	timeout  time.Duration
+	// awaitingWont indicates that the remote accepted the probe, and we've sent DONT. If the
+	// remote doesn't confirm the DONT within the probe's timeout, the probe is forgotten.
+	awaitingWont bool
+	expiry       *time.Timer
+}
+
+// ProbeRemote asks the remote whether it supports a telopt, without keeping the telopt active. DO
+// is sent for the option, and if the remote answers WILL, DONT is sent right away to restore the
+// previous state. This is useful to find out what a server is capable of, such as whether it
+// supports MXP, before deciding how the client should behave. The option doesn't need to be
+// registered with the terminal.
+//
+// ProbeRemote returns true if the remote answered WILL, and false if it answered WONT. If the
+// remote side of a registered option is already active, true is returned without probing. If
+// the remote does not answer before the timeout expires, ErrProbeTimeout is returned, and a late
+// answer is treated like any other request from the remote. The remote's own requests for the
+// option can't be told apart from answers to the probe, so options should not be probed while
+// they are being negotiated.
+func (t *Terminal) ProbeRemote(code TelOptCode, timeout time.Duration) (bool, error) {
+	option, hasOption := t.telOpt(code)
+	if hasOption {
+		negotiation := t.telOptNegotiation(code, TelOptSideRemote)
+
+		negotiation.lock.Lock()
+		state := negotiation.state
+		negotiation.lock.Unlock()
+
+		if state == qYes {
+			return true, nil
+		} else if state != qNo {
+			return false, fmt.Errorf("telopt %s is already being negotiated", option)
+		}
+	}
+
+	t.probesLock.Lock()
+	if _, probing := t.probes[code]; probing {
+		t.probesLock.Unlock()
+		return false, fmt.Errorf("telopt %d is already being probed", code)
+	}
+
+	if t.probes == nil {
+		t.probes = make(map[TelOptCode]*remoteProbe)
+	}
+	probe := &remoteProbe{done: make(chan struct{}), timeout: timeout}
+	t.probes[code] = probe
+	t.probesLock.Unlock()
+
+	t.keyboard.WriteCommand(Command{
+		OpCode: DO,
+		Option: code,
+	}, nil)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-probe.done:
+		return probe.accepted, nil
+	case <-timer.C:
+		t.abandonProbe(code, probe)
+		return false, ErrProbeTimeout
+	case <-t.ctx.Done():
+		t.abandonProbe(code, probe)
+		return false, t.ctx.Err()
+	}
+}
+
+// abandonProbe stops waiting for the remote to answer a probe, unless it already has. Probes
+// that are waiting for the remote to confirm our DONT expire on their own.
+func (t *Terminal) abandonProbe(code TelOptCode, probe *remoteProbe) {
+	t.probesLock.Lock()
+	defer t.probesLock.Unlock()
+
+	if t.probes[code] == probe && !probe.awaitingWont {
+		delete(t.probes, code)
+	}
+}
+
+// expireProbe forgets a probe that is still waiting for the remote to confirm our DONT
+func (t *Terminal) expireProbe(code TelOptCode, probe *remoteProbe) {
+	t.probesLock.Lock()
+	defer t.probesLock.Unlock()
+
+	if t.probes[code] == probe {
+		delete(t.probes, code)
+	}
+}
+
+// answerProbe handles a WILL/WONT received from the remote if it answers a probe. It returns
+// true if the command was consumed.
+func (t *Terminal) answerProbe(c Command) bool {
+	if c.OpCode != WILL && c.OpCode != WONT {
+		return false
+	}
+
+	t.probesLock.Lock()
+	probe, probing := t.probes[c.Option]
+	if !probing {
+		t.probesLock.Unlock()
+		return false
+	}
+
+	if probe.awaitingWont {
+		// The remote is confirming our DONT
+		if c.OpCode == WONT {
+			probe.expiry.Stop()
+			delete(t.probes, c.Option)
+		}
+		t.probesLock.Unlock()
+		return true
+	}
+
+	probe.accepted = c.OpCode == WILL
+	if probe.accepted {
+		probe.awaitingWont = true
+		probe.expiry = time.AfterFunc(probe.timeout, func() {
+			t.expireProbe(c.Option, probe)
+		})
+	} else {
+		delete(t.probes, c.Option)
+	}
+	t.probesLock.Unlock()
+
+	if probe.accepted {
+		t.keyboard.WriteCommand(Command{
+			OpCode: DONT,
+			Option: c.Option,
+		}, nil)
+	}
+
+	close(probe.done)
+	return true
+}
@@ -1,6 +1,9 @@
 package telnet
 
-import "sync/atomic"
+import (
+	"strings"
+	"sync/atomic"
+)
 
 // PromptCommands is a set of flags indicating which IAC opcodes indicate
 // the end of a prompt line. MUDs like to use GA or EOR to indicate where
@@ -23,8 +26,42 @@ const (
 	PromptCommandEOR
 )
 
+func (p PromptCommands) String() string {
+	var names []string
+	if p&PromptCommandGA != 0 {
+		names = append(names, "GA")
+	}
+	if p&PromptCommandEOR != 0 {
+		names = append(names, "EOR")
+	}
+
+	if len(names) == 0 {
+		return "None"
+	}
+
+	return strings.Join(names, "|")
+}
+
+// PromptCommandState is the combined view of the prompt commands in use on both directions
+// of the connection, returned by Terminal.PromptCommands
+type PromptCommandState struct {
+	// Keyboard holds the prompt commands that the keyboard will send with SendPromptHint
+	Keyboard PromptCommands
+	// Printer holds the prompt commands that the printer will deliver, rather than ignore
+	Printer PromptCommands
+}
+
+// PromptCommandsChangedEvent is delivered to PromptCommandsChanged hooks when the keyboard's or
+// printer's prompt commands change, usually because SUPPRESS-GO-AHEAD or EOR changed state
+type PromptCommandsChangedEvent struct {
+	Old PromptCommandState
+	New PromptCommandState
+}
+
 type atomicPromptCommands struct {
 	promptCommands atomic.Uint32
+	// changed, if set, is called with the old value after the prompt commands change
+	changed func(oldValue PromptCommands)
 }
 
 func (p *atomicPromptCommands) Init() {
@@ -39,6 +76,7 @@ func (p *atomicPromptCommands) SetPromptCommand(flag PromptCommands) {
 	for {
 		oldValue := p.promptCommands.Load()
 		if p.promptCommands.CompareAndSwap(oldValue, oldValue|uint32(flag)) {
+			p.notify(oldValue, oldValue|uint32(flag))
 			break
 		}
 	}
@@ -48,7 +86,38 @@ func (p *atomicPromptCommands) ClearPromptCommand(flag PromptCommands) {
 	for {
 		oldValue := p.promptCommands.Load()
 		if p.promptCommands.CompareAndSwap(oldValue, oldValue&uint32(^flag)) {
+			p.notify(oldValue, oldValue&uint32(^flag))
 			break
 		}
 	}
 }
+
+func (p *atomicPromptCommands) notify(oldValue uint32, newValue uint32) {
+	if oldValue != newValue && p.changed != nil {
+		p.changed(PromptCommands(oldValue))
+	}
+}
+
+// PromptCommands returns the prompt commands currently in use by the keyboard and the printer
+func (t *Terminal) PromptCommands() PromptCommandState {
+	return PromptCommandState{
+		Keyboard: t.keyboard.PromptCommands(),
+		Printer:  t.printer.PromptCommands(),
+	}
+}
+
+func (t *Terminal) keyboardPromptCommandsChanged(oldValue PromptCommands) {
+	newState := t.PromptCommands()
+	oldState := newState
+	oldState.Keyboard = oldValue
+
+	t.promptCommandsChangedHooks.Fire(t, PromptCommandsChangedEvent{Old: oldState, New: newState})
+}
+
+func (t *Terminal) printerPromptCommandsChanged(oldValue PromptCommands) {
+	newState := t.PromptCommands()
+	oldState := newState
+	oldState.Printer = oldValue
+
+	t.promptCommandsChangedHooks.Fire(t, PromptCommandsChangedEvent{Old: oldState, New: newState})
+}
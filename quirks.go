@@ -0,0 +1,124 @@
+package telnet
+
+import (
+	"net"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// HostQuirks adjusts the TerminalConfig used to connect to a particular host, in order to work
+// around servers that don't behave the way the defaults expect. Fields left at their zero values
+// don't change the config.
+type HostQuirks struct {
+	// DefaultCharsetName replaces TerminalConfig.DefaultCharsetName, for servers that use a
+	// non-ASCII charset without negotiating it
+	DefaultCharsetName string
+	// FallbackCharsetName replaces TerminalConfig.FallbackCharsetName
+	FallbackCharsetName string
+	// DisableTelOpts removes the telopts with these codes from TerminalConfig.TelOpts and
+	// TerminalConfig.TelOptNames, for servers that misbehave when they are negotiated
+	DisableTelOpts []TelOptCode
+	// Modify, if not nil, is called after the other quirks have been applied, and can make
+	// any other changes to the config
+	Modify func(config *TerminalConfig)
+}
+
+func (q HostQuirks) apply(config TerminalConfig) TerminalConfig {
+	if q.DefaultCharsetName != "" {
+		config.DefaultCharsetName = q.DefaultCharsetName
+	}
+
+	if q.FallbackCharsetName != "" {
+		config.FallbackCharsetName = q.FallbackCharsetName
+	}
+
+	if len(q.DisableTelOpts) > 0 {
+		config.TelOpts = slices.DeleteFunc(slices.Clone(config.TelOpts), func(option TelnetOption) bool {
+			return slices.Contains(q.DisableTelOpts, option.Code())
+		})
+		config.TelOptNames = slices.DeleteFunc(slices.Clone(config.TelOptNames), func(name string) bool {
+			// Unknown names are left for NewTerminal to report
+			option, err := NewTelOptByName(name, config.Side)
+			return err == nil && slices.Contains(q.DisableTelOpts, option.Code())
+		})
+	}
+
+	if q.Modify != nil {
+		q.Modify(&config)
+	}
+
+	return config
+}
+
+// QuirkRegistry stores HostQuirks by host, and is consulted by Dial and DialURL. Quirks can be
+// registered for a host on every port ("example.com") or for a single port ("example.com:4000").
+// When both exist, the port-specific quirks are applied after the host-wide quirks.
+type QuirkRegistry struct {
+	lock   sync.RWMutex
+	quirks map[string]HostQuirks
+}
+
+// DefaultQuirks is the registry used by Dial and DialURL
+var DefaultQuirks = NewQuirkRegistry()
+
+func NewQuirkRegistry() *QuirkRegistry {
+	return &QuirkRegistry{
+		quirks: make(map[string]HostQuirks),
+	}
+}
+
+// Register stores quirks for the provided host, which may be either a hostname or a host:port
+// pair. Hostnames are not case-sensitive. Quirks previously registered for the same host are
+// replaced.
+func (r *QuirkRegistry) Register(host string, quirks HostQuirks) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.quirks[strings.ToLower(host)] = quirks
+}
+
+// Unregister removes the quirks stored for the provided host
+func (r *QuirkRegistry) Unregister(host string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	delete(r.quirks, strings.ToLower(host))
+}
+
+// Lookup returns the quirks that apply to the provided address, which should be a host:port pair.
+// Port-specific quirks are returned after host-wide quirks.
+func (r *QuirkRegistry) Lookup(address string) []HostQuirks {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	address = strings.ToLower(address)
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+
+	var matches []HostQuirks
+	quirks, hasQuirks := r.quirks[host]
+	if hasQuirks {
+		matches = append(matches, quirks)
+	}
+
+	if host != address {
+		quirks, hasQuirks = r.quirks[address]
+		if hasQuirks {
+			matches = append(matches, quirks)
+		}
+	}
+
+	return matches
+}
+
+// Apply returns a copy of config with all quirks that apply to the provided address applied
+func (r *QuirkRegistry) Apply(address string, config TerminalConfig) TerminalConfig {
+	for _, quirks := range r.Lookup(address) {
+		config = quirks.apply(config)
+	}
+
+	return config
+}
@@ -0,0 +1,100 @@
+package telnet
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// rawTap passes a copy of the bytes that cross the underlying connection to an io.Writer, for
+// capturing protocol traffic. Errors from the writer are ignored, so that a failing capture
+// can't bring down the connection.
+type rawTap struct {
+	writer atomic.Pointer[io.Writer]
+}
+
+func (t *rawTap) set(writer io.Writer) {
+	if writer == nil {
+		t.writer.Store(nil)
+		return
+	}
+
+	t.writer.Store(&writer)
+}
+
+func (t *rawTap) get() io.Writer {
+	writer := t.writer.Load()
+	if writer == nil {
+		return nil
+	}
+
+	return *writer
+}
+
+func (t *rawTap) tap(p []byte) {
+	writer := t.get()
+	if writer != nil && len(p) > 0 {
+		_, _ = writer.Write(p)
+	}
+}
+
+type tapReader struct {
+	reader io.Reader
+	tap    *rawTap
+}
+
+func (r *tapReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.tap.tap(p[:n])
+
+	return n, err
+}
+
+type tapWriter struct {
+	writer io.Writer
+	tap    *rawTap
+}
+
+func (w *tapWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.tap.tap(p[:n])
+
+	return n, err
+}
+
+// SetRawTap establishes a writer that receives a copy of every byte the scanner reads from the
+// underlying input stream, before any reader installed with WrapReader, charset decoding, or
+// telnet parsing is applied. The writer is called on the goroutine that reads from the stream,
+// and errors it returns are ignored. A nil writer removes the tap.
+func (s *TelnetScanner) SetRawTap(writer io.Writer) {
+	s.rawTap.set(writer)
+}
+
+// RawTap returns the writer established with SetRawTap, or nil if there isn't one
+func (s *TelnetScanner) RawTap() io.Writer {
+	return s.rawTap.get()
+}
+
+// SetRawTap establishes a writer that receives a copy of every byte the printer reads from the
+// connection, exactly as it arrived. Wrapping the writer with hex.Dumper produces a readable
+// capture of the remote's traffic. See TerminalConfig.PrinterRawTap.
+func (p *TelnetPrinter) SetRawTap(writer io.Writer) {
+	p.scanner.SetRawTap(writer)
+}
+
+// RawTap returns the writer established with SetRawTap, or nil if there isn't one
+func (p *TelnetPrinter) RawTap() io.Writer {
+	return p.scanner.RawTap()
+}
+
+// SetRawTap establishes a writer that receives a copy of every byte the keyboard writes to the
+// connection, after encoding and after any writer installed with WrapWriter. The writer is called
+// while the keyboard is writing, and errors it returns are ignored. A nil writer removes the tap.
+// See TerminalConfig.KeyboardRawTap.
+func (k *TelnetKeyboard) SetRawTap(writer io.Writer) {
+	k.rawTap.set(writer)
+}
+
+// RawTap returns the writer established with SetRawTap, or nil if there isn't one
+func (k *TelnetKeyboard) RawTap() io.Writer {
+	return k.rawTap.get()
+}
@@ -0,0 +1,413 @@
+package telnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrServerClosed is returned from Server.Serve once Server.Shutdown has been called
+var ErrServerClosed = errors.New("telnet: server closed")
+
+// ErrNegotiationDeadline is reported to ServerConfig.ErrorHandler when a connection is dropped
+// because its initial negotiation did not complete before ServerConfig.NegotiationDeadline
+var ErrNegotiationDeadline = errors.New("telnet: initial negotiation did not complete before the deadline")
+
+// ServerHandler is called on its own goroutine for each terminal created by a Server. When
+// the handler returns, the terminal is closed.
+type ServerHandler func(t *Terminal)
+
+type ServerConfig struct {
+	// TerminalConfig is used to create a terminal for each accepted connection. If Side is not
+	// set, it is set to SideServer.
+	TerminalConfig TerminalConfig
+	// Handler is called for each terminal. It is required.
+	Handler ServerHandler
+	// ErrorHandler, if not nil, receives errors that don't stop the server, such as failures
+	// to create a terminal for an accepted connection
+	ErrorHandler func(err error)
+	// CloseTimeout is passed to Terminal.CloseWithTimeout for each terminal during
+	// Server.Shutdown. The default is DefaultCloseTimeout.
+	CloseTimeout time.Duration
+	// NegotiationDeadline, if set, is the longest a new connection may spend in its initial telopt
+	// negotiation before the handler is called. The handler is not called until negotiation
+	// completes, so a client that connects and goes silent only ties up its own goroutine. When
+	// the deadline passes, negotiation is completed with NegotiationDeadline, and the
+	// NegotiationCompleteEvent lists the telopts that never resolved.
+	NegotiationDeadline time.Duration
+	// DropOnNegotiationDeadline closes connections that reach NegotiationDeadline instead of
+	// serving them anyway. ErrNegotiationDeadline is reported to ErrorHandler for each of them.
+	DropOnNegotiationDeadline bool
+}
+
+// Server accepts connections from one or more listeners and creates a terminal for each of
+// them. The listeners are provided by the caller rather than opened by the server, so they can
+// come from anywhere, including socket activation (see SystemdListeners).
+//
+// The server keeps track of every terminal it has created until the terminal's handler returns.
+// Terminals returns the terminals that are currently live, and Shutdown gracefully closes them.
+type Server struct {
+	config    ServerConfig
+	listeners []net.Listener
+
+	lock      sync.Mutex
+	terminals map[*Terminal]struct{}
+	serving   bool
+	closed    bool
+	handlers  sync.WaitGroup
+}
+
+// NewServer creates a server that will accept connections from the provided listeners once
+// Serve is called. The server takes ownership of the listeners and closes them during Shutdown.
+func NewServer(config ServerConfig, listeners ...net.Listener) (*Server, error) {
+	if config.Handler == nil {
+		return nil, errors.New("telnet: server config has no handler")
+	}
+
+	if len(listeners) == 0 {
+		return nil, errors.New("telnet: server has no listeners")
+	}
+
+	if config.TerminalConfig.Side == SideUnknown {
+		config.TerminalConfig.Side = SideServer
+	}
+
+	if config.CloseTimeout <= 0 {
+		config.CloseTimeout = DefaultCloseTimeout
+	}
+
+	return &Server{
+		config:    config,
+		listeners: listeners,
+		terminals: make(map[*Terminal]struct{}),
+	}, nil
+}
+
+// NewSystemdServer creates a server using the listeners passed to this process by systemd
+// socket activation. See SystemdListeners for more information.
+func NewSystemdServer(config ServerConfig) (*Server, error) {
+	listeners, err := SystemdListeners()
+	if err != nil {
+		return nil, err
+	}
+
+	server, err := NewServer(config, listeners...)
+	if err != nil {
+		for _, listener := range listeners {
+			_ = listener.Close()
+		}
+		return nil, err
+	}
+
+	return server, nil
+}
+
+// SystemdListeners returns the listening sockets passed to this process by systemd socket
+// activation (or anything else that follows the LISTEN_FDS protocol), in the order they were
+// passed. If no sockets were passed to this process, it returns no listeners and no error.
+//
+// The LISTEN_PID, LISTEN_FDS, and LISTEN_FDNAMES environment variables are unset, so that
+// child processes won't also try to use the sockets.
+func SystemdListeners() ([]net.Listener, error) {
+	pid := os.Getenv("LISTEN_PID")
+	fds := os.Getenv("LISTEN_FDS")
+	names := os.Getenv("LISTEN_FDNAMES")
+
+	_ = os.Unsetenv("LISTEN_PID")
+	_ = os.Unsetenv("LISTEN_FDS")
+	_ = os.Unsetenv("LISTEN_FDNAMES")
+
+	if fds == "" || (pid != "" && pid != strconv.Itoa(os.Getpid())) {
+		return nil, nil
+	}
+
+	count, err := strconv.Atoi(fds)
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("telnet: invalid LISTEN_FDS %q", fds)
+	}
+
+	fdNames := strings.Split(names, ":")
+
+	// Descriptors passed by the LISTEN_FDS protocol start immediately after stderr
+	const firstFd = 3
+
+	var listeners []net.Listener
+	for i := 0; i < count; i++ {
+		name := "LISTEN_FD_" + strconv.Itoa(firstFd+i)
+		if i < len(fdNames) && fdNames[i] != "" {
+			name = fdNames[i]
+		}
+
+		// FileListener duplicates the descriptor, so the file is closed either way
+		file := os.NewFile(uintptr(firstFd+i), name)
+		listener, err := net.FileListener(file)
+		_ = file.Close()
+
+		if err != nil {
+			for _, opened := range listeners {
+				_ = opened.Close()
+			}
+			return nil, fmt.Errorf("telnet: could not use socket %s: %w", name, err)
+		}
+
+		listeners = append(listeners, listener)
+	}
+
+	return listeners, nil
+}
+
+// Listeners returns the listeners the server accepts connections from
+func (s *Server) Listeners() []net.Listener {
+	return s.listeners
+}
+
+// Terminals returns the terminals whose handlers are currently running
+func (s *Server) Terminals() []*Terminal {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	terminals := make([]*Terminal, 0, len(s.terminals))
+	for terminal := range s.terminals {
+		terminals = append(terminals, terminal)
+	}
+
+	return terminals
+}
+
+// Serve accepts connections from all of the server's listeners until Shutdown is called, the
+// provided context is cancelled, or a listener fails. Terminals are created with a context that
+// carries ctx's values, but is not cancelled along with it- when ctx is cancelled, Shutdown is
+// called with no deadline instead, so terminals are closed gracefully.
+//
+// Serve always returns a non-nil error. After Shutdown, the error is ErrServerClosed. If a
+// listener fails, the server's listeners are closed and the error is returned, but live terminals
+// are left running until Shutdown is called.
+func (s *Server) Serve(ctx context.Context) error {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return ErrServerClosed
+	}
+	if s.serving {
+		s.lock.Unlock()
+		return errors.New("telnet: server is already serving")
+	}
+	s.serving = true
+	s.lock.Unlock()
+
+	terminalCtx := context.WithoutCancel(ctx)
+	stopShutdown := context.AfterFunc(ctx, func() {
+		_ = s.Shutdown(context.Background())
+	})
+	defer stopShutdown()
+
+	errs := make(chan error, len(s.listeners))
+	for _, listener := range s.listeners {
+		go func() {
+			errs <- s.acceptLoop(terminalCtx, listener)
+		}()
+	}
+
+	var serveErr error
+	for range s.listeners {
+		err := <-errs
+		if serveErr == nil {
+			serveErr = err
+			s.closeListeners()
+		}
+	}
+
+	if s.isClosed() {
+		return ErrServerClosed
+	}
+
+	return serveErr
+}
+
+func (s *Server) acceptLoop(ctx context.Context, listener net.Listener) error {
+	var delay time.Duration
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+
+			var netError interface{ Temporary() bool }
+			if errors.As(err, &netError) && netError.Temporary() {
+				delay = min(max(2*delay, 5*time.Millisecond), time.Second)
+				s.reportError(err)
+				time.Sleep(delay)
+				continue
+			}
+
+			return err
+		}
+		delay = 0
+
+		terminal, err := NewTerminal(ctx, conn, s.config.TerminalConfig)
+		if err != nil {
+			_ = conn.Close()
+			s.reportError(err)
+			continue
+		}
+
+		if !s.track(terminal) {
+			// Shutdown started while we were creating the terminal
+			_ = terminal.CloseWithTimeout(s.config.CloseTimeout)
+			continue
+		}
+
+		go s.handle(terminal, conn.RemoteAddr())
+	}
+}
+
+func (s *Server) handle(terminal *Terminal, remoteAddr net.Addr) {
+	defer s.handlers.Done()
+	defer s.untrack(terminal)
+
+	if !s.awaitNegotiation(terminal) {
+		s.reportError(fmt.Errorf("dropped connection from %s: %w", remoteAddr, ErrNegotiationDeadline))
+		_ = terminal.CloseWithTimeout(s.config.CloseTimeout)
+		return
+	}
+
+	err := terminal.callRecovering(func() error {
+		s.config.Handler(terminal)
+		return nil
+	})
+	if err != nil {
+		s.reportError(err)
+	}
+
+	_ = terminal.CloseWithTimeout(s.config.CloseTimeout)
+}
+
+// awaitNegotiation waits for the terminal's initial negotiation to complete, for up to
+// ServerConfig.NegotiationDeadline. It returns false if the terminal should be dropped.
+func (s *Server) awaitNegotiation(terminal *Terminal) bool {
+	if s.config.NegotiationDeadline <= 0 {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.NegotiationDeadline)
+	defer cancel()
+
+	err := terminal.WaitForNegotiation(ctx)
+	if err == nil || terminal.ctx.Err() != nil {
+		// If the terminal has shut down, the handler can find that out for itself
+		return true
+	}
+
+	// Negotiation may have completed on its own just as the deadline passed
+	if !terminal.negotiation.finish(NegotiationDeadline) {
+		return true
+	}
+
+	return !s.config.DropOnNegotiationDeadline
+}
+
+func (s *Server) track(terminal *Terminal) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return false
+	}
+
+	s.terminals[terminal] = struct{}{}
+	s.handlers.Add(1)
+	return true
+}
+
+func (s *Server) untrack(terminal *Terminal) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.terminals, terminal)
+}
+
+func (s *Server) isClosed() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.closed
+}
+
+func (s *Server) reportError(err error) {
+	if s.config.ErrorHandler != nil {
+		s.config.ErrorHandler(err)
+	}
+}
+
+func (s *Server) closeListeners() []error {
+	var errs []error
+	for _, listener := range s.listeners {
+		err := listener.Close()
+		if err != nil && !errors.Is(err, net.ErrClosed) {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs
+}
+
+// Shutdown gracefully shuts down the server. The listeners are closed, so no new connections
+// are accepted, and every live terminal is closed with Terminal.CloseWithTimeout, which flushes
+// queued keyboard output before closing the connection. Shutdown then waits for all handlers to
+// return, or for ctx to be done, in which case ctx's error is returned.
+//
+// Calling this method more than once has no effect beyond waiting for handlers to return.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.lock.Lock()
+	alreadyClosed := s.closed
+	s.closed = true
+	terminals := make([]*Terminal, 0, len(s.terminals))
+	for terminal := range s.terminals {
+		terminals = append(terminals, terminal)
+	}
+	s.lock.Unlock()
+
+	var errs []error
+	if !alreadyClosed {
+		errs = s.closeListeners()
+
+		var closeLock sync.Mutex
+		var closing sync.WaitGroup
+		for _, terminal := range terminals {
+			closing.Add(1)
+			go func() {
+				defer closing.Done()
+
+				err := terminal.CloseWithTimeout(s.config.CloseTimeout)
+				if err != nil {
+					closeLock.Lock()
+					errs = append(errs, err)
+					closeLock.Unlock()
+				}
+			}()
+		}
+		closing.Wait()
+	}
+
+	handlersDone := make(chan struct{})
+	go func() {
+		s.handlers.Wait()
+		close(handlersDone)
+	}()
+
+	select {
+	case <-handlersDone:
+	case <-ctx.Done():
+		errs = append(errs, ctx.Err())
+	}
+
+	return errors.Join(errs...)
+}
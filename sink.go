@@ -0,0 +1,253 @@
+package telnet
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultSinkBufferSize is the number of entries a Sink will buffer for its handler if
+// SinkConfig.BufferSize is not set
+const DefaultSinkBufferSize = 256
+
+// SinkSource indicates which of the terminal's data streams an entry delivered to a Sink came from
+type SinkSource byte
+
+const (
+	// SinkSourcePrinter indicates data received from the remote
+	SinkSourcePrinter SinkSource = 1 << iota
+	// SinkSourceKeyboard indicates data sent to the remote
+	SinkSourceKeyboard
+)
+
+func (s SinkSource) String() string {
+	switch s {
+	case SinkSourcePrinter:
+		return "Printer"
+	case SinkSourceKeyboard:
+		return "Keyboard"
+	case SinkSourcePrinter | SinkSourceKeyboard:
+		return "Printer|Keyboard"
+	default:
+		return "None"
+	}
+}
+
+// SinkDropPolicy determines what a Sink does with new data when its buffer is full
+type SinkDropPolicy byte
+
+const (
+	// SinkDropNewest discards data that arrives while the buffer is full
+	SinkDropNewest SinkDropPolicy = iota
+	// SinkDropOldest discards the oldest buffered data to make room for data that arrives while
+	// the buffer is full
+	SinkDropOldest
+)
+
+// SinkEntry is a single piece of data delivered to a Sink
+type SinkEntry struct {
+	Source SinkSource
+	// Time is when the data was received or sent
+	Time time.Time
+	Data TerminalData
+}
+
+// SinkHandler receives entries from a Sink
+type SinkHandler func(t *Terminal, entry SinkEntry)
+
+type SinkConfig struct {
+	// Sources indicates which data streams the sink receives. If it is 0, the sink receives both.
+	Sources SinkSource
+	// BufferSize is the number of entries that can be waiting for the handler before entries are
+	// dropped. The default is DefaultSinkBufferSize.
+	BufferSize int
+	// DropPolicy determines which entries are dropped when the buffer is full
+	DropPolicy SinkDropPolicy
+}
+
+// Sink is a read-only observer of a terminal's printer and keyboard data streams, attached with
+// Terminal.AttachSink. This is useful for logging, metrics, or mirroring a session to an observer.
+//
+// Sinks receive data, including commands, exactly as the printer produced it and the keyboard sent
+// it, before any printer middlewares have run. Unlike hooks, each sink runs its handler on its own
+// goroutine with its own buffer, and when a slow handler lets that buffer fill up, entries are dropped
+// according to SinkConfig.DropPolicy rather than blocking the terminal.
+type Sink struct {
+	terminal   *Terminal
+	handler    SinkHandler
+	sources    SinkSource
+	dropPolicy SinkDropPolicy
+
+	entries  chan SinkEntry
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+	dropped  atomic.Uint64
+}
+
+// AttachSink attaches a new sink to the terminal, which will deliver data to the provided handler
+// until it is detached or the terminal shuts down
+func (t *Terminal) AttachSink(config SinkConfig, handler SinkHandler) *Sink {
+	sources := config.Sources
+	if sources == 0 {
+		sources = SinkSourcePrinter | SinkSourceKeyboard
+	}
+
+	bufferSize := config.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = DefaultSinkBufferSize
+	}
+
+	sink := &Sink{
+		terminal:   t,
+		handler:    handler,
+		sources:    sources,
+		dropPolicy: config.DropPolicy,
+		entries:    make(chan SinkEntry, bufferSize),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+
+	t.sinks.add(sink)
+	go sink.sinkLoop()
+
+	return sink
+}
+
+func (s *Sink) sinkLoop() {
+	defer close(s.done)
+
+	for {
+		select {
+		case entry := <-s.entries:
+			s.deliver(entry)
+		case <-s.stop:
+			return
+		case <-s.terminal.ctx.Done():
+			// Deliver whatever was sent before the terminal shut down
+			for {
+				select {
+				case entry := <-s.entries:
+					s.deliver(entry)
+				default:
+					s.terminal.sinks.remove(s)
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *Sink) deliver(entry SinkEntry) {
+	err := s.terminal.callRecovering(func() error {
+		s.handler(s.terminal, entry)
+		return nil
+	})
+	if err != nil {
+		s.terminal.encounteredError(err)
+	}
+}
+
+func (s *Sink) publish(entry SinkEntry) {
+	if s.sources&entry.Source == 0 {
+		return
+	}
+
+	for {
+		select {
+		case s.entries <- entry:
+			return
+		default:
+		}
+
+		if s.dropPolicy == SinkDropNewest {
+			s.dropped.Add(1)
+			return
+		}
+
+		// Make room by discarding the oldest entry. The handler may have made room in the
+		// meantime, in which case we'll just try again.
+		select {
+		case <-s.entries:
+			s.dropped.Add(1)
+		default:
+		}
+	}
+}
+
+// Dropped returns the number of entries that have been dropped because the buffer was full
+func (s *Sink) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Detach stops the sink from receiving any more data. Entries that are still buffered are discarded.
+// Calling this method more than once has no effect, and it is safe to call it from within the handler.
+func (s *Sink) Detach() {
+	s.stopOnce.Do(func() {
+		s.terminal.sinks.remove(s)
+		close(s.stop)
+	})
+}
+
+// Done returns a channel that is closed once the sink has stopped delivering entries, either
+// because it was detached or because the terminal shut down
+func (s *Sink) Done() <-chan struct{} {
+	return s.done
+}
+
+// sinkSet is the set of sinks attached to a terminal. Like EventPublisher, the slice is replaced
+// rather than modified, so that publishing doesn't need to hold the lock.
+type sinkSet struct {
+	lock  sync.Mutex
+	sinks atomic.Pointer[[]*Sink]
+}
+
+func (s *sinkSet) add(sink *Sink) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var sinks []*Sink
+	current := s.sinks.Load()
+	if current != nil {
+		sinks = slices.Clone(*current)
+	}
+
+	sinks = append(sinks, sink)
+	s.sinks.Store(&sinks)
+}
+
+func (s *sinkSet) remove(sink *Sink) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	current := s.sinks.Load()
+	if current == nil {
+		return
+	}
+
+	index := slices.Index(*current, sink)
+	if index < 0 {
+		return
+	}
+
+	sinks := slices.Delete(slices.Clone(*current), index, index+1)
+	s.sinks.Store(&sinks)
+}
+
+func (s *sinkSet) publish(source SinkSource, data TerminalData) {
+	sinks := s.sinks.Load()
+	if sinks == nil || len(*sinks) == 0 {
+		return
+	}
+
+	entry := SinkEntry{
+		Source: source,
+		Time:   time.Now(),
+		Data:   data,
+	}
+
+	for _, sink := range *sinks {
+		sink.publish(entry)
+	}
+}
@@ -0,0 +1,214 @@
+package telnet
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TelOptSnapshot records the state of a single telopt at the time a TerminalSnapshot was taken
+type TelOptSnapshot struct {
+	Code        TelOptCode
+	Name        string
+	Usage       TelOptUsage
+	LocalState  TelOptState
+	RemoteState TelOptState
+}
+
+// KeyboardSnapshot records the state of the keyboard at the time a TerminalSnapshot was taken
+type KeyboardSnapshot struct {
+	// Closed indicates whether the keyboard has stopped accepting writes
+	Closed bool
+	// QueuedCommands is the number of commands waiting to be picked up by the keyboard loop
+	QueuedCommands int
+	// QueuedText is the number of text writes waiting to be picked up by the keyboard loop
+	QueuedText int
+	// HeldText contains the escaped text of every write that has been picked up by the keyboard
+	// loop but is being held back by a keyboard lock
+	HeldText []string
+	// Locks contains the expiry time of every active keyboard lock
+	Locks map[string]time.Time
+	// PromptCommands indicates which prompt commands the keyboard is currently sending
+	PromptCommands PromptCommands
+}
+
+// PrinterSnapshot records the state of the printer at the time a TerminalSnapshot was taken
+type PrinterSnapshot struct {
+	// UndecodedBytes contains bytes that have been read from the input stream but could not
+	// yet be decoded, usually because they are an incomplete character. This is only populated
+	// when the snapshot is frozen.
+	UndecodedBytes []byte
+	// PartialSequence indicates whether the parser is holding an incomplete escape sequence
+	// while it waits for the remainder. This is only populated when the snapshot is frozen.
+	PartialSequence bool
+	// PromptCommands indicates which prompt commands the printer is currently accepting
+	PromptCommands PromptCommands
+	// Stats contains the printer's stream statistics
+	Stats StreamStats
+}
+
+// TerminalSnapshot is a structured report of the terminal's internal state, produced by
+// Terminal.DebugSnapshot. It is intended for diagnosing sessions that have stopped
+// behaving as expected.
+type TerminalSnapshot struct {
+	// Time is the time at which the snapshot was taken
+	Time time.Time
+	// Frozen indicates whether the keyboard & printer loops were successfully paused while
+	// the snapshot was taken. If they were not, some fields will not be populated, and the
+	// remaining fields may not be consistent with one another.
+	Frozen bool
+
+	Side            TerminalSide
+	EncodingCharset string
+	DecodingCharset string
+
+	// EventQueue is the number of events waiting to be delivered to hooks
+	EventQueue int
+	// PrinterDispatchQueue is the number of printer output events waiting to be delivered to
+	// hooks, when TerminalConfig.PrinterDispatchQueueSize is in use
+	PrinterDispatchQueue int
+
+	Keyboard KeyboardSnapshot
+	Printer  PrinterSnapshot
+	TelOpts  []TelOptSnapshot
+}
+
+// String produces a multi-line human-readable report of the snapshot
+func (s TerminalSnapshot) String() string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "terminal snapshot at %s (frozen: %t)\n", s.Time.Format(time.RFC3339Nano), s.Frozen)
+	fmt.Fprintf(&sb, "side: %d, encoding: %s, decoding: %s\n", s.Side, s.EncodingCharset, s.DecodingCharset)
+	fmt.Fprintf(&sb, "event queue: %d, printer dispatch queue: %d\n", s.EventQueue, s.PrinterDispatchQueue)
+
+	fmt.Fprintf(&sb, "keyboard: closed: %t, queued commands: %d, queued text: %d, prompt commands: %d\n",
+		s.Keyboard.Closed, s.Keyboard.QueuedCommands, s.Keyboard.QueuedText, s.Keyboard.PromptCommands)
+
+	lockNames := make([]string, 0, len(s.Keyboard.Locks))
+	for lockName := range s.Keyboard.Locks {
+		lockNames = append(lockNames, lockName)
+	}
+	sort.Strings(lockNames)
+
+	for _, lockName := range lockNames {
+		fmt.Fprintf(&sb, "  lock %s expires in %s\n", lockName, s.Keyboard.Locks[lockName].Sub(s.Time))
+	}
+
+	for _, text := range s.Keyboard.HeldText {
+		fmt.Fprintf(&sb, "  held: %s\n", text)
+	}
+
+	fmt.Fprintf(&sb, "printer: partial sequence: %t, undecoded bytes: %q, prompt commands: %d\n",
+		s.Printer.PartialSequence, s.Printer.UndecodedBytes, s.Printer.PromptCommands)
+	fmt.Fprintf(&sb, "  wire bytes: %d, stream bytes: %d, corrupt streams: %d, clean stream ends: %d\n",
+		s.Printer.Stats.WireBytes, s.Printer.Stats.StreamBytes, s.Printer.Stats.CorruptStreams, s.Printer.Stats.CleanStreamEnds)
+
+	for _, option := range s.TelOpts {
+		fmt.Fprintf(&sb, "telopt %s (%d): usage: %s, local: %s, remote: %s\n",
+			option.Name, option.Code, option.Usage, option.LocalState, option.RemoteState)
+	}
+
+	return sb.String()
+}
+
+// freeze pauses the keyboard and printer loops. Each loop pauses the next time it finishes
+// what it is doing or begins waiting on its stream, so freeze will block while either loop is
+// blocked elsewhere- for instance, writing to a stalled connection. If the context expires before
+// both loops are paused, an error is returned and the loops are left running.
+func (t *Terminal) freeze(ctx context.Context) (unfreeze func(), err error) {
+	frozen := make(chan struct{})
+	abandoned := make(chan struct{})
+
+	go func() {
+		t.printer.pause.Lock()
+		t.keyboard.pause.Lock()
+
+		select {
+		case frozen <- struct{}{}:
+		case <-abandoned:
+			t.keyboard.pause.Unlock()
+			t.printer.pause.Unlock()
+		}
+	}()
+
+	select {
+	case <-frozen:
+		return func() {
+			t.keyboard.pause.Unlock()
+			t.printer.pause.Unlock()
+		}, nil
+	case <-ctx.Done():
+		close(abandoned)
+		return nil, ctx.Err()
+	}
+}
+
+// DebugSnapshot pauses the keyboard and printer loops, records the terminal's internal state, and
+// then resumes the loops. This is intended for diagnosing sessions that have wedged in production.
+//
+// If the loops cannot be paused before ctx expires (which usually indicates that one of them is stuck
+// writing to the connection or delivering events to a slow hook), a partial snapshot is returned with
+// Frozen set to false, alongside the context's error.
+func (t *Terminal) DebugSnapshot(ctx context.Context) (TerminalSnapshot, error) {
+	unfreeze, err := t.freeze(ctx)
+	if err == nil {
+		defer unfreeze()
+	}
+
+	snapshot := TerminalSnapshot{
+		Time:            time.Now(),
+		Frozen:          err == nil,
+		Side:            t.side,
+		EncodingCharset: t.charset.EncodingName(),
+		DecodingCharset: t.charset.DecodingName(),
+		EventQueue:      len(t.eventPump.events),
+		Keyboard: KeyboardSnapshot{
+			Closed:         t.keyboard.closed.Load(),
+			QueuedCommands: len(t.keyboard.commands),
+			QueuedText:     t.keyboard.queue.waitingCount(),
+			Locks:          t.keyboard.lock.activeLocks(),
+			PromptCommands: t.keyboard.promptCommands.Get(),
+		},
+		Printer: PrinterSnapshot{
+			PromptCommands: t.printer.promptCommands.Get(),
+			Stats:          t.printer.StreamStats(),
+		},
+	}
+
+	if t.eventPump.printerEvents != nil {
+		snapshot.PrinterDispatchQueue = len(t.eventPump.printerEvents)
+	}
+
+	for _, held := range t.keyboard.queue.heldTransports() {
+		text := held.unparsedString
+		if held.data != nil {
+			text = held.data.EscapedString(t)
+		}
+
+		snapshot.Keyboard.HeldText = append(snapshot.Keyboard.HeldText, text)
+	}
+
+	if snapshot.Frozen {
+		scanner := t.printer.scanner
+		snapshot.Printer.UndecodedBytes = append([]byte(nil), scanner.bytesToDecode...)
+		snapshot.Printer.PartialSequence = scanner.parser.HasPartialSequence()
+	}
+
+	for _, option := range t.telOptList() {
+		snapshot.TelOpts = append(snapshot.TelOpts, TelOptSnapshot{
+			Code:        option.Code(),
+			Name:        option.String(),
+			Usage:       option.Usage(),
+			LocalState:  option.LocalState(),
+			RemoteState: option.RemoteState(),
+		})
+	}
+
+	sort.Slice(snapshot.TelOpts, func(i, j int) bool {
+		return snapshot.TelOpts[i].Code < snapshot.TelOpts[j].Code
+	})
+
+	return snapshot, err
+}
@@ -0,0 +1,101 @@
+package telnet
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// TelOptStaleEvent is raised by a watch started with Terminal.WatchTelOptStaleness when a telopt
+// has gone longer than the watch's maximum age without receiving a subnegotiation from the remote.
+// Servers that cache data a client reports by subnegotiation, such as NAWS sizes, can use it to
+// decide when to ask for the data again.
+type TelOptStaleEvent struct {
+	TelnetOption TelnetOption
+	// LastSubnegotiation is the time the telopt last received a subnegotiation, or the zero time
+	// if it never has
+	LastSubnegotiation time.Time
+	MaxAge             time.Duration
+}
+
+func (e TelOptStaleEvent) Option() TelnetOption {
+	return e.TelnetOption
+}
+
+func (e TelOptStaleEvent) String() string {
+	if e.LastSubnegotiation.IsZero() {
+		return fmt.Sprintf("%s: no subnegotiation received in %s", e.TelnetOption, e.MaxAge)
+	}
+
+	return fmt.Sprintf("%s: no subnegotiation received since %s (max age %s)", e.TelnetOption, e.LastSubnegotiation.Format(time.RFC3339), e.MaxAge)
+}
+
+// LastSubnegotiation returns the time that the telopt with the provided code last received a
+// subnegotiation from the remote, or the zero time if it never has
+func (t *Terminal) LastSubnegotiation(code TelOptCode) time.Time {
+	return t.printer.stats.lastSubnegotiationTime(code)
+}
+
+// WatchTelOptStaleness raises a TelOptStaleEvent whenever the telopt with the provided code goes
+// longer than maxAge without receiving a subnegotiation while it is active on either side. The
+// clock starts when the watch does, so a telopt that received its last subnegotiation long ago
+// is not reported until maxAge has passed. Once an event has been raised, the next one is not
+// raised until another maxAge has passed. The watch ends when the terminal shuts down, or when
+// the returned Subscription is unregistered.
+func (t *Terminal) WatchTelOptStaleness(code TelOptCode, maxAge time.Duration) (*Subscription, error) {
+	option, hasOption := t.telOpt(code)
+	if !hasOption {
+		return nil, fmt.Errorf("telopt %d is not registered", code)
+	}
+
+	if maxAge <= 0 {
+		return nil, errors.New("staleness watch requires a positive max age")
+	}
+
+	stop := make(chan struct{})
+	go t.watchStaleness(option, maxAge, stop)
+
+	return &Subscription{
+		unregister: func() {
+			close(stop)
+		},
+	}, nil
+}
+
+func (t *Terminal) watchStaleness(option TelnetOption, maxAge time.Duration, stop <-chan struct{}) {
+	baseline := time.Now()
+	timer := time.NewTimer(maxAge)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		lastSubnegotiation := t.LastSubnegotiation(option.Code())
+		if lastSubnegotiation.After(baseline) {
+			baseline = lastSubnegotiation
+		}
+
+		age := time.Since(baseline)
+		if age < maxAge {
+			timer.Reset(maxAge - age)
+			continue
+		}
+
+		if option.LocalState() == TelOptActive || option.RemoteState() == TelOptActive {
+			t.RaiseTelOptEvent(TelOptStaleEvent{
+				TelnetOption:       option,
+				LastSubnegotiation: lastSubnegotiation,
+				MaxAge:             maxAge,
+			})
+		}
+
+		baseline = time.Now()
+		timer.Reset(maxAge)
+	}
+}
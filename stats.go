@@ -0,0 +1,159 @@
+package telnet
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// TerminalStats contains statistics about the traffic that has passed through a Terminal,
+// produced by Terminal.Stats
+type TerminalStats struct {
+	// BytesRead is the number of bytes that have been read from the underlying connection
+	BytesRead uint64
+	// BytesWritten is the number of bytes that have been written to the underlying connection
+	BytesWritten uint64
+
+	// CommandsReceived is the number of commands received from the remote, by opcode. NOP commands
+	// are not counted.
+	CommandsReceived map[byte]uint64
+	// CommandsSent is the number of commands sent to the remote, by opcode
+	CommandsSent map[byte]uint64
+
+	// SubnegotiationsReceived is the number of subnegotiations received from the remote, by telopt
+	SubnegotiationsReceived map[TelOptCode]uint64
+	// SubnegotiationsSent is the number of subnegotiations sent to the remote, by telopt
+	SubnegotiationsSent map[TelOptCode]uint64
+	// SubnegotiationsRejected is the number of subnegotiations received from the remote that were
+	// rejected by the telopt's SubnegotiationValidator, by telopt. Rejected subnegotiations are also
+	// counted in SubnegotiationsReceived.
+	SubnegotiationsRejected map[TelOptCode]uint64
+	// LastSubnegotiationReceived is the time that a subnegotiation was last received from the remote,
+	// by telopt. Telopts that have never received one are left out.
+	LastSubnegotiationReceived map[TelOptCode]time.Time
+
+	// LinesReceived is the number of line feeds received from the remote
+	LinesReceived uint64
+
+	// LastRead is the time that the printer last received output from the remote. It is the zero
+	// time if nothing has been received.
+	LastRead time.Time
+	// LastWrite is the time that the keyboard last wrote to the remote. It is the zero time if
+	// nothing has been written.
+	LastWrite time.Time
+}
+
+// directionStats records the traffic travelling in one direction. The printer and keyboard
+// each own one, so that each loop only writes its own counters.
+type directionStats struct {
+	bytes           atomic.Uint64
+	commands        [256]atomic.Uint64
+	subnegotiations [256]atomic.Uint64
+	rejected        [256]atomic.Uint64
+	// lastSubnegotiation holds the UnixNano time of the most recent subnegotiation, by telopt
+	lastSubnegotiation [256]atomic.Int64
+	lines              atomic.Uint64
+	lastActivity       atomic.Int64
+}
+
+func (s *directionStats) recordActivity() {
+	s.lastActivity.Store(time.Now().UnixNano())
+}
+
+func (s *directionStats) recordCommand(c Command) {
+	s.commands[c.OpCode].Add(1)
+
+	if c.OpCode == SB {
+		s.subnegotiations[c.Option].Add(1)
+		s.lastSubnegotiation[c.Option].Store(time.Now().UnixNano())
+	}
+}
+
+func (s *directionStats) lastSubnegotiationTime(option TelOptCode) time.Time {
+	lastSubnegotiation := s.lastSubnegotiation[option].Load()
+	if lastSubnegotiation == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, lastSubnegotiation)
+}
+
+func (s *directionStats) lastSubnegotiationTimes() map[TelOptCode]time.Time {
+	times := make(map[TelOptCode]time.Time)
+
+	for i := range s.lastSubnegotiation {
+		lastSubnegotiation := s.lastSubnegotiationTime(TelOptCode(i))
+		if !lastSubnegotiation.IsZero() {
+			times[TelOptCode(i)] = lastSubnegotiation
+		}
+	}
+
+	return times
+}
+
+func (s *directionStats) recordRejectedSubnegotiation(option TelOptCode) {
+	s.rejected[option].Add(1)
+}
+
+func (s *directionStats) rejectedCounts() map[TelOptCode]uint64 {
+	rejected := make(map[TelOptCode]uint64)
+
+	for i := range s.rejected {
+		count := s.rejected[i].Load()
+		if count > 0 {
+			rejected[TelOptCode(i)] = count
+		}
+	}
+
+	return rejected
+}
+
+func (s *directionStats) lastActivityTime() time.Time {
+	lastActivity := s.lastActivity.Load()
+	if lastActivity == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, lastActivity)
+}
+
+func (s *directionStats) commandCounts() (map[byte]uint64, map[TelOptCode]uint64) {
+	commands := make(map[byte]uint64)
+	subnegotiations := make(map[TelOptCode]uint64)
+
+	for i := range s.commands {
+		count := s.commands[i].Load()
+		if count > 0 {
+			commands[byte(i)] = count
+		}
+
+		count = s.subnegotiations[i].Load()
+		if count > 0 {
+			subnegotiations[TelOptCode(i)] = count
+		}
+	}
+
+	return commands, subnegotiations
+}
+
+// Stats returns statistics about the traffic that has passed through this terminal. Each
+// counter is updated atomically as the keyboard & printer loops run, so this method is
+// cheap enough to poll, but the counters are not guaranteed to be consistent with one another.
+func (t *Terminal) Stats() TerminalStats {
+	received, receivedSubnegotiations := t.printer.stats.commandCounts()
+	sent, sentSubnegotiations := t.keyboard.stats.commandCounts()
+
+	return TerminalStats{
+		BytesRead:               t.printer.StreamStats().WireBytes,
+		BytesWritten:            t.keyboard.stats.bytes.Load(),
+		CommandsReceived:        received,
+		CommandsSent:            sent,
+		SubnegotiationsReceived: receivedSubnegotiations,
+		SubnegotiationsSent:     sentSubnegotiations,
+		SubnegotiationsRejected: t.printer.stats.rejectedCounts(),
+		LinesReceived:           t.printer.stats.lines.Load(),
+		LastRead:                t.printer.stats.lastActivityTime(),
+		LastWrite:               t.keyboard.stats.lastActivityTime(),
+
+		LastSubnegotiationReceived: t.printer.stats.lastSubnegotiationTimes(),
+	}
+}
@@ -0,0 +1,115 @@
+package telnet
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// StreamStats contains statistics about the health of the printer's inbound data stream, or
+// the keyboard's outbound data stream. Most of these values are only interesting when the
+// stream has been wrapped, such as by a compressing writer or decompressing reader for MCCP.
+type StreamStats struct {
+	// WireBytes is the number of bytes that have been read from the underlying connection
+	WireBytes uint64
+	// StreamBytes is the number of bytes that have been read by the printer after any reader
+	// installed with WrapReader has been applied. If the stream is compressed, this is the
+	// number of decompressed bytes.
+	StreamBytes uint64
+	// CorruptStreams is the number of times a wrapped reader failed with an error and the
+	// printer fell back to reading from the underlying connection directly
+	CorruptStreams uint64
+	// CleanStreamEnds is the number of times a wrapped reader ended without error and the
+	// printer resumed reading from the underlying connection directly
+	CleanStreamEnds uint64
+}
+
+// CompressionRatio returns the ratio of stream bytes to wire bytes. A value of 4 indicates
+// that for every byte that arrived on the wire, 4 bytes were received by the printer. If
+// no bytes have been read, this returns 1.
+func (s StreamStats) CompressionRatio() float64 {
+	if s.WireBytes == 0 {
+		return 1
+	}
+
+	return float64(s.StreamBytes) / float64(s.WireBytes)
+}
+
+// StreamCorruptionError is delivered via the EncounteredError hook when a reader installed
+// with TelnetPrinter.WrapReader fails to read the remote's data- for instance, because the
+// remote sent a corrupt zlib stream.  When this happens, the printer will fall back to reading
+// directly from the underlying connection.
+type StreamCorruptionError struct {
+	Err error
+}
+
+func (e *StreamCorruptionError) Error() string {
+	return fmt.Sprintf("wrapped input stream failed, falling back to unwrapped stream: %v", e.Err)
+}
+
+func (e *StreamCorruptionError) Unwrap() error {
+	return e.Err
+}
+
+type countingReader struct {
+	reader io.Reader
+	count  *atomic.Uint64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		r.count.Add(uint64(n))
+	}
+
+	return n, err
+}
+
+type countingWriter struct {
+	writer io.Writer
+	count  *atomic.Uint64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	if n > 0 {
+		w.count.Add(uint64(n))
+	}
+
+	return n, err
+}
+
+// readTimeoutRetries is the number of times in a row that retryingReader retries a read that
+// timed out
+const readTimeoutRetries = 5
+
+// readTimeoutBackoff is how long retryingReader waits before its first retry. The wait doubles
+// with each retry after that.
+const readTimeoutBackoff = 10 * time.Millisecond
+
+// retryingReader retries reads that fail with a timeout before receiving any data, so that
+// a passing hiccup doesn't end the printer- bufio.Scanner stops for good once its reader returns
+// an error. Once a read deadline has passed, every read fails immediately, so retries are
+// limited and back off, and the timeout is returned once they run out.
+type retryingReader struct {
+	reader io.Reader
+}
+
+func (r *retryingReader) Read(p []byte) (int, error) {
+	backoff := readTimeoutBackoff
+
+	for retries := 0; ; retries++ {
+		n, err := r.reader.Read(p)
+
+		var netErr net.Error
+		if n > 0 || retries >= readTimeoutRetries || !errors.As(err, &netErr) || !netErr.Timeout() {
+			return n, err
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
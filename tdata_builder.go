@@ -2,6 +2,8 @@ package telnet
 
 import (
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/charmbracelet/x/ansi"
 )
@@ -16,15 +18,49 @@ type TerminalDataParser struct {
 }
 
 func NewTerminalDataParser() *TerminalDataParser {
-	parser := &TerminalDataParser{
-		terminalData: newQueue[TerminalData](50),
-		bytes:        newQueue[byte](1000),
-	}
-	parser.parser = ansi.NewParser(nil)
+	parser := &TerminalDataParser{}
+	parser.allocate()
 	return parser
 }
 
+// allocate creates the parser's buffers if they don't exist, either because the parser is
+// new or because they were released by ReleaseBuffers
+func (p *TerminalDataParser) allocate() {
+	if p.parser != nil {
+		return
+	}
+
+	p.terminalData = newQueue[TerminalData](50)
+	p.bytes = newQueue[byte](1000)
+	p.parser = ansi.NewParser(nil)
+}
+
+// ReleaseBuffers frees the parser's internal buffers so that they can be garbage collected. They
+// will be recreated the next time the parser is used. This is useful for parsers that may sit idle
+// for long periods. Buffers are only released when the parser is not holding any data, and the
+// return value indicates whether they were released.
+func (p *TerminalDataParser) ReleaseBuffers() bool {
+	if p.parser == nil {
+		return true
+	}
+
+	if p.parserState != ansi.NormalState || p.builder.Len() > 0 || len(p.parsedBytes) > 0 ||
+		p.bytes.Len() > 0 || p.terminalData.Len() > 0 {
+		return false
+	}
+
+	p.parser = nil
+	p.terminalData = nil
+	p.bytes = nil
+	p.parsedBytes = nil
+	p.builder = strings.Builder{}
+
+	return true
+}
+
 func NextOutput[T string | []byte](p *TerminalDataParser, data T) TerminalData {
+	p.allocate()
+
 	if len(data) > 0 {
 		for byteIndex := 0; byteIndex < len(data); byteIndex++ {
 			p.bytes.Queue(data[byteIndex])
@@ -43,7 +79,7 @@ func NextOutput[T string | []byte](p *TerminalDataParser, data T) TerminalData {
 
 		p.bytes.DropElements(consumed)
 
-		if width == 0 {
+		if width == 0 && !isZeroWidthText(parsed, p.parserState) {
 			p.parsedBytes = append(p.parsedBytes, parsed...)
 		} else {
 			p.builder.Write(parsed)
@@ -88,6 +124,17 @@ func NextOutput[T string | []byte](p *TerminalDataParser, data T) TerminalData {
 	return p.terminalData.Dequeue()
 }
 
+// isZeroWidthText returns true if the parser produced a character with no width, such as U+FEFF,
+// rather than a control code or part of an escape sequence
+func isZeroWidthText(parsed []byte, state byte) bool {
+	if state != ansi.NormalState || len(parsed) < 2 {
+		return false
+	}
+
+	decoded, _ := utf8.DecodeRune(parsed)
+	return decoded != utf8.RuneError && !unicode.IsControl(decoded)
+}
+
 func (p *TerminalDataParser) Flush() TerminalData {
 	if p.builder.Len() > 0 {
 		out := p.builder.String()
@@ -98,6 +145,86 @@ func (p *TerminalDataParser) Flush() TerminalData {
 	return nil
 }
 
+// HasPartialSequence returns true if the parser is currently in the middle of an escape
+// sequence and is waiting for more data to complete it
+func (p *TerminalDataParser) HasPartialSequence() bool {
+	return p.parserState != ansi.NormalState
+}
+
+// FlushPartial forces out any text and incomplete escape sequence that the parser is holding
+// while it waits for more data. The incomplete sequence's introducer (ESC or a C1 control code)
+// is emitted as ControlCodeData and the remainder is emitted as literal TextData.  The parser
+// then returns to its normal state.
+//
+// Like NextOutput, this returns a single TerminalData. Additional data may be retrieved by
+// calling NextOutput with no new data.
+func (p *TerminalDataParser) FlushPartial() TerminalData {
+	p.allocate()
+
+	if p.builder.Len() > 0 {
+		p.terminalData.Queue(TextData(p.builder.String()))
+		p.builder.Reset()
+	}
+
+	if p.parserState != ansi.NormalState && len(p.parsedBytes) > 0 {
+		p.terminalData.Queue(ControlCodeData(p.parsedBytes[0]))
+
+		if len(p.parsedBytes) > 1 {
+			p.terminalData.Queue(TextData(p.parsedBytes[1:]))
+		}
+	}
+
+	p.parsedBytes = p.parsedBytes[:0]
+	p.parserState = ansi.NormalState
+	p.parser.Reset()
+
+	return p.terminalData.Dequeue()
+}
+
+// TerminalDataParserState is a snapshot of the data held by a TerminalDataParser, produced by
+// SaveState
+type TerminalDataParserState struct {
+	// Pending holds output that has been parsed but not yet returned by NextOutput
+	Pending []TerminalData
+	// Partial holds data that has not been parsed into output yet, such as the start of an
+	// escape sequence
+	Partial []byte
+}
+
+// SaveState returns the data that the parser is holding. Passing the state to RestoreState on
+// another parser will cause it to produce the same output as this one would have.
+func (p *TerminalDataParser) SaveState() TerminalDataParserState {
+	var state TerminalDataParserState
+	if p.parser == nil {
+		return state
+	}
+
+	state.Pending = append(state.Pending, p.terminalData.Buffer()...)
+
+	state.Partial = append(state.Partial, p.builder.String()...)
+	state.Partial = append(state.Partial, p.parsedBytes...)
+	state.Partial = append(state.Partial, p.bytes.Buffer()...)
+
+	return state
+}
+
+// RestoreState discards the data the parser is holding and replaces it with a state produced
+// by SaveState
+func (p *TerminalDataParser) RestoreState(state TerminalDataParserState) {
+	p.allocate()
+
+	p.terminalData = newQueue[TerminalData](max(50, len(state.Pending)))
+	p.terminalData.Queue(state.Pending...)
+
+	p.bytes = newQueue[byte](max(1000, len(state.Partial)))
+	p.bytes.Queue(state.Partial...)
+
+	p.builder.Reset()
+	p.parsedBytes = p.parsedBytes[:0]
+	p.parserState = ansi.NormalState
+	p.parser.Reset()
+}
+
 func (p *TerminalDataParser) FireAll(terminal *Terminal, data string, publisher *EventPublisher[TerminalData]) {
 	outData := NextOutput(p, data)
 
@@ -0,0 +1,30 @@
+package telnettest
+
+// Canned output for use as ServerConfig.Greeting or in responses. Line breaks are written as \r\n.
+
+// ColorBanner is a bordered banner drawn with ASCII characters and SGR colors, including bold,
+// 16-color foregrounds, and a reset at the end
+const ColorBanner = "\x1b[1;34m+----------------------------------+\x1b[0m\r\n" +
+	"\x1b[1;34m|\x1b[0m   \x1b[1;33mWelcome to the \x1b[31mTest \x1b[32mServer\x1b[0m!      \x1b[1;34m|\x1b[0m\r\n" +
+	"\x1b[1;34m|\x1b[0m   \x1b[36mAll connections are scripted\x1b[0m   \x1b[1;34m|\x1b[0m\r\n" +
+	"\x1b[1;34m+----------------------------------+\x1b[0m\r\n"
+
+// PaletteArt shows blocks of each of the 16 basic background colors, followed by samples of the
+// 256-color and truecolor SGR forms
+const PaletteArt = "\x1b[40m  \x1b[41m  \x1b[42m  \x1b[43m  \x1b[44m  \x1b[45m  \x1b[46m  \x1b[47m  \x1b[0m\r\n" +
+	"\x1b[100m  \x1b[101m  \x1b[102m  \x1b[103m  \x1b[104m  \x1b[105m  \x1b[106m  \x1b[107m  \x1b[0m\r\n" +
+	"\x1b[38;5;208m256-color orange\x1b[0m \x1b[38;2;128;0;255mtruecolor purple\x1b[0m\r\n"
+
+// CursorArt clears the screen and draws with absolute cursor positioning, which exercises clients
+// that render a screen rather than a scrollback
+const CursorArt = "\x1b[2J\x1b[H" +
+	"\x1b[2;5H\x1b[7m TOP LEFT \x1b[0m" +
+	"\x1b[2;30H\x1b[7m TOP RIGHT \x1b[0m" +
+	"\x1b[6;15H\x1b[1m* center *\x1b[0m" +
+	"\x1b[10;1H\r\n"
+
+// UnicodeBoxArt is a banner drawn with box-drawing characters. It can only be sent once a charset
+// that includes them, such as UTF-8, is in use.
+const UnicodeBoxArt = "\x1b[32m╔══════════════════╗\x1b[0m\r\n" +
+	"\x1b[32m║\x1b[0m  Unicode output  \x1b[32m║\x1b[0m\r\n" +
+	"\x1b[32m╚══════════════════╝\x1b[0m\r\n"
@@ -0,0 +1,312 @@
+package telnettest
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/moodclient/telnet"
+	"github.com/moodclient/telnet/telopts"
+	"github.com/moodclient/telnet/utils"
+)
+
+// Response is a scripted reply to a line received from the client
+type Response struct {
+	// Line is the line that triggers this response. Leading and trailing whitespace is ignored
+	// when comparing lines.
+	Line string
+	// Match, if not nil, is used instead of Line to decide whether a line triggers this response
+	Match func(line string) bool
+	// Reply is written to the client as-is, so line breaks should be written as \r\n
+	Reply string
+	// Disconnect closes the connection once Reply has been sent
+	Disconnect bool
+}
+
+func (r Response) matches(line string) bool {
+	if r.Match != nil {
+		return r.Match(line)
+	}
+
+	return strings.TrimSpace(r.Line) == strings.TrimSpace(line)
+}
+
+// OptionBehavior describes how the server negotiates a telopt that it doesn't implement. The telopt
+// is negotiated according to Usage, but all subnegotiations are rejected. This is useful for testing
+// how clients react to servers that request, accept, or refuse options.
+type OptionBehavior struct {
+	Code  telnet.TelOptCode
+	Name  string
+	Usage telnet.TelOptUsage
+}
+
+type ServerConfig struct {
+	// TerminalConfig is used to create a terminal for each connection. Side is always SideServer,
+	// and if DefaultCharsetName is not set, US-ASCII is used. TelOpts is replaced with the telopts
+	// created from the TelOpts and Options fields below, because telopts can't be shared between
+	// terminals. TelOptNames can be used as usual.
+	TerminalConfig telnet.TerminalConfig
+	// TelOpts, if not nil, is called to create telopts for each connection
+	TelOpts func() []telnet.TelnetOption
+	// Options adds a telopt with no behavior of its own for each entry
+	Options []OptionBehavior
+
+	// Greeting is written to each client as soon as it connects, such as one of the canned art
+	// banners in this package
+	Greeting string
+	// Prompt, if not empty, is written after the greeting and after each reply, followed by a
+	// prompt hint (IAC GA or IAC EOR)
+	Prompt string
+	// Responses are checked in order for each line received from the client, and the first
+	// matching response is sent
+	Responses []Response
+	// UnknownReply is written in response to lines that don't match any response. If it is empty,
+	// no reply is sent.
+	UnknownReply string
+}
+
+// Server is a scripted telnet server for integration tests. It listens on a loopback port,
+// greets each client, and answers the lines they send with canned responses, so that clients
+// can be tested end-to-end without depending on a real MUD or BBS.
+type Server struct {
+	config   ServerConfig
+	listener net.Listener
+	ctx      context.Context
+	cancel   context.CancelFunc
+
+	lock      sync.Mutex
+	terminals map[*telnet.Terminal]struct{}
+	received  []string
+	closed    bool
+	running   sync.WaitGroup
+}
+
+// NewServer starts a server listening on a random loopback port. Call Close when the test is
+// finished.
+func NewServer(config ServerConfig) (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	server := NewUnstartedServer(config)
+	server.listener = listener
+
+	server.running.Add(1)
+	go server.acceptLoop()
+
+	return server, nil
+}
+
+// NewUnstartedServer creates a server that does not listen for connections. Connections can be
+// served with ServeConn, such as one end of a net.Pipe.
+func NewUnstartedServer(config ServerConfig) *Server {
+	if config.TerminalConfig.DefaultCharsetName == "" {
+		config.TerminalConfig.DefaultCharsetName = "US-ASCII"
+	}
+	config.TerminalConfig.Side = telnet.SideServer
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Server{
+		config:    config,
+		ctx:       ctx,
+		cancel:    cancel,
+		terminals: make(map[*telnet.Terminal]struct{}),
+	}
+}
+
+// Addr returns the host:port address the server is listening on, or an empty string if the
+// server was created with NewUnstartedServer
+func (s *Server) Addr() string {
+	if s.listener == nil {
+		return ""
+	}
+
+	return s.listener.Addr().String()
+}
+
+// URL returns a telnet URL for the server, suitable for telnet.DialURL
+func (s *Server) URL() string {
+	return "telnet://" + s.Addr()
+}
+
+// Dial connects a new client terminal to the server
+func (s *Server) Dial(ctx context.Context, config telnet.TerminalConfig) (*telnet.Terminal, error) {
+	return telnet.Dial(ctx, s.Addr(), config)
+}
+
+func (s *Server) acceptLoop() {
+	defer s.running.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		_, _ = s.ServeConn(conn)
+	}
+}
+
+// ServeConn creates a server terminal for the provided connection and runs the script on it.
+// The terminal is closed when the server is closed.
+func (s *Server) ServeConn(conn net.Conn) (*telnet.Terminal, error) {
+	config := s.config.TerminalConfig
+	config.TelOpts = nil
+	if s.config.TelOpts != nil {
+		config.TelOpts = s.config.TelOpts()
+	}
+	for _, behavior := range s.config.Options {
+		option := telopts.NewBaseTelOpt(behavior.Code, behavior.Name, behavior.Usage)
+		config.TelOpts = append(config.TelOpts, &option)
+	}
+
+	terminal, err := telnet.NewTerminal(s.ctx, conn, config)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	if !s.track(terminal) {
+		_ = terminal.Close()
+		return nil, telnet.ErrServerClosed
+	}
+
+	lines := utils.NewServerLineReader(terminal)
+	lines.RegisterLineHook(s.respond)
+
+	if s.config.Greeting != "" {
+		terminal.Keyboard().WriteString(s.config.Greeting)
+	}
+	s.prompt(terminal)
+
+	go func() {
+		defer s.running.Done()
+		defer s.untrack(terminal)
+
+		_ = terminal.WaitForExit()
+	}()
+
+	return terminal, nil
+}
+
+func (s *Server) respond(terminal *telnet.Terminal, line string) {
+	s.lock.Lock()
+	s.received = append(s.received, line)
+	s.lock.Unlock()
+
+	reply := s.config.UnknownReply
+	disconnect := false
+	for _, response := range s.config.Responses {
+		if response.matches(line) {
+			reply = response.Reply
+			disconnect = response.Disconnect
+			break
+		}
+	}
+
+	if reply != "" {
+		terminal.Keyboard().WriteString(reply)
+	}
+
+	if disconnect {
+		// Closing waits for the terminal loop, which is running this hook
+		go func() {
+			_ = terminal.Close()
+		}()
+		return
+	}
+
+	s.prompt(terminal)
+}
+
+func (s *Server) prompt(terminal *telnet.Terminal) {
+	if s.config.Prompt == "" {
+		return
+	}
+
+	terminal.Keyboard().WriteString(s.config.Prompt)
+	terminal.Keyboard().SendPromptHint()
+}
+
+func (s *Server) track(terminal *telnet.Terminal) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return false
+	}
+
+	s.terminals[terminal] = struct{}{}
+	s.running.Add(1)
+	return true
+}
+
+func (s *Server) untrack(terminal *telnet.Terminal) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.terminals, terminal)
+}
+
+// Terminals returns the server terminals for the connections that are currently open
+func (s *Server) Terminals() []*telnet.Terminal {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	terminals := make([]*telnet.Terminal, 0, len(s.terminals))
+	for terminal := range s.terminals {
+		terminals = append(terminals, terminal)
+	}
+
+	return terminals
+}
+
+// ReceivedLines returns every line the server has received from its clients, in the order they
+// were received
+func (s *Server) ReceivedLines() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return append([]string(nil), s.received...)
+}
+
+// Close stops listening, closes every open connection, and waits for them to finish
+func (s *Server) Close() error {
+	s.lock.Lock()
+	alreadyClosed := s.closed
+	s.closed = true
+	terminals := make([]*telnet.Terminal, 0, len(s.terminals))
+	for terminal := range s.terminals {
+		terminals = append(terminals, terminal)
+	}
+	s.lock.Unlock()
+
+	if alreadyClosed {
+		s.running.Wait()
+		return nil
+	}
+
+	var errs []error
+	if s.listener != nil {
+		err := s.listener.Close()
+		if err != nil && !errors.Is(err, net.ErrClosed) {
+			errs = append(errs, err)
+		}
+	}
+
+	for _, terminal := range terminals {
+		err := terminal.Close()
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	s.cancel()
+	s.running.Wait()
+
+	return errors.Join(errs...)
+}
@@ -2,6 +2,7 @@ package telnet
 
 import (
 	"fmt"
+	"strings"
 )
 
 // TelOptUsage indicates how a particular TelnetOption is supposed to be used by the
@@ -32,6 +33,28 @@ const (
 	TelOptRequestLocal TelOptUsage = TelOptAllowLocal | telOptOnlyRequestLocal
 )
 
+func (u TelOptUsage) String() string {
+	var flags []string
+
+	if u&TelOptRequestLocal == TelOptRequestLocal {
+		flags = append(flags, "RequestLocal")
+	} else if u&TelOptAllowLocal != 0 {
+		flags = append(flags, "AllowLocal")
+	}
+
+	if u&TelOptRequestRemote == TelOptRequestRemote {
+		flags = append(flags, "RequestRemote")
+	} else if u&TelOptAllowRemote != 0 {
+		flags = append(flags, "AllowRemote")
+	}
+
+	if len(flags) == 0 {
+		return "None"
+	}
+
+	return strings.Join(flags, "|")
+}
+
 // TelOptCode - each telopt has a unique identification number between 0 and 255
 type TelOptCode byte
 
@@ -92,6 +115,25 @@ type TelnetOption interface {
 	SubnegotiationString(subnegotiation []byte) (string, error)
 }
 
+// TelOptUsageSetter is an optional interface that TelnetOption implementations can satisfy
+// in order to allow their usage to be changed after the terminal has been created via
+// Terminal.SetTelOptUsage. Telopts that embed telopts.BaseTelOpt get this for free.
+type TelOptUsageSetter interface {
+	SetUsage(usage TelOptUsage)
+}
+
+// SubnegotiationValidator is an optional interface that TelnetOption implementations can satisfy
+// in order to check the shape of subnegotiations before they are processed. ValidateSubnegotiation
+// is called before Subnegotiate for every subnegotiation that would be passed to it, and if it returns
+// an error, Subnegotiate is not called. Instead, a SubnegotiationRejectedError is delivered via the
+// EncounteredError hook and the subnegotiation is counted in TerminalStats.SubnegotiationsRejected.
+//
+// Validators should only check that the subnegotiation is well-formed, not whether the telopt is
+// in a state to act on it- they are called without regard to the telopt's state.
+type SubnegotiationValidator interface {
+	ValidateSubnegotiation(subnegotiation []byte) error
+}
+
 // TelOptState indicates whether the telopt is currently active, inactive, or other
 type TelOptState byte
 
@@ -152,6 +194,44 @@ type TelOptEvent interface {
 	Option() TelnetOption
 }
 
+// TelOptChangeReason indicates why a telopt changed state
+type TelOptChangeReason byte
+
+const (
+	TelOptChangeUnknown TelOptChangeReason = iota
+	// TelOptChangeRemoteRequest indicates that the remote sent a negotiation command, either asking
+	// for the change or answering a request made by this terminal
+	TelOptChangeRemoteRequest
+	// TelOptChangeLocalRequest indicates that this terminal asked for the change, either because the
+	// telopt's usage requested it when it was registered, or because of Terminal.DisableTelOpt
+	TelOptChangeLocalRequest
+	// TelOptChangePolicy indicates that the telopt's usage was changed with Terminal.SetTelOptUsage
+	TelOptChangePolicy
+	// TelOptChangeConnectionClose indicates that the telopt was deactivated because the terminal
+	// is closing and TerminalConfig.DisableTelOptsOnClose was set
+	TelOptChangeConnectionClose
+	// TelOptChangeTimeout indicates that the remote did not answer a request within the telopt's
+	// negotiation timeout, or that the request is being retried. See TelOptNegotiationTimeoutEvent.
+	TelOptChangeTimeout
+)
+
+func (r TelOptChangeReason) String() string {
+	switch r {
+	case TelOptChangeRemoteRequest:
+		return "Remote Request"
+	case TelOptChangeLocalRequest:
+		return "Local Request"
+	case TelOptChangePolicy:
+		return "Policy"
+	case TelOptChangeConnectionClose:
+		return "Connection Close"
+	case TelOptChangeTimeout:
+		return "Timeout"
+	default:
+		return "Unknown"
+	}
+}
+
 // TelOptStateChangeEvent is a TelOptEvent that indicates that a single telopt has changed state
 // on one side of the connection
 type TelOptStateChangeEvent struct {
@@ -159,6 +239,7 @@ type TelOptStateChangeEvent struct {
 	Side         TelOptSide
 	OldState     TelOptState
 	NewState     TelOptState
+	Reason       TelOptChangeReason
 }
 
 func (e TelOptStateChangeEvent) Option() TelnetOption {
@@ -166,7 +247,23 @@ func (e TelOptStateChangeEvent) Option() TelnetOption {
 }
 
 func (e TelOptStateChangeEvent) String() string {
-	return fmt.Sprintf("%s: %s state changed from %s to %s", e.TelnetOption, e.Side, e.OldState, e.NewState)
+	return fmt.Sprintf("%s: %s state changed from %s to %s (%s)", e.TelnetOption, e.Side, e.OldState, e.NewState, e.Reason)
+}
+
+// TelOptUsageChangeEvent is a TelOptEvent that indicates that the consumer has changed
+// the permitted usage of a single telopt with Terminal.SetTelOptUsage
+type TelOptUsageChangeEvent struct {
+	TelnetOption TelnetOption
+	OldUsage     TelOptUsage
+	NewUsage     TelOptUsage
+}
+
+func (e TelOptUsageChangeEvent) Option() TelnetOption {
+	return e.TelnetOption
+}
+
+func (e TelOptUsageChangeEvent) String() string {
+	return fmt.Sprintf("%s: usage changed from %s to %s", e.TelnetOption, e.OldUsage, e.NewUsage)
 }
 
 // TypedTelnetOption - this is used as a bit of a hack for GetTelOpt. It allows
@@ -181,27 +278,33 @@ type TypedTelnetOption[OptionStruct any] interface {
 //	telnet.GetTelOpt[telopts.ECHO](terminal)
 //
 // The above will return a value of type *telopts.ECHO, or nil if ECHO is not a registered
-// telopt.  If there is a telopt of a different type registered under ECHO's code, then the method
-// will return an error.
+// telopt.  If more than one telopt of the requested type is registered, then the method will
+// return an error.
 //
 // This can be used to update the local state of a telopt, or respond to TelOptEvents by querying
 // the newly-updated remote state of a telopt.
 func GetTelOpt[OptionStruct any, T TypedTelnetOption[OptionStruct]](terminal *Terminal) (T, error) {
 	var zero OptionStruct
-	var err error
-	code := T(&zero).Code()
 
-	option, hasOption := terminal.options[code]
+	// Telopt codes are assigned by their constructors, so the zero value can't tell us which code
+	// to look under- find the telopt by type instead
+	var found TelnetOption
+	for _, option := range terminal.telOptList() {
+		typed, ok := option.(T)
+		if !ok {
+			continue
+		}
 
-	if !hasOption {
-		return nil, nil
+		if found != nil {
+			return nil, fmt.Errorf("multiple telopts of type %T are registered", zero)
+		}
+
+		found = typed
 	}
 
-	typed, ok := option.(T)
-	if !ok {
-		name := T(&zero).String()
-		return nil, fmt.Errorf("TelOpt %s did not return type %T- it returned type %T", name, zero, option)
+	if found == nil {
+		return nil, nil
 	}
 
-	return typed, err
+	return found.(T), nil
 }
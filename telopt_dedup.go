@@ -0,0 +1,107 @@
+package telnet
+
+import (
+	"reflect"
+	"sync"
+)
+
+type telOptEventKey struct {
+	code      TelOptCode
+	eventType reflect.Type
+}
+
+// telOptEventFilter suppresses TelOptEvents that are identical to the previous event of the
+// same type raised by the same telopt. Only event types that have been marked for
+// deduplication are filtered.
+type telOptEventFilter struct {
+	lock       sync.Mutex
+	eventTypes map[reflect.Type]struct{}
+	lastEvents map[telOptEventKey]TelOptEvent
+}
+
+func newTelOptEventFilter(events []TelOptEvent) *telOptEventFilter {
+	filter := &telOptEventFilter{
+		eventTypes: make(map[reflect.Type]struct{}),
+		lastEvents: make(map[telOptEventKey]TelOptEvent),
+	}
+	filter.deduplicate(events, true)
+
+	return filter
+}
+
+func (f *telOptEventFilter) deduplicate(events []TelOptEvent, enabled bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for _, event := range events {
+		eventType := reflect.TypeOf(event)
+
+		if enabled {
+			f.eventTypes[eventType] = struct{}{}
+			continue
+		}
+
+		delete(f.eventTypes, eventType)
+		for key := range f.lastEvents {
+			if key.eventType == eventType {
+				delete(f.lastEvents, key)
+			}
+		}
+	}
+}
+
+// suppress returns true if the provided event should not be delivered to hooks
+func (f *telOptEventFilter) suppress(event TelOptEvent) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	option := event.Option()
+	if option == nil {
+		return false
+	}
+
+	// A telopt that is deactivated and then reactivated should deliver its events afresh
+	stateChange, isStateChange := event.(TelOptStateChangeEvent)
+	if isStateChange && stateChange.NewState == TelOptInactive {
+		for key := range f.lastEvents {
+			if key.code == option.Code() {
+				delete(f.lastEvents, key)
+			}
+		}
+	}
+
+	eventType := reflect.TypeOf(event)
+	_, deduplicate := f.eventTypes[eventType]
+	if !deduplicate {
+		return false
+	}
+
+	key := telOptEventKey{code: option.Code(), eventType: eventType}
+	lastEvent, hasLastEvent := f.lastEvents[key]
+	if hasLastEvent && reflect.DeepEqual(lastEvent, event) {
+		return true
+	}
+
+	f.lastEvents[key] = event
+	return false
+}
+
+// DeduplicateTelOptEvents will prevent TelOptEvent hooks from receiving events of the same types
+// as the provided events when they are identical to the previous event of that type raised by the
+// same telopt. This is useful for remotes that resend unchanged subnegotiations (such as NAWS or
+// TTYPE) every few seconds. The provided events are only used to identify the event types, so
+// zero values can be used:
+//
+//	terminal.DeduplicateTelOptEvents(telopts.NAWSRemoteSizeChangedEvent{})
+//
+// Telopts can call this method from Initialize to deduplicate their own events by default. Deduplication
+// can also be requested at creation time with TerminalConfig.DeduplicateTelOptEvents.
+func (t *Terminal) DeduplicateTelOptEvents(events ...TelOptEvent) {
+	t.telOptEventFilter.deduplicate(events, true)
+}
+
+// StopDeduplicatingTelOptEvents reverses DeduplicateTelOptEvents for the types of the provided events,
+// so that every event of those types is delivered to hooks
+func (t *Terminal) StopDeduplicatingTelOptEvents(events ...TelOptEvent) {
+	t.telOptEventFilter.deduplicate(events, false)
+}
@@ -0,0 +1,98 @@
+package telnet
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// TelOptConstructor creates a new telopt for a terminal on the provided side of the connection.
+// Constructors registered with RegisterTelOptConstructor should choose a usage & configuration
+// that is sensible for that side.
+type TelOptConstructor func(side TerminalSide) TelnetOption
+
+var telOptConstructorsLock sync.RWMutex
+var telOptConstructors = make(map[string]TelOptConstructor)
+
+func normalizeTelOptName(name string) string {
+	return strings.ToUpper(strings.TrimSpace(name))
+}
+
+// RegisterTelOptConstructor makes a telopt available under the provided name, so that it can be
+// added to a terminal with TerminalConfig.TelOptNames. Names are not case-sensitive. This is
+// intended to be called from the init function of packages that provide telopts- the telopts
+// package registers all of its telopts that don't need any configuration under their usual
+// names (NAWS, TTYPE, etc.), so a blank import of it is enough to use them by name.
+//
+// Registering the same name twice panics.
+func RegisterTelOptConstructor(name string, constructor TelOptConstructor) {
+	normalized := normalizeTelOptName(name)
+	if normalized == "" || constructor == nil {
+		panic("telnet: RegisterTelOptConstructor requires a name and a constructor")
+	}
+
+	telOptConstructorsLock.Lock()
+	defer telOptConstructorsLock.Unlock()
+
+	_, alreadyRegistered := telOptConstructors[normalized]
+	if alreadyRegistered {
+		panic(fmt.Sprintf("telnet: telopt constructor %s is already registered", normalized))
+	}
+
+	telOptConstructors[normalized] = constructor
+}
+
+// TelOptConstructorNames returns the names of all registered telopt constructors, in sorted order
+func TelOptConstructorNames() []string {
+	telOptConstructorsLock.RLock()
+	defer telOptConstructorsLock.RUnlock()
+
+	names := make([]string, 0, len(telOptConstructors))
+	for name := range telOptConstructors {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+// NewTelOptByName creates a telopt for the provided side using the constructor registered under
+// the provided name
+func NewTelOptByName(name string, side TerminalSide) (TelnetOption, error) {
+	telOptConstructorsLock.RLock()
+	constructor, hasConstructor := telOptConstructors[normalizeTelOptName(name)]
+	telOptConstructorsLock.RUnlock()
+
+	if !hasConstructor {
+		return nil, fmt.Errorf("telnet: no telopt constructor is registered under the name %q", name)
+	}
+
+	return constructor(side), nil
+}
+
+// configuredTelOpts returns the telopts from config.TelOpts, followed by the telopts created from
+// config.TelOptNames. Named telopts whose code is already in use are skipped, so telopts passed
+// explicitly in config.TelOpts take precedence.
+func configuredTelOpts(config TerminalConfig) ([]TelnetOption, error) {
+	if len(config.TelOptNames) == 0 {
+		return config.TelOpts, nil
+	}
+
+	options := slices.Clone(config.TelOpts)
+	for _, name := range config.TelOptNames {
+		option, err := NewTelOptByName(name, config.Side)
+		if err != nil {
+			return nil, err
+		}
+
+		alreadyConfigured := slices.ContainsFunc(options, func(configured TelnetOption) bool {
+			return configured.Code() == option.Code()
+		})
+		if !alreadyConfigured {
+			options = append(options, option)
+		}
+	}
+
+	return options, nil
+}
@@ -2,9 +2,10 @@ package telopts
 
 import (
 	"bytes"
-	"errors"
 	"fmt"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/moodclient/telnet"
 	"golang.org/x/text/encoding/ianaindex"
@@ -33,6 +34,16 @@ func (e CHARSETNegotiationSuccessEvent) String() string {
 	return fmt.Sprintf("CHARSET Negotiated To: %s", e.NewCharsetName)
 }
 
+// CHARSETNegotiationFailedEvent is raised when the remote rejects a REQUEST sent by this side
+type CHARSETNegotiationFailedEvent struct {
+	BaseTelOptEvent
+	RequestedCharsets []string
+}
+
+func (e CHARSETNegotiationFailedEvent) String() string {
+	return fmt.Sprintf("CHARSET Negotiation Rejected For: %s", strings.Join(e.RequestedCharsets, " "))
+}
+
 type CHARSETDefaultChangedEvent struct {
 	BaseTelOptEvent
 	NewDefaultCharset string
@@ -42,6 +53,23 @@ func (e CHARSETDefaultChangedEvent) String() string {
 	return fmt.Sprintf("CHARSET Default Changed To: %s", e.NewDefaultCharset)
 }
 
+// CHARSETRequestCollisionEvent is raised when the remote sends a REQUEST while a REQUEST from this
+// side is still waiting for a response. RFC 2066 resolves this in favor of the server: the server
+// rejects the client's REQUEST, and the client abandons its own REQUEST and answers the server's.
+type CHARSETRequestCollisionEvent struct {
+	BaseTelOptEvent
+	// LocalRequestWon indicates that this side is the server, so the remote's REQUEST was rejected
+	LocalRequestWon bool
+}
+
+func (e CHARSETRequestCollisionEvent) String() string {
+	if e.LocalRequestWon {
+		return "CHARSET Request Collision: Rejected Remote Request"
+	}
+
+	return "CHARSET Request Collision: Abandoned Local Request"
+}
+
 type CHARSETConfig struct {
 	PreferredCharsets []string
 	AllowAnyCharset   bool
@@ -63,10 +91,19 @@ func RegisterCHARSET(usage telnet.TelOptUsage, options CHARSETConfig) telnet.Tel
 type CHARSET struct {
 	BaseTelOpt
 
-	options CHARSETConfig
-
-	bestRemoteEncoding   string
+	optionsLock          sync.Mutex
+	options              CHARSETConfig
 	localAllowedCharsets map[string]struct{}
+
+	bestRemoteEncoding string
+	// requestedCharsets holds the charsets of the REQUEST we sent most recently
+	requestedCharsets atomic.Pointer[[]string]
+
+	// requestPending indicates that we have sent a REQUEST and are waiting for the response
+	requestPending atomic.Bool
+	// requestAbandoned indicates that we gave up on our REQUEST because the remote sent one
+	// at the same time, so the response to ours should be ignored
+	requestAbandoned atomic.Bool
 }
 
 func (o *CHARSET) writeRequest(charSets []string) error {
@@ -94,7 +131,11 @@ func (o *CHARSET) writeRequest(charSets []string) error {
 		}
 	}
 
-	o.Terminal().Keyboard().WriteCommand(telnet.Command{
+	o.requestedCharsets.Store(&charSets)
+	o.requestPending.Store(true)
+	o.requestAbandoned.Store(false)
+	// Text that was already on its way out was written for the current charset
+	o.Terminal().Keyboard().WriteCommandAfterText(telnet.Command{
 		OpCode:         telnet.SB,
 		Option:         charset,
 		Subnegotiation: subnegotiation.Bytes(),
@@ -103,12 +144,14 @@ func (o *CHARSET) writeRequest(charSets []string) error {
 	return nil
 }
 
+// writeAccept sends ACCEPTED after the text written before it, which was meant for the old
+// charset. postSend switches to the new charset before any text written after it is sent.
 func (o *CHARSET) writeAccept(acceptedCharset string, postSend func() error) {
 	subnegotiation := make([]byte, 0, len(acceptedCharset)+1)
 	subnegotiation = append(subnegotiation, charsetACCEPTED)
 	subnegotiation = append(subnegotiation, []byte(acceptedCharset)...)
 
-	o.Terminal().Keyboard().WriteCommand(telnet.Command{
+	o.Terminal().Keyboard().WriteCommandAfterText(telnet.Command{
 		OpCode:         telnet.SB,
 		Option:         charset,
 		Subnegotiation: subnegotiation,
@@ -124,7 +167,7 @@ func (o *CHARSET) writeReject() {
 }
 
 func (o *CHARSET) TransitionRemoteState(newState telnet.TelOptState) (func() error, error) {
-	postSend, err := o.BaseTelOpt.TransitionLocalState(newState)
+	postSend, err := o.BaseTelOpt.TransitionRemoteState(newState)
 	if err != nil {
 		return postSend, err
 	}
@@ -143,6 +186,8 @@ func (o *CHARSET) TransitionLocalState(newState telnet.TelOptState) (func() erro
 	}
 
 	if newState == telnet.TelOptInactive {
+		o.requestPending.Store(false)
+		o.requestAbandoned.Store(false)
 		o.Terminal().Keyboard().ClearLock(charsetKeyboardLock)
 	}
 
@@ -152,16 +197,72 @@ func (o *CHARSET) TransitionLocalState(newState telnet.TelOptState) (func() erro
 
 	// Send REQUEST- if we don't have any preferred charsets we don't care so we won't
 	// send anything
-	if len(o.options.PreferredCharsets) > 0 {
-		o.Terminal().Keyboard().SetLock(charsetKeyboardLock, telnet.DefaultKeyboardLock)
-
+	preferredCharsets := o.preferredCharsets()
+	if len(preferredCharsets) > 0 {
 		// Send subnegotiation immediately after accepting
-		return nil, o.writeRequest(o.options.PreferredCharsets)
+		return nil, o.request(preferredCharsets)
 	}
 
 	return postSend, nil
 }
 
+func (o *CHARSET) preferredCharsets() []string {
+	o.optionsLock.Lock()
+	defer o.optionsLock.Unlock()
+
+	return o.options.PreferredCharsets
+}
+
+// SetPreferredCharsets replaces CHARSETConfig.PreferredCharsets. If CHARSET is active locally,
+// a new REQUEST is sent to the remote with the new charsets.
+func (o *CHARSET) SetPreferredCharsets(charsets []string) error {
+	o.optionsLock.Lock()
+	o.options.PreferredCharsets = charsets
+	o.localAllowedCharsets = make(map[string]struct{})
+	for _, c := range charsets {
+		o.localAllowedCharsets[c] = struct{}{}
+	}
+	o.optionsLock.Unlock()
+
+	if len(charsets) == 0 || o.LocalState() != telnet.TelOptActive {
+		return nil
+	}
+
+	return o.request(charsets)
+}
+
+// RequestCharsets sends a new REQUEST to the remote, such as when the user has changed a setting
+// that affects which charset should be used. If no charsets are provided, the preferred charsets
+// are requested. Every charset must be one this side would accept. The keyboard is locked until
+// the remote answers, and a CHARSETNegotiationSuccessEvent or CHARSETNegotiationFailedEvent is
+// raised with the result.
+func (o *CHARSET) RequestCharsets(names ...string) error {
+	if o.LocalState() != telnet.TelOptActive {
+		return fmt.Errorf("charset: cannot send REQUEST while CHARSET is not active locally")
+	}
+
+	if len(names) == 0 {
+		names = o.preferredCharsets()
+		if len(names) == 0 {
+			return fmt.Errorf("charset: no charsets to request")
+		}
+	}
+
+	for _, name := range names {
+		if !o.isAcceptableCharset(name) {
+			return fmt.Errorf("charset: cannot request unacceptable charset %s", name)
+		}
+	}
+
+	return o.request(names)
+}
+
+// request locks the keyboard and sends a REQUEST. The lock is cleared once the remote answers.
+func (o *CHARSET) request(charsets []string) error {
+	o.Terminal().Keyboard().SetLock(charsetKeyboardLock, telnet.DefaultKeyboardLock)
+	return o.writeRequest(charsets)
+}
+
 func (o *CHARSET) isAcceptableCharset(charSet string) bool {
 	// Has to be a valid IANA encoding name
 	_, err := ianaindex.IANA.Encoding(charSet)
@@ -169,6 +270,9 @@ func (o *CHARSET) isAcceptableCharset(charSet string) bool {
 		return false
 	}
 
+	o.optionsLock.Lock()
+	defer o.optionsLock.Unlock()
+
 	// We have to allow all encodings or have it in our list of allowed encodings
 	if !o.options.AllowAnyCharset {
 		_, inAllowedEncodings := o.localAllowedCharsets[charSet]
@@ -220,22 +324,38 @@ func (o *CHARSET) subnegotiateREQUEST(subnegotiation []byte) error {
 		}
 	}
 
-	if bestCharSet == "" {
+	collision := o.requestPending.Load()
+	if collision && o.Terminal().Side() == telnet.SideServer {
+		// Both sides sent a REQUEST at once. The server's REQUEST wins, so reject the client's and
+		// keep the keyboard locked until the client answers ours
+		o.bestRemoteEncoding = bestCharSet
 		o.writeReject()
-		o.Terminal().Keyboard().ClearLock(charsetKeyboardLock)
+		o.Terminal().RaiseTelOptEvent(CHARSETRequestCollisionEvent{
+			BaseTelOptEvent: BaseTelOptEvent{o},
+			LocalRequestWon: true,
+		})
 		return nil
 	}
 
-	o.bestRemoteEncoding = bestCharSet
+	if collision {
+		// The client abandons its own REQUEST and answers the server's. The server will reject
+		// our REQUEST, and that response shouldn't disturb this negotiation.
+		o.requestPending.Store(false)
+		o.requestAbandoned.Store(true)
+		o.Terminal().RaiseTelOptEvent(CHARSETRequestCollisionEvent{
+			BaseTelOptEvent: BaseTelOptEvent{o},
+			LocalRequestWon: false,
+		})
+	}
 
-	if o.Terminal().Side() == telnet.SideServer && o.Terminal().Keyboard().HasActiveLock(charsetKeyboardLock) {
-		// We have worked on a negotiation originating from local in the last 5 seconds
-		// and we are set up to demand priority for our negotiations, so reject the remote negotiation
+	if bestCharSet == "" {
 		o.writeReject()
 		o.Terminal().Keyboard().ClearLock(charsetKeyboardLock)
 		return nil
 	}
 
+	o.bestRemoteEncoding = bestCharSet
+
 	// We have no reason not to accept the encoding
 	err := o.Terminal().Charset().SetNegotiatedDecodingCharset(o.bestRemoteEncoding)
 	if err != nil {
@@ -258,17 +378,32 @@ func (o *CHARSET) subnegotiateREQUEST(subnegotiation []byte) error {
 }
 
 func (o *CHARSET) subnegotiateREJECTED() error {
+	o.requestPending.Store(false)
+	if o.requestAbandoned.Swap(false) {
+		// This is the response to a REQUEST that we abandoned- the keyboard lock belongs to the
+		// remote's REQUEST, which we accepted
+		return nil
+	}
+
 	if o.LocalState() != telnet.TelOptActive {
 		// We may have deactivated while the negotiation was ongoing
 		return nil
 	}
 
+	var requested []string
+	if charSets := o.requestedCharsets.Load(); charSets != nil {
+		requested = *charSets
+	}
+	o.Terminal().RaiseTelOptEvent(CHARSETNegotiationFailedEvent{
+		BaseTelOptEvent:   BaseTelOptEvent{o},
+		RequestedCharsets: requested,
+	})
+
 	if o.bestRemoteEncoding != "" && o.Terminal().Side() == telnet.SideServer {
 		// The client rejected us but they did send us some preferences that we rejected due to having
 		// an active local negotiation- let's request that the client use it
 		if o.Terminal().Charset().EncodingName() != o.bestRemoteEncoding || o.Terminal().Charset().DecodingName() != o.bestRemoteEncoding {
-			o.Terminal().Keyboard().SetLock(charsetKeyboardLock, telnet.DefaultKeyboardLock)
-			return o.writeRequest([]string{o.bestRemoteEncoding})
+			return o.request([]string{o.bestRemoteEncoding})
 		}
 	}
 
@@ -277,6 +412,9 @@ func (o *CHARSET) subnegotiateREJECTED() error {
 }
 
 func (o *CHARSET) subnegotiateACCEPTED(subnegotiation []byte) error {
+	o.requestPending.Store(false)
+	o.requestAbandoned.Store(false)
+
 	if o.LocalState() != telnet.TelOptActive {
 		// We may have deactivated while the negotiation was ongoing
 		return nil
@@ -290,7 +428,7 @@ func (o *CHARSET) subnegotiateACCEPTED(subnegotiation []byte) error {
 
 	charSet := string(subnegotiation[1:])
 	if !o.isAcceptableCharset(charSet) {
-		return fmt.Errorf("charset: client sent ACCEPT for invalid charset %s", charSet)
+		return telnet.ProtocolErrorf("charset: client sent ACCEPT for invalid charset %s", charSet)
 	}
 
 	o.bestRemoteEncoding = charSet
@@ -312,11 +450,15 @@ func (o *CHARSET) subnegotiateACCEPTED(subnegotiation []byte) error {
 	return nil
 }
 
-func (o *CHARSET) Subnegotiate(subnegotiation []byte) error {
+func (o *CHARSET) ValidateSubnegotiation(subnegotiation []byte) error {
 	if len(subnegotiation) == 0 {
-		return errors.New("charset: received empty subnegotiation")
+		return telnet.ProtocolErrorf("charset: received empty subnegotiation")
 	}
 
+	return nil
+}
+
+func (o *CHARSET) Subnegotiate(subnegotiation []byte) error {
 	if subnegotiation[0] == charsetREQUEST {
 		return o.subnegotiateREQUEST(subnegotiation)
 	}
@@ -0,0 +1,765 @@
+package telopts
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/moodclient/telnet"
+)
+
+const encrypt telnet.TelOptCode = 38
+
+const (
+	encryptIS byte = iota
+	encryptSUPPORT
+	encryptREPLY
+	encryptSTART
+	encryptEND
+	encryptREQUESTSTART
+	encryptREQUESTEND
+	encryptENCKEYID
+	encryptDECKEYID
+)
+
+const (
+	encryptFB64IV byte = iota + 1
+	encryptFB64IVOK
+	encryptFB64IVBAD
+)
+
+// encryptNULL is the encryption type sent in IS when there are no types in common
+const encryptNULL byte = 0
+
+// ENCRYPTType describes an encryption type that can be negotiated with ENCRYPT. Types exchange
+// an initial vector with the FB64_IV suboption of RFC 2952, and use a stream cipher built from
+// that vector and a key provided by ENCRYPTConfig.KeySource.
+type ENCRYPTType struct {
+	// Code is the type's code from the telnet ENCRYPT type registry
+	Code byte
+	Name string
+	// IVSize is the size of the initial vector, in bytes
+	IVSize int
+	// NewStream creates the stream that encrypts (if encrypt is true) or decrypts data
+	NewStream func(key []byte, iv []byte, encrypt bool) (cipher.Stream, error)
+}
+
+func newCFBStream(block cipher.Block, iv []byte, encrypt bool) cipher.Stream {
+	if encrypt {
+		return cipher.NewCFBEncrypter(block, iv)
+	}
+
+	return cipher.NewCFBDecrypter(block, iv)
+}
+
+// ENCRYPTTypeDESCFB64 is DES in 64-bit cipher feedback mode (RFC 2952). It requires an 8 byte key.
+var ENCRYPTTypeDESCFB64 = ENCRYPTType{
+	Code:   1,
+	Name:   "DES_CFB64",
+	IVSize: des.BlockSize,
+	NewStream: func(key []byte, iv []byte, encrypt bool) (cipher.Stream, error) {
+		block, err := des.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+
+		return newCFBStream(block, iv, encrypt), nil
+	},
+}
+
+// ENCRYPTTypeDES3CFB64 is triple DES in 64-bit cipher feedback mode (RFC 2947). It requires a
+// 24 byte key.
+var ENCRYPTTypeDES3CFB64 = ENCRYPTType{
+	Code:   3,
+	Name:   "DES3_CFB64",
+	IVSize: des.BlockSize,
+	NewStream: func(key []byte, iv []byte, encrypt bool) (cipher.Stream, error) {
+		block, err := des.NewTripleDESCipher(key)
+		if err != nil {
+			return nil, err
+		}
+
+		return newCFBStream(block, iv, encrypt), nil
+	},
+}
+
+// ENCRYPTKeySource provides the key for an encryption type once it has been negotiated. Keys
+// are usually agreed on by an authentication telopt, such as AUTHENTICATION.
+type ENCRYPTKeySource func(t *telnet.Terminal, encryptionType ENCRYPTType) ([]byte, error)
+
+type ENCRYPTConfig struct {
+	// Types is the encryption types this terminal supports, in order of preference
+	Types []ENCRYPTType
+	// KeySource provides keys for negotiated encryption types. It is required.
+	KeySource ENCRYPTKeySource
+	// AutoStart begins encrypting output as soon as an encryption type has been negotiated.
+	// Otherwise, encryption begins when StartEncrypting is called or the remote sends REQUEST-START.
+	AutoStart bool
+}
+
+// ENCRYPTStateChangedEvent is raised when encryption starts or ends in either direction
+type ENCRYPTStateChangedEvent struct {
+	BaseTelOptEvent
+	// Side is TelOptSideLocal if this terminal's output is affected, or TelOptSideRemote
+	// if the remote's output is affected
+	Side      telnet.TelOptSide
+	Encrypted bool
+	TypeName  string
+}
+
+func (e ENCRYPTStateChangedEvent) String() string {
+	if !e.Encrypted {
+		return fmt.Sprintf("ENCRYPT- %s output no longer encrypted", e.Side)
+	}
+
+	return fmt.Sprintf("ENCRYPT- %s output encrypted with %s", e.Side, e.TypeName)
+}
+
+// RegisterENCRYPT implements RFC 2946, which allows each side of the connection to encrypt the
+// data it sends. When ENCRYPT is active locally, this terminal can encrypt its output: the remote
+// lists the types it supports, this terminal chooses one and sends an initial vector, and once the
+// remote accepts it, StartEncrypting wraps the keyboard's writer with the cipher. When ENCRYPT is
+// active on the remote, the same happens in reverse, and the printer's reader is wrapped with the
+// cipher when the remote sends START. Encryption keys are provided by ENCRYPTConfig.KeySource.
+//
+// Only a single key ID (0) is used- ENC_KEYID requests from the remote are accepted as-is.
+func RegisterENCRYPT(usage telnet.TelOptUsage, config ENCRYPTConfig) telnet.TelnetOption {
+	return &ENCRYPT{
+		BaseTelOpt: NewBaseTelOpt(encrypt, "ENCRYPT", usage),
+		config:     config,
+	}
+}
+
+// encryptDirection holds the negotiated encryption for one direction of the connection
+type encryptDirection struct {
+	encryptionType *ENCRYPTType
+	key            []byte
+	iv             []byte
+	// ready indicates that the remote has accepted the initial vector
+	ready  bool
+	active bool
+}
+
+type ENCRYPT struct {
+	BaseTelOpt
+
+	config ENCRYPTConfig
+
+	lock        sync.Mutex
+	local       encryptDirection
+	remote      encryptDirection
+	startWanted bool
+}
+
+func (o *ENCRYPT) findType(code byte) *ENCRYPTType {
+	for index := range o.config.Types {
+		if o.config.Types[index].Code == code {
+			return &o.config.Types[index]
+		}
+	}
+
+	return nil
+}
+
+func (o *ENCRYPT) writeSubnegotiation(subnegotiation []byte, postSend func() error) {
+	o.Terminal().Keyboard().WriteCommand(telnet.Command{
+		OpCode:         telnet.SB,
+		Option:         encrypt,
+		Subnegotiation: subnegotiation,
+	}, postSend)
+}
+
+func (o *ENCRYPT) TransitionLocalState(newState telnet.TelOptState) (func() error, error) {
+	postSend, err := o.BaseTelOpt.TransitionLocalState(newState)
+	if err != nil {
+		return postSend, err
+	}
+
+	if newState != telnet.TelOptInactive {
+		return postSend, nil
+	}
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	wasActive := o.local.active
+	o.local = encryptDirection{}
+	o.startWanted = false
+
+	if !wasActive {
+		return postSend, nil
+	}
+
+	// The WONT is the last thing encrypted
+	return func() error {
+		o.raiseStateChanged(telnet.TelOptSideLocal, false, "")
+		return o.Terminal().Keyboard().WrapWriter(func(writer io.Writer) (io.Writer, error) {
+			return writer, nil
+		})
+	}, nil
+}
+
+func (o *ENCRYPT) TransitionRemoteState(newState telnet.TelOptState) (func() error, error) {
+	postSend, err := o.BaseTelOpt.TransitionRemoteState(newState)
+	if err != nil {
+		return postSend, err
+	}
+
+	if newState == telnet.TelOptActive {
+		// Tell the remote which types we can decrypt
+		support := []byte{encryptSUPPORT}
+		for _, encryptionType := range o.config.Types {
+			support = append(support, encryptionType.Code)
+		}
+
+		o.writeSubnegotiation(support, nil)
+	} else if newState == telnet.TelOptInactive {
+		o.lock.Lock()
+		wasActive := o.remote.active
+		o.remote = encryptDirection{}
+		o.lock.Unlock()
+
+		// The decrypting reader stops decrypting when it sees WONT ENCRYPT
+		if wasActive {
+			o.raiseStateChanged(telnet.TelOptSideRemote, false, "")
+		}
+	}
+
+	return postSend, nil
+}
+
+func (o *ENCRYPT) raiseStateChanged(side telnet.TelOptSide, encrypted bool, typeName string) {
+	o.Terminal().RaiseTelOptEvent(ENCRYPTStateChangedEvent{
+		BaseTelOptEvent: BaseTelOptEvent{o},
+		Side:            side,
+		Encrypted:       encrypted,
+		TypeName:        typeName,
+	})
+}
+
+// Encrypting returns true if this terminal's output is currently being encrypted
+func (o *ENCRYPT) Encrypting() bool {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	return o.local.active
+}
+
+// Decrypting returns true if the remote's output is currently being decrypted
+func (o *ENCRYPT) Decrypting() bool {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	return o.remote.active
+}
+
+// StartEncrypting begins encrypting this terminal's output. If an encryption type hasn't been
+// negotiated yet, encryption will begin as soon as it has been. An error is returned if ENCRYPT
+// isn't active locally.
+func (o *ENCRYPT) StartEncrypting() error {
+	if o.LocalState() != telnet.TelOptActive {
+		return errors.New("encrypt: cannot start encrypting while ENCRYPT is inactive")
+	}
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	return o.startEncrypting()
+}
+
+// startEncrypting must be called while holding the lock
+func (o *ENCRYPT) startEncrypting() error {
+	if o.local.active {
+		return nil
+	}
+
+	if !o.local.ready {
+		o.startWanted = true
+		return nil
+	}
+
+	stream, err := o.local.encryptionType.NewStream(o.local.key, o.local.iv, true)
+	if err != nil {
+		return fmt.Errorf("encrypt: could not start %s: %w", o.local.encryptionType.Name, err)
+	}
+
+	o.local.active = true
+	o.startWanted = false
+	typeName := o.local.encryptionType.Name
+
+	// Everything after START is encrypted
+	o.writeSubnegotiation([]byte{encryptSTART, 0}, func() error {
+		err := o.Terminal().Keyboard().WrapWriter(func(writer io.Writer) (io.Writer, error) {
+			return &encryptingWriter{writer: writer, stream: stream}, nil
+		})
+		if err != nil {
+			return err
+		}
+
+		o.raiseStateChanged(telnet.TelOptSideLocal, true, typeName)
+		return nil
+	})
+
+	return nil
+}
+
+// StopEncrypting stops encrypting this terminal's output
+func (o *ENCRYPT) StopEncrypting() {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	o.startWanted = false
+	if !o.local.active {
+		return
+	}
+
+	o.local.active = false
+
+	// END is the last thing encrypted
+	o.writeSubnegotiation([]byte{encryptEND}, func() error {
+		err := o.Terminal().Keyboard().WrapWriter(func(writer io.Writer) (io.Writer, error) {
+			return writer, nil
+		})
+		if err != nil {
+			return err
+		}
+
+		o.raiseStateChanged(telnet.TelOptSideLocal, false, "")
+		return nil
+	})
+}
+
+// RequestStart asks the remote to begin encrypting its output. It returns false if ENCRYPT isn't
+// active on the remote.
+func (o *ENCRYPT) RequestStart() bool {
+	if o.RemoteState() != telnet.TelOptActive {
+		return false
+	}
+
+	o.writeSubnegotiation([]byte{encryptREQUESTSTART, 0}, nil)
+	return true
+}
+
+// RequestEnd asks the remote to stop encrypting its output. It returns false if ENCRYPT isn't
+// active on the remote.
+func (o *ENCRYPT) RequestEnd() bool {
+	if o.RemoteState() != telnet.TelOptActive {
+		return false
+	}
+
+	o.writeSubnegotiation([]byte{encryptREQUESTEND}, nil)
+	return true
+}
+
+// subnegotiateSUPPORT chooses an encryption type for our output from the types the remote supports
+func (o *ENCRYPT) subnegotiateSUPPORT(codes []byte) error {
+	var chosen *ENCRYPTType
+	for index := range o.config.Types {
+		if bytes.IndexByte(codes, o.config.Types[index].Code) >= 0 {
+			chosen = &o.config.Types[index]
+			break
+		}
+	}
+
+	if chosen == nil {
+		o.writeSubnegotiation([]byte{encryptIS, encryptNULL}, nil)
+		return nil
+	}
+
+	if o.config.KeySource == nil {
+		return errors.New("encrypt: no key source was configured")
+	}
+
+	key, err := o.config.KeySource(o.Terminal(), *chosen)
+	if err != nil {
+		o.writeSubnegotiation([]byte{encryptIS, encryptNULL}, nil)
+		return fmt.Errorf("encrypt: could not get key for %s: %w", chosen.Name, err)
+	}
+
+	iv := make([]byte, chosen.IVSize)
+	_, err = rand.Read(iv)
+	if err != nil {
+		return err
+	}
+
+	o.lock.Lock()
+	o.local = encryptDirection{
+		encryptionType: chosen,
+		key:            key,
+		iv:             iv,
+	}
+	o.lock.Unlock()
+
+	is := append([]byte{encryptIS, chosen.Code, encryptFB64IV}, iv...)
+	o.writeSubnegotiation(is, nil)
+	return nil
+}
+
+// subnegotiateIS prepares to decrypt the remote's output with the type and initial vector it chose
+func (o *ENCRYPT) subnegotiateIS(data []byte) error {
+	if len(data) < 1 || data[0] == encryptNULL {
+		// No types in common
+		return nil
+	}
+
+	encryptionType := o.findType(data[0])
+	if encryptionType == nil {
+		return telnet.ProtocolErrorf("encrypt: remote chose unsupported type %d", data[0])
+	}
+
+	reject := []byte{encryptREPLY, encryptionType.Code, encryptFB64IVBAD}
+	if len(data) != 2+encryptionType.IVSize || data[1] != encryptFB64IV {
+		o.writeSubnegotiation(reject, nil)
+		return telnet.ProtocolErrorf("encrypt: remote sent malformed IS for %s", encryptionType.Name)
+	}
+
+	if o.config.KeySource == nil {
+		o.writeSubnegotiation(reject, nil)
+		return errors.New("encrypt: no key source was configured")
+	}
+
+	key, err := o.config.KeySource(o.Terminal(), *encryptionType)
+	if err != nil {
+		o.writeSubnegotiation(reject, nil)
+		return fmt.Errorf("encrypt: could not get key for %s: %w", encryptionType.Name, err)
+	}
+
+	o.lock.Lock()
+	o.remote = encryptDirection{
+		encryptionType: encryptionType,
+		key:            key,
+		iv:             bytes.Clone(data[2:]),
+		ready:          true,
+	}
+	o.lock.Unlock()
+
+	o.writeSubnegotiation([]byte{encryptREPLY, encryptionType.Code, encryptFB64IVOK}, nil)
+	return nil
+}
+
+// subnegotiateREPLY finds out whether the remote accepted the initial vector for our output
+func (o *ENCRYPT) subnegotiateREPLY(data []byte) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if o.local.encryptionType == nil || o.local.encryptionType.Code != data[0] {
+		return nil
+	}
+
+	if data[1] != encryptFB64IVOK {
+		o.local = encryptDirection{}
+		return nil
+	}
+
+	o.local.ready = true
+	if o.config.AutoStart || o.startWanted {
+		return o.startEncrypting()
+	}
+
+	return nil
+}
+
+// subnegotiateSTART begins decrypting the remote's output
+func (o *ENCRYPT) subnegotiateSTART() error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if !o.remote.ready {
+		return telnet.ProtocolErrorf("encrypt: remote sent START before an encryption type was negotiated")
+	}
+
+	if o.remote.active {
+		return nil
+	}
+
+	stream, err := o.remote.encryptionType.NewStream(o.remote.key, o.remote.iv, false)
+	if err != nil {
+		return err
+	}
+
+	// Subnegotiations are processed by the printer before it reads anything else, so everything
+	// after START goes through the decrypting reader
+	err = o.Terminal().Printer().WrapReader(func(reader io.Reader) (io.Reader, error) {
+		return &decryptingReader{reader: reader, stream: stream, decrypting: true}, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	o.remote.active = true
+	o.raiseStateChanged(telnet.TelOptSideRemote, true, o.remote.encryptionType.Name)
+	return nil
+}
+
+func (o *ENCRYPT) ValidateSubnegotiation(subnegotiation []byte) error {
+	if len(subnegotiation) == 0 {
+		return telnet.ProtocolErrorf("encrypt: received empty subnegotiation")
+	}
+
+	if subnegotiation[0] == encryptREPLY && len(subnegotiation) < 3 {
+		return telnet.ProtocolErrorf("encrypt: received truncated REPLY")
+	}
+
+	return nil
+}
+
+func (o *ENCRYPT) Subnegotiate(subnegotiation []byte) error {
+	data := subnegotiation[1:]
+	localActive := o.LocalState() == telnet.TelOptActive
+	remoteActive := o.RemoteState() == telnet.TelOptActive
+
+	switch subnegotiation[0] {
+	case encryptSUPPORT:
+		if localActive {
+			return o.subnegotiateSUPPORT(data)
+		}
+	case encryptIS:
+		if remoteActive {
+			return o.subnegotiateIS(data)
+		}
+	case encryptREPLY:
+		if localActive {
+			return o.subnegotiateREPLY(data)
+		}
+	case encryptSTART:
+		if remoteActive {
+			return o.subnegotiateSTART()
+		}
+	case encryptEND:
+		// The decrypting reader has already stopped decrypting
+		o.lock.Lock()
+		wasActive := o.remote.active
+		o.remote.active = false
+		o.lock.Unlock()
+
+		if wasActive {
+			o.raiseStateChanged(telnet.TelOptSideRemote, false, "")
+		}
+	case encryptREQUESTSTART:
+		if localActive {
+			o.lock.Lock()
+			defer o.lock.Unlock()
+			return o.startEncrypting()
+		}
+	case encryptREQUESTEND:
+		if localActive {
+			o.StopEncrypting()
+		}
+	case encryptENCKEYID:
+		if remoteActive {
+			o.writeSubnegotiation(append([]byte{encryptDECKEYID}, data...), nil)
+		}
+	case encryptDECKEYID:
+		// We only use the default key ID
+	default:
+		return o.BaseTelOpt.Subnegotiate(subnegotiation)
+	}
+
+	return nil
+}
+
+func (o *ENCRYPT) typeName(code byte) string {
+	encryptionType := o.findType(code)
+	if encryptionType != nil {
+		return encryptionType.Name
+	}
+
+	if code == encryptNULL {
+		return "NULL"
+	}
+
+	return fmt.Sprintf("%d", code)
+}
+
+func (o *ENCRYPT) SubnegotiationString(subnegotiation []byte) (string, error) {
+	if len(subnegotiation) == 0 {
+		return "", fmt.Errorf("encrypt: received empty subnegotiation")
+	}
+
+	data := subnegotiation[1:]
+	switch subnegotiation[0] {
+	case encryptSUPPORT:
+		var sb bytes.Buffer
+		sb.WriteString("SUPPORT")
+		for _, code := range data {
+			sb.WriteString(" ")
+			sb.WriteString(o.typeName(code))
+		}
+		return sb.String(), nil
+	case encryptIS:
+		if len(data) == 0 {
+			return "IS", nil
+		}
+		return fmt.Sprintf("IS %s %+v", o.typeName(data[0]), data[1:]), nil
+	case encryptREPLY:
+		if len(data) == 0 {
+			return "REPLY", nil
+		}
+		return fmt.Sprintf("REPLY %s %+v", o.typeName(data[0]), data[1:]), nil
+	case encryptSTART:
+		return fmt.Sprintf("START %+v", data), nil
+	case encryptEND:
+		return "END", nil
+	case encryptREQUESTSTART:
+		return fmt.Sprintf("REQUEST-START %+v", data), nil
+	case encryptREQUESTEND:
+		return "REQUEST-END", nil
+	case encryptENCKEYID:
+		return fmt.Sprintf("ENC_KEYID %+v", data), nil
+	case encryptDECKEYID:
+		return fmt.Sprintf("DEC_KEYID %+v", data), nil
+	}
+
+	return o.BaseTelOpt.SubnegotiationString(subnegotiation)
+}
+
+// encryptingWriter encrypts everything written to it
+type encryptingWriter struct {
+	writer io.Writer
+	stream cipher.Stream
+}
+
+func (w *encryptingWriter) Write(p []byte) (int, error) {
+	encrypted := make([]byte, len(p))
+	w.stream.XORKeyStream(encrypted, p)
+
+	// The keystream has moved past this data, so it has to be written in full here rather than
+	// letting the keyboard retry with the plaintext
+	var written int
+	for written < len(encrypted) {
+		n, err := w.writer.Write(encrypted[written:])
+		written += n
+
+		var netError net.Error
+		if errors.As(err, &netError) && netError.Timeout() {
+			continue
+		}
+
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+// The sequences in the decrypted stream after which the remote's data is no longer encrypted
+var encryptionEndMarkers = [][]byte{
+	{telnet.IAC, telnet.SB, byte(encrypt), encryptEND, telnet.IAC, telnet.SE},
+	{telnet.IAC, telnet.WONT, byte(encrypt)},
+}
+
+// decryptingReader decrypts the remote's data until it finds the end of encryption in the
+// decrypted data, and then passes the rest of the remote's data through untouched. Doing this
+// in the reader, rather than when the printer processes END, means that data read past END
+// doesn't need to be recovered from the printer's buffer.
+type decryptingReader struct {
+	reader     io.Reader
+	stream     cipher.Stream
+	decrypting bool
+
+	// held is decrypted data at the end of the last read that might be the beginning of an end
+	// marker. It is returned once the next read shows whether it is.
+	held []byte
+	err  error
+}
+
+// findEncryptionEnd returns the index just past the end marker in data, if there is one. If data
+// ends with what might be the beginning of a marker, it returns the index where it begins.
+func findEncryptionEnd(data []byte) (end int, partial int) {
+	for index := 0; index < len(data); index++ {
+		if data[index] != telnet.IAC {
+			continue
+		}
+
+		if index+1 < len(data) && data[index+1] == telnet.IAC {
+			// Escaped IAC
+			index++
+			continue
+		}
+
+		rest := data[index:]
+		for _, marker := range encryptionEndMarkers {
+			if bytes.HasPrefix(rest, marker) {
+				return index + len(marker), -1
+			}
+
+			if len(rest) < len(marker) && bytes.HasPrefix(marker, rest) {
+				return -1, index
+			}
+		}
+	}
+
+	return -1, -1
+}
+
+func (r *decryptingReader) Read(p []byte) (int, error) {
+	if !r.decrypting {
+		if len(r.held) > 0 {
+			n := copy(p, r.held)
+			r.held = r.held[n:]
+			return n, nil
+		}
+
+		return r.reader.Read(p)
+	}
+
+	for {
+		if r.err != nil {
+			// Nothing more is coming, so held data can't be part of a marker
+			n := copy(p, r.held)
+			r.held = r.held[n:]
+			if n > 0 {
+				return n, nil
+			}
+
+			return 0, r.err
+		}
+
+		// Leave room to return held data along with the new data
+		readSize := len(p) - len(r.held)
+		if readSize <= 0 {
+			n := copy(p, r.held)
+			r.held = r.held[n:]
+			return n, nil
+		}
+
+		n, err := r.reader.Read(p[len(r.held):][:readSize])
+		r.err = err
+		if n == 0 {
+			continue
+		}
+
+		raw := p[len(r.held) : len(r.held)+n]
+		decrypted := make([]byte, len(r.held)+n)
+		copy(decrypted, r.held)
+		r.stream.XORKeyStream(decrypted[len(r.held):], raw)
+		heldLen := len(r.held)
+		r.held = nil
+
+		end, partial := findEncryptionEnd(decrypted)
+		if end >= 0 {
+			// Everything after the marker was never encrypted, so the raw bytes are what we want
+			r.decrypting = false
+			copy(p[heldLen:], raw)
+			copy(p, decrypted[:end])
+			copy(p[end:], raw[end-heldLen:])
+			return len(decrypted), nil
+		}
+
+		if partial >= 0 {
+			r.held = bytes.Clone(decrypted[partial:])
+			decrypted = decrypted[:partial]
+		}
+
+		if len(decrypted) > 0 {
+			return copy(p, decrypted), nil
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package telopts
+
+import (
+	"github.com/moodclient/telnet"
+)
+
+const environ telnet.TelOptCode = 36
+
+type ENVIRONConfig struct {
+	NEWENVIRONConfig
+
+	// Shared, if not nil, should be the telopt returned by RegisterNEWENVIRON. ENVIRON will use its
+	// variable store instead of creating its own from NEWENVIRONConfig, so variables set on either
+	// telopt are sent by both, and variables received by either can be read from both.
+	Shared telnet.TelnetOption
+	// SwapVarValue swaps the codes for VAR and VALUE. Many implementations that predate RFC 1571
+	// (most famously BSD's) use the codes the wrong way around.
+	SwapVarValue bool
+}
+
+// RegisterENVIRON implements RFC 1408, the predecessor to NEW-ENVIRON, which some old servers ask
+// for instead. It works just like NEW-ENVIRON, and raises NEWENVIRONRemoteVarsChangedEvent when the
+// remote's variables change.
+func RegisterENVIRON(usage telnet.TelOptUsage, config ENVIRONConfig) telnet.TelnetOption {
+	option := &ENVIRON{
+		NEWENVIRON: NEWENVIRON{
+			BaseTelOpt: NewBaseTelOpt(environ, "ENVIRON", usage),
+			varCode:    newenvironVAR,
+			valueCode:  newenvironVALUE,
+		},
+	}
+	option.self = option
+
+	if config.SwapVarValue {
+		option.varCode, option.valueCode = option.valueCode, option.varCode
+	}
+
+	shared, isShared := config.Shared.(*NEWENVIRON)
+	if isShared {
+		option.environVars = shared.environVars
+	} else {
+		option.environVars = newEnvironVars(config.NEWENVIRONConfig)
+	}
+	option.sharing = append(option.sharing, &option.NEWENVIRON)
+
+	return option
+}
+
+type ENVIRON struct {
+	NEWENVIRON
+}
@@ -3,6 +3,7 @@ package telopts
 import (
 	"fmt"
 	"strings"
+	"sync"
 	"sync/atomic"
 
 	"github.com/moodclient/telnet"
@@ -91,19 +92,23 @@ func RegisterLINEMODE(usage telnet.TelOptUsage, mode LineModeFlags) telnet.Telne
 }
 
 // LINEMODE allows linemode to be negotiated- this is used by some BBS's but we
-// are not going to support most features provided by the telopt.  We'll just support
-// MODE EDIT and that's it.  RFC LINEMODE also has a system
-// for defining characters to trigger telnet functions, and FORWARDMASK, which allows
-// the remote to demand we instantly send them our line-in-progress. We will
-// accept the functions but never use them, and we will reject all attempts to
-// establish FORWARDMASK.  We will also reject attempts at MODE SOFT_TAB and
-// MODE LIT_ECHO.  We will accept MODE TRAPSIG, as that is required by the
-// RFC, but we won't do anything about it since we don't allow the client
+// are not going to support most features provided by the telopt.  We support MODE EDIT,
+// and SLC, the system for agreeing on which characters trigger telnet functions such as
+// erasing a character or interrupting a process. The agreed characters are available with
+// SLC and SLCTable, and SLCChangedEvent is raised when the remote changes them. RFC LINEMODE
+// also has FORWARDMASK, which allows the remote to demand we instantly send them our
+// line-in-progress. We will reject all attempts to establish FORWARDMASK.  We will also
+// reject attempts at MODE SOFT_TAB and MODE LIT_ECHO.  We will accept MODE TRAPSIG, as that
+// is required by the RFC, but we won't do anything about it since we don't allow the client
 // to send any of the TRAPSIG signals on demand anyway.
 type LINEMODE struct {
 	BaseTelOpt
 
 	mode atomic.Int64
+
+	slcLock        sync.Mutex
+	slcTable       [slcFunctionCount]SLCEntry
+	slcInitialized bool
 }
 
 func (m *LINEMODE) writeModeCommand(mode LineModeFlags) {
@@ -115,11 +120,32 @@ func (m *LINEMODE) writeModeCommand(mode LineModeFlags) {
 	m.Terminal().Keyboard().WriteCommand(command, nil)
 }
 
+func (m *LINEMODE) TransitionLocalState(newState telnet.TelOptState) (func() error, error) {
+	postSend, err := m.BaseTelOpt.TransitionLocalState(newState)
+	if err != nil {
+		return postSend, err
+	}
+
+	if newState == telnet.TelOptInactive {
+		m.resetSLC()
+	} else if newState == telnet.TelOptActive {
+		// The client tells the server which characters it uses once LINEMODE is on
+		return func() error {
+			m.sendSLCTable()
+			return nil
+		}, nil
+	}
+
+	return postSend, nil
+}
+
 func (m *LINEMODE) TransitionRemoteState(newState telnet.TelOptState) (func() error, error) {
 	if newState == telnet.TelOptActive {
 		// We need to send the MODE request immediately after the client confirms their
 		// state
 		m.writeModeCommand(m.Mode())
+	} else if newState == telnet.TelOptInactive {
+		m.resetSLC()
 	}
 
 	return m.BaseTelOpt.TransitionRemoteState(newState)
@@ -190,18 +216,22 @@ func (m *LINEMODE) subnegotiateMODE(subnegotiation []byte) error {
 	return nil
 }
 
-func (m *LINEMODE) Subnegotiate(subnegotiation []byte) error {
+func (m *LINEMODE) ValidateSubnegotiation(subnegotiation []byte) error {
 	if len(subnegotiation) == 0 {
-		return fmt.Errorf("linemode: received empty subnegotiation")
+		return telnet.ProtocolErrorf("linemode: received empty subnegotiation")
 	}
 
-	if subnegotiation[0] == linemodeSLC {
-		// Don't do anything with SLC
-		return nil
+	if subnegotiation[0] != linemodeSLC && len(subnegotiation) < 2 {
+		return telnet.ProtocolErrorf("linemode: unexpected subnegotiation: %+v", subnegotiation)
 	}
 
-	if len(subnegotiation) < 2 {
-		return fmt.Errorf("linemode: unexpected subnegotiation: %+v", subnegotiation)
+	return nil
+}
+
+func (m *LINEMODE) Subnegotiate(subnegotiation []byte) error {
+	if subnegotiation[0] == linemodeSLC {
+		m.subnegotiateSLC(subnegotiation[1:])
+		return nil
 	}
 
 	if subnegotiation[0] == linemodeMODE {
@@ -246,7 +276,7 @@ func (m *LINEMODE) SubnegotiationString(subnegotiation []byte) (string, error) {
 
 	if subnegotiation[0] == linemodeSLC {
 		sb.WriteString("SLC ")
-		sb.WriteString(fmt.Sprintf("%+v", subnegotiation[1:]))
+		sb.WriteString(slcString(subnegotiation[1:]))
 		return sb.String(), nil
 	}
 
@@ -281,10 +311,371 @@ func (m *LINEMODE) Mode() LineModeFlags {
 	return LineModeFlags(m.mode.Load())
 }
 
+// SetMode changes the mode flags. If LINEMODE is active on the remote, which means that this is
+// the server, the new mode is sent to the client. Otherwise, it is sent when LINEMODE activates.
 func (m *LINEMODE) SetMode(mode LineModeFlags) {
 	mode &= supportedModes
 
 	if mode != m.Mode() {
 		m.updateMode(mode)
+
+		if m.RemoteState() == telnet.TelOptActive {
+			m.writeModeCommand(mode)
+		}
+	}
+}
+
+// SLCFunction is one of the functions whose characters are negotiated with LINEMODE's SLC
+// (Set Local Characters) subnegotiation
+type SLCFunction byte
+
+const (
+	SLCSynch SLCFunction = iota + 1
+	SLCBreak
+	SLCInterrupt
+	SLCAbortOutput
+	SLCAreYouThere
+	SLCEndOfRecord
+	SLCAbort
+	SLCEndOfFile
+	SLCSuspend
+	SLCEraseCharacter
+	SLCEraseLine
+	SLCEraseWord
+	SLCReprint
+	SLCLiteralNext
+	SLCXOn
+	SLCXOff
+	SLCForward1
+	SLCForward2
+)
+
+const slcFunctionCount = int(SLCForward2) + 1
+
+var slcFunctionNames = [slcFunctionCount]string{
+	"", "SYNCH", "BRK", "IP", "AO", "AYT", "EOR", "ABORT", "EOF", "SUSP",
+	"EC", "EL", "EW", "RP", "LNEXT", "XON", "XOFF", "FORW1", "FORW2",
+}
+
+func (f SLCFunction) String() string {
+	if f > 0 && int(f) < slcFunctionCount {
+		return slcFunctionNames[f]
+	}
+
+	return fmt.Sprintf("SLC(%d)", byte(f))
+}
+
+// SLCLevel indicates how an SLC function is supported
+type SLCLevel byte
+
+const (
+	// SLCNoSupport indicates that the function is not supported
+	SLCNoSupport SLCLevel = iota
+	// SLCCantChange indicates that the function is supported, but its character can't be changed
+	SLCCantChange
+	// SLCValue indicates that the function is supported with the provided character
+	SLCValue
+	// SLCDefault asks for the function to use the other side's default character
+	SLCDefault
+)
+
+func (l SLCLevel) String() string {
+	switch l {
+	case SLCNoSupport:
+		return "NOSUPPORT"
+	case SLCCantChange:
+		return "CANTCHANGE"
+	case SLCValue:
+		return "VALUE"
+	case SLCDefault:
+		return "DEFAULT"
+	default:
+		return fmt.Sprintf("SLCLevel(%d)", byte(l))
+	}
+}
+
+const (
+	slcLevelBits byte = 0x03
+	slcFlushOut  byte = 0x20
+	slcFlushIn   byte = 0x40
+	slcAck       byte = 0x80
+)
+
+// SLCEntry is the character that has been agreed on for an SLC function
+type SLCEntry struct {
+	Function SLCFunction
+	Level    SLCLevel
+	// Value is the character that triggers the function
+	Value byte
+	// FlushIn and FlushOut indicate that input or output should be flushed when the function
+	// is triggered
+	FlushIn  bool
+	FlushOut bool
+}
+
+func (e SLCEntry) String() string {
+	if e.Level == SLCNoSupport {
+		return fmt.Sprintf("%s NOSUPPORT", e.Function)
+	}
+
+	return fmt.Sprintf("%s %s %#02x", e.Function, e.Level, e.Value)
+}
+
+func (e SLCEntry) modifier() byte {
+	modifier := byte(e.Level) & slcLevelBits
+	if e.FlushIn {
+		modifier |= slcFlushIn
+	}
+	if e.FlushOut {
+		modifier |= slcFlushOut
+	}
+
+	return modifier
+}
+
+// defaultSLCTable holds the characters that are used until the remote asks for different ones,
+// which are the usual characters for a unix terminal
+var defaultSLCTable = [slcFunctionCount]SLCEntry{
+	SLCInterrupt:      {Level: SLCValue, Value: 0x03, FlushIn: true, FlushOut: true},
+	SLCAbortOutput:    {Level: SLCValue, Value: 0x0f, FlushOut: true},
+	SLCAbort:          {Level: SLCValue, Value: 0x1c, FlushIn: true, FlushOut: true},
+	SLCEndOfFile:      {Level: SLCValue, Value: 0x04},
+	SLCSuspend:        {Level: SLCValue, Value: 0x1a, FlushIn: true},
+	SLCEraseCharacter: {Level: SLCValue, Value: 0x7f},
+	SLCEraseLine:      {Level: SLCValue, Value: 0x15},
+	SLCEraseWord:      {Level: SLCValue, Value: 0x17},
+	SLCReprint:        {Level: SLCValue, Value: 0x12},
+	SLCLiteralNext:    {Level: SLCValue, Value: 0x16},
+	SLCXOn:            {Level: SLCValue, Value: 0x11},
+	SLCXOff:           {Level: SLCValue, Value: 0x13},
+}
+
+func defaultSLCEntry(function SLCFunction) SLCEntry {
+	entry := defaultSLCTable[function]
+	entry.Function = function
+	return entry
+}
+
+// SLCChangedEvent is raised when the characters for one or more SLC functions have been changed
+// by the remote
+type SLCChangedEvent struct {
+	BaseTelOptEvent
+	Changes []SLCEntry
+}
+
+func (e SLCChangedEvent) String() string {
+	changes := make([]string, 0, len(e.Changes))
+	for _, change := range e.Changes {
+		changes = append(changes, change.String())
+	}
+
+	return "LINEMODE SLC changed: " + strings.Join(changes, ", ")
+}
+
+// SLC returns the agreed character for an SLC function. ok is false if the function isn't
+// supported.
+func (m *LINEMODE) SLC(function SLCFunction) (entry SLCEntry, ok bool) {
+	if function == 0 || int(function) >= slcFunctionCount {
+		return SLCEntry{Function: function}, false
+	}
+
+	m.slcLock.Lock()
+	defer m.slcLock.Unlock()
+
+	m.initSLC()
+	entry = m.slcTable[function]
+	return entry, entry.Level != SLCNoSupport
+}
+
+// SLCTable returns the agreed characters for every supported SLC function
+func (m *LINEMODE) SLCTable() []SLCEntry {
+	m.slcLock.Lock()
+	defer m.slcLock.Unlock()
+
+	m.initSLC()
+	table := make([]SLCEntry, 0, slcFunctionCount)
+	for _, entry := range m.slcTable[1:] {
+		if entry.Level != SLCNoSupport {
+			table = append(table, entry)
+		}
+	}
+
+	return table
+}
+
+// resetSLC returns the table to the defaults when LINEMODE turns off
+func (m *LINEMODE) resetSLC() {
+	m.slcLock.Lock()
+	defer m.slcLock.Unlock()
+
+	m.slcInitialized = false
+}
+
+// initSLC fills the table with the defaults the first time it is used. It must be called while
+// holding slcLock.
+func (m *LINEMODE) initSLC() {
+	if m.slcInitialized {
+		return
 	}
+
+	m.slcInitialized = true
+	for function := 1; function < slcFunctionCount; function++ {
+		m.slcTable[function] = defaultSLCEntry(SLCFunction(function))
+	}
+}
+
+func (m *LINEMODE) writeSLC(entries []SLCEntry, ack bool) {
+	if len(entries) == 0 {
+		return
+	}
+
+	subnegotiation := make([]byte, 0, 1+3*len(entries))
+	subnegotiation = append(subnegotiation, linemodeSLC)
+	for _, entry := range entries {
+		modifier := entry.modifier()
+		if ack {
+			modifier |= slcAck
+		}
+		subnegotiation = append(subnegotiation, byte(entry.Function), modifier, entry.Value)
+	}
+
+	m.Terminal().Keyboard().WriteCommand(telnet.Command{
+		OpCode:         telnet.SB,
+		Option:         linemode,
+		Subnegotiation: subnegotiation,
+	}, nil)
+}
+
+// sendSLCTable sends every SLC function to the remote
+func (m *LINEMODE) sendSLCTable() {
+	m.slcLock.Lock()
+	m.initSLC()
+	table := append([]SLCEntry(nil), m.slcTable[1:]...)
+	m.slcLock.Unlock()
+
+	m.writeSLC(table, false)
+}
+
+// subnegotiateSLC processes the remote's SLC triplets, following RFC 1184: changes we can make are
+// acknowledged, requests for defaults are answered with our defaults, and requests that we can't
+// honor are answered with what we have
+func (m *LINEMODE) subnegotiateSLC(triplets []byte) {
+	var acks, replies, changes []SLCEntry
+	var sendDefaults, sendCurrent bool
+
+	m.slcLock.Lock()
+	m.initSLC()
+
+	for len(triplets) >= 3 {
+		function := SLCFunction(triplets[0])
+		modifier := triplets[1]
+		value := triplets[2]
+		triplets = triplets[3:]
+
+		level := SLCLevel(modifier & slcLevelBits)
+		received := SLCEntry{
+			Function: function,
+			Level:    level,
+			Value:    value,
+			FlushIn:  modifier&slcFlushIn != 0,
+			FlushOut: modifier&slcFlushOut != 0,
+		}
+
+		if function == 0 {
+			// Function 0 asks for the whole table
+			if level == SLCDefault {
+				sendDefaults = true
+			} else if level == SLCValue {
+				sendCurrent = true
+			}
+			continue
+		}
+
+		if int(function) >= slcFunctionCount {
+			replies = append(replies, SLCEntry{Function: function, Level: SLCNoSupport})
+			continue
+		}
+
+		current := m.slcTable[function]
+		if level == current.Level && value == current.Value {
+			// We already agree
+			continue
+		}
+
+		if modifier&slcAck != 0 {
+			// An acknowledgement that doesn't match what we have is stale
+			continue
+		}
+
+		switch {
+		case level == SLCDefault:
+			entry := defaultSLCEntry(function)
+			if entry != current {
+				m.slcTable[function] = entry
+				changes = append(changes, entry)
+			}
+			replies = append(replies, entry)
+		case current.Level == SLCCantChange:
+			replies = append(replies, current)
+		case level == SLCNoSupport:
+			received.Value = 0
+			m.slcTable[function] = received
+			changes = append(changes, received)
+			acks = append(acks, received)
+		default:
+			m.slcTable[function] = received
+			changes = append(changes, received)
+			acks = append(acks, received)
+		}
+	}
+
+	m.slcLock.Unlock()
+
+	if sendDefaults {
+		m.slcLock.Lock()
+		for function := 1; function < slcFunctionCount; function++ {
+			entry := defaultSLCEntry(SLCFunction(function))
+			if entry != m.slcTable[function] {
+				m.slcTable[function] = entry
+				changes = append(changes, entry)
+			}
+		}
+		m.slcLock.Unlock()
+	}
+
+	if sendDefaults || sendCurrent {
+		m.sendSLCTable()
+	}
+
+	m.writeSLC(acks, true)
+	m.writeSLC(replies, false)
+
+	if len(changes) > 0 {
+		m.Terminal().RaiseTelOptEvent(SLCChangedEvent{
+			BaseTelOptEvent: BaseTelOptEvent{m},
+			Changes:         changes,
+		})
+	}
+}
+
+func slcString(triplets []byte) string {
+	var sb strings.Builder
+	for len(triplets) >= 3 {
+		if sb.Len() > 0 {
+			sb.WriteString(", ")
+		}
+
+		entry := SLCEntry{
+			Function: SLCFunction(triplets[0]),
+			Level:    SLCLevel(triplets[1] & slcLevelBits),
+			Value:    triplets[2],
+		}
+		sb.WriteString(entry.String())
+		if triplets[1]&slcAck != 0 {
+			sb.WriteString(" ACK")
+		}
+		triplets = triplets[3:]
+	}
+
+	return sb.String()
 }
@@ -0,0 +1,300 @@
+package telopts
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/moodclient/telnet"
+)
+
+// linemodeTestRemote is the server end of a connection to a client terminal that has LINEMODE
+// active. It records the raw bytes the client sends and the SLCChangedEvents it raises.
+type linemodeTestRemote struct {
+	conn     net.Conn
+	linemode *LINEMODE
+	changes  chan []SLCEntry
+
+	lock     sync.Mutex
+	received []byte
+	arrived  chan struct{}
+}
+
+func newLinemodeTestRemote(t *testing.T) *linemodeTestRemote {
+	t.Helper()
+
+	local, remote := net.Pipe()
+	option := RegisterLINEMODE(telnet.TelOptAllowLocal, LineModeEDIT)
+	terminal, err := telnet.NewTerminal(context.Background(), local, telnet.TerminalConfig{
+		DefaultCharsetName: "US-ASCII",
+		Side:               telnet.SideClient,
+		TelOpts:            []telnet.TelnetOption{option},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testRemote := &linemodeTestRemote{
+		conn:     remote,
+		linemode: option.(*LINEMODE),
+		changes:  make(chan []SLCEntry, 10),
+		arrived:  make(chan struct{}, 1),
+	}
+	terminal.RegisterTelOptEventHook(func(_ *telnet.Terminal, event telnet.TelOptEvent) {
+		changed, isChanged := event.(SLCChangedEvent)
+		if isChanged {
+			testRemote.changes <- changed.Changes
+		}
+	})
+
+	t.Cleanup(func() {
+		_ = remote.Close()
+		_ = terminal.CloseWithTimeout(time.Second)
+		_ = terminal.WaitForExit()
+	})
+
+	go func() {
+		buffer := make([]byte, 256)
+		for {
+			n, err := remote.Read(buffer)
+			if n > 0 {
+				testRemote.lock.Lock()
+				testRemote.received = append(testRemote.received, buffer[:n]...)
+				testRemote.lock.Unlock()
+
+				select {
+				case testRemote.arrived <- struct{}{}:
+				default:
+				}
+			}
+
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	// The client sends its whole table as soon as LINEMODE is active
+	testRemote.write(t, []byte{telnet.IAC, telnet.DO, byte(linemode)})
+	testRemote.expect(t, []byte{telnet.IAC, telnet.WILL, byte(linemode)})
+	testRemote.expect(t, slcSubnegotiation(defaultSLCTriplets()...))
+
+	return testRemote
+}
+
+func (r *linemodeTestRemote) write(t *testing.T, data []byte) {
+	t.Helper()
+
+	_, err := r.conn.Write(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// sendSLC sends an SLC subnegotiation with the provided triplets to the client
+func (r *linemodeTestRemote) sendSLC(t *testing.T, triplets ...byte) {
+	t.Helper()
+	r.write(t, slcSubnegotiation(triplets...))
+}
+
+// expect fails the test unless the next bytes received from the client are want
+func (r *linemodeTestRemote) expect(t *testing.T, want []byte) {
+	t.Helper()
+
+	timeout := time.After(2 * time.Second)
+	for {
+		r.lock.Lock()
+		if len(r.received) >= len(want) {
+			got := r.received[:len(want)]
+			r.received = r.received[len(want):]
+			r.lock.Unlock()
+
+			if !bytes.Equal(got, want) {
+				t.Fatalf("expected %q, got %q", want, got)
+			}
+			return
+		}
+		r.lock.Unlock()
+
+		select {
+		case <-r.arrived:
+		case <-timeout:
+			r.lock.Lock()
+			defer r.lock.Unlock()
+			t.Fatalf("expected %q, only received %q", want, r.received)
+		}
+	}
+}
+
+// expectNothing fails the test if the client sends anything or raises an SLCChangedEvent
+func (r *linemodeTestRemote) expectNothing(t *testing.T) {
+	t.Helper()
+
+	time.Sleep(50 * time.Millisecond)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(r.received) > 0 {
+		t.Fatalf("expected nothing, received %q", r.received)
+	}
+
+	select {
+	case changes := <-r.changes:
+		t.Fatalf("expected no SLC changes, got %+v", changes)
+	default:
+	}
+}
+
+// expectChanges fails the test unless the next SLCChangedEvent holds the provided changes
+func (r *linemodeTestRemote) expectChanges(t *testing.T, want ...SLCEntry) {
+	t.Helper()
+
+	select {
+	case changes := <-r.changes:
+		if !reflect.DeepEqual(changes, want) {
+			t.Fatalf("expected changes %+v, got %+v", want, changes)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected changes %+v, received nothing", want)
+	}
+}
+
+func slcSubnegotiation(triplets ...byte) []byte {
+	subnegotiation := []byte{telnet.IAC, telnet.SB, byte(linemode), linemodeSLC}
+	subnegotiation = append(subnegotiation, triplets...)
+	return append(subnegotiation, telnet.IAC, telnet.SE)
+}
+
+// defaultSLCTriplets returns the triplets for the client's whole default table
+func defaultSLCTriplets() []byte {
+	var triplets []byte
+	for function := 1; function < slcFunctionCount; function++ {
+		entry := defaultSLCEntry(SLCFunction(function))
+		triplets = append(triplets, byte(function), entry.modifier(), entry.Value)
+	}
+
+	return triplets
+}
+
+func TestSLCAcknowledgesValues(t *testing.T) {
+	remote := newLinemodeTestRemote(t)
+
+	remote.sendSLC(t,
+		byte(SLCEraseCharacter), byte(SLCValue), 0x08,
+		byte(SLCInterrupt), byte(SLCValue)|slcFlushIn, 0x7f,
+	)
+	remote.expect(t, slcSubnegotiation(
+		byte(SLCEraseCharacter), byte(SLCValue)|slcAck, 0x08,
+		byte(SLCInterrupt), byte(SLCValue)|slcFlushIn|slcAck, 0x7f,
+	))
+	remote.expectChanges(t,
+		SLCEntry{Function: SLCEraseCharacter, Level: SLCValue, Value: 0x08},
+		SLCEntry{Function: SLCInterrupt, Level: SLCValue, Value: 0x7f, FlushIn: true},
+	)
+
+	entry, ok := remote.linemode.SLC(SLCEraseCharacter)
+	if !ok || entry.Value != 0x08 {
+		t.Fatalf("expected EC to be 0x08, got %s", entry)
+	}
+}
+
+func TestSLCIgnoresAcknowledgements(t *testing.T) {
+	remote := newLinemodeTestRemote(t)
+
+	// An acknowledgement of what we have needs no answer, and one that doesn't match is stale
+	remote.sendSLC(t,
+		byte(SLCEraseCharacter), byte(SLCValue)|slcAck, 0x7f,
+		byte(SLCEraseLine), byte(SLCValue)|slcAck, 0x01,
+	)
+	remote.expectNothing(t)
+
+	entry, _ := remote.linemode.SLC(SLCEraseLine)
+	if entry.Value != 0x15 {
+		t.Fatalf("expected EL to be unchanged, got %s", entry)
+	}
+}
+
+func TestSLCAnswersDefaultWithOurDefault(t *testing.T) {
+	remote := newLinemodeTestRemote(t)
+
+	remote.sendSLC(t, byte(SLCEraseCharacter), byte(SLCValue), 0x08)
+	remote.expect(t, slcSubnegotiation(byte(SLCEraseCharacter), byte(SLCValue)|slcAck, 0x08))
+	remote.expectChanges(t, SLCEntry{Function: SLCEraseCharacter, Level: SLCValue, Value: 0x08})
+
+	// Only functions that were not already at their defaults are changed
+	remote.sendSLC(t,
+		byte(SLCEraseCharacter), byte(SLCDefault), 0,
+		byte(SLCEraseLine), byte(SLCDefault), 0,
+	)
+	remote.expect(t, slcSubnegotiation(
+		byte(SLCEraseCharacter), byte(SLCValue), 0x7f,
+		byte(SLCEraseLine), byte(SLCValue), 0x15,
+	))
+	remote.expectChanges(t, SLCEntry{Function: SLCEraseCharacter, Level: SLCValue, Value: 0x7f})
+}
+
+func TestSLCCantChangeIsKept(t *testing.T) {
+	remote := newLinemodeTestRemote(t)
+
+	remote.sendSLC(t, byte(SLCXOn), byte(SLCCantChange), 0x11)
+	remote.expect(t, slcSubnegotiation(byte(SLCXOn), byte(SLCCantChange)|slcAck, 0x11))
+	remote.expectChanges(t, SLCEntry{Function: SLCXOn, Level: SLCCantChange, Value: 0x11})
+
+	// The function can't be changed any more, so the remote is told what it is
+	remote.sendSLC(t, byte(SLCXOn), byte(SLCValue), 0x01)
+	remote.expect(t, slcSubnegotiation(byte(SLCXOn), byte(SLCCantChange), 0x11))
+	remote.expectNothing(t)
+}
+
+func TestSLCNoSupport(t *testing.T) {
+	remote := newLinemodeTestRemote(t)
+
+	remote.sendSLC(t, byte(SLCEraseWord), byte(SLCNoSupport), 0x17)
+	remote.expect(t, slcSubnegotiation(byte(SLCEraseWord), byte(SLCNoSupport)|slcAck, 0))
+	remote.expectChanges(t, SLCEntry{Function: SLCEraseWord, Level: SLCNoSupport})
+
+	_, ok := remote.linemode.SLC(SLCEraseWord)
+	if ok {
+		t.Fatal("expected EW to be unsupported")
+	}
+
+	// Functions we don't know about aren't supported
+	remote.sendSLC(t, 30, byte(SLCValue), 0x01)
+	remote.expect(t, slcSubnegotiation(30, byte(SLCNoSupport), 0))
+	remote.expectNothing(t)
+}
+
+func TestSLCWholeTableRequests(t *testing.T) {
+	remote := newLinemodeTestRemote(t)
+
+	remote.sendSLC(t,
+		byte(SLCEraseCharacter), byte(SLCValue), 0x08,
+		byte(SLCEraseWord), byte(SLCNoSupport), 0,
+	)
+	remote.expect(t, slcSubnegotiation(
+		byte(SLCEraseCharacter), byte(SLCValue)|slcAck, 0x08,
+		byte(SLCEraseWord), byte(SLCNoSupport)|slcAck, 0,
+	))
+	remote.expectChanges(t,
+		SLCEntry{Function: SLCEraseCharacter, Level: SLCValue, Value: 0x08},
+		SLCEntry{Function: SLCEraseWord, Level: SLCNoSupport},
+	)
+
+	// Function 0 with VALUE asks for our current table
+	current := defaultSLCTriplets()
+	current[(int(SLCEraseCharacter)-1)*3+2] = 0x08
+	current[(int(SLCEraseWord)-1)*3+1] = byte(SLCNoSupport)
+	current[(int(SLCEraseWord)-1)*3+2] = 0
+	remote.sendSLC(t, 0, byte(SLCValue), 0)
+	remote.expect(t, slcSubnegotiation(current...))
+	remote.expectNothing(t)
+
+	// Function 0 with DEFAULT puts our defaults back and sends them
+	remote.sendSLC(t, 0, byte(SLCDefault), 0)
+	remote.expect(t, slcSubnegotiation(defaultSLCTriplets()...))
+	remote.expectChanges(t, defaultSLCEntry(SLCEraseCharacter), defaultSLCEntry(SLCEraseWord))
+}
@@ -0,0 +1,231 @@
+package telopts
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/moodclient/telnet"
+)
+
+// MNESVars are the variables defined by the Mud New-Environ Standard. MNES sends them as VARs
+// rather than USERVARs.
+var MNESVars = []string{"CHARSET", "CLIENT_NAME", "CLIENT_VERSION", "MTTS", "TERMINAL_TYPE", "IPADDRESS"}
+
+// MNESInfo holds the values of the MNES variables. Empty fields (and an MTTS of 0) are not sent.
+type MNESInfo struct {
+	ClientName    string
+	ClientVersion string
+	// Charset is only used by RemoteInfo. The local CHARSET variable always holds the name of the
+	// charset the printer is decoding with.
+	Charset      string
+	TerminalType string
+	MTTS         MTTSFlags
+	IPAddress    string
+}
+
+// MNESUpdateFailedEvent is raised when MNES is unable to send the remote an automatic INFO update
+type MNESUpdateFailedEvent struct {
+	BaseTelOptEvent
+	Err error
+}
+
+func (e MNESUpdateFailedEvent) String() string {
+	return fmt.Sprintf("MNES Update Failed: %s", e.Err)
+}
+
+func (i MNESInfo) keysAndValues() []string {
+	var keysAndValues []string
+	add := func(key string, value string) {
+		if value != "" {
+			keysAndValues = append(keysAndValues, key, value)
+		}
+	}
+
+	add("CLIENT_NAME", i.ClientName)
+	add("CLIENT_VERSION", i.ClientVersion)
+	add("TERMINAL_TYPE", i.TerminalType)
+	if i.MTTS != 0 {
+		add("MTTS", strconv.Itoa(int(i.MTTS)))
+	}
+	add("IPADDRESS", i.IPAddress)
+
+	return keysAndValues
+}
+
+// RegisterMNES implements the Mud New-Environ Standard on top of NEW-ENVIRON. Clients report
+// the values in info, along with CHARSET, which is kept up to date with the charset the printer
+// is decoding with: whenever it changes, such as after CHARSET or TRANSMIT-BINARY negotiation,
+// the remote is sent an INFO update. If NAWS is active locally, the size it reports is also sent
+// as the USERVARs COLUMNS and LINES, and kept up to date as the size changes. Servers that
+// request MNES on the remote can read what the client reported with RemoteInfo.
+//
+// MNES uses telopt code 39, so it can't be registered alongside RegisterNEWENVIRON.
+func RegisterMNES(usage telnet.TelOptUsage, info MNESInfo) telnet.TelnetOption {
+	initialVars := make(map[string]string)
+	keysAndValues := info.keysAndValues()
+	for index := 0; index < len(keysAndValues); index += 2 {
+		initialVars[keysAndValues[index]] = keysAndValues[index+1]
+	}
+
+	option := &MNES{
+		NEWENVIRON: NEWENVIRON{
+			BaseTelOpt: NewBaseTelOpt(newenviron, "NEW-ENVIRON", usage),
+			varCode:    newenvironVAR,
+			valueCode:  newenvironVALUE,
+		},
+	}
+	option.self = option
+	option.environVars = newEnvironVars(NEWENVIRONConfig{
+		WellKnownVarKeys: slices.Concat(NEWENVIRONWellKnownVars, MNESVars),
+		InitialVars:      initialVars,
+	})
+	option.sharing = []*NEWENVIRON{&option.NEWENVIRON}
+
+	return option
+}
+
+type MNES struct {
+	NEWENVIRON
+}
+
+func (o *MNES) Initialize(terminal *telnet.Terminal) {
+	o.NEWENVIRON.Initialize(terminal)
+
+	o.localVarsLock.Lock()
+	o.localWellKnownVars["CHARSET"] = terminal.Charset().DecodingName()
+	o.localVarsLock.Unlock()
+
+	terminal.RegisterTelOptEventHook(o.telOptEvent)
+}
+
+func (o *MNES) telOptEvent(terminal *telnet.Terminal, event telnet.TelOptEvent) {
+	if event.Option() == o {
+		return
+	}
+
+	var changed []string
+
+	charsetName := terminal.Charset().DecodingName()
+
+	o.localVarsLock.Lock()
+	if charsetName != o.localWellKnownVars["CHARSET"] {
+		changed = append(changed, "CHARSET", charsetName)
+	}
+	o.localVarsLock.Unlock()
+
+	changed = append(changed, o.nawsChanges(event)...)
+
+	if len(changed) == 0 {
+		return
+	}
+
+	err := o.SetVars(changed...)
+	if err != nil {
+		terminal.RaiseTelOptEvent(MNESUpdateFailedEvent{
+			BaseTelOptEvent: BaseTelOptEvent{o},
+			Err:             err,
+		})
+	}
+}
+
+// nawsChanges returns the COLUMNS and LINES values that need to be sent to the remote after a
+// NAWS event, if any
+func (o *MNES) nawsChanges(event telnet.TelOptEvent) []string {
+	var width, height int
+
+	switch typed := event.(type) {
+	case NAWSLocalSizeChangedEvent:
+		if typed.Option().LocalState() != telnet.TelOptActive {
+			return nil
+		}
+
+		width, height = typed.NewLocalWidth, typed.NewLocalHeight
+	case telnet.TelOptStateChangeEvent:
+		naws, isNAWS := typed.Option().(*NAWS)
+		if !isNAWS || typed.Side != telnet.TelOptSideLocal || typed.NewState != telnet.TelOptActive {
+			return nil
+		}
+
+		width, height = naws.GetLocalSize()
+	default:
+		return nil
+	}
+
+	var changed []string
+	columns, lines := strconv.Itoa(width), strconv.Itoa(height)
+
+	o.localVarsLock.Lock()
+	defer o.localVarsLock.Unlock()
+
+	if width > 0 && o.localUserVars["COLUMNS"] != columns {
+		changed = append(changed, "COLUMNS", columns)
+	}
+
+	if height > 0 && o.localUserVars["LINES"] != lines {
+		changed = append(changed, "LINES", lines)
+	}
+
+	return changed
+}
+
+// SetInfo replaces the local MNES variables other than CHARSET, and sends the remote an INFO
+// update for the ones that changed
+func (o *MNES) SetInfo(info MNESInfo) error {
+	o.localVarsLock.Lock()
+	newValues := make(map[string]string)
+	keysAndValues := info.keysAndValues()
+	for index := 0; index < len(keysAndValues); index += 2 {
+		newValues[keysAndValues[index]] = keysAndValues[index+1]
+	}
+
+	var changed, cleared []string
+	for _, key := range MNESVars {
+		if key == "CHARSET" {
+			continue
+		}
+
+		oldValue, hadValue := o.localWellKnownVars[key]
+		newValue, hasValue := newValues[key]
+		if hasValue && (!hadValue || oldValue != newValue) {
+			changed = append(changed, key, newValue)
+		} else if !hasValue && hadValue {
+			cleared = append(cleared, key)
+		}
+	}
+	o.localVarsLock.Unlock()
+
+	if len(changed) > 0 {
+		err := o.SetVars(changed...)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(cleared) > 0 {
+		o.ClearVars(cleared...)
+	}
+
+	return nil
+}
+
+// RemoteInfo returns the MNES variables the remote has reported
+func (o *MNES) RemoteInfo() MNESInfo {
+	o.remoteVarsLock.Lock()
+	defer o.remoteVarsLock.Unlock()
+
+	info := MNESInfo{
+		ClientName:    o.remoteWellKnownVars["CLIENT_NAME"],
+		ClientVersion: o.remoteWellKnownVars["CLIENT_VERSION"],
+		Charset:       o.remoteWellKnownVars["CHARSET"],
+		TerminalType:  o.remoteWellKnownVars["TERMINAL_TYPE"],
+		IPAddress:     o.remoteWellKnownVars["IPADDRESS"],
+	}
+
+	mtts, err := strconv.Atoi(o.remoteWellKnownVars["MTTS"])
+	if err == nil && mtts > 0 {
+		info.MTTS = MTTSFlags(mtts)
+	}
+
+	return info
+}
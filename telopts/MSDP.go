@@ -0,0 +1,719 @@
+package telopts
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/moodclient/telnet"
+)
+
+const msdp telnet.TelOptCode = 69
+
+const (
+	msdpVAR byte = iota + 1
+	msdpVAL
+	msdpTABLEOPEN
+	msdpTABLECLOSE
+	msdpARRAYOPEN
+	msdpARRAYCLOSE
+)
+
+// The commands a client can send to an MSDP server
+const (
+	MSDPCommandList     = "LIST"
+	MSDPCommandReport   = "REPORT"
+	MSDPCommandReset    = "RESET"
+	MSDPCommandSend     = "SEND"
+	MSDPCommandUnreport = "UNREPORT"
+)
+
+// The lists a client can request from an MSDP server with the LIST command
+const (
+	MSDPListCommands              = "COMMANDS"
+	MSDPListLists                 = "LISTS"
+	MSDPListConfigurableVariables = "CONFIGURABLE_VARIABLES"
+	MSDPListReportableVariables   = "REPORTABLE_VARIABLES"
+	MSDPListReportedVariables     = "REPORTED_VARIABLES"
+	MSDPListSendableVariables     = "SENDABLE_VARIABLES"
+)
+
+var msdpCommands = []string{MSDPCommandList, MSDPCommandReport, MSDPCommandReset, MSDPCommandSend, MSDPCommandUnreport}
+
+var msdpLists = []string{
+	MSDPListCommands,
+	MSDPListLists,
+	MSDPListConfigurableVariables,
+	MSDPListReportableVariables,
+	MSDPListReportedVariables,
+	MSDPListSendableVariables,
+}
+
+// MSDPVarsReceivedEvent is raised when the remote sends variables, other than commands. On a client,
+// these are usually variables the server is reporting or answers to LIST. On a server, these are
+// configurable variables set by the client.
+type MSDPVarsReceivedEvent struct {
+	BaseTelOptEvent
+	// Vars holds the variables in the order they were received. Each value is a string, a []any
+	// for arrays, or a map[string]any for tables.
+	Vars []MSDPVar
+}
+
+func (e MSDPVarsReceivedEvent) String() string {
+	names := make([]string, 0, len(e.Vars))
+	for _, variable := range e.Vars {
+		names = append(names, variable.Name)
+	}
+
+	return fmt.Sprintf("MSDP Vars Received: %+v", names)
+}
+
+// MSDPReportedVarsChangedEvent is raised on a server when the client changes which variables
+// it wants reported
+type MSDPReportedVarsChangedEvent struct {
+	BaseTelOptEvent
+	ReportedVars []string
+}
+
+func (e MSDPReportedVarsChangedEvent) String() string {
+	return fmt.Sprintf("MSDP Reported Vars Changed: %+v", e.ReportedVars)
+}
+
+// MSDPVar is a single variable sent via MSDP
+type MSDPVar struct {
+	Name  string
+	Value any
+}
+
+type MSDPConfig struct {
+	// ReportableVariables is the variables that a client may ask this server to REPORT. When one of
+	// these variables is changed with SetLocalVar after the client has asked for it, it is sent to
+	// the client automatically.
+	ReportableVariables []string
+	// SendableVariables is the variables that a client may ask this server to SEND, in addition to
+	// ReportableVariables
+	SendableVariables []string
+	// ConfigurableVariables is the variables that a client may set on this server, such as
+	// CLIENT_NAME. These are only used to answer LIST- variables sent by the remote are always accepted.
+	ConfigurableVariables []string
+}
+
+// RegisterMSDP implements the MUD Server Data Protocol, which allows a server to send structured
+// variables to the client. Variables can hold strings, arrays, and tables, which are represented
+// as string, []any, and map[string]any. When setting variables, []string and map[string]string are
+// accepted as well, and other values are formatted with fmt.Sprint.
+//
+// Servers should activate MSDP locally, and use SetLocalVar to keep variables up to date. This
+// telopt answers the client's LIST, REPORT, UNREPORT, RESET, and SEND commands itself, using
+// MSDPConfig to decide which variables are available, and sends reported variables whenever they
+// change. Clients should activate MSDP on the remote, and use the List, Report, Send, etc. methods
+// to send commands. Variables received from the remote raise MSDPVarsReceivedEvent and can be read
+// with RemoteVar.
+func RegisterMSDP(usage telnet.TelOptUsage, config MSDPConfig) telnet.TelnetOption {
+	return &MSDP{
+		BaseTelOpt:   NewBaseTelOpt(msdp, "MSDP", usage),
+		config:       config,
+		localVars:    make(map[string]any),
+		reportedVars: make(map[string]struct{}),
+		remoteVars:   make(map[string]any),
+	}
+}
+
+type MSDP struct {
+	BaseTelOpt
+
+	config MSDPConfig
+
+	localLock    sync.Mutex
+	localVars    map[string]any
+	reportedVars map[string]struct{}
+
+	remoteLock sync.Mutex
+	remoteVars map[string]any
+}
+
+func (o *MSDP) TransitionLocalState(newState telnet.TelOptState) (func() error, error) {
+	postSend, err := o.BaseTelOpt.TransitionLocalState(newState)
+	if err != nil {
+		return postSend, err
+	}
+
+	if newState == telnet.TelOptInactive {
+		o.localLock.Lock()
+		clear(o.reportedVars)
+		o.localLock.Unlock()
+	}
+
+	return postSend, nil
+}
+
+func (o *MSDP) TransitionRemoteState(newState telnet.TelOptState) (func() error, error) {
+	postSend, err := o.BaseTelOpt.TransitionRemoteState(newState)
+	if err != nil {
+		return postSend, err
+	}
+
+	if newState == telnet.TelOptInactive {
+		o.remoteLock.Lock()
+		clear(o.remoteVars)
+		o.remoteLock.Unlock()
+	}
+
+	return postSend, nil
+}
+
+func (o *MSDP) writeVars(vars []MSDPVar) {
+	if len(vars) == 0 {
+		return
+	}
+
+	subnegotiation, chunker := encodeMSDPVars(vars)
+	_ = chunker.Write(o.Terminal().Keyboard(), msdp, subnegotiation)
+}
+
+// encodeMSDPVars returns the subnegotiation for the provided variables, along with a chunker
+// that splits it between variables
+func encodeMSDPVars(vars []MSDPVar) ([]byte, SubnegotiationChunker) {
+	var subnegotiation []byte
+	var varStarts []int
+	for _, variable := range vars {
+		varStarts = append(varStarts, len(subnegotiation))
+		subnegotiation = appendMSDPVar(subnegotiation, variable.Name, variable.Value)
+	}
+
+	// Large dumps are split between variables, since each subnegotiation is read on its own
+	chunker := SubnegotiationChunker{
+		Boundary: func(remaining []byte, limit int) int {
+			offset := len(subnegotiation) - len(remaining)
+			index, _ := slices.BinarySearch(varStarts, offset+limit+1)
+			if index > 0 && varStarts[index-1] > offset {
+				return varStarts[index-1] - offset
+			}
+
+			// A single variable is too large to send, so it has to be split
+			return limit
+		},
+	}
+
+	return subnegotiation, chunker
+}
+
+// SendCommand sends an MSDP command, such as REPORT, to the server. It returns an error if MSDP
+// is not active on the remote.
+func (o *MSDP) SendCommand(command string, args ...string) error {
+	if o.RemoteState() != telnet.TelOptActive {
+		return fmt.Errorf("msdp: cannot send %s while MSDP is not active on the remote", command)
+	}
+
+	// Arguments are sent as repeated VALs rather than an array, which is what servers expect
+	subnegotiation := append([]byte{msdpVAR}, command...)
+	for _, arg := range args {
+		subnegotiation = append(subnegotiation, msdpVAL)
+		subnegotiation = append(subnegotiation, arg...)
+	}
+
+	o.Terminal().Keyboard().WriteCommand(telnet.Command{
+		OpCode:         telnet.SB,
+		Option:         msdp,
+		Subnegotiation: subnegotiation,
+	}, nil)
+	return nil
+}
+
+// List asks the server to send one of its lists, such as MSDPListReportableVariables. The list
+// will arrive in an MSDPVarsReceivedEvent as a variable with the list's name.
+func (o *MSDP) List(list string) error {
+	return o.SendCommand(MSDPCommandList, list)
+}
+
+// Report asks the server to send the provided variables now and whenever they change
+func (o *MSDP) Report(names ...string) error {
+	return o.SendCommand(MSDPCommandReport, names...)
+}
+
+// Unreport asks the server to stop reporting the provided variables
+func (o *MSDP) Unreport(names ...string) error {
+	return o.SendCommand(MSDPCommandUnreport, names...)
+}
+
+// Send asks the server to send the provided variables once
+func (o *MSDP) Send(names ...string) error {
+	return o.SendCommand(MSDPCommandSend, names...)
+}
+
+// Reset asks the server to reset one of its lists. Resetting MSDPListReportableVariables stops
+// all variables from being reported.
+func (o *MSDP) Reset(list string) error {
+	return o.SendCommand(MSDPCommandReset, list)
+}
+
+// SetConfigurableVar sends a configurable variable, such as CLIENT_NAME, to the server. It
+// returns an error if MSDP is not active on the remote.
+func (o *MSDP) SetConfigurableVar(name string, value any) error {
+	if o.RemoteState() != telnet.TelOptActive {
+		return fmt.Errorf("msdp: cannot set %s while MSDP is not active on the remote", name)
+	}
+
+	o.writeVars([]MSDPVar{{Name: name, Value: value}})
+	return nil
+}
+
+// RemoteVar returns the most recent value the remote sent for a variable
+func (o *MSDP) RemoteVar(name string) (any, bool) {
+	o.remoteLock.Lock()
+	defer o.remoteLock.Unlock()
+
+	value, hasValue := o.remoteVars[name]
+	return value, hasValue
+}
+
+// RemoteVars returns the most recent value the remote sent for each variable
+func (o *MSDP) RemoteVars() map[string]any {
+	o.remoteLock.Lock()
+	defer o.remoteLock.Unlock()
+
+	return maps.Clone(o.remoteVars)
+}
+
+// SetLocalVar sets the value of a variable on a server. If the client has asked for the variable
+// to be reported and the value has changed, it is sent to the client.
+func (o *MSDP) SetLocalVar(name string, value any) {
+	o.SetLocalVars(map[string]any{name: value})
+}
+
+// SetLocalVars sets the values of several variables on a server, sending any that the client has
+// asked to be reported and have changed in a single subnegotiation
+func (o *MSDP) SetLocalVars(vars map[string]any) {
+	o.localLock.Lock()
+	defer o.localLock.Unlock()
+
+	var changed []MSDPVar
+	for _, name := range slices.Sorted(maps.Keys(vars)) {
+		value := vars[name]
+		oldValue, hadValue := o.localVars[name]
+		o.localVars[name] = value
+
+		_, reported := o.reportedVars[name]
+		if reported && (!hadValue || !reflect.DeepEqual(oldValue, value)) {
+			changed = append(changed, MSDPVar{Name: name, Value: value})
+		}
+	}
+
+	if o.LocalState() == telnet.TelOptActive {
+		o.writeVars(changed)
+	}
+}
+
+// LocalVar returns the value of a variable set with SetLocalVar
+func (o *MSDP) LocalVar(name string) (any, bool) {
+	o.localLock.Lock()
+	defer o.localLock.Unlock()
+
+	value, hasValue := o.localVars[name]
+	return value, hasValue
+}
+
+// ReportedVars returns the variables that the client has asked this server to report
+func (o *MSDP) ReportedVars() []string {
+	o.localLock.Lock()
+	defer o.localLock.Unlock()
+
+	return slices.Sorted(maps.Keys(o.reportedVars))
+}
+
+func (o *MSDP) isReportable(name string) bool {
+	return slices.Contains(o.config.ReportableVariables, name)
+}
+
+func (o *MSDP) isSendable(name string) bool {
+	return o.isReportable(name) || slices.Contains(o.config.SendableVariables, name)
+}
+
+// msdpArgs flattens the value of a command into a list of arguments
+func msdpArgs(value any) []string {
+	switch typed := value.(type) {
+	case string:
+		if typed == "" {
+			return nil
+		}
+		return []string{typed}
+	case []any:
+		var args []string
+		for _, item := range typed {
+			args = append(args, msdpArgs(item)...)
+		}
+		return args
+	}
+
+	return nil
+}
+
+// subnegotiateCommand answers a command from the client. It must be called while holding
+// the local lock.
+func (o *MSDP) subnegotiateCommand(command string, args []string) (response []MSDPVar, reportedChanged bool) {
+	switch command {
+	case MSDPCommandList:
+		for _, list := range args {
+			response = append(response, MSDPVar{Name: list, Value: o.list(list)})
+		}
+	case MSDPCommandReport:
+		for _, name := range args {
+			if !o.isReportable(name) {
+				continue
+			}
+
+			_, alreadyReported := o.reportedVars[name]
+			if !alreadyReported {
+				o.reportedVars[name] = struct{}{}
+				reportedChanged = true
+			}
+
+			value, hasValue := o.localVars[name]
+			if hasValue {
+				response = append(response, MSDPVar{Name: name, Value: value})
+			}
+		}
+	case MSDPCommandUnreport:
+		for _, name := range args {
+			_, reported := o.reportedVars[name]
+			if reported {
+				delete(o.reportedVars, name)
+				reportedChanged = true
+			}
+		}
+	case MSDPCommandReset:
+		if slices.Contains(args, MSDPListReportableVariables) || slices.Contains(args, MSDPListReportedVariables) {
+			reportedChanged = len(o.reportedVars) > 0
+			clear(o.reportedVars)
+		}
+	case MSDPCommandSend:
+		for _, name := range args {
+			value, hasValue := o.localVars[name]
+			if hasValue && o.isSendable(name) {
+				response = append(response, MSDPVar{Name: name, Value: value})
+			}
+		}
+	}
+
+	return response, reportedChanged
+}
+
+func (o *MSDP) list(list string) []any {
+	var names []string
+	switch list {
+	case MSDPListCommands:
+		names = msdpCommands
+	case MSDPListLists:
+		names = msdpLists
+	case MSDPListConfigurableVariables:
+		names = o.config.ConfigurableVariables
+	case MSDPListReportableVariables:
+		names = o.config.ReportableVariables
+	case MSDPListReportedVariables:
+		names = slices.Sorted(maps.Keys(o.reportedVars))
+	case MSDPListSendableVariables:
+		names = append(slices.Clone(o.config.ReportableVariables), o.config.SendableVariables...)
+	}
+
+	values := make([]any, 0, len(names))
+	for _, name := range names {
+		values = append(values, name)
+	}
+
+	return values
+}
+
+func (o *MSDP) ValidateSubnegotiation(subnegotiation []byte) error {
+	_, err := decodeMSDP(subnegotiation)
+	return err
+}
+
+func (o *MSDP) Subnegotiate(subnegotiation []byte) error {
+	vars, err := decodeMSDP(subnegotiation)
+	if err != nil {
+		return err
+	}
+
+	var received []MSDPVar
+	if o.LocalState() == telnet.TelOptActive {
+		o.localLock.Lock()
+
+		var response []MSDPVar
+		var reportedChanged bool
+		for _, variable := range vars {
+			if !slices.Contains(msdpCommands, variable.Name) {
+				received = append(received, variable)
+				continue
+			}
+
+			commandResponse, changed := o.subnegotiateCommand(variable.Name, msdpArgs(variable.Value))
+			response = append(response, commandResponse...)
+			reportedChanged = reportedChanged || changed
+		}
+
+		o.writeVars(response)
+		reported := slices.Sorted(maps.Keys(o.reportedVars))
+		o.localLock.Unlock()
+
+		if reportedChanged {
+			o.Terminal().RaiseTelOptEvent(MSDPReportedVarsChangedEvent{
+				BaseTelOptEvent: BaseTelOptEvent{o},
+				ReportedVars:    reported,
+			})
+		}
+	} else {
+		received = vars
+	}
+
+	if len(received) == 0 {
+		return nil
+	}
+
+	o.remoteLock.Lock()
+	for _, variable := range received {
+		o.remoteVars[variable.Name] = variable.Value
+	}
+	o.remoteLock.Unlock()
+
+	o.Terminal().RaiseTelOptEvent(MSDPVarsReceivedEvent{
+		BaseTelOptEvent: BaseTelOptEvent{o},
+		Vars:            received,
+	})
+
+	return nil
+}
+
+func (o *MSDP) SubnegotiationString(subnegotiation []byte) (string, error) {
+	vars, err := decodeMSDP(subnegotiation)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	for index, variable := range vars {
+		if index > 0 {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(variable.Name)
+		sb.WriteByte('=')
+		writeMSDPValueString(&sb, variable.Value)
+	}
+
+	return sb.String(), nil
+}
+
+func writeMSDPValueString(sb *strings.Builder, value any) {
+	switch typed := value.(type) {
+	case []any:
+		sb.WriteByte('[')
+		for index, item := range typed {
+			if index > 0 {
+				sb.WriteString(", ")
+			}
+			writeMSDPValueString(sb, item)
+		}
+		sb.WriteByte(']')
+	case map[string]any:
+		sb.WriteByte('{')
+		for index, key := range slices.Sorted(maps.Keys(typed)) {
+			if index > 0 {
+				sb.WriteString(", ")
+			}
+			sb.WriteString(key)
+			sb.WriteByte('=')
+			writeMSDPValueString(sb, typed[key])
+		}
+		sb.WriteByte('}')
+	default:
+		fmt.Fprintf(sb, "%q", typed)
+	}
+}
+
+func appendMSDPVar(buffer []byte, name string, value any) []byte {
+	buffer = append(buffer, msdpVAR)
+	buffer = append(buffer, name...)
+	buffer = append(buffer, msdpVAL)
+	return appendMSDPValue(buffer, value)
+}
+
+func appendMSDPValue(buffer []byte, value any) []byte {
+	switch typed := value.(type) {
+	case nil:
+		return buffer
+	case string:
+		return append(buffer, typed...)
+	case []string:
+		buffer = append(buffer, msdpARRAYOPEN)
+		for _, item := range typed {
+			buffer = append(buffer, msdpVAL)
+			buffer = append(buffer, item...)
+		}
+		return append(buffer, msdpARRAYCLOSE)
+	case []any:
+		buffer = append(buffer, msdpARRAYOPEN)
+		for _, item := range typed {
+			buffer = append(buffer, msdpVAL)
+			buffer = appendMSDPValue(buffer, item)
+		}
+		return append(buffer, msdpARRAYCLOSE)
+	case map[string]string:
+		buffer = append(buffer, msdpTABLEOPEN)
+		for _, key := range slices.Sorted(maps.Keys(typed)) {
+			buffer = appendMSDPVar(buffer, key, typed[key])
+		}
+		return append(buffer, msdpTABLECLOSE)
+	case map[string]any:
+		buffer = append(buffer, msdpTABLEOPEN)
+		for _, key := range slices.Sorted(maps.Keys(typed)) {
+			buffer = appendMSDPVar(buffer, key, typed[key])
+		}
+		return append(buffer, msdpTABLECLOSE)
+	default:
+		return append(buffer, fmt.Sprint(typed)...)
+	}
+}
+
+// msdpDecoder reads MSDP variables from a subnegotiation
+type msdpDecoder struct {
+	data  []byte
+	index int
+}
+
+func decodeMSDP(subnegotiation []byte) ([]MSDPVar, error) {
+	decoder := &msdpDecoder{data: subnegotiation}
+
+	var vars []MSDPVar
+	for decoder.index < len(decoder.data) {
+		name, value, err := decoder.readVar()
+		if err != nil {
+			return nil, err
+		}
+
+		vars = append(vars, MSDPVar{Name: name, Value: value})
+	}
+
+	if len(vars) == 0 {
+		return nil, telnet.ProtocolErrorf("msdp: received empty subnegotiation")
+	}
+
+	return vars, nil
+}
+
+func (d *msdpDecoder) peek() (byte, bool) {
+	if d.index >= len(d.data) {
+		return 0, false
+	}
+
+	return d.data[d.index], true
+}
+
+func (d *msdpDecoder) readText() string {
+	start := d.index
+	for d.index < len(d.data) && d.data[d.index] > msdpARRAYCLOSE {
+		d.index++
+	}
+
+	return string(d.data[start:d.index])
+}
+
+// readVar reads VAR name followed by any number of VAL value. A variable with more than one VAL is
+// treated as an array.
+func (d *msdpDecoder) readVar() (string, any, error) {
+	next, _ := d.peek()
+	if next != msdpVAR {
+		return "", nil, telnet.ProtocolErrorf("msdp: expected VAR at index %d of %+v", d.index, d.data)
+	}
+	d.index++
+
+	name := d.readText()
+	if name == "" {
+		return "", nil, telnet.ProtocolErrorf("msdp: received VAR with empty name")
+	}
+
+	var values []any
+	for {
+		next, hasNext := d.peek()
+		if !hasNext || next != msdpVAL {
+			break
+		}
+		d.index++
+
+		value, err := d.readValue()
+		if err != nil {
+			return "", nil, err
+		}
+
+		values = append(values, value)
+	}
+
+	switch len(values) {
+	case 0:
+		return name, "", nil
+	case 1:
+		return name, values[0], nil
+	default:
+		return name, values, nil
+	}
+}
+
+func (d *msdpDecoder) readValue() (any, error) {
+	next, _ := d.peek()
+
+	switch next {
+	case msdpARRAYOPEN:
+		d.index++
+
+		array := []any{}
+		for {
+			next, hasNext := d.peek()
+			if !hasNext {
+				return nil, telnet.ProtocolErrorf("msdp: received unterminated array")
+			}
+			d.index++
+
+			if next == msdpARRAYCLOSE {
+				return array, nil
+			}
+
+			if next != msdpVAL {
+				return nil, telnet.ProtocolErrorf("msdp: expected VAL in array at index %d of %+v", d.index-1, d.data)
+			}
+
+			value, err := d.readValue()
+			if err != nil {
+				return nil, err
+			}
+
+			array = append(array, value)
+		}
+	case msdpTABLEOPEN:
+		d.index++
+
+		table := make(map[string]any)
+		for {
+			next, hasNext := d.peek()
+			if !hasNext {
+				return nil, telnet.ProtocolErrorf("msdp: received unterminated table")
+			}
+
+			if next == msdpTABLECLOSE {
+				d.index++
+				return table, nil
+			}
+
+			name, value, err := d.readVar()
+			if err != nil {
+				return nil, err
+			}
+
+			table[name] = value
+		}
+	case msdpARRAYCLOSE, msdpTABLECLOSE:
+		return nil, telnet.ProtocolErrorf("msdp: unexpected close at index %d of %+v", d.index, d.data)
+	default:
+		return d.readText(), nil
+	}
+}
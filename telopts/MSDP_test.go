@@ -0,0 +1,361 @@
+package telopts
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moodclient/telnet"
+)
+
+func TestMSDPValuesRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		value any
+		want  any
+	}{
+		{name: "string", value: "Bubba", want: "Bubba"},
+		{name: "empty string", value: "", want: ""},
+		{name: "nil", value: nil, want: ""},
+		{name: "other type", value: 42, want: "42"},
+		{name: "array", value: []any{"north", "south"}, want: []any{"north", "south"}},
+		{name: "single item array", value: []any{"north"}, want: []any{"north"}},
+		{name: "empty array", value: []any{}, want: []any{}},
+		{name: "string array", value: []string{"north", "south"}, want: []any{"north", "south"}},
+		{name: "table", value: map[string]any{"HP": "10", "MAXHP": "20"}, want: map[string]any{"HP": "10", "MAXHP": "20"}},
+		{name: "empty table", value: map[string]any{}, want: map[string]any{}},
+		{name: "string table", value: map[string]string{"HP": "10"}, want: map[string]any{"HP": "10"}},
+		{
+			name: "table of arrays",
+			value: map[string]any{
+				"EXITS": []any{"north", "south"},
+				"VNUM":  "6008",
+			},
+			want: map[string]any{
+				"EXITS": []any{"north", "south"},
+				"VNUM":  "6008",
+			},
+		},
+		{
+			name: "array of tables",
+			value: []any{
+				map[string]any{"NAME": "orc", "LEVEL": "3"},
+				map[string]any{"NAME": "troll", "ITEMS": []any{"club", map[string]any{}}},
+			},
+			want: []any{
+				map[string]any{"NAME": "orc", "LEVEL": "3"},
+				map[string]any{"NAME": "troll", "ITEMS": []any{"club", map[string]any{}}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			vars, err := decodeMSDP(appendMSDPVar(nil, "VAR", test.value))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := []MSDPVar{{Name: "VAR", Value: test.want}}
+			if !reflect.DeepEqual(vars, want) {
+				t.Fatalf("expected %+v, got %+v", want, vars)
+			}
+		})
+	}
+}
+
+func TestMSDPDecodesRepeatedValsAsArray(t *testing.T) {
+	subnegotiation := concatMSDP(
+		[]byte{msdpVAR}, []byte("REPORT"),
+		[]byte{msdpVAL}, []byte("HEALTH"),
+		[]byte{msdpVAL}, []byte("MANA"),
+		[]byte{msdpVAL}, []byte{msdpARRAYOPEN, msdpVAL}, []byte("a"), []byte{msdpARRAYCLOSE},
+		[]byte{msdpVAR}, []byte("EMPTY"),
+	)
+
+	vars, err := decodeMSDP(subnegotiation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []MSDPVar{
+		{Name: "REPORT", Value: []any{"HEALTH", "MANA", []any{"a"}}},
+		{Name: "EMPTY", Value: ""},
+	}
+	if !reflect.DeepEqual(vars, want) {
+		t.Fatalf("expected %+v, got %+v", want, vars)
+	}
+}
+
+func TestMSDPRejectsMalformedSubnegotiations(t *testing.T) {
+	tests := []struct {
+		name           string
+		subnegotiation []byte
+	}{
+		{name: "empty", subnegotiation: nil},
+		{name: "missing var", subnegotiation: concatMSDP([]byte{msdpVAL}, []byte("value"))},
+		{name: "empty name", subnegotiation: concatMSDP([]byte{msdpVAR, msdpVAL}, []byte("value"))},
+		{name: "unterminated array", subnegotiation: concatMSDP([]byte{msdpVAR}, []byte("A"), []byte{msdpVAL, msdpARRAYOPEN, msdpVAL}, []byte("a"))},
+		{name: "unterminated table", subnegotiation: concatMSDP([]byte{msdpVAR}, []byte("T"), []byte{msdpVAL, msdpTABLEOPEN, msdpVAR}, []byte("a"), []byte{msdpVAL}, []byte("b"))},
+		{name: "var in array", subnegotiation: concatMSDP([]byte{msdpVAR}, []byte("A"), []byte{msdpVAL, msdpARRAYOPEN, msdpVAR}, []byte("a"), []byte{msdpARRAYCLOSE})},
+		{name: "stray array close", subnegotiation: concatMSDP([]byte{msdpVAR}, []byte("A"), []byte{msdpVAL, msdpARRAYCLOSE})},
+		{name: "stray table close", subnegotiation: concatMSDP([]byte{msdpVAR}, []byte("T"), []byte{msdpVAL}, []byte("a"), []byte{msdpTABLECLOSE})},
+		{name: "mismatched close", subnegotiation: concatMSDP([]byte{msdpVAR}, []byte("T"), []byte{msdpVAL, msdpTABLEOPEN, msdpARRAYCLOSE})},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			vars, err := decodeMSDP(test.subnegotiation)
+			if !errors.Is(err, telnet.ErrProtocol) {
+				t.Fatalf("expected a protocol error, got %+v and %v", vars, err)
+			}
+		})
+	}
+}
+
+func TestMSDPChunksBetweenVars(t *testing.T) {
+	var vars []MSDPVar
+	for _, name := range []string{"HEALTH", "MANA", "MOVEMENT", "ROOM_NAME", "ROOM_EXITS", "GOLD"} {
+		vars = append(vars, MSDPVar{Name: name, Value: strings.Repeat("x", 10)})
+	}
+
+	subnegotiation, chunker := encodeMSDPVars(vars)
+	chunker.MaxSize = 50
+
+	chunks, err := chunker.Chunk(subnegotiation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) < 2 {
+		t.Fatalf("expected the vars to be split, got %d chunk", len(chunks))
+	}
+
+	// Each chunk holds whole variables, so it can be read on its own
+	var decoded []MSDPVar
+	for _, chunk := range chunks {
+		if SubnegotiationWireSize(chunk) > chunker.MaxSize {
+			t.Fatalf("chunk %q is larger than %d bytes", chunk, chunker.MaxSize)
+		}
+
+		chunkVars, err := decodeMSDP(chunk)
+		if err != nil {
+			t.Fatalf("chunk %q could not be decoded: %v", chunk, err)
+		}
+		decoded = append(decoded, chunkVars...)
+	}
+
+	if !reflect.DeepEqual(decoded, vars) {
+		t.Fatalf("expected %+v, got %+v", vars, decoded)
+	}
+}
+
+func TestMSDPChunksSplitOversizedVar(t *testing.T) {
+	vars := []MSDPVar{
+		{Name: "A", Value: "a"},
+		{Name: "HUGE", Value: strings.Repeat("x", 100)},
+		{Name: "B", Value: "b"},
+	}
+
+	subnegotiation, chunker := encodeMSDPVars(vars)
+	chunker.MaxSize = 50
+
+	chunks, err := chunker.Chunk(subnegotiation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The vars before the oversized one are sent on their own, and the oversized one is split
+	// wherever it has to be
+	wantFirst := appendMSDPVar(nil, "A", "a")
+	if !bytes.Equal(chunks[0], wantFirst) {
+		t.Fatalf("expected the first chunk to be %q, got %q", wantFirst, chunks[0])
+	}
+	if len(chunks) < 3 {
+		t.Fatalf("expected the oversized var to be split, got %d chunks", len(chunks))
+	}
+	for _, chunk := range chunks {
+		if SubnegotiationWireSize(chunk) > chunker.MaxSize {
+			t.Fatalf("chunk %q is larger than %d bytes", chunk, chunker.MaxSize)
+		}
+	}
+	if !bytes.Equal(bytes.Join(chunks, nil), subnegotiation) {
+		t.Fatalf("expected the chunks to add up to %q, got %q", subnegotiation, chunks)
+	}
+}
+
+func TestMSDPServerAnswersCommands(t *testing.T) {
+	client, server := newMSDPTestTerminals(t, MSDPConfig{
+		ReportableVariables: []string{"HEALTH", "MANA"},
+		SendableVariables:   []string{"ROOM"},
+	})
+	server.msdp.SetLocalVars(map[string]any{
+		"HEALTH": "100",
+		"MANA":   "50",
+		"ROOM":   "Hall",
+		"SECRET": "hidden",
+	})
+
+	err := client.msdp.List(MSDPListReportableVariables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.expectVars(t, MSDPVar{Name: MSDPListReportableVariables, Value: []any{"HEALTH", "MANA"}})
+
+	// Variables that can't be reported are ignored
+	err = client.msdp.Report("HEALTH", "SECRET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.expectVars(t, MSDPVar{Name: "HEALTH", Value: "100"})
+	server.expectReported(t, "HEALTH")
+
+	// Reported variables are sent when they change, and others are not
+	server.msdp.SetLocalVars(map[string]any{"HEALTH": "90", "MANA": "40"})
+	client.expectVars(t, MSDPVar{Name: "HEALTH", Value: "90"})
+	server.msdp.SetLocalVar("HEALTH", "90")
+
+	err = client.msdp.Send("ROOM", "SECRET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.expectVars(t, MSDPVar{Name: "ROOM", Value: "Hall"})
+
+	err = client.msdp.List(MSDPListReportedVariables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.expectVars(t, MSDPVar{Name: MSDPListReportedVariables, Value: []any{"HEALTH"}})
+
+	err = client.msdp.Unreport("HEALTH")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.expectReported(t)
+
+	server.msdp.SetLocalVar("HEALTH", "80")
+	err = client.msdp.Send("HEALTH")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.expectVars(t, MSDPVar{Name: "HEALTH", Value: "80"})
+
+	err = client.msdp.Report("MANA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.expectVars(t, MSDPVar{Name: "MANA", Value: "40"})
+	server.expectReported(t, "MANA")
+
+	err = client.msdp.Reset(MSDPListReportableVariables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.expectReported(t)
+
+	remoteVars := client.msdp.RemoteVars()
+	if remoteVars["HEALTH"] != "80" || remoteVars["ROOM"] != "Hall" {
+		t.Fatalf("unexpected remote vars: %+v", remoteVars)
+	}
+}
+
+func concatMSDP(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+// msdpTestTerminal is one end of an MSDP connection between two terminals, which collects the
+// MSDP events the terminal raises
+type msdpTestTerminal struct {
+	terminal *telnet.Terminal
+	msdp     *MSDP
+
+	vars     chan []MSDPVar
+	reported chan []string
+}
+
+// newMSDPTestTerminals connects a client terminal to a server terminal that sends MSDP with the
+// provided config. MSDP is active when it returns.
+func newMSDPTestTerminals(t *testing.T, serverConfig MSDPConfig) (*msdpTestTerminal, *msdpTestTerminal) {
+	t.Helper()
+
+	clientConn, serverConn := net.Pipe()
+	client := newMSDPTestTerminal(t, clientConn, telnet.SideClient, RegisterMSDP(telnet.TelOptAllowRemote, MSDPConfig{}))
+	server := newMSDPTestTerminal(t, serverConn, telnet.SideServer, RegisterMSDP(telnet.TelOptRequestLocal, serverConfig))
+
+	deadline := time.Now().Add(2 * time.Second)
+	for client.msdp.RemoteState() != telnet.TelOptActive || server.msdp.LocalState() != telnet.TelOptActive {
+		if time.Now().After(deadline) {
+			t.Fatal("MSDP was not activated")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	return client, server
+}
+
+func newMSDPTestTerminal(t *testing.T, conn net.Conn, side telnet.TerminalSide, option telnet.TelnetOption) *msdpTestTerminal {
+	t.Helper()
+
+	terminal, err := telnet.NewTerminal(context.Background(), conn, telnet.TerminalConfig{
+		DefaultCharsetName: "US-ASCII",
+		Side:               side,
+		TelOpts:            []telnet.TelnetOption{option},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = terminal.CloseWithTimeout(time.Second)
+		_ = terminal.WaitForExit()
+	})
+
+	testTerminal := &msdpTestTerminal{
+		terminal: terminal,
+		msdp:     option.(*MSDP),
+		vars:     make(chan []MSDPVar, 10),
+		reported: make(chan []string, 10),
+	}
+	terminal.RegisterTelOptEventHook(func(_ *telnet.Terminal, event telnet.TelOptEvent) {
+		switch typed := event.(type) {
+		case MSDPVarsReceivedEvent:
+			testTerminal.vars <- typed.Vars
+		case MSDPReportedVarsChangedEvent:
+			testTerminal.reported <- typed.ReportedVars
+		}
+	})
+
+	return testTerminal
+}
+
+// expectVars fails the test unless the next variables received from the remote are the provided ones
+func (m *msdpTestTerminal) expectVars(t *testing.T, want ...MSDPVar) {
+	t.Helper()
+
+	select {
+	case vars := <-m.vars:
+		if !reflect.DeepEqual(vars, want) {
+			t.Fatalf("expected %+v, got %+v", want, vars)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected %+v, received nothing", want)
+	}
+}
+
+// expectReported fails the test unless the next change to the reported variables leaves the
+// provided ones
+func (m *msdpTestTerminal) expectReported(t *testing.T, want ...string) {
+	t.Helper()
+
+	select {
+	case reported := <-m.reported:
+		if len(reported) != len(want) || (len(want) > 0 && !reflect.DeepEqual(reported, want)) {
+			t.Fatalf("expected %+v to be reported, got %+v", want, reported)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected %+v to be reported, received nothing", want)
+	}
+}
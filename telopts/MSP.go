@@ -0,0 +1,302 @@
+package telopts
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/x/ansi"
+	"github.com/moodclient/telnet"
+)
+
+const msp telnet.TelOptCode = 90
+
+// MSPTriggerKind indicates whether a SoundTriggerEvent came from a !!SOUND or !!MUSIC trigger
+type MSPTriggerKind int
+
+const (
+	MSPSound MSPTriggerKind = iota
+	MSPMusic
+)
+
+func (k MSPTriggerKind) String() string {
+	if k == MSPMusic {
+		return "MUSIC"
+	}
+
+	return "SOUND"
+}
+
+// mspMaxTrigger is the longest a trigger can be before we give up and treat it as text
+const mspMaxTrigger = 512
+
+// SoundTriggerEvent is raised when an MSP trigger is received. Fields that the trigger didn't
+// provide hold the protocol's defaults.
+type SoundTriggerEvent struct {
+	BaseTelOptEvent
+	Kind MSPTriggerKind
+	// FileName is the file to play, which may contain wildcards (* and ?) for the client to choose
+	// from. If it is "Off", the client should stop playing sounds (or music), and if URL is set,
+	// use it as the default URL from now on.
+	FileName string
+	// Volume is the volume to play at, from 0 to 100. The default is 100.
+	Volume int
+	// Loops is the number of times to play the file, or -1 to play it until told to stop. The default
+	// is 1.
+	Loops int
+	// Priority is the priority of a sound from 0 to 100. A sound that is triggered while another is
+	// playing should only interrupt it if its priority is higher. The default is 50. Music triggers
+	// don't have a priority.
+	Priority int
+	// Continue indicates, for music triggers, that music that is already playing should continue
+	// rather than restart if the same file is triggered again. The default is true.
+	Continue bool
+	// Type is the category of the file, such as "combat" or "weather", which is also the subdirectory
+	// it is found in
+	Type string
+	// URL is the base URL to download the file from, if the client doesn't have it
+	URL string
+	// Raw is the trigger as it was received
+	Raw string
+}
+
+func (e SoundTriggerEvent) String() string {
+	return fmt.Sprintf("MSP %s Trigger: %s", e.Kind, e.Raw)
+}
+
+// Off indicates whether the trigger asks the client to stop playing
+func (e SoundTriggerEvent) Off() bool {
+	return strings.EqualFold(e.FileName, "Off")
+}
+
+// RegisterMSP implements the MUD Sound Protocol. While MSP is active on the remote, a printer
+// middleware removes !!SOUND(...) and !!MUSIC(...) triggers from the server's text and raises a
+// SoundTriggerEvent for each of them. Lines that held nothing but triggers are removed entirely.
+// Servers write triggers as ordinary text once MSP is active locally.
+func RegisterMSP(usage telnet.TelOptUsage) telnet.TelnetOption {
+	option := &MSP{
+		BaseTelOpt: NewBaseTelOpt(msp, "MSP", usage),
+	}
+	option.parser = &mspParser{option: option}
+
+	return option
+}
+
+type MSP struct {
+	BaseTelOpt
+
+	parser *mspParser
+}
+
+func (o *MSP) TransitionRemoteState(newState telnet.TelOptState) (func() error, error) {
+	oldState := o.RemoteState()
+	postSend, err := o.BaseTelOpt.TransitionRemoteState(newState)
+	if err != nil {
+		return postSend, err
+	}
+
+	if newState == telnet.TelOptActive {
+		o.parser.reset()
+		o.Terminal().Printer().Middlewares().PushMiddleware(o.parser)
+	} else if oldState == telnet.TelOptActive {
+		o.Terminal().Printer().Middlewares().RemoveMiddleware(o.parser)
+	}
+
+	return postSend, nil
+}
+
+// mspParser is the printer middleware that removes triggers from the text
+type mspParser struct {
+	option *MSP
+
+	lock sync.Mutex
+	// pending holds text that may be the start of a trigger
+	pending string
+	// lineHasText indicates whether text has been sent since the last line break
+	lineHasText bool
+	// triggerOnlyLine indicates that a trigger was removed from a line that has no other text,
+	// so the line break should be removed as well
+	triggerOnlyLine bool
+}
+
+var _ telnet.Middleware = &mspParser{}
+
+var mspTriggerPrefixes = []string{"!!SOUND(", "!!MUSIC("}
+
+func (p *mspParser) reset() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.pending = ""
+	p.lineHasText = false
+	p.triggerOnlyLine = false
+}
+
+func (p *mspParser) Handle(terminal *telnet.Terminal, data telnet.TerminalData, next telnet.TerminalDataHandler) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	switch d := data.(type) {
+	case telnet.TextData:
+		p.text(terminal, string(d), next)
+	case telnet.ControlCodeData:
+		p.flushPending(terminal, next)
+
+		code := ansi.ControlCode(d)
+		if (code == ansi.CR || code == ansi.LF) && p.triggerOnlyLine {
+			if code == ansi.LF {
+				p.triggerOnlyLine = false
+				p.lineHasText = false
+			}
+			return
+		}
+
+		if code == ansi.LF {
+			p.lineHasText = false
+		}
+		next(terminal, data)
+	default:
+		p.flushPending(terminal, next)
+		next(terminal, data)
+	}
+}
+
+func (p *mspParser) flushPending(terminal *telnet.Terminal, next telnet.TerminalDataHandler) {
+	if p.pending == "" {
+		return
+	}
+
+	p.sendText(terminal, p.pending, next)
+	p.pending = ""
+}
+
+func (p *mspParser) sendText(terminal *telnet.Terminal, text string, next telnet.TerminalDataHandler) {
+	if text == "" {
+		return
+	}
+
+	p.lineHasText = true
+	p.triggerOnlyLine = false
+	next(terminal, telnet.TextData(text))
+}
+
+func (p *mspParser) text(terminal *telnet.Terminal, text string, next telnet.TerminalDataHandler) {
+	text = p.pending + text
+	p.pending = ""
+
+	var plain strings.Builder
+	for len(text) > 0 {
+		bang := strings.IndexByte(text, '!')
+		if bang < 0 {
+			plain.WriteString(text)
+			break
+		}
+
+		plain.WriteString(text[:bang])
+		text = text[bang:]
+
+		prefix, complete := mspTriggerPrefix(text)
+		if prefix == "" {
+			plain.WriteByte('!')
+			text = text[1:]
+			continue
+		}
+
+		if !complete {
+			// The rest of the text might be the start of a trigger
+			p.pending = text
+			break
+		}
+
+		end := strings.IndexByte(text, ')')
+		if end < 0 {
+			if len(text) > mspMaxTrigger {
+				plain.WriteString(text)
+			} else {
+				p.pending = text
+			}
+			break
+		}
+
+		p.sendText(terminal, plain.String(), next)
+		plain.Reset()
+
+		p.trigger(text[:end+1], prefix)
+		text = text[end+1:]
+	}
+
+	p.sendText(terminal, plain.String(), next)
+}
+
+// mspTriggerPrefix returns the trigger prefix that text begins with. If text is shorter than the
+// prefix but matches it so far, complete is false.
+func mspTriggerPrefix(text string) (prefix string, complete bool) {
+	for _, prefix := range mspTriggerPrefixes {
+		if strings.HasPrefix(text, prefix) {
+			return prefix, true
+		}
+
+		if len(text) < len(prefix) && strings.HasPrefix(prefix, text) {
+			return prefix, false
+		}
+	}
+
+	return "", false
+}
+
+func (p *mspParser) trigger(raw string, prefix string) {
+	if !p.lineHasText {
+		p.triggerOnlyLine = true
+	}
+
+	event := SoundTriggerEvent{
+		BaseTelOptEvent: BaseTelOptEvent{p.option},
+		Kind:            MSPSound,
+		Volume:          100,
+		Loops:           1,
+		Priority:        50,
+		Continue:        true,
+		Raw:             raw,
+	}
+	if prefix == "!!MUSIC(" {
+		event.Kind = MSPMusic
+		event.Priority = 0
+	}
+
+	for index, field := range strings.Fields(raw[len(prefix) : len(raw)-1]) {
+		key, value, isParameter := strings.Cut(field, "=")
+		if !isParameter {
+			if index == 0 {
+				event.FileName = field
+			}
+			continue
+		}
+
+		number, numberErr := strconv.Atoi(value)
+		switch strings.ToUpper(key) {
+		case "V":
+			if numberErr == nil {
+				event.Volume = min(max(number, 0), 100)
+			}
+		case "L":
+			if numberErr == nil {
+				event.Loops = number
+			}
+		case "P":
+			if numberErr == nil && event.Kind == MSPSound {
+				event.Priority = min(max(number, 0), 100)
+			}
+		case "C":
+			if event.Kind == MSPMusic {
+				event.Continue = value != "0"
+			}
+		case "T":
+			event.Type = value
+		case "U":
+			event.URL = value
+		}
+	}
+
+	p.option.Terminal().RaiseTelOptEvent(event)
+}
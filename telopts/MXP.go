@@ -0,0 +1,597 @@
+package telopts
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/x/ansi"
+	"github.com/moodclient/telnet"
+)
+
+const mxp telnet.TelOptCode = 91
+
+// MXPLineMode is an MXP line mode, which the server selects with the sequence ESC [ mode z
+type MXPLineMode int
+
+const (
+	// MXPModeOpen allows only formatting tags on the current line
+	MXPModeOpen MXPLineMode = iota
+	// MXPModeSecure allows all tags on the current line
+	MXPModeSecure
+	// MXPModeLocked disables tag parsing on the current line
+	MXPModeLocked
+	// MXPModeReset returns to the default mode, MXPModeOpen
+	MXPModeReset
+	// MXPModeTempSecure allows all tags for the next tag only
+	MXPModeTempSecure
+	// MXPModeLockOpen makes MXPModeOpen the mode for all lines until the mode is changed
+	MXPModeLockOpen
+	// MXPModeLockSecure makes MXPModeSecure the mode for all lines until the mode is changed
+	MXPModeLockSecure
+	// MXPModeLockLocked makes MXPModeLocked the mode for all lines until the mode is changed
+	MXPModeLockLocked
+)
+
+func (m MXPLineMode) String() string {
+	switch m {
+	case MXPModeOpen:
+		return "OPEN"
+	case MXPModeSecure:
+		return "SECURE"
+	case MXPModeLocked:
+		return "LOCKED"
+	case MXPModeReset:
+		return "RESET"
+	case MXPModeTempSecure:
+		return "TEMP SECURE"
+	case MXPModeLockOpen:
+		return "LOCK OPEN"
+	case MXPModeLockSecure:
+		return "LOCK SECURE"
+	case MXPModeLockLocked:
+		return "LOCK LOCKED"
+	default:
+		return fmt.Sprintf("LINE TAG %d", int(m))
+	}
+}
+
+// MXPModeSequence returns the escape sequence that selects a line mode, for servers that
+// send MXP
+func MXPModeSequence(mode MXPLineMode) string {
+	return fmt.Sprintf("\x1b[%dz", int(mode))
+}
+
+// The tags that are permitted in MXPModeOpen
+var mxpOpenTags = []string{
+	"b", "bold", "strong",
+	"i", "italic", "em",
+	"u", "underline",
+	"s", "strikeout", "strike",
+	"c", "color",
+	"h", "high",
+	"font",
+}
+
+// MXPTagData is printer output representing an MXP tag that does not have its own type, such
+// as <b> or <font>. Tag and attribute names are lowercase.
+type MXPTagData struct {
+	Name string
+	// Attributes holds the attributes written as name=value
+	Attributes map[string]string
+	// Args holds the attributes written without a name, in order
+	Args []string
+	Raw  string
+}
+
+var _ telnet.TerminalData = MXPTagData{}
+
+func (o MXPTagData) String() string { return "" }
+func (o MXPTagData) EscapedString(terminal telnet.TelOptLibrary) string {
+	return o.Raw
+}
+
+// MXPCloseTagData is printer output representing a closing MXP tag, such as </send>
+type MXPCloseTagData struct {
+	Name string
+	Raw  string
+}
+
+var _ telnet.TerminalData = MXPCloseTagData{}
+
+func (o MXPCloseTagData) String() string { return "" }
+func (o MXPCloseTagData) EscapedString(terminal telnet.TelOptLibrary) string {
+	return o.Raw
+}
+
+// MXPSendData is printer output representing an MXP <send> tag, which makes the text up to the
+// matching </send> into a link that sends a command to the server
+type MXPSendData struct {
+	// Href is the command to send. If it is empty, the text inside the tag is the command. It may
+	// contain several commands separated by |, which should be presented as a menu.
+	Href string
+	// Hint is the text to display when hovering over the link. It may also contain several entries
+	// separated by |, which label the menu.
+	Hint string
+	// Prompt indicates that the command should be placed on the input line instead of sent
+	Prompt bool
+	Raw    string
+}
+
+var _ telnet.TerminalData = MXPSendData{}
+
+func (o MXPSendData) String() string { return "" }
+func (o MXPSendData) EscapedString(terminal telnet.TelOptLibrary) string {
+	return o.Raw
+}
+
+// MXPLinkData is printer output representing an MXP <a> tag, which makes the text up to the
+// matching </a> into a link to a URL
+type MXPLinkData struct {
+	Href string
+	Hint string
+	Raw  string
+}
+
+var _ telnet.TerminalData = MXPLinkData{}
+
+func (o MXPLinkData) String() string { return "" }
+func (o MXPLinkData) EscapedString(terminal telnet.TelOptLibrary) string {
+	return o.Raw
+}
+
+// MXPColorData is printer output representing an MXP <color> or <c> tag, which colors the text
+// up to the matching close tag. Colors are either names or #RRGGBB.
+type MXPColorData struct {
+	Fore string
+	Back string
+	Raw  string
+}
+
+var _ telnet.TerminalData = MXPColorData{}
+
+func (o MXPColorData) String() string { return "" }
+func (o MXPColorData) EscapedString(terminal telnet.TelOptLibrary) string {
+	return o.Raw
+}
+
+// RegisterMXP implements the MUD eXtension Protocol. While MXP is active on the remote, a printer
+// middleware parses MXP out of the server's output: line mode sequences (ESC [ mode z) are removed
+// and used to track whether the current line is open, secure, or locked, and tags are removed from
+// text and delivered as MXPSendData, MXPLinkData, MXPColorData, MXPCloseTagData, or MXPTagData.
+// Entities such as &lt; are decoded. Tags that aren't permitted by the current line mode are
+// dropped, and nothing is parsed on locked lines.
+//
+// Custom elements and entities defined with <!ELEMENT> and <!ENTITY> are not expanded- the
+// definitions are delivered as MXPTagData, and custom tags are delivered as MXPTagData in secure
+// mode.
+func RegisterMXP(usage telnet.TelOptUsage) telnet.TelnetOption {
+	return &MXP{
+		BaseTelOpt: NewBaseTelOpt(mxp, "MXP", usage),
+		parser:     &mxpParser{},
+	}
+}
+
+type MXP struct {
+	BaseTelOpt
+
+	parser *mxpParser
+}
+
+func (o *MXP) TransitionRemoteState(newState telnet.TelOptState) (func() error, error) {
+	oldState := o.RemoteState()
+	postSend, err := o.BaseTelOpt.TransitionRemoteState(newState)
+	if err != nil {
+		return postSend, err
+	}
+
+	if newState == telnet.TelOptActive {
+		o.parser.reset()
+		o.Terminal().Printer().Middlewares().PushMiddleware(o.parser)
+	} else if oldState == telnet.TelOptActive {
+		o.Terminal().Printer().Middlewares().RemoveMiddleware(o.parser)
+	}
+
+	return postSend, nil
+}
+
+// LineMode returns the line mode in effect for the current line: MXPModeOpen, MXPModeSecure,
+// or MXPModeLocked
+func (o *MXP) LineMode() MXPLineMode {
+	return o.parser.currentMode()
+}
+
+func (o *MXP) Subnegotiate(subnegotiation []byte) error {
+	// Servers send an empty subnegotiation to announce that MXP is starting, but parsing
+	// began as soon as MXP was activated
+	if len(subnegotiation) == 0 {
+		return nil
+	}
+
+	return o.BaseTelOpt.Subnegotiate(subnegotiation)
+}
+
+func (o *MXP) SubnegotiationString(subnegotiation []byte) (string, error) {
+	if len(subnegotiation) == 0 {
+		return "", nil
+	}
+
+	return o.BaseTelOpt.SubnegotiationString(subnegotiation)
+}
+
+// mxpMaxPending is the longest a tag or entity can be before we give up and treat it as text
+const mxpMaxPending = 1024
+
+// mxpParser is the printer middleware that parses MXP
+type mxpParser struct {
+	lock sync.Mutex
+
+	defaultMode MXPLineMode
+	lineMode    MXPLineMode
+	tempSecure  bool
+
+	// pending holds an incomplete tag or entity, including its leading < or &
+	pending strings.Builder
+	// quote is the quote character we are inside of in a pending tag, if any
+	quote byte
+}
+
+var _ telnet.Middleware = &mxpParser{}
+
+func (p *mxpParser) reset() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.defaultMode = MXPModeOpen
+	p.lineMode = MXPModeOpen
+	p.tempSecure = false
+	p.pending.Reset()
+	p.quote = 0
+}
+
+func (p *mxpParser) currentMode() MXPLineMode {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.lineMode
+}
+
+func (p *mxpParser) Handle(terminal *telnet.Terminal, data telnet.TerminalData, next telnet.TerminalDataHandler) {
+	// The output is delivered after the lock is released, so that hooks further down the chain
+	// can call MXP.LineMode
+	for _, output := range p.parse(terminal, data) {
+		next(terminal, output)
+	}
+}
+
+// parse runs data through the parser and returns the output it produces
+func (p *mxpParser) parse(terminal *telnet.Terminal, data telnet.TerminalData) []telnet.TerminalData {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var outputs []telnet.TerminalData
+	next := func(_ *telnet.Terminal, output telnet.TerminalData) {
+		outputs = append(outputs, output)
+	}
+
+	switch d := data.(type) {
+	case telnet.TextData:
+		if p.lineMode == MXPModeLocked {
+			next(terminal, data)
+			return outputs
+		}
+
+		p.text(terminal, string(d), next)
+	case telnet.CsiData:
+		if d.Marker() != 0 || d.Intermediate() != 0 || d.Command() != 'z' {
+			p.flushPending(terminal, next)
+			next(terminal, data)
+			return outputs
+		}
+
+		mode, _ := d.Param(0, 0)
+		p.setMode(MXPLineMode(mode))
+	case telnet.ControlCodeData:
+		p.flushPending(terminal, next)
+		next(terminal, data)
+
+		if ansi.ControlCode(d) == ansi.LF {
+			// Line modes only last until the end of the line
+			p.lineMode = p.defaultMode
+			p.tempSecure = false
+		}
+	default:
+		p.flushPending(terminal, next)
+		next(terminal, data)
+	}
+
+	return outputs
+}
+
+func (p *mxpParser) setMode(mode MXPLineMode) {
+	switch mode {
+	case MXPModeOpen, MXPModeSecure, MXPModeLocked:
+		p.lineMode = mode
+	case MXPModeReset:
+		p.defaultMode = MXPModeOpen
+		p.lineMode = MXPModeOpen
+		p.tempSecure = false
+	case MXPModeTempSecure:
+		p.tempSecure = true
+	case MXPModeLockOpen, MXPModeLockSecure, MXPModeLockLocked:
+		p.defaultMode = mode - MXPModeLockOpen
+		p.lineMode = p.defaultMode
+	}
+
+	// Line tags (10 and up) refer to custom elements, which aren't supported
+}
+
+// flushPending delivers an incomplete tag or entity as text
+func (p *mxpParser) flushPending(terminal *telnet.Terminal, next telnet.TerminalDataHandler) {
+	if p.pending.Len() == 0 {
+		return
+	}
+
+	next(terminal, telnet.TextData(p.pending.String()))
+	p.pending.Reset()
+	p.quote = 0
+}
+
+func (p *mxpParser) text(terminal *telnet.Terminal, text string, next telnet.TerminalDataHandler) {
+	var plain strings.Builder
+	flushPlain := func() {
+		if plain.Len() > 0 {
+			next(terminal, telnet.TextData(plain.String()))
+			plain.Reset()
+		}
+	}
+
+	for index := 0; index < len(text); index++ {
+		b := text[index]
+
+		if p.pending.Len() == 0 {
+			if b == '<' {
+				flushPlain()
+				p.pending.WriteByte(b)
+			} else if b == '&' {
+				p.pending.WriteByte(b)
+			} else {
+				plain.WriteByte(b)
+			}
+			continue
+		}
+
+		pending := p.pending.String()
+		if pending[0] == '&' {
+			if b == ';' {
+				p.pending.Reset()
+				plain.WriteString(decodeMXPEntity(pending + ";"))
+				continue
+			}
+
+			if b == '#' || b == '_' || (b >= '0' && b <= '9') || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') {
+				p.pending.WriteByte(b)
+				continue
+			}
+
+			// Not an entity after all- reconsider this byte as text
+			plain.WriteString(pending)
+			p.pending.Reset()
+			index--
+			continue
+		}
+
+		p.pending.WriteByte(b)
+		if p.quote != 0 {
+			if b == p.quote {
+				p.quote = 0
+			}
+		} else if b == '"' || b == '\'' {
+			p.quote = b
+		} else if b == '>' {
+			p.pending.Reset()
+			flushPlain()
+			p.tag(terminal, pending[1:], pending+">", next)
+			continue
+		}
+
+		if p.pending.Len() > mxpMaxPending {
+			flushPlain()
+			p.flushPending(terminal, next)
+		}
+	}
+
+	flushPlain()
+}
+
+// tag delivers a complete tag. content is everything between < and >.
+func (p *mxpParser) tag(terminal *telnet.Terminal, content string, raw string, next telnet.TerminalDataHandler) {
+	secure := p.lineMode == MXPModeSecure || p.tempSecure
+	p.tempSecure = false
+
+	if strings.HasPrefix(content, "!--") {
+		// Comment
+		return
+	}
+
+	if strings.HasPrefix(content, "/") {
+		name := strings.ToLower(strings.TrimSpace(content[1:]))
+		if secure || slices.Contains(mxpOpenTags, name) {
+			next(terminal, MXPCloseTagData{Name: name, Raw: raw})
+		}
+		return
+	}
+
+	name, attributes, args := parseMXPTag(content)
+	if name == "" || (!secure && !slices.Contains(mxpOpenTags, name)) {
+		return
+	}
+
+	positional := func(key string, index int) string {
+		value, hasValue := attributes[key]
+		if hasValue {
+			return value
+		}
+
+		if index < len(args) {
+			return args[index]
+		}
+
+		return ""
+	}
+
+	switch name {
+	case "send":
+		_, prompt := attributes["prompt"]
+		promptIndex := slices.Index(args, "prompt")
+		if promptIndex >= 0 {
+			prompt = true
+			args = slices.Delete(args, promptIndex, promptIndex+1)
+		}
+
+		next(terminal, MXPSendData{
+			Href:   positional("href", 0),
+			Hint:   positional("hint", 1),
+			Prompt: prompt,
+			Raw:    raw,
+		})
+	case "a":
+		next(terminal, MXPLinkData{
+			Href: positional("href", 0),
+			Hint: positional("hint", 1),
+			Raw:  raw,
+		})
+	case "c", "color":
+		next(terminal, MXPColorData{
+			Fore: positional("fore", 0),
+			Back: positional("back", 1),
+			Raw:  raw,
+		})
+	default:
+		next(terminal, MXPTagData{
+			Name:       name,
+			Attributes: attributes,
+			Args:       args,
+			Raw:        raw,
+		})
+	}
+}
+
+// parseMXPTag splits the contents of a tag into its lowercase name, its name=value attributes (with
+// lowercase names), and its unnamed attributes. Unquoted unnamed attributes are lowercased, so
+// that flags like "prompt" can be recognized.
+func parseMXPTag(content string) (string, map[string]string, []string) {
+	var tokens []string
+	// startsQuoted records which tokens began with a quote, which makes them unnamed attributes
+	// even if they contain =
+	var startsQuoted []bool
+
+	index := 0
+	for index < len(content) {
+		for index < len(content) && (content[index] == ' ' || content[index] == '\t') {
+			index++
+		}
+		if index >= len(content) {
+			break
+		}
+
+		var token strings.Builder
+		startsQuoted = append(startsQuoted, content[index] == '"' || content[index] == '\'')
+		for index < len(content) && content[index] != ' ' && content[index] != '\t' {
+			b := content[index]
+			if b == '"' || b == '\'' {
+				end := strings.IndexByte(content[index+1:], b)
+				if end < 0 {
+					end = len(content) - index - 1
+				}
+				token.WriteString(content[index+1 : index+1+end])
+				index += end + 2
+				continue
+			}
+
+			token.WriteByte(b)
+			index++
+		}
+
+		tokens = append(tokens, token.String())
+	}
+
+	if len(tokens) == 0 {
+		return "", nil, nil
+	}
+
+	name := strings.ToLower(tokens[0])
+	attributes := make(map[string]string)
+	var args []string
+
+	for tokenIndex := 1; tokenIndex < len(tokens); tokenIndex++ {
+		token := tokens[tokenIndex]
+
+		equals := strings.IndexByte(token, '=')
+		if equals > 0 && !startsQuoted[tokenIndex] {
+			key := strings.ToLower(token[:equals])
+			if isMXPAttributeName(key) {
+				attributes[key] = token[equals+1:]
+				continue
+			}
+		}
+
+		if !startsQuoted[tokenIndex] {
+			token = strings.ToLower(token)
+		}
+
+		args = append(args, token)
+	}
+
+	return name, attributes, args
+}
+
+func isMXPAttributeName(name string) bool {
+	for _, r := range name {
+		if !(r == '_' || r == '-' || (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9')) {
+			return false
+		}
+	}
+
+	return true
+}
+
+var mxpEntities = map[string]string{
+	"lt":   "<",
+	"gt":   ">",
+	"amp":  "&",
+	"quot": "\"",
+	"apos": "'",
+	"nbsp": " ",
+}
+
+// decodeMXPEntity decodes an entity such as &lt; or &#60;. Entities we don't know are returned
+// unchanged.
+func decodeMXPEntity(entity string) string {
+	name := entity[1 : len(entity)-1]
+
+	if strings.HasPrefix(name, "#") {
+		var codepoint uint64
+		var err error
+		if strings.HasPrefix(name, "#x") || strings.HasPrefix(name, "#X") {
+			codepoint, err = strconv.ParseUint(name[2:], 16, 32)
+		} else {
+			codepoint, err = strconv.ParseUint(name[1:], 10, 32)
+		}
+
+		if err != nil {
+			return entity
+		}
+
+		return string(rune(codepoint))
+	}
+
+	decoded, known := mxpEntities[strings.ToLower(name)]
+	if !known {
+		return entity
+	}
+
+	return decoded
+}
@@ -0,0 +1,39 @@
+package telopts
+
+import (
+	"testing"
+	"time"
+
+	"github.com/moodclient/telnet"
+)
+
+func TestMXPOutputCanReadLineMode(t *testing.T) {
+	parser := &mxpParser{}
+	parser.reset()
+
+	var modes []MXPLineMode
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		next := func(_ *telnet.Terminal, _ telnet.TerminalData) {
+			modes = append(modes, parser.currentMode())
+		}
+		parser.Handle(nil, telnet.TextData("<b>bold</b>"), next)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the parser deadlocked when its output read the line mode")
+	}
+
+	if len(modes) != 3 {
+		t.Fatalf("expected 3 outputs, got %d", len(modes))
+	}
+	for _, mode := range modes {
+		if mode != MXPModeOpen {
+			t.Fatalf("expected %s, got %s", MXPModeOpen, mode)
+		}
+	}
+}
@@ -3,25 +3,90 @@ package telopts
 import (
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/moodclient/telnet"
 )
 
 const naws telnet.TelOptCode = 31
 
+// DefaultNAWSResizeInterval is the default minimum time between size subnegotiations. See
+// NAWS.SetResizeInterval.
+const DefaultNAWSResizeInterval = 250 * time.Millisecond
+
+// NAWSRemoteSizeChangedEvent is raised when the remote reports its size. Per RFC 1073, a width
+// or height of 0 means that the remote doesn't know or isn't saying, so it isn't a literal size.
+// NewRemoteWidth and NewRemoteHeight have been clamped to the limits set with SetRemoteSizeLimits.
 type NAWSRemoteSizeChangedEvent struct {
 	BaseTelOptEvent
 	NewRemoteWidth  int
 	NewRemoteHeight int
+	// ReportedWidth and ReportedHeight are the size the remote sent, before clamping
+	ReportedWidth  int
+	ReportedHeight int
+	// Hint indicates that the size was provided with SetRemoteSizeHint rather than reported by
+	// the remote
+	Hint bool
+}
+
+// Unspecified indicates that the remote sent a size of 0x0, meaning that it doesn't know its size
+func (e NAWSRemoteSizeChangedEvent) Unspecified() bool {
+	return e.ReportedWidth == 0 && e.ReportedHeight == 0
 }
 
 func (e NAWSRemoteSizeChangedEvent) String() string {
+	if e.Unspecified() {
+		return "NAWS Remote Size Changed- Unspecified"
+	}
+
+	if e.Hint {
+		return fmt.Sprintf("NAWS Remote Size Hinted- Width: %d, Height: %d", e.NewRemoteWidth, e.NewRemoteHeight)
+	}
+
 	return fmt.Sprintf("NAWS Remote Size Changed- Width: %d, Height: %d", e.NewRemoteWidth, e.NewRemoteHeight)
 }
 
+// NAWSSizeLimits bounds the sizes reported by the remote. Fields that are 0 are not enforced.
+// Reported dimensions of 0 are left alone, since they mean the size is unknown.
+type NAWSSizeLimits struct {
+	MinWidth  int
+	MinHeight int
+	MaxWidth  int
+	MaxHeight int
+}
+
+func clampNAWSDimension(value int, minValue int, maxValue int) int {
+	if value == 0 {
+		return 0
+	}
+
+	if minValue > 0 {
+		value = max(value, minValue)
+	}
+
+	if maxValue > 0 {
+		value = min(value, maxValue)
+	}
+
+	return value
+}
+
+// NAWSLocalSizeChangedEvent is raised when the local size is changed with SetLocalSize. It is
+// raised for every change, even when sending the size to the remote is delayed.
+type NAWSLocalSizeChangedEvent struct {
+	BaseTelOptEvent
+	NewLocalWidth  int
+	NewLocalHeight int
+}
+
+func (e NAWSLocalSizeChangedEvent) String() string {
+	return fmt.Sprintf("NAWS Local Size Changed- Width: %d, Height: %d", e.NewLocalWidth, e.NewLocalHeight)
+}
+
 func RegisterNAWS(usage telnet.TelOptUsage) telnet.TelnetOption {
 	return &NAWS{
-		BaseTelOpt: NewBaseTelOpt(naws, "NAWS", usage),
+		BaseTelOpt:     NewBaseTelOpt(naws, "NAWS", usage),
+		resizeInterval: DefaultNAWSResizeInterval,
 	}
 }
 
@@ -35,6 +100,14 @@ type NAWS struct {
 	localHeight  int
 	remoteWidth  int
 	remoteHeight int
+	remoteLimits NAWSSizeLimits
+
+	// These are protected by localLock
+	resizeInterval time.Duration
+	sentWidth      int
+	sentHeight     int
+	lastSent       time.Time
+	pendingSend    *time.Timer
 }
 
 func (o *NAWS) writeSizeSubnegotiation(width, height int) {
@@ -57,14 +130,27 @@ func (o *NAWS) TransitionLocalState(newState telnet.TelOptState) (func() error,
 	}
 
 	if newState == telnet.TelOptActive {
+		// NAWS works by having the client subnegotiate its bounds to the server after activation
+		// and whenever it changes. The size has to follow our WILL, or the server will discard it.
+		return func() error {
+			o.localLock.Lock()
+			defer o.localLock.Unlock()
+
+			if o.localWidth > 0 && o.localHeight > 0 {
+				o.sendLocalSize()
+			}
+
+			return nil
+		}, nil
+	}
+
+	if newState == telnet.TelOptInactive {
 		o.localLock.Lock()
 		defer o.localLock.Unlock()
 
-		// NAWS works by having the client subnegotiate its bounds to the server after activation
-		// and whenever it changes
-		if o.localWidth > 0 && o.localHeight > 0 {
-			o.writeSizeSubnegotiation(o.localWidth, o.localHeight)
-		}
+		o.stopPendingSend()
+		o.sentWidth = 0
+		o.sentHeight = 0
 	}
 
 	return postSend, nil
@@ -78,23 +164,40 @@ func (o *NAWS) storeRemoteSize(width, height int) {
 	o.remoteHeight = height
 }
 
+func (o *NAWS) ValidateSubnegotiation(subnegotiation []byte) error {
+	if len(subnegotiation) != 4 {
+		return telnet.ProtocolErrorf("naws: expected a four byte subnegotiation but received %d", len(subnegotiation))
+	}
+
+	return nil
+}
+
 func (o *NAWS) Subnegotiate(subnegotiation []byte) error {
 	if o.RemoteState() != telnet.TelOptActive {
 		return nil
 	}
 
 	if len(subnegotiation) != 4 {
-		return fmt.Errorf("naws: expected a four byte subnegotiation but received %d", len(subnegotiation))
+		return telnet.ProtocolErrorf("naws: expected a four byte subnegotiation but received %d", len(subnegotiation))
 	}
 
-	width := (int(subnegotiation[0]) << 8) | int(subnegotiation[1])
-	height := (int(subnegotiation[2]) << 8) | int(subnegotiation[3])
+	reportedWidth := (int(subnegotiation[0]) << 8) | int(subnegotiation[1])
+	reportedHeight := (int(subnegotiation[2]) << 8) | int(subnegotiation[3])
+
+	o.remoteLock.Lock()
+	limits := o.remoteLimits
+	o.remoteLock.Unlock()
+
+	width := clampNAWSDimension(reportedWidth, limits.MinWidth, limits.MaxWidth)
+	height := clampNAWSDimension(reportedHeight, limits.MinHeight, limits.MaxHeight)
 
 	o.storeRemoteSize(width, height)
 	o.Terminal().RaiseTelOptEvent(NAWSRemoteSizeChangedEvent{
 		BaseTelOptEvent: BaseTelOptEvent{o},
 		NewRemoteWidth:  width,
 		NewRemoteHeight: height,
+		ReportedWidth:   reportedWidth,
+		ReportedHeight:  reportedHeight,
 	})
 
 	return nil
@@ -104,25 +207,191 @@ func (o *NAWS) SubnegotiationString(subnegotiation []byte) (string, error) {
 	return fmt.Sprintf("%+v", subnegotiation), nil
 }
 
-func (o *NAWS) SetLocalSize(newWidth, newHeight int) {
+// sendLocalSize sends the local size to the remote and records it. It must be called while
+// holding localLock.
+func (o *NAWS) sendLocalSize() {
+	o.stopPendingSend()
+
+	o.writeSizeSubnegotiation(o.localWidth, o.localHeight)
+	o.sentWidth = o.localWidth
+	o.sentHeight = o.localHeight
+	o.lastSent = time.Now()
+}
+
+func (o *NAWS) stopPendingSend() {
+	if o.pendingSend != nil {
+		o.pendingSend.Stop()
+		o.pendingSend = nil
+	}
+}
+
+// sendPendingSize is called when the resize interval has passed after a size change was held back
+func (o *NAWS) sendPendingSize(timer *time.Timer) {
 	o.localLock.Lock()
 	defer o.localLock.Unlock()
 
+	// The timer may have been stopped or replaced while this was waiting for the lock
+	if o.pendingSend != timer {
+		return
+	}
+
+	o.pendingSend = nil
+	if o.LocalState() != telnet.TelOptActive {
+		return
+	}
+
+	if o.localWidth != o.sentWidth || o.localHeight != o.sentHeight {
+		o.sendLocalSize()
+	}
+}
+
+// SetLocalSize changes the size that is sent to the remote. When the size changes rapidly, such as
+// while the user drags a window's border, sizes are sent at most once per resize interval, and
+// the final size is always sent.
+func (o *NAWS) SetLocalSize(newWidth, newHeight int) {
+	o.localLock.Lock()
+
 	if o.localWidth == newWidth && o.localHeight == newHeight {
+		o.localLock.Unlock()
 		return
 	}
 
 	o.localWidth = newWidth
 	o.localHeight = newHeight
 
-	if o.LocalState() == telnet.TelOptActive {
-		o.writeSizeSubnegotiation(newWidth, newHeight)
+	if o.LocalState() == telnet.TelOptActive && o.pendingSend == nil {
+		sinceLastSend := time.Since(o.lastSent)
+		if o.resizeInterval <= 0 || sinceLastSend >= o.resizeInterval {
+			o.sendLocalSize()
+		} else {
+			var timer *time.Timer
+			timer = time.AfterFunc(o.resizeInterval-sinceLastSend, func() {
+				o.sendPendingSize(timer)
+			})
+			o.pendingSend = timer
+		}
+	}
+
+	o.localLock.Unlock()
+
+	if o.Terminal() != nil {
+		o.Terminal().RaiseTelOptEvent(NAWSLocalSizeChangedEvent{
+			BaseTelOptEvent: BaseTelOptEvent{o},
+			NewLocalWidth:   newWidth,
+			NewLocalHeight:  newHeight,
+		})
 	}
 }
 
+// GetLocalSize returns the size most recently passed to SetLocalSize
+func (o *NAWS) GetLocalSize() (width, height int) {
+	o.localLock.Lock()
+	defer o.localLock.Unlock()
+
+	return o.localWidth, o.localHeight
+}
+
+// SetResizeInterval sets the minimum time between size subnegotiations, which is
+// DefaultNAWSResizeInterval unless changed. An interval of 0 or less sends every size
+// change immediately.
+func (o *NAWS) SetResizeInterval(interval time.Duration) {
+	o.localLock.Lock()
+	defer o.localLock.Unlock()
+
+	o.resizeInterval = interval
+}
+
+// GetRemoteSize returns the size most recently reported by the remote, clamped to the remote
+// size limits. A width or height of 0 means that it is unknown.
 func (o *NAWS) GetRemoteSize() (width, height int) {
 	o.remoteLock.Lock()
 	defer o.remoteLock.Unlock()
 
 	return o.remoteWidth, o.remoteHeight
 }
+
+// OnRemoteSize calls callback with the size most recently reported by the remote, if one is
+// known, and then again whenever the remote reports a different size, until the returned
+// subscription is unregistered or the terminal shuts down. Unknown sizes (a width or height of 0)
+// are not delivered. This replaces checking GetRemoteSize, subscribing to
+// NAWSRemoteSizeChangedEvent, and deduplicating the two. NAWS must be registered with a terminal.
+func (o *NAWS) OnRemoteSize(callback func(width, height int)) *telnet.Subscription {
+	var lock sync.Mutex
+	var lastWidth, lastHeight int
+
+	deliver := func(width, height int) {
+		if width <= 0 || height <= 0 {
+			return
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		if width == lastWidth && height == lastHeight {
+			return
+		}
+
+		lastWidth, lastHeight = width, height
+		callback(width, height)
+	}
+
+	// Subscribe before reading the current size, so that no change can slip between them
+	subscription := o.Terminal().RegisterTelOptEventHook(func(_ *telnet.Terminal, event telnet.TelOptEvent) {
+		sizeChanged, isSizeChanged := event.(NAWSRemoteSizeChangedEvent)
+		if isSizeChanged && sizeChanged.Option() == o {
+			deliver(sizeChanged.NewRemoteWidth, sizeChanged.NewRemoteHeight)
+		}
+	})
+
+	deliver(o.GetRemoteSize())
+
+	return subscription
+}
+
+// SetRemoteSizeLimits sets the bounds that sizes reported by the remote are clamped to. It
+// applies to sizes received after it is called.
+func (o *NAWS) SetRemoteSizeLimits(limits NAWSSizeLimits) {
+	o.remoteLock.Lock()
+	defer o.remoteLock.Unlock()
+
+	o.remoteLimits = limits
+}
+
+// SetRemoteSizeHint provides a size to use for the remote until it reports one, such as the size it
+// reported in a previous session. The hint is clamped to the remote size limits, and is ignored if
+// the remote has already reported a size. A NAWSRemoteSizeChangedEvent with Hint set is raised
+// if the hint is used.
+func (o *NAWS) SetRemoteSizeHint(width, height int) {
+	if width <= 0 || height <= 0 {
+		return
+	}
+
+	o.remoteLock.Lock()
+	if o.remoteWidth > 0 && o.remoteHeight > 0 {
+		o.remoteLock.Unlock()
+		return
+	}
+
+	hintWidth := clampNAWSDimension(width, o.remoteLimits.MinWidth, o.remoteLimits.MaxWidth)
+	hintHeight := clampNAWSDimension(height, o.remoteLimits.MinHeight, o.remoteLimits.MaxHeight)
+	o.remoteWidth = hintWidth
+	o.remoteHeight = hintHeight
+	o.remoteLock.Unlock()
+
+	o.Terminal().RaiseTelOptEvent(NAWSRemoteSizeChangedEvent{
+		BaseTelOptEvent: BaseTelOptEvent{o},
+		NewRemoteWidth:  hintWidth,
+		NewRemoteHeight: hintHeight,
+		ReportedWidth:   width,
+		ReportedHeight:  height,
+		Hint:            true,
+	})
+}
+
+// RequestRemoteSize asks the remote to report its size again. NAWS has no way to ask for the
+// size directly, and some clients only report it once, so the option is renegotiated: clients
+// send their size whenever NAWS is activated. A NAWSRemoteSizeChangedEvent is raised when the
+// size arrives.
+func (o *NAWS) RequestRemoteSize() error {
+	return o.Terminal().RenegotiateTelOpt(naws, telnet.TelOptSideRemote)
+}
@@ -2,7 +2,6 @@ package telopts
 
 import (
 	"bytes"
-	"errors"
 	"fmt"
 	"strings"
 	"sync"
@@ -46,7 +45,35 @@ type NEWENVIRONConfig struct {
 func RegisterNEWENVIRON(usage telnet.TelOptUsage, config NEWENVIRONConfig) telnet.TelnetOption {
 	option := &NEWENVIRON{
 		BaseTelOpt: NewBaseTelOpt(newenviron, "NEW-ENVIRON", usage),
+		varCode:    newenvironVAR,
+		valueCode:  newenvironVALUE,
+	}
+	option.self = option
+	option.environVars = newEnvironVars(config)
+	option.environVars.sharing = []*NEWENVIRON{option}
+
+	return option
+}
+
+// environVars is the variable store for NEW-ENVIRON, which may be shared with the legacy ENVIRON
+// telopt
+type environVars struct {
+	localVarsLock  sync.Mutex
+	remoteVarsLock sync.Mutex
+
+	wellKnownVars map[string]struct{}
 
+	localUserVars       map[string]string
+	localWellKnownVars  map[string]string
+	remoteUserVars      map[string]string
+	remoteWellKnownVars map[string]string
+
+	// sharing is every telopt that uses this store. It is only modified during registration.
+	sharing []*NEWENVIRON
+}
+
+func newEnvironVars(config NEWENVIRONConfig) *environVars {
+	vars := &environVars{
 		wellKnownVars: make(map[string]struct{}),
 
 		localUserVars:       make(map[string]string),
@@ -56,35 +83,43 @@ func RegisterNEWENVIRON(usage telnet.TelOptUsage, config NEWENVIRONConfig) telne
 	}
 
 	for _, varKey := range config.WellKnownVarKeys {
-		option.wellKnownVars[varKey] = struct{}{}
+		vars.wellKnownVars[varKey] = struct{}{}
 	}
 
 	if config.InitialVars != nil {
 		for key, value := range config.InitialVars {
-			_, isWellKnown := option.wellKnownVars[key]
+			_, isWellKnown := vars.wellKnownVars[key]
 			if isWellKnown {
-				option.localWellKnownVars[key] = value
+				vars.localWellKnownVars[key] = value
 			} else {
-				option.localUserVars[key] = value
+				vars.localUserVars[key] = value
 			}
 		}
 	}
 
-	return option
+	return vars
 }
 
 type NEWENVIRON struct {
 	BaseTelOpt
+	*environVars
 
-	localVarsLock  sync.Mutex
-	remoteVarsLock sync.Mutex
+	// self is the telopt registered with the terminal, which is reported in events
+	self telnet.TelnetOption
 
-	wellKnownVars map[string]struct{}
+	varCode   byte
+	valueCode byte
+}
 
-	localUserVars       map[string]string
-	localWellKnownVars  map[string]string
-	remoteUserVars      map[string]string
-	remoteWellKnownVars map[string]string
+// writeInfo sends an INFO subnegotiation, if this telopt is active locally
+func (o *NEWENVIRON) writeInfo(subnegotiation []byte) {
+	if o.LocalState() == telnet.TelOptActive {
+		o.Terminal().Keyboard().WriteCommand(telnet.Command{
+			OpCode:         telnet.SB,
+			Option:         o.Code(),
+			Subnegotiation: subnegotiation,
+		}, nil)
+	}
 }
 
 func (o *NEWENVIRON) TransitionRemoteState(newState telnet.TelOptState) (func() error, error) {
@@ -94,6 +129,13 @@ func (o *NEWENVIRON) TransitionRemoteState(newState telnet.TelOptState) (func()
 	}
 
 	if newState == telnet.TelOptInactive {
+		// Vars received through another telopt sharing the store are still good
+		for _, option := range o.sharing {
+			if option != o && option.RemoteState() == telnet.TelOptActive {
+				return postSend, nil
+			}
+		}
+
 		o.remoteVarsLock.Lock()
 		defer o.remoteVarsLock.Unlock()
 
@@ -162,28 +204,83 @@ func (o *NEWENVIRON) writeSendAll() {
 	// Spell out the well-known vars we want for the benefit of the remote- we want at least an
 	// "I don't have that value" from them
 	for wellKnownVar := range o.wellKnownVars {
-		buffer.WriteByte(newenvironVAR)
+		buffer.WriteByte(o.varCode)
 		o.encodeText(buffer, wellKnownVar)
 	}
 	// Also send us anything else you might have
-	buffer.WriteByte(newenvironVAR)
+	buffer.WriteByte(o.varCode)
 	buffer.WriteByte(newenvironUSERVAR)
 
 	o.Terminal().Keyboard().WriteCommand(telnet.Command{
 		OpCode:         telnet.SB,
-		Option:         newenviron,
+		Option:         o.Code(),
 		Subnegotiation: buffer.Bytes(),
 	}, nil)
 }
 
+// RequestRemoteVars sends a SEND subnegotiation asking the remote for the values of specific
+// variables, such as to refresh values that may have changed since activation. Keys that are
+// well-known vars are requested as VARs and all others as USERVARs. The remote's answer raises
+// a NEWENVIRONRemoteVarsChangedEvent as usual.
+func (o *NEWENVIRON) RequestRemoteVars(keys ...string) error {
+	if o.RemoteState() != telnet.TelOptActive {
+		return fmt.Errorf("new-environ: cannot send SEND while %s is not active on the remote", o)
+	}
+
+	if len(keys) == 0 {
+		return fmt.Errorf("new-environ: no vars to request")
+	}
+
+	var estimatedBufferSize int
+	for _, key := range keys {
+		estimatedBufferSize += len(key) + 1
+	}
+
+	buffer := bytes.NewBuffer(make([]byte, 0, estimatedBufferSize*2))
+	buffer.WriteByte(newenvironSEND)
+
+	for _, key := range keys {
+		_, isWellKnown := o.wellKnownVars[key]
+		if isWellKnown {
+			buffer.WriteByte(o.varCode)
+		} else {
+			buffer.WriteByte(newenvironUSERVAR)
+		}
+
+		o.encodeText(buffer, key)
+	}
+
+	o.Terminal().Keyboard().WriteCommand(telnet.Command{
+		OpCode:         telnet.SB,
+		Option:         o.Code(),
+		Subnegotiation: buffer.Bytes(),
+	}, nil)
+
+	return nil
+}
+
+// RequestAll sends the same SEND subnegotiation that is sent on activation, asking the remote for
+// the well-known vars and every user var it has
+func (o *NEWENVIRON) RequestAll() error {
+	if o.RemoteState() != telnet.TelOptActive {
+		return fmt.Errorf("new-environ: cannot send SEND while %s is not active on the remote", o)
+	}
+
+	o.localVarsLock.Lock()
+	defer o.localVarsLock.Unlock()
+
+	o.writeSendAll()
+	return nil
+}
+
 func (o *NEWENVIRON) writeVarValues(buffer *bytes.Buffer, varKeys map[string]struct{}, userVarKeys map[string]struct{}) {
 	for key := range varKeys {
-		buffer.WriteByte(newenvironVAR)
+		buffer.WriteByte(o.varCode)
 		o.encodeText(buffer, key)
 
 		value, hasValue := o.localWellKnownVars[key]
 		if hasValue {
-			buffer.WriteByte(newenvironVALUE)
+			buffer.WriteByte(o.valueCode)
 			o.encodeText(buffer, value)
 		}
 	}
@@ -194,7 +291,7 @@ func (o *NEWENVIRON) writeVarValues(buffer *bytes.Buffer, varKeys map[string]str
 
 		value, hasValue := o.localUserVars[key]
 		if hasValue {
-			buffer.WriteByte(newenvironVALUE)
+			buffer.WriteByte(o.valueCode)
 			o.encodeText(buffer, value)
 		}
 	}
@@ -212,7 +309,7 @@ func (o *NEWENVIRON) subnegotiateSEND(subnegotiation []byte) {
 			nextToken := subnegotiation[index]
 			index++
 
-			if nextToken == newenvironUSERVAR || nextToken == newenvironVAR {
+			if nextToken == newenvironUSERVAR || nextToken == o.varCode {
 				keySize, key := o.decodeText(subnegotiation[index:])
 				index += keySize
 
@@ -265,12 +362,12 @@ func (o *NEWENVIRON) subnegotiateSEND(subnegotiation []byte) {
 
 	o.Terminal().Keyboard().WriteCommand(telnet.Command{
 		OpCode:         telnet.SB,
-		Option:         newenviron,
+		Option:         o.Code(),
 		Subnegotiation: buffer.Bytes(),
 	}, nil)
 }
 
-func (o *NEWENVIRON) subnegotiationLoadValues(subnegotiation []byte) ([]string, []string, error) {
+func (o *NEWENVIRON) subnegotiationLoadValues(subnegotiation []byte) ([]string, []string) {
 	o.remoteVarsLock.Lock()
 	defer o.remoteVarsLock.Unlock()
 
@@ -280,11 +377,8 @@ func (o *NEWENVIRON) subnegotiationLoadValues(subnegotiation []byte) ([]string,
 		nextToken := subnegotiation[index]
 		index++
 
-		if nextToken == newenvironUSERVAR || nextToken == newenvironVAR {
+		if nextToken == newenvironUSERVAR || nextToken == o.varCode {
 			keySize, key := o.decodeText(subnegotiation[index:])
-			if keySize == 0 {
-				return nil, nil, errors.New("new-environ: received 0-sized key with IS/INFO subnegotiation")
-			}
 
 			if nextToken == newenvironUSERVAR {
 				modifiedUserKeys = append(modifiedUserKeys, key)
@@ -294,7 +388,7 @@ func (o *NEWENVIRON) subnegotiationLoadValues(subnegotiation []byte) ([]string,
 
 			index += keySize
 
-			if index < len(subnegotiation) && subnegotiation[index] == newenvironVALUE {
+			if index < len(subnegotiation) && subnegotiation[index] == o.valueCode {
 				index++
 
 				valueSize, value := o.decodeText(subnegotiation[index:])
@@ -313,12 +407,43 @@ func (o *NEWENVIRON) subnegotiationLoadValues(subnegotiation []byte) ([]string,
 		}
 	}
 
-	return modifiedWellKnownKeys, modifiedUserKeys, nil
+	return modifiedWellKnownKeys, modifiedUserKeys
+}
+
+func (o *NEWENVIRON) ValidateSubnegotiation(subnegotiation []byte) error {
+	if len(subnegotiation) == 0 {
+		return telnet.ProtocolErrorf("new-environ: received empty subnegotiation")
+	}
+
+	if subnegotiation[0] != newenvironIS && subnegotiation[0] != newenvironINFO {
+		return nil
+	}
+
+	// Every VAR and USERVAR in IS/INFO must have a key
+	index := 1
+	for index < len(subnegotiation) {
+		nextToken := subnegotiation[index]
+		index++
+
+		if nextToken == newenvironUSERVAR || nextToken == o.varCode {
+			keySize, _ := o.decodeText(subnegotiation[index:])
+			if keySize == 0 {
+				return telnet.ProtocolErrorf("new-environ: received 0-sized key with IS/INFO subnegotiation")
+			}
+
+			index += keySize
+		} else if nextToken == o.valueCode {
+			valueSize, _ := o.decodeText(subnegotiation[index:])
+			index += valueSize
+		}
+	}
+
+	return nil
 }
 
 func (o *NEWENVIRON) Subnegotiate(subnegotiation []byte) error {
 	if len(subnegotiation) == 0 {
-		return fmt.Errorf("new-environ received empty subnegotiation")
+		return telnet.ProtocolErrorf("new-environ: received empty subnegotiation")
 	}
 
 	if subnegotiation[0] == newenvironSEND && o.LocalState() == telnet.TelOptActive {
@@ -331,16 +456,14 @@ func (o *NEWENVIRON) Subnegotiate(subnegotiation []byte) error {
 
 	if o.RemoteState() == telnet.TelOptActive && (subnegotiation[0] == newenvironIS || subnegotiation[0] == newenvironINFO) {
 		// This method locks remote locks
-		modifiedWellKnownKeys, modifiedUserKeys, err := o.subnegotiationLoadValues(subnegotiation[1:])
-		if err != nil {
-			return err
-		}
+		modifiedWellKnownKeys, modifiedUserKeys := o.subnegotiationLoadValues(subnegotiation[1:])
 
 		o.Terminal().RaiseTelOptEvent(NEWENVIRONRemoteVarsChangedEvent{
-			BaseTelOptEvent:      BaseTelOptEvent{o},
+			BaseTelOptEvent:      BaseTelOptEvent{o.self},
 			UpdatedWellKnownVars: modifiedWellKnownKeys,
 			UpdatedUserVars:      modifiedUserKeys,
 		})
+		return nil
 	}
 
 	return o.BaseTelOpt.Subnegotiate(subnegotiation)
@@ -352,12 +475,12 @@ func (o *NEWENVIRON) subnegotiationSENDString(sb *strings.Builder, subnegotiatio
 		nextToken := subnegotiation[index]
 		index++
 
-		if nextToken == newenvironVAR {
+		if nextToken == o.varCode {
 			sb.WriteString("VAR ")
 		} else if nextToken == newenvironUSERVAR {
 			sb.WriteString("USERVAR ")
 		} else {
-			return fmt.Errorf("new-environ: unexpected token %d", nextToken)
+			return telnet.ProtocolErrorf("new-environ: unexpected token %d", nextToken)
 		}
 
 		keyLen, key := o.decodeText(subnegotiation[index:])
@@ -379,23 +502,23 @@ func (o *NEWENVIRON) subnegotiationValueString(sb *strings.Builder, subnegotiati
 		nextToken := subnegotiation[index]
 		index++
 
-		if nextToken == newenvironVAR {
+		if nextToken == o.varCode {
 			sb.WriteString("VAR ")
 		} else if nextToken == newenvironUSERVAR {
 			sb.WriteString("USERVAR ")
 		} else {
-			return fmt.Errorf("new-environ: unexpected token %d", nextToken)
+			return telnet.ProtocolErrorf("new-environ: unexpected token %d", nextToken)
 		}
 
 		keyLen, key := o.decodeText(subnegotiation[index:])
 		if keyLen == 0 {
-			return fmt.Errorf("new-environ: 0-length key in IS/INFO subnegotiation")
+			return telnet.ProtocolErrorf("new-environ: 0-length key in IS/INFO subnegotiation")
 		}
 		sb.WriteString(key)
 		sb.WriteString(" ")
 		index += keyLen
 
-		if index < len(subnegotiation) && subnegotiation[index] == newenvironVALUE {
+		if index < len(subnegotiation) && subnegotiation[index] == o.valueCode {
 			sb.WriteString("VALUE ")
 			index++
 
@@ -444,6 +567,9 @@ func (o *NEWENVIRON) SubnegotiationString(subnegotiation []byte) (string, error)
 	return o.BaseTelOpt.SubnegotiationString(subnegotiation)
 }
 
+// SetVars sets local variables from alternating keys and values, and informs the remote of the
+// new values. If the variable store is shared with ENVIRON, every telopt sharing it that is active
+// locally informs its remote.
 func (o *NEWENVIRON) SetVars(keysAndValues ...string) error {
 	o.localVarsLock.Lock()
 	defer o.localVarsLock.Unlock()
@@ -452,6 +578,26 @@ func (o *NEWENVIRON) SetVars(keysAndValues ...string) error {
 		return fmt.Errorf("new-environ: uneven numbers of keys and values. dangling value: %s", keysAndValues[len(keysAndValues)-1])
 	}
 
+	for index := 0; index < len(keysAndValues); index += 2 {
+		key := keysAndValues[index]
+		value := keysAndValues[index+1]
+
+		_, isWellKnown := o.wellKnownVars[key]
+		if isWellKnown {
+			o.localWellKnownVars[key] = value
+		} else {
+			o.localUserVars[key] = value
+		}
+	}
+
+	for _, option := range o.sharing {
+		option.writeInfo(option.encodeSetVars(keysAndValues))
+	}
+
+	return nil
+}
+
+func (o *NEWENVIRON) encodeSetVars(keysAndValues []string) []byte {
 	var estimatedBufferSize int
 
 	for _, item := range keysAndValues {
@@ -467,33 +613,37 @@ func (o *NEWENVIRON) SetVars(keysAndValues ...string) error {
 
 		_, isWellKnown := o.wellKnownVars[key]
 		if isWellKnown {
-			buffer.WriteByte(newenvironVAR)
-			o.localWellKnownVars[key] = value
+			buffer.WriteByte(o.varCode)
 		} else {
 			buffer.WriteByte(newenvironUSERVAR)
-			o.localUserVars[key] = value
 		}
 
 		o.encodeText(buffer, key)
-		buffer.WriteByte(newenvironVALUE)
+		buffer.WriteByte(o.valueCode)
 		o.encodeText(buffer, value)
 	}
 
-	if o.LocalState() == telnet.TelOptActive {
-		o.Terminal().Keyboard().WriteCommand(telnet.Command{
-			OpCode:         telnet.SB,
-			Option:         newenviron,
-			Subnegotiation: buffer.Bytes(),
-		}, nil)
-	}
-
-	return nil
+	return buffer.Bytes()
 }
 
+// ClearVars deletes local variables, and informs the remote that they have been deleted. If the
+// variable store is shared with ENVIRON, every telopt sharing it that is active locally informs
+// its remote.
 func (o *NEWENVIRON) ClearVars(keys ...string) {
 	o.localVarsLock.Lock()
 	defer o.localVarsLock.Unlock()
 
+	for _, key := range keys {
+		delete(o.localWellKnownVars, key)
+		delete(o.localUserVars, key)
+	}
+
+	for _, option := range o.sharing {
+		option.writeInfo(option.encodeClearVars(keys))
+	}
+}
+
+func (o *NEWENVIRON) encodeClearVars(keys []string) []byte {
 	var estimatedBufferSize int
 
 	for _, key := range keys {
@@ -506,23 +656,15 @@ func (o *NEWENVIRON) ClearVars(keys ...string) {
 	for _, key := range keys {
 		_, isWellKnown := o.wellKnownVars[key]
 		if isWellKnown {
-			buffer.WriteByte(newenvironVAR)
-			delete(o.localWellKnownVars, key)
+			buffer.WriteByte(o.varCode)
 		} else {
 			buffer.WriteByte(newenvironUSERVAR)
-			delete(o.localUserVars, key)
 		}
 
 		o.encodeText(buffer, key)
 	}
 
-	if o.LocalState() == telnet.TelOptActive {
-		o.Terminal().Keyboard().WriteCommand(telnet.Command{
-			OpCode:         telnet.SB,
-			Option:         newenviron,
-			Subnegotiation: buffer.Bytes(),
-		}, nil)
-	}
+	return buffer.Bytes()
 }
 
 func (o *NEWENVIRON) RemoteWellKnownVar(key string) (string, bool) {
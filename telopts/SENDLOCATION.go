@@ -73,6 +73,7 @@ func (o *SENDLOCATION) Subnegotiate(subnegotiation []byte) error {
 			BaseTelOptEvent: BaseTelOptEvent{o},
 			NewLocation:     string(subnegotiation),
 		})
+		return nil
 	}
 
 	return o.BaseTelOpt.Subnegotiate(subnegotiation)
@@ -0,0 +1,342 @@
+package telopts
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/moodclient/telnet"
+)
+
+const status telnet.TelOptCode = 5
+
+const (
+	statusIS byte = iota
+	statusSEND
+)
+
+// STATUSMismatch is a single disagreement between a status report received from the remote and
+// what this terminal believes the state of a telopt to be
+type STATUSMismatch struct {
+	Code telnet.TelOptCode
+	// Side is the side of the telopt that the terminals disagree about, from this terminal's
+	// perspective
+	Side telnet.TelOptSide
+	// RemoteActive indicates whether the remote reported that side of the telopt as active.
+	// This terminal believes the opposite.
+	RemoteActive bool
+}
+
+func (m STATUSMismatch) String() string {
+	state := "inactive"
+	if m.RemoteActive {
+		state = "active"
+	}
+
+	return fmt.Sprintf("%d (%s side reported %s)", m.Code, m.Side, state)
+}
+
+// STATUSRemoteReportEvent is raised when the remote sends a status report, in response to
+// STATUS.RequestStatus
+type STATUSRemoteReportEvent struct {
+	BaseTelOptEvent
+	// RemoteWill is the telopts that the remote reported as active on its side
+	RemoteWill []telnet.TelOptCode
+	// RemoteDo is the telopts that the remote reported as active on our side
+	RemoteDo []telnet.TelOptCode
+	// Mismatches is every telopt side where the remote's report disagrees with this terminal's state
+	Mismatches []STATUSMismatch
+}
+
+func (e STATUSRemoteReportEvent) String() string {
+	return fmt.Sprintf("STATUS- Remote Report: WILL %v, DO %v, Mismatches: %v", e.RemoteWill, e.RemoteDo, e.Mismatches)
+}
+
+// RegisterSTATUS implements RFC 859, which allows a terminal to ask the remote which telopts
+// it believes are active. When STATUS is active locally, requests from the remote will be answered
+// with the terminal's live telopt state. When STATUS is active on the remote, RequestStatus can be
+// used to ask for the remote's state, which will be delivered in a STATUSRemoteReportEvent.
+func RegisterSTATUS(usage telnet.TelOptUsage) telnet.TelnetOption {
+	return &STATUS{
+		BaseTelOpt: NewBaseTelOpt(status, "STATUS", usage),
+	}
+}
+
+type STATUS struct {
+	BaseTelOpt
+
+	remoteLock   sync.Mutex
+	remoteWill   []telnet.TelOptCode
+	remoteDo     []telnet.TelOptCode
+	remoteReport bool
+}
+
+// RequestStatus asks the remote to send a status report. It returns false if STATUS is not
+// active on the remote.
+func (o *STATUS) RequestStatus() bool {
+	if o.RemoteState() != telnet.TelOptActive {
+		return false
+	}
+
+	o.Terminal().Keyboard().WriteCommand(telnet.Command{
+		OpCode:         telnet.SB,
+		Option:         status,
+		Subnegotiation: []byte{statusSEND},
+	}, nil)
+
+	return true
+}
+
+// GetRemoteReport returns the contents of the most recent status report received from the
+// remote. The final return value is false if no report has been received.
+func (o *STATUS) GetRemoteReport() (will []telnet.TelOptCode, do []telnet.TelOptCode, received bool) {
+	o.remoteLock.Lock()
+	defer o.remoteLock.Unlock()
+
+	return o.remoteWill, o.remoteDo, o.remoteReport
+}
+
+func (o *STATUS) TransitionRemoteState(newState telnet.TelOptState) (func() error, error) {
+	postSend, err := o.BaseTelOpt.TransitionRemoteState(newState)
+	if err != nil {
+		return postSend, err
+	}
+
+	if newState == telnet.TelOptInactive {
+		o.remoteLock.Lock()
+		defer o.remoteLock.Unlock()
+
+		o.remoteWill = nil
+		o.remoteDo = nil
+		o.remoteReport = false
+	}
+
+	return postSend, nil
+}
+
+// appendStatusByte adds a byte to an IS subnegotiation. SE bytes must be doubled in IS
+// subnegotiations, in addition to the usual IAC doubling.
+func appendStatusByte(report []byte, b byte) []byte {
+	report = append(report, b)
+	if b == telnet.SE {
+		report = append(report, telnet.SE)
+	}
+
+	return report
+}
+
+func (o *STATUS) writeReport() {
+	report := []byte{statusIS}
+
+	for _, option := range o.Terminal().TelOpts() {
+		if option.LocalState() == telnet.TelOptActive {
+			report = append(report, telnet.WILL)
+			report = appendStatusByte(report, byte(option.Code()))
+		}
+
+		if option.RemoteState() == telnet.TelOptActive {
+			report = append(report, telnet.DO)
+			report = appendStatusByte(report, byte(option.Code()))
+		}
+	}
+
+	o.Terminal().Keyboard().WriteCommand(telnet.Command{
+		OpCode:         telnet.SB,
+		Option:         status,
+		Subnegotiation: report,
+	}, nil)
+}
+
+// parseReport reads the WILL and DO entries from the body of an IS subnegotiation. Subnegotiation
+// entries are skipped.
+func parseReport(report []byte) (will []telnet.TelOptCode, do []telnet.TelOptCode, err error) {
+	readOption := func(index int) (telnet.TelOptCode, int, error) {
+		if index >= len(report) {
+			return 0, index, telnet.ProtocolErrorf("status: report ended before option code")
+		}
+
+		code := report[index]
+		index++
+		if code == telnet.SE {
+			if index >= len(report) || report[index] != telnet.SE {
+				return 0, index, telnet.ProtocolErrorf("status: report contained undoubled SE")
+			}
+			index++
+		}
+
+		return telnet.TelOptCode(code), index, nil
+	}
+
+	for index := 0; index < len(report); {
+		opCode := report[index]
+		index++
+
+		var code telnet.TelOptCode
+		switch opCode {
+		case telnet.WILL, telnet.DO:
+			code, index, err = readOption(index)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			if opCode == telnet.WILL {
+				will = append(will, code)
+			} else {
+				do = append(do, code)
+			}
+		case telnet.WONT, telnet.DONT:
+			// Not supposed to appear, but there's no harm in them
+			_, index, err = readOption(index)
+			if err != nil {
+				return nil, nil, err
+			}
+		case telnet.SB:
+			// Skip to the lone SE that ends the subnegotiation entry
+			for {
+				if index >= len(report) {
+					return nil, nil, telnet.ProtocolErrorf("status: report ended inside subnegotiation entry")
+				}
+
+				if report[index] == telnet.SE {
+					if index+1 < len(report) && report[index+1] == telnet.SE {
+						index += 2
+						continue
+					}
+
+					index++
+					break
+				}
+
+				index++
+			}
+		default:
+			return nil, nil, telnet.ProtocolErrorf("status: unexpected byte %d in report", opCode)
+		}
+	}
+
+	return will, do, nil
+}
+
+// findMismatches compares the remote's report against this terminal's live telopt state
+func (o *STATUS) findMismatches(will []telnet.TelOptCode, do []telnet.TelOptCode) []STATUSMismatch {
+	localActive := make(map[telnet.TelOptCode]bool)
+	remoteActive := make(map[telnet.TelOptCode]bool)
+	for _, option := range o.Terminal().TelOpts() {
+		localActive[option.Code()] = option.LocalState() == telnet.TelOptActive
+		remoteActive[option.Code()] = option.RemoteState() == telnet.TelOptActive
+	}
+
+	var mismatches []STATUSMismatch
+	compare := func(side telnet.TelOptSide, reported []telnet.TelOptCode, active map[telnet.TelOptCode]bool) {
+		for code := 0; code < 256; code++ {
+			optionCode := telnet.TelOptCode(code)
+			remote := slices.Contains(reported, optionCode)
+			if remote != active[optionCode] {
+				mismatches = append(mismatches, STATUSMismatch{
+					Code:         optionCode,
+					Side:         side,
+					RemoteActive: remote,
+				})
+			}
+		}
+	}
+
+	// The remote's WILL is our remote side, and the remote's DO is our local side
+	compare(telnet.TelOptSideRemote, will, remoteActive)
+	compare(telnet.TelOptSideLocal, do, localActive)
+
+	return mismatches
+}
+
+func (o *STATUS) ValidateSubnegotiation(subnegotiation []byte) error {
+	if len(subnegotiation) < 1 {
+		return telnet.ProtocolErrorf("status: received empty subnegotiation")
+	}
+
+	return nil
+}
+
+func (o *STATUS) Subnegotiate(subnegotiation []byte) error {
+	// Remote is asking us for a report
+	if subnegotiation[0] == statusSEND {
+		if o.LocalState() != telnet.TelOptActive {
+			return nil
+		}
+
+		o.writeReport()
+		return nil
+	}
+
+	// Remote is sending us a report
+	if subnegotiation[0] == statusIS {
+		if o.RemoteState() != telnet.TelOptActive {
+			return nil
+		}
+
+		will, do, err := parseReport(subnegotiation[1:])
+		if err != nil {
+			return err
+		}
+
+		o.remoteLock.Lock()
+		o.remoteWill = will
+		o.remoteDo = do
+		o.remoteReport = true
+		o.remoteLock.Unlock()
+
+		o.Terminal().RaiseTelOptEvent(STATUSRemoteReportEvent{
+			BaseTelOptEvent: BaseTelOptEvent{o},
+			RemoteWill:      will,
+			RemoteDo:        do,
+			Mismatches:      o.findMismatches(will, do),
+		})
+
+		return nil
+	}
+
+	return o.BaseTelOpt.Subnegotiate(subnegotiation)
+}
+
+func (o *STATUS) optionName(code telnet.TelOptCode) string {
+	for _, option := range o.Terminal().TelOpts() {
+		if option.Code() == code {
+			return option.String()
+		}
+	}
+
+	return strconv.Itoa(int(code))
+}
+
+func (o *STATUS) SubnegotiationString(subnegotiation []byte) (string, error) {
+	if len(subnegotiation) < 1 {
+		return "", fmt.Errorf("status: received empty subnegotiation")
+	}
+
+	if subnegotiation[0] == statusSEND {
+		return "SEND", nil
+	}
+
+	if subnegotiation[0] == statusIS {
+		will, do, err := parseReport(subnegotiation[1:])
+		if err != nil {
+			return "", err
+		}
+
+		var sb strings.Builder
+		sb.WriteString("IS")
+		for _, code := range will {
+			sb.WriteString(" WILL ")
+			sb.WriteString(o.optionName(code))
+		}
+		for _, code := range do {
+			sb.WriteString(" DO ")
+			sb.WriteString(o.optionName(code))
+		}
+
+		return sb.String(), nil
+	}
+
+	return o.BaseTelOpt.SubnegotiationString(subnegotiation)
+}
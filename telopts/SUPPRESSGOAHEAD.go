@@ -29,10 +29,12 @@ func (o *SUPPRESSGOAHEAD) TransitionLocalState(newState telnet.TelOptState) (fun
 	}
 
 	return func() error {
-		if newState == telnet.TelOptActive {
-			o.Terminal().Keyboard().ClearPromptCommand(telnet.PromptCommandGA)
-		} else if newState == telnet.TelOptInactive {
-			o.Terminal().Keyboard().SetPromptCommand(telnet.PromptCommandGA)
+		if newState == telnet.TelOptActive || newState == telnet.TelOptInactive {
+			if o.Terminal().CharacterModePolicy().SendsGoAhead(newState == telnet.TelOptActive) {
+				o.Terminal().Keyboard().SetPromptCommand(telnet.PromptCommandGA)
+			} else {
+				o.Terminal().Keyboard().ClearPromptCommand(telnet.PromptCommandGA)
+			}
 		}
 
 		o.Terminal().Keyboard().ClearLock(suppressgoaheadKeyboardLock)
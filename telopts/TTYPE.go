@@ -3,6 +3,7 @@ package telopts
 import (
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -17,6 +18,144 @@ const (
 	ttypeSEND
 )
 
+// MTTSFlags is a bitfield of client capabilities used by the Mud Terminal Type Standard.
+// MTTS clients report these capabilities as the third terminal type in the TTYPE cycle,
+// in the form "MTTS <bitfield>".
+type MTTSFlags int
+
+const (
+	MTTSANSI MTTSFlags = 1 << iota
+	MTTSVT100
+	MTTSUTF8
+	MTTS256Colors
+	MTTSMouseTracking
+	MTTSOSCColorPalette
+	MTTSScreenReader
+	MTTSProxy
+	MTTSTrueColor
+	MTTSMNES
+	MTTSMSLP
+	MTTSSSL
+)
+
+var mttsFlagNames = []string{
+	"ANSI", "VT100", "UTF-8", "256 COLORS", "MOUSE TRACKING", "OSC COLOR PALETTE",
+	"SCREEN READER", "PROXY", "TRUECOLOR", "MNES", "MSLP", "SSL",
+}
+
+func (f MTTSFlags) String() string {
+	var names []string
+	for index, name := range mttsFlagNames {
+		if f&(1<<index) != 0 {
+			names = append(names, name)
+		}
+	}
+
+	return "[" + strings.Join(names, " ") + "]"
+}
+
+// TerminalType returns the terminal type that reports these flags, "MTTS <bitfield>"
+func (f MTTSFlags) TerminalType() string {
+	return "MTTS " + strconv.Itoa(int(f))
+}
+
+// Capabilities decodes the flags into a struct
+func (f MTTSFlags) Capabilities() MTTSCapabilities {
+	return MTTSCapabilities{
+		ANSI:            f&MTTSANSI != 0,
+		VT100:           f&MTTSVT100 != 0,
+		UTF8:            f&MTTSUTF8 != 0,
+		Colors256:       f&MTTS256Colors != 0,
+		MouseTracking:   f&MTTSMouseTracking != 0,
+		OSCColorPalette: f&MTTSOSCColorPalette != 0,
+		ScreenReader:    f&MTTSScreenReader != 0,
+		Proxy:           f&MTTSProxy != 0,
+		TrueColor:       f&MTTSTrueColor != 0,
+		MNES:            f&MTTSMNES != 0,
+		MSLP:            f&MTTSMSLP != 0,
+		SSL:             f&MTTSSSL != 0,
+	}
+}
+
+// MTTSCapabilities is the decoded form of MTTSFlags
+type MTTSCapabilities struct {
+	ANSI            bool
+	VT100           bool
+	UTF8            bool
+	Colors256       bool
+	MouseTracking   bool
+	OSCColorPalette bool
+	ScreenReader    bool
+	Proxy           bool
+	TrueColor       bool
+	MNES            bool
+	MSLP            bool
+	SSL             bool
+}
+
+// Flags encodes the capabilities as MTTSFlags
+func (c MTTSCapabilities) Flags() MTTSFlags {
+	var flags MTTSFlags
+	for flag, set := range map[MTTSFlags]bool{
+		MTTSANSI:            c.ANSI,
+		MTTSVT100:           c.VT100,
+		MTTSUTF8:            c.UTF8,
+		MTTS256Colors:       c.Colors256,
+		MTTSMouseTracking:   c.MouseTracking,
+		MTTSOSCColorPalette: c.OSCColorPalette,
+		MTTSScreenReader:    c.ScreenReader,
+		MTTSProxy:           c.Proxy,
+		MTTSTrueColor:       c.TrueColor,
+		MTTSMNES:            c.MNES,
+		MTTSMSLP:            c.MSLP,
+		MTTSSSL:             c.SSL,
+	} {
+		if set {
+			flags |= flag
+		}
+	}
+
+	return flags
+}
+
+// ParseMTTS parses a terminal type of the form "MTTS <bitfield>". ok is false if the terminal type
+// isn't an MTTS bitfield.
+func ParseMTTS(terminalType string) (flags MTTSFlags, ok bool) {
+	prefix, bits, hasSpace := strings.Cut(strings.TrimSpace(terminalType), " ")
+	if !hasSpace || !strings.EqualFold(prefix, "MTTS") {
+		return 0, false
+	}
+
+	value, err := strconv.Atoi(strings.TrimSpace(bits))
+	if err != nil || value < 0 {
+		return 0, false
+	}
+
+	return MTTSFlags(value), true
+}
+
+// MTTSTerminals builds the terminal types that an MTTS client reports to TTYPE, in order: the
+// client's name, its terminal type (such as "XTERM" or "ANSI"), and the MTTS bitfield. The result
+// can be passed to RegisterTTYPE or SetLocalTerminals.
+func MTTSTerminals(clientName string, terminalType string, flags MTTSFlags) []string {
+	return []string{clientName, terminalType, flags.TerminalType()}
+}
+
+// TTYPEMTTSEvent is raised along with TTYPERemoteTerminalsUpdatedEvent when the remote's terminal
+// types include an MTTS bitfield
+type TTYPEMTTSEvent struct {
+	BaseTelOptEvent
+	// ClientName is the first terminal type the remote reported, which MTTS clients use for
+	// their name
+	ClientName   string
+	Flags        MTTSFlags
+	Capabilities MTTSCapabilities
+}
+
+func (e TTYPEMTTSEvent) String() string {
+	return fmt.Sprintf("TTYPE- MTTS Client %s: %s", e.ClientName, e.Flags)
+}
+
 type TTYPERemoteTerminalsUpdatedEvent struct {
 	BaseTelOptEvent
 	RemoteTerminals []string
@@ -26,6 +165,58 @@ func (e TTYPERemoteTerminalsUpdatedEvent) String() string {
 	return fmt.Sprintf("TTYPE- Terminals Updated: %+v", e.RemoteTerminals)
 }
 
+// TTYPETerminalSelectedEvent is raised once the remote has finished reporting its terminal types,
+// with the terminal type chosen from them
+type TTYPETerminalSelectedEvent struct {
+	BaseTelOptEvent
+	Terminal string
+}
+
+func (e TTYPETerminalSelectedEvent) String() string {
+	return fmt.Sprintf("TTYPE- Terminal Selected: %s", e.Terminal)
+}
+
+// TTYPEChooseTerminalFunc chooses the terminal type to use from the types the remote reported, in
+// the order they were reported
+type TTYPEChooseTerminalFunc func(terminals []string) string
+
+// ttypePreferences are terminal type prefixes in order of preference
+var ttypePreferences = []string{"XTERM", "ANSI", "VT100"}
+
+// ChooseTTYPETerminal is the default TTYPEChooseTerminalFunc. It prefers XTERM, then ANSI, then
+// VT100, matching the start of each terminal type without regard to case, so XTERM-256COLOR is an
+// XTERM. If none of those were reported but the remote reported an MTTS bitfield, ANSI or VT100
+// is chosen according to its flags. Otherwise, the first terminal type is chosen, or an empty
+// string if there weren't any.
+func ChooseTTYPETerminal(terminals []string) string {
+	for _, preference := range ttypePreferences {
+		for _, terminal := range terminals {
+			if len(terminal) >= len(preference) && strings.EqualFold(terminal[:len(preference)], preference) {
+				return terminal
+			}
+		}
+	}
+
+	for _, terminal := range terminals {
+		flags, isMTTS := ParseMTTS(terminal)
+		if !isMTTS {
+			continue
+		}
+
+		if flags&MTTSANSI != 0 {
+			return "ANSI"
+		} else if flags&MTTSVT100 != 0 {
+			return "VT100"
+		}
+	}
+
+	if len(terminals) == 0 {
+		return ""
+	}
+
+	return terminals[0]
+}
+
 func RegisterTTYPE(usage telnet.TelOptUsage, localTerminals []string) telnet.TelnetOption {
 	return &TTYPE{
 		BaseTelOpt: NewBaseTelOpt(ttype, "TTYPE", usage),
@@ -43,7 +234,13 @@ type TTYPE struct {
 	localTerminalCursor int
 	localTerminals      []string
 
-	remoteTerminals []string
+	remoteTerminals  []string
+	remoteComplete   bool
+	chooseTerminal   TTYPEChooseTerminalFunc
+	selectedTerminal string
+
+	hintTerminals []string
+	hintSelected  string
 }
 
 func (o *TTYPE) writeRequestSend() {
@@ -90,6 +287,8 @@ func (o *TTYPE) TransitionRemoteState(newState telnet.TelOptState) (func() error
 		defer o.remoteTerminalLock.Unlock()
 
 		o.remoteTerminals = nil
+		o.remoteComplete = false
+		o.selectedTerminal = ""
 
 		return postSend, nil
 	} else if newState == telnet.TelOptActive {
@@ -148,15 +347,20 @@ func (o *TTYPE) addTerminal(subnegotiation []byte) bool {
 		return false
 	}
 
+	o.remoteComplete = true
 	o.Terminal().Keyboard().ClearLock(ttypeKeyboardLock)
 	return true
 }
 
-func (o *TTYPE) Subnegotiate(subnegotiation []byte) error {
+func (o *TTYPE) ValidateSubnegotiation(subnegotiation []byte) error {
 	if len(subnegotiation) < 1 {
-		return errors.New("ttype: received empty subnegotiation")
+		return telnet.ProtocolErrorf("ttype: received empty subnegotiation")
 	}
 
+	return nil
+}
+
+func (o *TTYPE) Subnegotiate(subnegotiation []byte) error {
 	// Remote is sending us an IS subnegotation giving us a terminal
 	if subnegotiation[0] == ttypeIS {
 		if o.RemoteState() != telnet.TelOptActive {
@@ -165,9 +369,25 @@ func (o *TTYPE) Subnegotiate(subnegotiation []byte) error {
 
 		complete := o.addTerminal(subnegotiation)
 		if complete {
+			terminals := o.GetRemoteTerminals()
 			o.Terminal().RaiseTelOptEvent(TTYPERemoteTerminalsUpdatedEvent{
 				BaseTelOptEvent: BaseTelOptEvent{o},
-				RemoteTerminals: o.GetRemoteTerminals(),
+				RemoteTerminals: terminals,
+			})
+
+			flags, isMTTS := findMTTS(terminals)
+			if isMTTS {
+				o.Terminal().RaiseTelOptEvent(TTYPEMTTSEvent{
+					BaseTelOptEvent: BaseTelOptEvent{o},
+					ClientName:      terminals[0],
+					Flags:           flags,
+					Capabilities:    flags.Capabilities(),
+				})
+			}
+
+			o.Terminal().RaiseTelOptEvent(TTYPETerminalSelectedEvent{
+				BaseTelOptEvent: BaseTelOptEvent{o},
+				Terminal:        o.selectTerminal(terminals),
 			})
 		}
 
@@ -176,7 +396,10 @@ func (o *TTYPE) Subnegotiate(subnegotiation []byte) error {
 
 	// Remote is sending us a SEND request to give them a terminal
 	if subnegotiation[0] == ttypeSEND {
-		if o.LocalState() != telnet.TelOptActive {
+		// A remote answering our WILL may send SEND ahead of its DO (this library does), so
+		// a requested local side is good enough
+		localState := o.LocalState()
+		if localState != telnet.TelOptActive && localState != telnet.TelOptRequested {
 			return nil
 		}
 
@@ -211,9 +434,97 @@ func (o *TTYPE) SetLocalTerminals(terminals []string) {
 	o.localTerminals = terminals
 }
 
+// GetRemoteTerminals returns the terminal types the remote reported. Until the remote has
+// finished reporting them, the hint provided with SetRemoteTerminalsHint is returned instead,
+// if there is one.
 func (o *TTYPE) GetRemoteTerminals() []string {
 	o.remoteTerminalLock.Lock()
 	defer o.remoteTerminalLock.Unlock()
 
+	if !o.remoteComplete && o.hintTerminals != nil {
+		return o.hintTerminals
+	}
+
 	return o.remoteTerminals
 }
+
+// SetRemoteTerminalsHint provides terminal types to use for the remote until it has finished
+// reporting its own, such as the terminal types it reported in a previous session. The hint is
+// returned by GetRemoteTerminals, GetRemoteMTTS, and SelectedTerminal in the meantime, and is
+// used again if TTYPE is deactivated, but no events are raised for it.
+func (o *TTYPE) SetRemoteTerminalsHint(terminals []string) {
+	o.remoteTerminalLock.Lock()
+	choose := o.chooseTerminal
+	o.remoteTerminalLock.Unlock()
+
+	if choose == nil {
+		choose = ChooseTTYPETerminal
+	}
+
+	var selected string
+	if len(terminals) > 0 {
+		selected = choose(terminals)
+	}
+
+	o.remoteTerminalLock.Lock()
+	defer o.remoteTerminalLock.Unlock()
+
+	o.hintTerminals = terminals
+	o.hintSelected = selected
+}
+
+// SetChooseTerminal replaces the function used to choose a terminal type once the remote has
+// finished reporting them. If choose is nil, ChooseTTYPETerminal is used.
+func (o *TTYPE) SetChooseTerminal(choose TTYPEChooseTerminalFunc) {
+	o.remoteTerminalLock.Lock()
+	defer o.remoteTerminalLock.Unlock()
+
+	o.chooseTerminal = choose
+}
+
+func (o *TTYPE) selectTerminal(terminals []string) string {
+	o.remoteTerminalLock.Lock()
+	choose := o.chooseTerminal
+	o.remoteTerminalLock.Unlock()
+
+	if choose == nil {
+		choose = ChooseTTYPETerminal
+	}
+	selected := choose(terminals)
+
+	o.remoteTerminalLock.Lock()
+	defer o.remoteTerminalLock.Unlock()
+
+	o.selectedTerminal = selected
+	return selected
+}
+
+// SelectedTerminal returns the terminal type chosen from the remote's terminal types, or an empty
+// string if the remote hasn't finished reporting them and no hint was provided with
+// SetRemoteTerminalsHint
+func (o *TTYPE) SelectedTerminal() string {
+	o.remoteTerminalLock.Lock()
+	defer o.remoteTerminalLock.Unlock()
+
+	if !o.remoteComplete && o.hintTerminals != nil {
+		return o.hintSelected
+	}
+
+	return o.selectedTerminal
+}
+
+// GetRemoteMTTS returns the MTTS bitfield the remote reported, if any
+func (o *TTYPE) GetRemoteMTTS() (flags MTTSFlags, ok bool) {
+	return findMTTS(o.GetRemoteTerminals())
+}
+
+func findMTTS(terminals []string) (flags MTTSFlags, ok bool) {
+	for _, terminal := range terminals {
+		flags, ok = ParseMTTS(terminal)
+		if ok {
+			return flags, true
+		}
+	}
+
+	return 0, false
+}
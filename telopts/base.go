@@ -22,14 +22,14 @@ type BaseTelOpt struct {
 	terminal    *telnet.Terminal
 	localState  uint32
 	remoteState uint32
-	usage       telnet.TelOptUsage
+	usage       uint32
 }
 
 func NewBaseTelOpt(code telnet.TelOptCode, name string, usage telnet.TelOptUsage) BaseTelOpt {
 	return BaseTelOpt{
 		code:        code,
 		name:        name,
-		usage:       usage,
+		usage:       uint32(usage),
 		localState:  uint32(telnet.TelOptInactive),
 		remoteState: uint32(telnet.TelOptInactive),
 	}
@@ -52,7 +52,13 @@ func (o *BaseTelOpt) RemoteState() telnet.TelOptState {
 }
 
 func (o *BaseTelOpt) Usage() telnet.TelOptUsage {
-	return o.usage
+	return telnet.TelOptUsage(atomic.LoadUint32(&o.usage))
+}
+
+// SetUsage modifies the permitted usage of this telopt. Consumers should not call this
+// directly- use Terminal.SetTelOptUsage so that negotiations are re-evaluated.
+func (o *BaseTelOpt) SetUsage(usage telnet.TelOptUsage) {
+	atomic.StoreUint32(&o.usage, uint32(usage))
 }
 
 func (o *BaseTelOpt) Initialize(terminal *telnet.Terminal) {
@@ -75,7 +81,7 @@ func (o *BaseTelOpt) TransitionRemoteState(newState telnet.TelOptState) (func()
 }
 
 func (o *BaseTelOpt) Subnegotiate(subnegotiation []byte) error {
-	return fmt.Errorf("%s: unexpected subnegotiation %+v", strings.ToLower(o.name), subnegotiation)
+	return telnet.ProtocolErrorf("%s: unexpected subnegotiation %+v", strings.ToLower(o.name), subnegotiation)
 }
 
 func (o *BaseTelOpt) SubnegotiationString(subnegotiation []byte) (string, error) {
@@ -0,0 +1,194 @@
+package telopts
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/moodclient/telnet"
+)
+
+// subnegotiationFraming is the number of bytes IAC SB <option> and IAC SE add to a subnegotiation
+const subnegotiationFraming = 5
+
+// SubnegotiationWireSize returns the number of bytes a subnegotiation with the provided payload
+// takes up on the wire, including IAC SB <option> and IAC SE, with each 255 byte doubled
+func SubnegotiationWireSize(payload []byte) int {
+	return subnegotiationFraming + len(payload) + bytes.Count(payload, []byte{telnet.IAC})
+}
+
+// SubnegotiationChunker splits payloads that are too large to send in one subnegotiation into
+// several. The receiving side of this library will not wait for a subnegotiation larger than
+// telnet.MaxSubnegotiationSize, and many MUD clients and servers have much smaller limits, so
+// telopts that can produce large payloads, such as MSDP or GMCP dumps, should send them through
+// a chunker.
+//
+// There are two ways to chunk a payload, depending on the option. If the option's messages can
+// be understood on their own, such as MSDP variables, Boundary should split the payload between
+// messages, and the remote needs no help to receive it. Otherwise, Frame should mark each chunk
+// so that the remote can put the payload back together, which a telopt built on this library
+// can do with SubnegotiationReassembler. Both can be used together.
+//
+// The zero value splits payloads at arbitrary bytes into subnegotiations no larger than
+// telnet.MaxSubnegotiationSize.
+type SubnegotiationChunker struct {
+	// MaxSize can be left at zero. If populated, it is the largest subnegotiation the chunker
+	// produces, measured as by SubnegotiationWireSize. If zero, telnet.MaxSubnegotiationSize
+	// is used.
+	MaxSize int
+	// Boundary can be left nil. If populated, it is called with the part of the payload that has
+	// not been chunked yet and the number of its bytes that fit in the next chunk, and returns the
+	// number of bytes to put in the next chunk, which must be between 1 and limit. It is not
+	// called for the final chunk.
+	Boundary func(remaining []byte, limit int) int
+	// Frame can be left nil. If populated, each piece of the payload is passed to it, along with
+	// its position and whether it is the last piece, and it returns the subnegotiation to send.
+	// Frame is called even when the payload fits in a single subnegotiation.
+	Frame func(piece []byte, index int, final bool) []byte
+	// FrameOverhead is the largest number of bytes, measured as by SubnegotiationWireSize, that
+	// Frame adds to a piece
+	FrameOverhead int
+}
+
+// Chunk splits payload into subnegotiations that are no larger than the chunker's MaxSize
+func (c SubnegotiationChunker) Chunk(payload []byte) ([][]byte, error) {
+	maxSize := c.MaxSize
+	if maxSize <= 0 {
+		maxSize = telnet.MaxSubnegotiationSize
+	}
+
+	budget := maxSize - subnegotiationFraming - c.FrameOverhead
+	if budget < 2 {
+		// A piece must be able to hold at least one escaped 255
+		return nil, fmt.Errorf("telopts: subnegotiation size %d leaves no room for a payload", maxSize)
+	}
+
+	var chunks [][]byte
+	remaining := payload
+	for index := 0; ; index++ {
+		limit := chunkLimit(remaining, budget)
+		final := limit == len(remaining)
+
+		size := limit
+		if !final && c.Boundary != nil {
+			size = c.Boundary(remaining, limit)
+			if size < 1 || size > limit {
+				return nil, fmt.Errorf("telopts: subnegotiation chunk boundary %d is outside of 1-%d", size, limit)
+			}
+		}
+
+		piece := remaining[:size]
+		remaining = remaining[size:]
+		final = len(remaining) == 0
+
+		if c.Frame != nil {
+			piece = c.Frame(piece, index, final)
+		}
+
+		chunks = append(chunks, piece)
+		if final {
+			return chunks, nil
+		}
+	}
+}
+
+// chunkLimit returns the number of bytes at the start of payload that fit in budget bytes once
+// 255 bytes have been escaped
+func chunkLimit(payload []byte, budget int) int {
+	size := 0
+	for i, b := range payload {
+		size++
+		if b == telnet.IAC {
+			size++
+		}
+
+		if size > budget {
+			return i
+		}
+	}
+
+	return len(payload)
+}
+
+// Write chunks payload and queues each chunk on the keyboard as a subnegotiation for option
+func (c SubnegotiationChunker) Write(keyboard *telnet.TelnetKeyboard, option telnet.TelOptCode, payload []byte) error {
+	chunks, err := c.Chunk(payload)
+	if err != nil {
+		return err
+	}
+
+	for _, chunk := range chunks {
+		keyboard.WriteCommand(telnet.Command{
+			OpCode:         telnet.SB,
+			Option:         option,
+			Subnegotiation: chunk,
+		}, nil)
+	}
+
+	return nil
+}
+
+// ErrSubnegotiationTooLarge is returned by SubnegotiationReassembler when a payload grows past
+// its MaxPayloadSize
+var ErrSubnegotiationTooLarge = errors.New("telopts: reassembled subnegotiation is too large")
+
+// SubnegotiationReassembler puts back together payloads that were split by a
+// SubnegotiationChunker with a Frame method. Telopts should pass each subnegotiation they receive
+// to Receive, and handle the payload once it is complete. The zero value is not usable: Unframe
+// must be populated.
+type SubnegotiationReassembler struct {
+	// Unframe is called with each subnegotiation that is received, and returns the piece of the
+	// payload it carries, whether it is the last piece, and a key identifying the payload it
+	// belongs to, so that pieces of different payloads can be interleaved. Subnegotiations that
+	// were not chunked should be returned as a single final piece. If Unframe returns an error,
+	// the pieces already received for the key are discarded.
+	Unframe func(subnegotiation []byte) (key string, piece []byte, final bool, err error)
+	// MaxPayloadSize can be left at zero. If populated, payloads that grow larger than this many
+	// bytes are discarded, and ErrSubnegotiationTooLarge is returned.
+	MaxPayloadSize int
+
+	lock    sync.Mutex
+	pending map[string][]byte
+}
+
+// Receive adds a received subnegotiation to the payload it belongs to. If the subnegotiation
+// completed its payload, the payload is returned along with true.
+func (r *SubnegotiationReassembler) Receive(subnegotiation []byte) ([]byte, bool, error) {
+	key, piece, final, err := r.Unframe(subnegotiation)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err != nil {
+		delete(r.pending, key)
+		return nil, false, err
+	}
+
+	payload := append(r.pending[key], piece...)
+	if r.MaxPayloadSize > 0 && len(payload) > r.MaxPayloadSize {
+		delete(r.pending, key)
+		return nil, false, ErrSubnegotiationTooLarge
+	}
+
+	if final {
+		delete(r.pending, key)
+		return payload, true, nil
+	}
+
+	if r.pending == nil {
+		r.pending = make(map[string][]byte)
+	}
+	r.pending[key] = payload
+
+	return nil, false, nil
+}
+
+// Reset discards all partially-received payloads. Telopts should call it when the option is
+// deactivated.
+func (r *SubnegotiationReassembler) Reset() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	clear(r.pending)
+}
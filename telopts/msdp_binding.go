@@ -0,0 +1,323 @@
+package telopts
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/moodclient/telnet"
+)
+
+// MSDPBindingFailedEvent is raised when a variable bound with BindMSDPVar is received but can't be
+// decoded into the bound type
+type MSDPBindingFailedEvent struct {
+	BaseTelOptEvent
+	Name string
+	Err  error
+}
+
+func (e MSDPBindingFailedEvent) String() string {
+	return fmt.Sprintf("MSDP Binding Failed For %s: %s", e.Name, e.Err)
+}
+
+// BindMSDPVar calls handler with the remote's value for the named variable, decoded into T with
+// DecodeMSDPValue, whenever the remote sends it, until the returned subscription is unregistered
+// or the terminal shuts down. If the remote has already sent the variable, handler is called with
+// its current value right away, so it may be called twice with the same value if the variable
+// arrives while BindMSDPVar is running. Values that can't be decoded raise MSDPBindingFailedEvent
+// instead. MSDP must be registered with a terminal.
+//
+//	type Room struct {
+//		VNum  int `msdp:"VNUM"`
+//		Name  string
+//		Exits map[string]int
+//	}
+//
+//	telopts.BindMSDPVar(msdp, "ROOM", func(room Room) { ... })
+func BindMSDPVar[T any](o *MSDP, name string, handler func(value T)) *telnet.Subscription {
+	deliver := func(value any) {
+		var decoded T
+		err := DecodeMSDPValue(value, &decoded)
+		if err != nil {
+			o.Terminal().RaiseTelOptEvent(MSDPBindingFailedEvent{
+				BaseTelOptEvent: BaseTelOptEvent{o},
+				Name:            name,
+				Err:             err,
+			})
+			return
+		}
+
+		handler(decoded)
+	}
+
+	// Subscribe before reading the current value, so that no change can slip between them
+	subscription := o.Terminal().RegisterTelOptEventHook(func(_ *telnet.Terminal, event telnet.TelOptEvent) {
+		received, isReceived := event.(MSDPVarsReceivedEvent)
+		if !isReceived || received.Option() != o {
+			return
+		}
+
+		for _, variable := range received.Vars {
+			if variable.Name == name {
+				deliver(variable.Value)
+			}
+		}
+	})
+
+	value, hasValue := o.RemoteVar(name)
+	if hasValue {
+		deliver(value)
+	}
+
+	return subscription
+}
+
+// MSDPRemoteVarAs returns the remote's current value for the named variable, decoded into T with
+// DecodeMSDPValue. ok is false if the remote hasn't sent the variable.
+func MSDPRemoteVarAs[T any](o *MSDP, name string) (value T, ok bool, err error) {
+	raw, hasValue := o.RemoteVar(name)
+	if !hasValue {
+		return value, false, nil
+	}
+
+	err = DecodeMSDPValue(raw, &value)
+	return value, true, err
+}
+
+var textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+
+// DecodeMSDPValue stores an MSDP value (a string, a []any for arrays, or a map[string]any for
+// tables) in the value pointed to by target:
+//
+//   - Strings can be decoded into strings, bools, numbers, and types that implement
+//     encoding.TextUnmarshaler. Empty strings decode to the zero value, since servers often
+//     send them for variables that aren't set.
+//   - Arrays can be decoded into slices. A string is decoded as an array with one item, since
+//     an array with one item is indistinguishable from a plain value in some servers' output.
+//   - Tables can be decoded into maps with string keys, and into structs. A table entry is stored
+//     in the struct field with an `msdp:"NAME"` tag, or otherwise the field whose name matches
+//     the entry's without regard to case or underscores, so HEALTH_MAX is stored in HealthMax.
+//     Entries without a field are ignored, and fields tagged `msdp:"-"` are skipped.
+//   - Any value can be decoded into an interface that it implements, such as any.
+//
+// Pointers are allocated as needed.
+func DecodeMSDPValue(value any, target any) error {
+	targetValue := reflect.ValueOf(target)
+	if targetValue.Kind() != reflect.Pointer || targetValue.IsNil() {
+		return fmt.Errorf("msdp: cannot decode into non-pointer %T", target)
+	}
+
+	return decodeMSDPValue(value, targetValue.Elem(), "")
+}
+
+func msdpDecodeError(path string, value any, target reflect.Value) error {
+	if path == "" {
+		return fmt.Errorf("msdp: cannot decode %T into %s", value, target.Type())
+	}
+
+	return fmt.Errorf("msdp: %s: cannot decode %T into %s", path, value, target.Type())
+}
+
+func decodeMSDPValue(value any, target reflect.Value, path string) error {
+	if target.Kind() == reflect.Pointer {
+		if target.IsNil() {
+			target.Set(reflect.New(target.Type().Elem()))
+		}
+
+		return decodeMSDPValue(value, target.Elem(), path)
+	}
+
+	if target.Kind() == reflect.Interface {
+		if value == nil {
+			target.SetZero()
+			return nil
+		}
+
+		raw := reflect.ValueOf(value)
+		if !raw.Type().AssignableTo(target.Type()) {
+			return msdpDecodeError(path, value, target)
+		}
+
+		target.Set(raw)
+		return nil
+	}
+
+	text, isText := value.(string)
+	if isText && target.CanAddr() && target.Addr().Type().Implements(textUnmarshalerType) {
+		err := target.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(text))
+		return wrapMSDPDecodeError(path, err)
+	}
+
+	switch target.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Uintptr, reflect.Float32, reflect.Float64:
+		if !isText {
+			return msdpDecodeError(path, value, target)
+		}
+
+		return decodeMSDPText(text, target, path)
+	case reflect.Slice:
+		return decodeMSDPArray(value, target, path)
+	case reflect.Map:
+		return decodeMSDPMap(value, target, path)
+	case reflect.Struct:
+		return decodeMSDPStruct(value, target, path)
+	default:
+		return msdpDecodeError(path, value, target)
+	}
+}
+
+func decodeMSDPText(text string, target reflect.Value, path string) error {
+	if target.Kind() == reflect.String {
+		target.SetString(text)
+		return nil
+	}
+
+	text = strings.TrimSpace(text)
+	if text == "" {
+		target.SetZero()
+		return nil
+	}
+
+	var err error
+	switch target.Kind() {
+	case reflect.Bool:
+		var parsed bool
+		parsed, err = strconv.ParseBool(text)
+		target.SetBool(parsed)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var parsed int64
+		parsed, err = strconv.ParseInt(text, 10, target.Type().Bits())
+		target.SetInt(parsed)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var parsed uint64
+		parsed, err = strconv.ParseUint(text, 10, target.Type().Bits())
+		target.SetUint(parsed)
+	default:
+		var parsed float64
+		parsed, err = strconv.ParseFloat(text, target.Type().Bits())
+		target.SetFloat(parsed)
+	}
+
+	return wrapMSDPDecodeError(path, err)
+}
+
+func wrapMSDPDecodeError(path string, err error) error {
+	if err == nil {
+		return nil
+	} else if path == "" {
+		return fmt.Errorf("msdp: %w", err)
+	}
+
+	return fmt.Errorf("msdp: %s: %w", path, err)
+}
+
+func decodeMSDPArray(value any, target reflect.Value, path string) error {
+	var items []any
+	switch typed := value.(type) {
+	case []any:
+		items = typed
+	case string:
+		if typed != "" {
+			items = []any{typed}
+		}
+	default:
+		return msdpDecodeError(path, value, target)
+	}
+
+	slice := reflect.MakeSlice(target.Type(), len(items), len(items))
+	for i, item := range items {
+		err := decodeMSDPValue(item, slice.Index(i), fmt.Sprintf("%s[%d]", path, i))
+		if err != nil {
+			return err
+		}
+	}
+
+	target.Set(slice)
+	return nil
+}
+
+func decodeMSDPMap(value any, target reflect.Value, path string) error {
+	table, isTable := value.(map[string]any)
+	if !isTable || target.Type().Key().Kind() != reflect.String {
+		return msdpDecodeError(path, value, target)
+	}
+
+	decoded := reflect.MakeMapWithSize(target.Type(), len(table))
+	for key, item := range table {
+		element := reflect.New(target.Type().Elem()).Elem()
+		err := decodeMSDPValue(item, element, msdpPath(path, key))
+		if err != nil {
+			return err
+		}
+
+		decoded.SetMapIndex(reflect.ValueOf(key).Convert(target.Type().Key()), element)
+	}
+
+	target.Set(decoded)
+	return nil
+}
+
+func decodeMSDPStruct(value any, target reflect.Value, path string) error {
+	table, isTable := value.(map[string]any)
+	if !isTable {
+		return msdpDecodeError(path, value, target)
+	}
+
+	for key, item := range table {
+		field, hasField := msdpStructField(target, key)
+		if !hasField {
+			continue
+		}
+
+		err := decodeMSDPValue(item, field, msdpPath(path, key))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// msdpStructField finds the field of target that a table entry is stored in
+func msdpStructField(target reflect.Value, key string) (reflect.Value, bool) {
+	normalizedKey := normalizeMSDPName(key)
+
+	var matched reflect.Value
+	for i := range target.NumField() {
+		field := target.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		tag, hasTag := field.Tag.Lookup("msdp")
+		if tag == "-" {
+			continue
+		}
+
+		if hasTag && tag != "" {
+			if tag == key {
+				return target.Field(i), true
+			}
+		} else if !matched.IsValid() && normalizeMSDPName(field.Name) == normalizedKey {
+			matched = target.Field(i)
+		}
+	}
+
+	return matched, matched.IsValid()
+}
+
+func normalizeMSDPName(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, "_", ""))
+}
+
+func msdpPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
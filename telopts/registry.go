@@ -0,0 +1,61 @@
+package telopts
+
+import (
+	"github.com/moodclient/telnet"
+)
+
+// bySide picks a usage depending on which side of the connection the terminal is on
+func bySide(side telnet.TerminalSide, client telnet.TelOptUsage, server telnet.TelOptUsage) telnet.TelOptUsage {
+	if side == telnet.SideServer {
+		return server
+	}
+
+	return client
+}
+
+// Telopts that need configuration that can't be guessed, like ENCRYPT's key source, aren't
+// registered here
+func init() {
+	telnet.RegisterTelOptConstructor("CHARSET", func(side telnet.TerminalSide) telnet.TelnetOption {
+		return RegisterCHARSET(bySide(side, telnet.TelOptAllowLocal|telnet.TelOptAllowRemote, telnet.TelOptRequestLocal|telnet.TelOptAllowRemote), CHARSETConfig{
+			AllowAnyCharset:   true,
+			PreferredCharsets: []string{"UTF-8", "US-ASCII"},
+		})
+	})
+	telnet.RegisterTelOptConstructor("ECHO", func(side telnet.TerminalSide) telnet.TelnetOption {
+		return RegisterECHO(bySide(side, telnet.TelOptAllowRemote, telnet.TelOptAllowLocal))
+	})
+	telnet.RegisterTelOptConstructor("ENVIRON", func(side telnet.TerminalSide) telnet.TelnetOption {
+		return RegisterENVIRON(bySide(side, telnet.TelOptAllowLocal, telnet.TelOptAllowRemote), ENVIRONConfig{})
+	})
+	telnet.RegisterTelOptConstructor("EOR", func(side telnet.TerminalSide) telnet.TelnetOption {
+		return RegisterEOR(bySide(side, telnet.TelOptRequestRemote|telnet.TelOptAllowLocal, telnet.TelOptRequestLocal|telnet.TelOptAllowRemote))
+	})
+	telnet.RegisterTelOptConstructor("MSDP", func(side telnet.TerminalSide) telnet.TelnetOption {
+		return RegisterMSDP(bySide(side, telnet.TelOptAllowRemote, telnet.TelOptAllowLocal), MSDPConfig{})
+	})
+	telnet.RegisterTelOptConstructor("MSP", func(side telnet.TerminalSide) telnet.TelnetOption {
+		return RegisterMSP(bySide(side, telnet.TelOptAllowRemote, telnet.TelOptAllowLocal))
+	})
+	telnet.RegisterTelOptConstructor("MXP", func(side telnet.TerminalSide) telnet.TelnetOption {
+		return RegisterMXP(bySide(side, telnet.TelOptAllowRemote, telnet.TelOptAllowLocal))
+	})
+	telnet.RegisterTelOptConstructor("NAWS", func(side telnet.TerminalSide) telnet.TelnetOption {
+		return RegisterNAWS(bySide(side, telnet.TelOptAllowLocal, telnet.TelOptRequestRemote))
+	})
+	telnet.RegisterTelOptConstructor("NEW-ENVIRON", func(side telnet.TerminalSide) telnet.TelnetOption {
+		return RegisterNEWENVIRON(bySide(side, telnet.TelOptAllowLocal, telnet.TelOptRequestRemote), NEWENVIRONConfig{})
+	})
+	telnet.RegisterTelOptConstructor("STATUS", func(side telnet.TerminalSide) telnet.TelnetOption {
+		return RegisterSTATUS(telnet.TelOptAllowLocal | telnet.TelOptAllowRemote)
+	})
+	telnet.RegisterTelOptConstructor("SUPPRESS-GO-AHEAD", func(side telnet.TerminalSide) telnet.TelnetOption {
+		return RegisterSUPPRESSGOAHEAD(telnet.TelOptAllowLocal | telnet.TelOptAllowRemote)
+	})
+	telnet.RegisterTelOptConstructor("TRANSMIT-BINARY", func(side telnet.TerminalSide) telnet.TelnetOption {
+		return RegisterTRANSMITBINARY(telnet.TelOptAllowLocal | telnet.TelOptAllowRemote)
+	})
+	telnet.RegisterTelOptConstructor("TTYPE", func(side telnet.TerminalSide) telnet.TelnetOption {
+		return RegisterTTYPE(bySide(side, telnet.TelOptAllowLocal, telnet.TelOptRequestRemote), nil)
+	})
+}
@@ -4,9 +4,12 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"maps"
 	"net"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 // Terminal is a wrapper around a connection to enable telnet communications
@@ -44,21 +47,61 @@ import (
 // blocking calls in hook methods that last long enough will block functioning
 // of the terminal altogether. It is the responsibility of the consumer to
 // move long-running calls to their own concurrency scheme where necessary.
+// Alternatively, TerminalConfig.PrinterDispatchQueueSize can be used to move printer
+// output hooks onto a fourth goroutine of their own.
 type Terminal struct {
-	reader             io.Reader
-	writer             io.Writer
-	side               TerminalSide
-	charset            *Charset
-	keyboard           *TelnetKeyboard
-	printer            *TelnetPrinter
-	eventPump          *terminalEventPump
-	options            map[TelOptCode]TelnetOption
-	outboundDataParser *TerminalDataParser
+	ctx          context.Context
+	reader       io.Reader
+	writer       io.Writer
+	side         TerminalSide
+	charset      *Charset
+	keyboard     *TelnetKeyboard
+	printer      *TelnetPrinter
+	eventPump    *terminalEventPump
+	options      map[TelOptCode]TelnetOption
+	negotiations map[TelOptCode]*telOptNegotiation
+	// initializing holds options that are being initialized, so that their codes can't be
+	// registered twice
+	initializing map[TelOptCode]TelnetOption
+	optionsLock  sync.RWMutex
+
+	closers               []io.Closer
+	closeOnce             sync.Once
+	closingOnce           sync.Once
+	closeReason           CloseReason
+	exitErr               error
+	stopKeyboard          context.CancelFunc
+	stopConn              context.CancelFunc
+	disableTelOptsOnClose bool
+	failFastOnPanic       bool
+	characterModePolicy   CharacterModePolicy
+
+	negotiation         *negotiationTracker
+	negotiationTimeout  time.Duration
+	negotiationTimeouts map[TelOptCode]time.Duration
+	retryNegotiation    bool
+	negotiationPolicy   NegotiationPolicyFunc
+
+	probesLock sync.Mutex
+	probes     map[TelOptCode]*remoteProbe
 
 	printerOutputHooks    *EventPublisher[TerminalData]
 	outboundDataHooks     *EventPublisher[TerminalData]
 	encounteredErrorHooks *EventPublisher[error]
 	telOptEventHooks      *EventPublisher[TelOptEvent]
+	// queuedTelOptEventHooks are fired by the event pump, in order with printer output
+	queuedTelOptEventHooks *EventPublisher[TelOptEvent]
+	telOptEventFilter      *telOptEventFilter
+
+	negotiationCompleteHooks   *EventPublisher[NegotiationCompleteEvent]
+	promptCommandsChangedHooks *EventPublisher[PromptCommandsChangedEvent]
+	lifecycleHooks             *EventPublisher[LifecycleEvent]
+	aytReceivedHooks           *EventPublisher[AYTReceivedEvent]
+	utf16DetectedHooks         *EventPublisher[UTF16DetectedEvent]
+
+	aytResponse AYTResponder
+
+	sinks sinkSet
 }
 
 // NewTerminal initializes a new terminal object from a net.Conn and begins reading from
@@ -85,15 +128,28 @@ func NewTerminalFromPipes(ctx context.Context, reader io.Reader, writer io.Write
 		return nil, err
 	}
 
-	pump := newEventPump()
+	err = charset.SetCP437ControlMode(config.CP437ControlMode)
+	if err != nil {
+		return nil, err
+	}
+
+	charset.SetBOMPolicy(config.BOMPolicy)
+	charset.SetInvalidUTF8Policy(config.InvalidUTF8Policy)
+	charset.SetUTF16Detection(config.DetectUTF16)
+
+	pump := newEventPump(config.EventQueueSize, config.PrinterDispatchQueueSize)
 
-	keyboard, err := newTelnetKeyboard(charset, writer, pump, config.KeyboardMiddlewares...)
+	keyboard, err := newTelnetKeyboard(charset, writer, pump, config)
 	if err != nil {
 		return nil, err
 	}
 
-	printer := newTelnetPrinter(charset, reader, pump)
+	printer := newTelnetPrinter(charset, reader, pump, config)
+	lifetimeCtx, lifetimeCancel := context.WithCancel(ctx)
+	connCtx, connCancel := context.WithCancel(ctx)
+	keyboardCtx, keyboardCancel := context.WithCancel(connCtx)
 	terminal := &Terminal{
+		ctx:       lifetimeCtx,
 		reader:    reader,
 		writer:    writer,
 		side:      config.Side,
@@ -103,12 +159,44 @@ func NewTerminalFromPipes(ctx context.Context, reader io.Reader, writer io.Write
 		eventPump: pump,
 		options:   make(map[TelOptCode]TelnetOption),
 
-		printerOutputHooks:    NewPublisher(config.EventHooks.PrinterOutput),
-		outboundDataHooks:     NewPublisher(config.EventHooks.OutboundData),
-		encounteredErrorHooks: NewPublisher(config.EventHooks.EncounteredError),
-		telOptEventHooks:      NewPublisher(config.EventHooks.TelOptEvent),
+		negotiations: make(map[TelOptCode]*telOptNegotiation),
+		initializing: make(map[TelOptCode]TelnetOption),
+
+		closers:               streamClosers(reader, writer),
+		stopKeyboard:          keyboardCancel,
+		stopConn:              connCancel,
+		disableTelOptsOnClose: config.DisableTelOptsOnClose,
+		failFastOnPanic:       config.FailFastOnPanic,
+		characterModePolicy:   config.CharacterModePolicy,
+		negotiationTimeout:    config.TelOptNegotiationTimeout,
+		negotiationTimeouts:   maps.Clone(config.TelOptNegotiationTimeouts),
+		retryNegotiation:      config.RetryTelOptNegotiation,
+		negotiationPolicy:     config.NegotiationPolicy,
+		aytResponse:           config.AYTResponse,
+
+		printerOutputHooks:     NewPublisher(config.EventHooks.PrinterOutput),
+		outboundDataHooks:      NewPublisher(config.EventHooks.OutboundData),
+		encounteredErrorHooks:  NewPublisher(config.EventHooks.EncounteredError),
+		telOptEventHooks:       NewPublisher(config.EventHooks.TelOptEvent),
+		queuedTelOptEventHooks: NewPublisher(config.EventHooks.QueuedTelOptEvent),
+		telOptEventFilter:      newTelOptEventFilter(config.DeduplicateTelOptEvents),
+
+		negotiationCompleteHooks:   NewPublisher(config.EventHooks.NegotiationComplete),
+		promptCommandsChangedHooks: NewPublisher(config.EventHooks.PromptCommandsChanged),
+		lifecycleHooks:             NewPublisher(config.EventHooks.Lifecycle),
+		aytReceivedHooks:           NewPublisher(config.EventHooks.AYTReceived),
+		utf16DetectedHooks:         NewPublisher(config.EventHooks.UTF16Detected),
 	}
 	keyboard.terminal = terminal
+	if !config.CharacterModePolicy.SendsGoAhead(false) {
+		keyboard.promptCommands.ClearPromptCommand(PromptCommandGA)
+	}
+	keyboard.promptCommands.changed = terminal.keyboardPromptCommandsChanged
+	printer.promptCommands.changed = terminal.printerPromptCommandsChanged
+	charset.utf16Detected = func(event UTF16DetectedEvent) {
+		terminal.utf16DetectedHooks.Fire(terminal, event)
+	}
+	terminal.negotiation = newNegotiationTracker(config, terminal.negotiationComplete)
 
 	printerLineOut := func(t *Terminal, data TerminalData) {
 		terminal.printerOutputHooks.Fire(t, data)
@@ -116,16 +204,28 @@ func NewTerminalFromPipes(ctx context.Context, reader io.Reader, writer io.Write
 
 	printer.middlewares = NewMiddlewareStack(printerLineOut, config.PrinterMiddlewares...)
 
-	terminal.outboundDataParser = NewTerminalDataParser()
-	err = terminal.initTelopts(config.TelOpts)
+	options, err := configuredTelOpts(config)
+	if err != nil {
+		keyboardCancel()
+		connCancel()
+		lifetimeCancel()
+		return nil, err
+	}
+
+	err = terminal.initTelopts(options)
 	if err != nil {
+		keyboardCancel()
+		connCancel()
+		lifetimeCancel()
 		return nil, err
 	}
 
 	// Run the keyboard, printer, and terminal loop until the connection is closed
 	// or the consumer kills the context
 	go func() {
-		connCtx, connCancel := context.WithCancel(ctx)
+		// Hooks may be relying on the terminal context to be cancelled once the terminal
+		// has finished running
+		defer lifetimeCancel()
 		defer connCancel()
 
 		terminalCtx, terminalCancel := context.WithCancel(context.Background())
@@ -136,28 +236,48 @@ func NewTerminalFromPipes(ctx context.Context, reader io.Reader, writer io.Write
 
 		// These goroutines will stop whenever the connection dies or whenever the
 		// original context passed in by the consumer is cancelled
-		go keyboard.keyboardLoop(connCtx)
+		go keyboard.keyboardLoop(keyboardCtx)
 		go printer.printerLoop(connCtx, terminal)
 
 		// We use WaitForExit purely to ensure that we don't cancel the terminal loop
 		// context until the keyboard and printer are closed- the consumer will actually
-		// care about the error when they call it but we don't
-		_ = printer.waitForExit()
+		// care about the error when they call it, and so will LifecycleClosed hooks
+		terminal.connectionEnded(ctx, printer.waitForExit())
 
 		// If the printer closed because the conn died, the keyboard might not notice- cancel explicitly
 		connCancel()
 		keyboard.waitForExit()
 	}()
 
+	terminal.raiseLifecycleEvent(LifecycleEvent{Phase: LifecycleConnected})
+
 	// Kick off telopt negotiation by writing commands for our requested telopts
 	err = terminal.writeTelOptRequests()
 	if err != nil {
+		// The loops shut down, and cancel the lifetime context, once the connection is cancelled
+		keyboardCancel()
+		connCancel()
 		return nil, err
 	}
+	terminal.raiseLifecycleEvent(LifecycleEvent{Phase: LifecycleNegotiationStarted})
+	terminal.checkNegotiationResolved()
 
 	return terminal, nil
 }
 
+// Context returns a context that is cancelled when the terminal shuts down, either due to
+// the context passed to NewTerminal being cancelled, or due to the underlying data streams
+// closing. Hooks can use this to bound their own I/O.
+func (t *Terminal) Context() context.Context {
+	return t.ctx
+}
+
+// CharacterModePolicy returns the policy used to interpret ECHO and SUPPRESS-GO-AHEAD, from
+// TerminalConfig.CharacterModePolicy
+func (t *Terminal) CharacterModePolicy() CharacterModePolicy {
+	return t.characterModePolicy
+}
+
 // Side returns a TerminalSide object indicating whether the
 // terminal represents a client or server
 func (t *Terminal) Side() TerminalSide {
@@ -199,8 +319,22 @@ func (t *Terminal) encounteredOutboundData(output TerminalData) {
 // TelOptStateChangeEvent when negotiations cause a telopt to change its state.  This is good
 // for event-delivery telopts such as GCMP, but it can also be used for things like NAWS to alert
 // the consumer that basic data has been collected from the remote.
+//
+// Hooks registered with TelOptEventSynchronous delivery are called before this method returns,
+// and hooks registered with TelOptEventQueued delivery are called later, in order with printer
+// output. See TelOptEventDelivery.
+//
+// Events may be suppressed if their type has been marked with DeduplicateTelOptEvents.
 func (t *Terminal) RaiseTelOptEvent(event TelOptEvent) {
+	if t.telOptEventFilter.suppress(event) {
+		return
+	}
+
 	t.telOptEventHooks.Fire(t, event)
+
+	if !t.queuedTelOptEventHooks.empty() {
+		t.eventPump.EncounteredTelOptEvent(event)
+	}
 }
 
 // CommandString converts a Command object into a legible stream. This can be useful
@@ -216,13 +350,13 @@ func (t *Terminal) CommandString(c Command) string {
 
 	sb.WriteString(opCode)
 
-	if c.OpCode == GA || c.OpCode == NOP || c.OpCode == EOR {
+	if !hasOption(c.OpCode) {
 		return sb.String()
 	}
 
 	sb.WriteByte(' ')
 
-	option, hasOption := t.options[c.Option]
+	option, hasOption := t.telOpt(c.Option)
 
 	if !hasOption {
 		sb.WriteString("? Unknown Option ")
@@ -267,31 +401,147 @@ func (t *Terminal) WaitForExit() error {
 }
 
 // RegisterPrinterOutputHook will register an event to be called when data is received
-// from the printer.
-func (t *Terminal) RegisterPrinterOutputHook(printerOutput TerminalDataHandler) {
-	t.printerOutputHooks.Register(EventHook[TerminalData](printerOutput))
+// from the printer. The returned Subscription can be used to unregister the hook.
+func (t *Terminal) RegisterPrinterOutputHook(printerOutput TerminalDataHandler) *Subscription {
+	return t.printerOutputHooks.Register(EventHook[TerminalData](printerOutput))
 }
 
 // RegisterOutboundDataHook will register an event to be called when something
-// has been sent from the keyboard. This is primarily useful for debug logging.
-func (t *Terminal) RegisterOutboundDataHook(outboundText TerminalDataHandler) {
-	t.outboundDataHooks.Register(EventHook[TerminalData](outboundText))
+// has been sent from the keyboard. This is primarily useful for debug logging. The
+// returned Subscription can be used to unregister the hook.
+func (t *Terminal) RegisterOutboundDataHook(outboundText TerminalDataHandler) *Subscription {
+	return t.outboundDataHooks.Register(EventHook[TerminalData](outboundText))
 }
 
 // RegisterEncounteredErrorHook will register an event to be called when an error
 // was encountered by the terminal or one of its subsidiaries. Not all errors will
 // be sent via this hook: just errors that are not returned to the user immediately.
+// Errors may be raised from any goroutine, so the hook may run concurrently with itself.
 //
 // If a method call to Terminal or one of its subsidiaries immediately returns an error
 // to the user, it will not be delivered via this hook. If an error ends terminal
 // processing immediately, it will not be delivered via this hook, it will be delivered
 // via WaitForExit.
-func (t *Terminal) RegisterEncounteredErrorHook(encounteredError ErrorHandler) {
-	t.encounteredErrorHooks.Register(EventHook[error](encounteredError))
+//
+// The returned Subscription can be used to unregister the hook.
+func (t *Terminal) RegisterEncounteredErrorHook(encounteredError ErrorHandler) *Subscription {
+	return t.encounteredErrorHooks.Register(EventHook[error](encounteredError))
 }
 
 // RegisterTelOptEventHook will register an event to be called when a telopt delivers
-// an event via RaiseTelOptEvent.
-func (t *Terminal) RegisterTelOptEventHook(telOptEvent TelOptEventHandler) {
-	t.telOptEventHooks.Register(EventHook[TelOptEvent](telOptEvent))
+// an event via RaiseTelOptEvent. The hook uses TelOptEventSynchronous delivery, so it is
+// called on whichever goroutine raised the event and may run concurrently with itself. The
+// returned Subscription can be used to unregister the hook.
+func (t *Terminal) RegisterTelOptEventHook(telOptEvent TelOptEventHandler) *Subscription {
+	return t.telOptEventHooks.Register(EventHook[TelOptEvent](telOptEvent))
+}
+
+// RegisterTelOptEventHookWithDelivery works like RegisterTelOptEventHook, but allows the
+// hook to choose when it is called relative to the printer. See TelOptEventDelivery.
+func (t *Terminal) RegisterTelOptEventHookWithDelivery(telOptEvent TelOptEventHandler, delivery TelOptEventDelivery) *Subscription {
+	return t.telOptEventPublisher(delivery).Register(EventHook[TelOptEvent](telOptEvent))
+}
+
+func (t *Terminal) telOptEventPublisher(delivery TelOptEventDelivery) *EventPublisher[TelOptEvent] {
+	if delivery == TelOptEventQueued {
+		return t.queuedTelOptEventHooks
+	}
+
+	return t.telOptEventHooks
+}
+
+// RegisterPrinterOutputHookWithContext works like RegisterPrinterOutputHook, but the
+// registered hook will receive the terminal's context, which is cancelled when the terminal
+// shuts down.
+func (t *Terminal) RegisterPrinterOutputHookWithContext(printerOutput ContextTerminalDataHandler) *Subscription {
+	return t.printerOutputHooks.Register(withContext(t, printerOutput))
+}
+
+// RegisterOutboundDataHookWithContext works like RegisterOutboundDataHook, but the
+// registered hook will receive the terminal's context, which is cancelled when the terminal
+// shuts down.
+func (t *Terminal) RegisterOutboundDataHookWithContext(outboundText ContextTerminalDataHandler) *Subscription {
+	return t.outboundDataHooks.Register(withContext(t, outboundText))
+}
+
+// RegisterEncounteredErrorHookWithContext works like RegisterEncounteredErrorHook, but the
+// registered hook will receive the terminal's context, which is cancelled when the terminal
+// shuts down.
+func (t *Terminal) RegisterEncounteredErrorHookWithContext(encounteredError ContextErrorHandler) *Subscription {
+	return t.encounteredErrorHooks.Register(withContext(t, encounteredError))
+}
+
+// RegisterTelOptEventHookWithContext works like RegisterTelOptEventHook, but the
+// registered hook will receive the terminal's context, which is cancelled when the terminal
+// shuts down.
+func (t *Terminal) RegisterTelOptEventHookWithContext(telOptEvent ContextTelOptEventHandler) *Subscription {
+	return t.telOptEventHooks.Register(withContext(t, telOptEvent))
+}
+
+// RegisterNegotiationCompleteHook will register an event to be called once the initial telopt
+// negotiation with the remote has settled. See Terminal.WaitForNegotiation for more information.
+// The returned Subscription can be used to unregister the hook.
+func (t *Terminal) RegisterNegotiationCompleteHook(negotiationComplete NegotiationCompleteHandler) *Subscription {
+	return t.negotiationCompleteHooks.Register(EventHook[NegotiationCompleteEvent](negotiationComplete))
+}
+
+// RegisterNegotiationCompleteHookWithContext works like RegisterNegotiationCompleteHook, but the
+// registered hook will receive the terminal's context, which is cancelled when the terminal
+// shuts down.
+func (t *Terminal) RegisterNegotiationCompleteHookWithContext(negotiationComplete ContextNegotiationCompleteHandler) *Subscription {
+	return t.negotiationCompleteHooks.Register(withContext(t, negotiationComplete))
+}
+
+// RegisterLifecycleHook will register an event to be called when the terminal enters a new phase
+// of its session. Hooks registered after NewTerminal returns will not receive LifecycleConnected or
+// LifecycleNegotiationStarted- use EventHooks.Lifecycle for those. The returned Subscription can be
+// used to unregister the hook.
+func (t *Terminal) RegisterLifecycleHook(lifecycle LifecycleHandler) *Subscription {
+	return t.lifecycleHooks.Register(EventHook[LifecycleEvent](lifecycle))
+}
+
+// RegisterLifecycleHookWithContext works like RegisterLifecycleHook, but the registered hook will
+// receive the terminal's context, which is cancelled when the terminal shuts down.
+func (t *Terminal) RegisterLifecycleHookWithContext(lifecycle ContextLifecycleHandler) *Subscription {
+	return t.lifecycleHooks.Register(withContext(t, lifecycle))
+}
+
+// RegisterPromptCommandsChangedHook will register an event to be called when the prompt commands
+// used by the keyboard or printer change. See Terminal.PromptCommands. The returned Subscription
+// can be used to unregister the hook.
+func (t *Terminal) RegisterPromptCommandsChangedHook(promptCommandsChanged PromptCommandsChangedHandler) *Subscription {
+	return t.promptCommandsChangedHooks.Register(EventHook[PromptCommandsChangedEvent](promptCommandsChanged))
+}
+
+// RegisterPromptCommandsChangedHookWithContext works like RegisterPromptCommandsChangedHook, but the
+// registered hook will receive the terminal's context, which is cancelled when the terminal
+// shuts down.
+func (t *Terminal) RegisterPromptCommandsChangedHookWithContext(promptCommandsChanged ContextPromptCommandsChangedHandler) *Subscription {
+	return t.promptCommandsChangedHooks.Register(withContext(t, promptCommandsChanged))
+}
+
+// RegisterAYTReceivedHook will register an event to be called when the remote sends IAC AYT,
+// after the terminal has answered it. See TerminalConfig.AYTResponse. The returned Subscription
+// can be used to unregister the hook.
+func (t *Terminal) RegisterAYTReceivedHook(aytReceived AYTReceivedHandler) *Subscription {
+	return t.aytReceivedHooks.Register(EventHook[AYTReceivedEvent](aytReceived))
+}
+
+// RegisterAYTReceivedHookWithContext works like RegisterAYTReceivedHook, but the registered hook
+// will receive the terminal's context, which is cancelled when the terminal shuts down.
+func (t *Terminal) RegisterAYTReceivedHookWithContext(aytReceived ContextAYTReceivedHandler) *Subscription {
+	return t.aytReceivedHooks.Register(withContext(t, aytReceived))
+}
+
+// RegisterUTF16DetectedHook will register an event to be called when the printer detects that the
+// remote is sending UTF-16. See TerminalConfig.DetectUTF16. The returned Subscription can be used
+// to unregister the hook.
+func (t *Terminal) RegisterUTF16DetectedHook(utf16Detected UTF16DetectedHandler) *Subscription {
+	return t.utf16DetectedHooks.Register(EventHook[UTF16DetectedEvent](utf16Detected))
+}
+
+// RegisterUTF16DetectedHookWithContext works like RegisterUTF16DetectedHook, but the registered
+// hook will receive the terminal's context, which is cancelled when the terminal shuts down.
+func (t *Terminal) RegisterUTF16DetectedHookWithContext(utf16Detected ContextUTF16DetectedHandler) *Subscription {
+	return t.utf16DetectedHooks.Register(withContext(t, utf16Detected))
 }
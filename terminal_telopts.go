@@ -1,66 +1,490 @@
 package telnet
 
 import (
+	"cmp"
 	"fmt"
+	"slices"
+	"sync"
+	"time"
 )
 
 func (t *Terminal) initTelopts(options []TelnetOption) error {
 	for _, option := range options {
-		oldOption, hasOldOption := t.options[option.Code()]
-		if hasOldOption {
-			return fmt.Errorf("telopt collision: TelOpt %d is already registered to an option of type %T. it cannot be registered to an option of type %T", option.Code(), oldOption, option)
+		err := t.addTelOpt(option)
+		if err != nil {
+			return err
 		}
+	}
 
-		option.Initialize(t)
-		t.options[option.Code()] = option
+	return nil
+}
+
+// addTelOpt initializes the provided option and adds it to the options map, as long
+// as no other option is registered under the same code
+func (t *Terminal) addTelOpt(option TelnetOption) error {
+	code := option.Code()
+
+	// Claim the code before initializing, so that an option that loses a collision is never
+	// initialized and doesn't leave hooks behind
+	t.optionsLock.Lock()
+	oldOption, hasOldOption := t.options[code]
+	if !hasOldOption {
+		oldOption, hasOldOption = t.initializing[code]
+	}
+	if hasOldOption {
+		t.optionsLock.Unlock()
+		return telOptCollisionError(oldOption, option)
 	}
+	t.initializing[code] = option
+	t.optionsLock.Unlock()
+
+	// Initialize outside the lock, since options may look up other options while initializing
+	option.Initialize(t)
+
+	t.optionsLock.Lock()
+	defer t.optionsLock.Unlock()
+
+	delete(t.initializing, code)
+	t.options[code] = option
+	t.negotiations[code] = &telOptNegotiation{}
 
 	return nil
 }
 
-func (t *Terminal) writeTelOptRequests() error {
+func telOptCollisionError(oldOption TelnetOption, option TelnetOption) error {
+	return fmt.Errorf("telopt collision: TelOpt %d is already registered to an option of type %T. it cannot be registered to an option of type %T", option.Code(), oldOption, option)
+}
+
+// telOpt retrieves the option registered under the provided code, if any
+func (t *Terminal) telOpt(code TelOptCode) (TelnetOption, bool) {
+	t.optionsLock.RLock()
+	defer t.optionsLock.RUnlock()
+
+	option, hasOption := t.options[code]
+	return option, hasOption
+}
+
+// telOptList returns all options currently registered with the terminal
+func (t *Terminal) telOptList() []TelnetOption {
+	t.optionsLock.RLock()
+	defer t.optionsLock.RUnlock()
+
+	options := make([]TelnetOption, 0, len(t.options))
 	for _, option := range t.options {
-		usage := option.Usage()
-		oldLocalState := option.LocalState()
-		oldRemoteState := option.RemoteState()
+		options = append(options, option)
+	}
+
+	return options
+}
+
+// TelOpts returns all telopts currently registered with the terminal, ordered by code
+func (t *Terminal) TelOpts() []TelnetOption {
+	options := t.telOptList()
+	slices.SortFunc(options, func(a, b TelnetOption) int {
+		return cmp.Compare(a.Code(), b.Code())
+	})
+
+	return options
+}
+
+// RegisterTelOpt adds a telopt to a terminal that is already running. This is useful for
+// telopts whose support is only discovered after the connection has begun, for instance via
+// MSSP data. The option is initialized and will take part in negotiations from this point forward.
+//
+// If requestNow is true, WILL/DO requests are sent immediately for any sides of the option that its
+// usage indicates should be requested. Otherwise, the option will only be activated if the remote
+// requests it.
+//
+// An error is returned if an option is already registered under the same code.
+func (t *Terminal) RegisterTelOpt(option TelnetOption, requestNow bool) error {
+	err := t.addTelOpt(option)
+	if err != nil {
+		return err
+	}
+
+	if !requestNow {
+		return nil
+	}
+
+	return t.requestTelOpt(option, TelOptChangeLocalRequest)
+}
+
+func (t *Terminal) writeTelOptRequests() error {
+	for _, option := range t.telOptList() {
+		err := t.requestTelOpt(option, TelOptChangeLocalRequest)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// requestTelOpt will request activation of any sides of the provided option that the
+// option's usage indicates we should request, if those sides are currently inactive
+func (t *Terminal) requestTelOpt(option TelnetOption, reason TelOptChangeReason) error {
+	usage := option.Usage()
+
+	if usage&telOptOnlyRequestLocal != 0 {
+		err := t.enableTelOpt(option, TelOptSideLocal, reason)
+		if err != nil {
+			return err
+		}
+	}
+
+	if usage&telOptOnlyRequestRemote != 0 {
+		return t.enableTelOpt(option, TelOptSideRemote, reason)
+	}
+
+	return nil
+}
+
+// enableTelOpt asks the remote to activate one side of the provided option, following the
+// RFC 1143 Q-method
+func (t *Terminal) enableTelOpt(option TelnetOption, side TelOptSide, reason TelOptChangeReason) error {
+	negotiation := t.telOptNegotiation(option.Code(), side)
+
+	negotiation.lock.Lock()
+	var newState TelOptState
+	var send bool
+	switch negotiation.state {
+	case qNo:
+		negotiation.state = qWantYes
+		t.startNegotiationTimeout(option, side, negotiation, false)
+		newState = TelOptRequested
+		send = true
+	case qWantNo:
+		if !negotiation.opposite {
+			// We'll ask again once the remote confirms that the option is off
+			negotiation.opposite = true
+			newState = TelOptRequested
+		}
+	case qWantYes:
+		if negotiation.opposite {
+			// The remote hasn't answered our first request yet, so we don't need to ask again
+			negotiation.opposite = false
+			newState = TelOptRequested
+			t.clearTelOptDisableLock(option, side)
+		}
+	}
+	negotiation.lock.Unlock()
+
+	return t.applyTelOptNegotiation(option, side, newState, send, true, reason)
+}
+
+// deactivateTelOpt will transition one side of the provided option to inactive. If the option
+// was active on that side, a WONT/DONT will be sent to the remote to let them know.
+func (t *Terminal) deactivateTelOpt(option TelnetOption, side TelOptSide, reason TelOptChangeReason) error {
+	negotiation := t.telOptNegotiation(option.Code(), side)
+
+	negotiation.lock.Lock()
+	var newState TelOptState
+	var send bool
+	switch negotiation.state {
+	case qYes:
+		negotiation.state = qWantNo
+		newState = TelOptInactive
+		send = true
+	case qWantNo:
+		if negotiation.opposite {
+			negotiation.opposite = false
+			newState = TelOptInactive
+		}
+	case qWantYes:
+		if !negotiation.opposite {
+			// We'll turn the option back off if the remote agrees to our request
+			negotiation.opposite = true
+			newState = TelOptInactive
+			t.setTelOptDisableLock(option, side)
+		}
+	}
+	negotiation.lock.Unlock()
+
+	return t.applyTelOptNegotiation(option, side, newState, send, false, reason)
+}
+
+// applyTelOptNegotiation transitions one side of the provided option to newState (unless newState
+// is TelOptUnknown or the option is already in that state), sends a negotiation command to the remote
+// if send is true, and raises a TelOptStateChangeEvent if the state changed. The command sent is
+// WILL/DO if activate is true, and WONT/DONT otherwise. reason is reported in the event.
+func (t *Terminal) applyTelOptNegotiation(option TelnetOption, side TelOptSide, newState TelOptState, send bool, activate bool, reason TelOptChangeReason) error {
+	oldState := option.RemoteState()
+	transitionFunc := option.TransitionRemoteState
+	if side == TelOptSideLocal {
+		oldState = option.LocalState()
+		transitionFunc = option.TransitionLocalState
+	}
+
+	if oldState == TelOptUnknown {
+		oldState = TelOptInactive
+	}
+
+	var postSend func() error
+	transitioned := newState != TelOptUnknown && newState != oldState
+	if transitioned {
+		err := t.callRecovering(func() error {
+			var err error
+			postSend, err = transitionFunc(newState)
+			return err
+		})
+		if err != nil {
+			return telOptError(option.Code(), err)
+		}
+	}
+
+	if send {
+		command := Command{
+			OpCode: negotiationOpCode(side, activate),
+			Option: option.Code(),
+		}
+
+		if side == TelOptSideLocal && !activate {
+			postSend = t.releaseTelOptDisableLock(option, postSend)
+		}
+
+		if side == TelOptSideLocal && !activate && reason != TelOptChangeRemoteRequest {
+			// Text that was queued before we decided to stop was written for the telopt's
+			// semantics, so unheld queued text goes out before the WONT. Text held by a
+			// lock (e.g. CHARSET) does not: the WONT can't wait for the lock to clear
+			t.keyboard.WriteCommandAfterText(command, postSend)
+		} else {
+			t.keyboard.WriteCommand(command, postSend)
+		}
+	} else if postSend != nil {
+		// There's no command to write but the postSend event still needs to be run
+		err := t.callRecovering(postSend)
+		if err != nil {
+			t.encounteredError(telOptError(option.Code(), err))
+		}
+	}
+
+	if transitioned {
+		t.RaiseTelOptEvent(TelOptStateChangeEvent{
+			TelnetOption: option,
+			Side:         side,
+			OldState:     oldState,
+			NewState:     newState,
+			Reason:       reason,
+		})
+	}
+
+	return nil
+}
+
+// When we disable the local side of a telopt, the WONT, the change in communication semantic that
+// the telopt makes once the WONT is sent (its postSend), and the text around them are sequenced
+// so that the remote can tell which semantic each piece of text was written for:
+//
+//   - If the telopt is active, the WONT is sent after all text queued before it, and the telopt's
+//     postSend runs immediately after the WONT is written, before any text queued after it
+//   - If we requested the telopt and changed our minds before the remote answered, the telopt
+//     already considers itself inactive, but the remote may still agree to our request. Text is
+//     held with a keyboard lock until the remote answers: if it agrees, the WONT is sent
+//     automatically and the lock is cleared once it has been written, and if it refuses, the lock
+//     is cleared immediately.
+
+// telOptDisableLock is the name of the keyboard lock that holds text while we wait to send a
+// WONT for the telopt with the provided code
+func telOptDisableLock(code TelOptCode) string {
+	return fmt.Sprintf("lock.telopt-disable.%d", code)
+}
 
-		if usage&telOptOnlyRequestLocal != 0 && oldLocalState == TelOptInactive {
-			postSend, err := option.TransitionLocalState(TelOptRequested)
+func (t *Terminal) setTelOptDisableLock(option TelnetOption, side TelOptSide) {
+	if side == TelOptSideLocal {
+		t.keyboard.SetLock(telOptDisableLock(option.Code()), DefaultKeyboardLock)
+	}
+}
+
+func (t *Terminal) clearTelOptDisableLock(option TelnetOption, side TelOptSide) {
+	if side == TelOptSideLocal {
+		t.keyboard.ClearLock(telOptDisableLock(option.Code()))
+	}
+}
+
+// releaseTelOptDisableLock wraps a WONT's postSend so that the telopt's disable lock is cleared
+// once the WONT has been written
+func (t *Terminal) releaseTelOptDisableLock(option TelnetOption, postSend func() error) func() error {
+	return func() error {
+		defer t.keyboard.ClearLock(telOptDisableLock(option.Code()))
+
+		if postSend == nil {
+			return nil
+		}
+
+		return postSend()
+	}
+}
+
+// negotiate makes a negotiation change requested from outside the printer loop. Incoming
+// negotiation commands are processed by the printer loop, so the change is run while the printer
+// loop is frozen, which serializes it with them: if the printer loop is waiting on the connection,
+// the change is made before negotiate returns, and otherwise, it is made as soon as the printer
+// loop finishes what it is doing. Errors are delivered to EncounteredError hooks.
+func (t *Terminal) negotiate(change func() error) {
+	t.printer.pause.run(func() {
+		err := change()
+		if err != nil {
+			t.eventPump.EncounteredError(err)
+		}
+	})
+}
+
+// SetTelOptUsage changes the permitted usage of a registered telopt. The option must implement
+// TelOptUsageSetter, which all telopts embedding telopts.BaseTelOpt do.
+//
+// After the usage has been changed, a TelOptUsageChangeEvent is raised and negotiations for the
+// option are re-evaluated: sides of the option that are active or requested but are no longer
+// permitted will be deactivated (sending WONT/DONT to the remote if they were active), and sides
+// that are now requested but are inactive will be requested from the remote.
+//
+// The change is serialized with the negotiations that the printer carries out for the remote,
+// so if the printer is busy, it may not have been made when SetTelOptUsage returns. An error is
+// returned if the option can't be changed, and errors encountered while renegotiating are
+// delivered to EncounteredError hooks.
+func (t *Terminal) SetTelOptUsage(code TelOptCode, usage TelOptUsage) error {
+	option, hasOption := t.telOpt(code)
+	if !hasOption {
+		return fmt.Errorf("telopt %d is not registered with this terminal", code)
+	}
+
+	setter, canSet := option.(TelOptUsageSetter)
+	if !canSet {
+		return fmt.Errorf("telopt %s of type %T does not support changing usage", option, option)
+	}
+
+	t.negotiate(func() error {
+		oldUsage := option.Usage()
+		if oldUsage == usage {
+			return nil
+		}
+
+		setter.SetUsage(usage)
+
+		t.RaiseTelOptEvent(TelOptUsageChangeEvent{
+			TelnetOption: option,
+			OldUsage:     oldUsage,
+			NewUsage:     usage,
+		})
+
+		if usage&TelOptAllowLocal == 0 {
+			err := t.deactivateTelOpt(option, TelOptSideLocal, TelOptChangePolicy)
 			if err != nil {
 				return err
 			}
-
-			t.keyboard.WriteCommand(Command{
-				OpCode: WILL,
-				Option: option.Code(),
-			}, postSend)
-
-			t.RaiseTelOptEvent(TelOptStateChangeEvent{
-				TelnetOption: option,
-				Side:         TelOptSideLocal,
-				OldState:     oldLocalState,
-				NewState:     TelOptRequested,
-			})
 		}
 
-		if usage&telOptOnlyRequestRemote != 0 && oldRemoteState == TelOptInactive {
-			postSend, err := option.TransitionRemoteState(TelOptRequested)
+		if usage&TelOptAllowRemote == 0 {
+			err := t.deactivateTelOpt(option, TelOptSideRemote, TelOptChangePolicy)
 			if err != nil {
 				return err
 			}
+		}
+
+		return t.requestTelOpt(option, TelOptChangePolicy)
+	})
 
-			t.keyboard.WriteCommand(Command{
-				OpCode: DO,
-				Option: option.Code(),
-			}, postSend)
+	return nil
+}
+
+// DisableTelOpt deactivates a registered telopt on both sides of the connection.  Sides of the
+// option that are active will send WONT/DONT to the remote, and a TelOptStateChangeEvent will be
+// raised for every side that changes state.  This is useful for telopts that are only needed
+// temporarily, such as a server turning ECHO off after a password prompt.
+//
+// The option's usage is not changed, so the remote may request the option again later. Use
+// SetTelOptUsage to prevent the option from being renegotiated. Like SetTelOptUsage, the change
+// is serialized with the printer's negotiations.
+func (t *Terminal) DisableTelOpt(code TelOptCode) error {
+	option, hasOption := t.telOpt(code)
+	if !hasOption {
+		return fmt.Errorf("telopt %d is not registered with this terminal", code)
+	}
 
-			t.RaiseTelOptEvent(TelOptStateChangeEvent{
-				TelnetOption: option,
-				Side:         TelOptSideRemote,
-				OldState:     oldRemoteState,
-				NewState:     TelOptRequested,
-			})
+	t.negotiate(func() error {
+		err := t.deactivateTelOpt(option, TelOptSideLocal, TelOptChangeLocalRequest)
+		if err != nil {
+			return err
 		}
+
+		return t.deactivateTelOpt(option, TelOptSideRemote, TelOptChangeLocalRequest)
+	})
+
+	return nil
+}
+
+// RenegotiateTelOpt turns one side of an active telopt off and immediately requests it again,
+// sending WONT/DONT followed by WILL/DO once the remote has agreed to turn it off. Some telopts
+// only send their data when they are activated, so this is a way to ask the remote to send it
+// again. If that side of the telopt is not active, it is requested as usual. Like
+// SetTelOptUsage, the change is serialized with the printer's negotiations.
+func (t *Terminal) RenegotiateTelOpt(code TelOptCode, side TelOptSide) error {
+	option, hasOption := t.telOpt(code)
+	if !hasOption {
+		return fmt.Errorf("telopt %d is not registered with this terminal", code)
+	}
+
+	err := checkTelOptAllowed(option, side)
+	if err != nil {
+		return err
+	}
+
+	t.negotiate(func() error {
+		err := t.deactivateTelOpt(option, side, TelOptChangeLocalRequest)
+		if err != nil {
+			return err
+		}
+
+		return t.enableTelOpt(option, side, TelOptChangeLocalRequest)
+	})
+
+	return nil
+}
+
+// EnableTelOptSide asks the remote to activate one side of a registered telopt, which must be
+// permitted on that side by the telopt's usage. Nothing is sent if that side is already active or
+// requested. This is useful for telopts that are only needed some of the time, such as a server
+// turning ECHO on for a password prompt. Like SetTelOptUsage, the change is serialized with the
+// printer's negotiations.
+func (t *Terminal) EnableTelOptSide(code TelOptCode, side TelOptSide) error {
+	option, hasOption := t.telOpt(code)
+	if !hasOption {
+		return fmt.Errorf("telopt %d is not registered with this terminal", code)
+	}
+
+	err := checkTelOptAllowed(option, side)
+	if err != nil {
+		return err
+	}
+
+	t.negotiate(func() error {
+		return t.enableTelOpt(option, side, TelOptChangeLocalRequest)
+	})
+
+	return nil
+}
+
+// DisableTelOptSide works like DisableTelOpt, but only deactivates one side of the telopt
+func (t *Terminal) DisableTelOptSide(code TelOptCode, side TelOptSide) error {
+	option, hasOption := t.telOpt(code)
+	if !hasOption {
+		return fmt.Errorf("telopt %d is not registered with this terminal", code)
+	}
+
+	t.negotiate(func() error {
+		return t.deactivateTelOpt(option, side, TelOptChangeLocalRequest)
+	})
+
+	return nil
+}
+
+func checkTelOptAllowed(option TelnetOption, side TelOptSide) error {
+	allowFlag := TelOptAllowRemote
+	if side == TelOptSideLocal {
+		allowFlag = TelOptAllowLocal
+	}
+
+	if option.Usage()&allowFlag == 0 {
+		return fmt.Errorf("telopt %s is not permitted on the %s side", option, side)
 	}
 
 	return nil
@@ -73,29 +497,52 @@ func (t *Terminal) rejectNegotiationRequest(c Command) {
 }
 
 func (t *Terminal) processSubnegotiation(c Command) error {
-	option, hasOption := t.options[c.Option]
+	option, hasOption := t.telOpt(c.Option)
 	if !hasOption {
 		// Getting subnegotiations for stuff we haven't agreed to
 		return nil
 	}
 
-	if option.LocalState() != TelOptActive && option.RemoteState() != TelOptActive {
+	// A remote that agrees to our request may subnegotiate before its agreement arrives (telopts
+	// that subnegotiate from their state transitions do), so a requested side is enough. Telopts
+	// check their own state before acting on a subnegotiation.
+	if !subnegotiationPermitted(option.LocalState()) && !subnegotiationPermitted(option.RemoteState()) {
 		// Getting subnegotiations for stuff we haven't agreed to
 		return nil
 	}
 
-	return option.Subnegotiate(c.Subnegotiation)
+	validator, hasValidator := option.(SubnegotiationValidator)
+	if hasValidator {
+		err := t.callRecovering(func() error {
+			return validator.ValidateSubnegotiation(c.Subnegotiation)
+		})
+		if err != nil {
+			t.printer.stats.recordRejectedSubnegotiation(c.Option)
+			return &SubnegotiationRejectedError{
+				Option:         option,
+				Subnegotiation: c.Subnegotiation,
+				Err:            err,
+			}
+		}
+	}
+
+	return t.callRecovering(func() error {
+		return option.Subnegotiate(c.Subnegotiation)
+	})
+}
+
+func subnegotiationPermitted(state TelOptState) bool {
+	return state == TelOptActive || state == TelOptRequested
 }
 
 func (t *Terminal) processTelOptCommand(c Command) error {
 	if c.OpCode == SB {
-		return t.processSubnegotiation(c)
+		t.negotiation.activity()
+		return telOptError(c.Option, t.processSubnegotiation(c))
 	}
 
 	if c.OpCode == AYT {
-		return t.keyboard.writeCommand(Command{
-			OpCode: NOP,
-		})
+		return t.answerAYT()
 	}
 
 	// It's not a negotiation command
@@ -103,8 +550,15 @@ func (t *Terminal) processTelOptCommand(c Command) error {
 		return nil
 	}
 
+	t.negotiation.activity()
+	defer t.checkNegotiationResolved()
+
+	if t.answerProbe(c) {
+		return nil
+	}
+
 	// Is this an option we know about?
-	option, hasOption := t.options[c.Option]
+	option, hasOption := t.telOpt(c.Option)
 	if !hasOption {
 		// Unregistered telopt
 		t.rejectNegotiationRequest(c)
@@ -112,82 +566,224 @@ func (t *Terminal) processTelOptCommand(c Command) error {
 		return nil
 	}
 
-	oldState := option.RemoteState()
 	side := TelOptSideRemote
-	transitionFunc := option.TransitionRemoteState
 	allowFlag := TelOptAllowRemote
 	if c.isLocalNegotiation() {
-		oldState = option.LocalState()
 		side = TelOptSideLocal
-		transitionFunc = option.TransitionLocalState
 		allowFlag = TelOptAllowLocal
 	}
 
-	// They are requesting WONT/DONT
-	if !c.isActivateNegotiation() && oldState == TelOptInactive {
-		// already turned off
-		return nil
-	} else if !c.isActivateNegotiation() {
-		// need to turn it off
-		postSend, err := transitionFunc(TelOptInactive)
-		if err != nil {
-			return err
+	negotiation := t.telOptNegotiation(option.Code(), side)
+
+	negotiation.lock.Lock()
+	loopErr := negotiation.countCommand()
+	if loopErr != nil {
+		negotiation.lock.Unlock()
+
+		if loopErr.Commands == telOptNegotiationLoopLimit+1 {
+			loopErr.Option = option
+			loopErr.Side = side
+			t.encounteredError(loopErr)
 		}
 
-		if oldState == TelOptActive {
-			t.keyboard.WriteCommand(c.agree(), postSend)
-		} else if oldState == TelOptRequested && postSend != nil {
-			// There's no command to write but the postSend event still needs to be run
-			err = postSend()
-			if err != nil {
-				t.encounteredError(err)
+		return nil
+	}
+
+	// RFC 1143 section 7
+	var newState TelOptState
+	var send, activate bool
+	var policyErr error
+	if c.isActivateNegotiation() {
+		switch negotiation.state {
+		case qNo:
+			if option.Usage()&allowFlag == 0 {
+				// Disallowed telopt
+				send = true
+				break
 			}
-		}
 
-		t.RaiseTelOptEvent(TelOptStateChangeEvent{
-			TelnetOption: option,
-			Side:         side,
-			OldState:     oldState,
-			NewState:     TelOptInactive,
-		})
+			var allowed bool
+			allowed, policyErr = t.negotiationPermitted(option, side)
+			if !allowed {
+				send = true
+				break
+			}
 
-		return nil
+			negotiation.state = qYes
+			newState = TelOptActive
+			send = true
+			activate = true
+		case qWantNo:
+			// The remote should not refuse to deactivate an option, but if we were going to
+			// request it again anyway, we'll take it
+			if negotiation.opposite {
+				negotiation.state = qYes
+				negotiation.opposite = false
+				newState = TelOptActive
+			} else {
+				negotiation.state = qNo
+			}
+		case qWantYes:
+			if negotiation.opposite {
+				// We changed our minds while we were waiting for the remote to agree
+				negotiation.state = qWantNo
+				negotiation.opposite = false
+				send = true
+			} else {
+				negotiation.state = qYes
+				newState = TelOptActive
+			}
+		}
+	} else {
+		switch negotiation.state {
+		case qYes:
+			negotiation.state = qNo
+			newState = TelOptInactive
+			send = true
+		case qWantNo:
+			if negotiation.opposite {
+				negotiation.state = qWantYes
+				negotiation.opposite = false
+				t.startNegotiationTimeout(option, side, negotiation, false)
+				send = true
+				activate = true
+			} else {
+				negotiation.state = qNo
+			}
+		case qWantYes:
+			negotiation.state = qNo
+			negotiation.opposite = false
+			newState = TelOptInactive
+			t.clearTelOptDisableLock(option, side)
+		}
 	}
+	negotiation.lock.Unlock()
 
-	// They are requesting DO/WILL
-	if oldState == TelOptActive {
-		// Already turned on
-		return nil
+	if policyErr != nil {
+		t.encounteredError(telOptError(option.Code(), policyErr))
 	}
 
-	if option.Usage()&allowFlag == 0 {
-		// Disallowed telopt
-		t.rejectNegotiationRequest(c)
+	return t.applyTelOptNegotiation(option, side, newState, send, activate, TelOptChangeRemoteRequest)
+}
+
+// negotiationPermitted consults the terminal's NegotiationPolicyFunc, if any, about a request
+// from the remote to activate one side of the provided option. Requests are rejected if the
+// policy panics.
+func (t *Terminal) negotiationPermitted(option TelnetOption, side TelOptSide) (bool, error) {
+	if t.negotiationPolicy == nil {
+		return true, nil
+	}
 
+	var allowed bool
+	err := t.callRecovering(func() error {
+		allowed = t.negotiationPolicy(t, option, side, TelOptActive)
 		return nil
+	})
+
+	return allowed && err == nil, err
+}
+
+// telOptNegotiationLoopLimit is the number of negotiation commands the remote may send for a
+// single side of a single telopt within telOptNegotiationLoopWindow before the terminal stops
+// responding to them
+const (
+	telOptNegotiationLoopLimit  = 10
+	telOptNegotiationLoopWindow = time.Second
+)
+
+// TelOptNegotiationLoopError is delivered via the EncounteredError hook when the remote sends an
+// excessive number of negotiation commands for one side of a telopt in a short period of time, which
+// usually indicates that the remote is stuck in a negotiation loop. Negotiation commands for that side
+// of the telopt are ignored until the remote calms down.
+type TelOptNegotiationLoopError struct {
+	Option   TelnetOption
+	Side     TelOptSide
+	Commands int
+}
+
+func (e *TelOptNegotiationLoopError) Unwrap() error {
+	return ErrProtocol
+}
+
+func (e *TelOptNegotiationLoopError) Error() string {
+	return fmt.Sprintf("telopt %s: remote sent %d negotiation commands for the %s side in %s, ignoring further negotiation",
+		e.Option, e.Commands, e.Side, telOptNegotiationLoopWindow)
+}
+
+// qState is an RFC 1143 Q-method negotiation state
+type qState byte
+
+const (
+	qNo qState = iota
+	qYes
+	qWantNo
+	qWantYes
+)
+
+// telOptSideNegotiation holds the RFC 1143 Q-method state for one side of a telopt. TelOptState
+// is what telopts see- qNo & qWantNo are both TelOptInactive, qWantYes is TelOptRequested, and qYes
+// is TelOptActive- except that when opposite is set, the telopt is told about the state we will
+// be requesting once the remote answers.
+type telOptSideNegotiation struct {
+	lock  sync.Mutex
+	state qState
+	// opposite indicates that we changed our minds while waiting for the remote to answer a request,
+	// so the opposite request should be sent as soon as the answer arrives
+	opposite bool
+
+	windowStart time.Time
+	commands    int
+
+	// requestGeneration identifies the most recent WILL/DO request, so that negotiation timeouts
+	// belonging to earlier requests can tell that they no longer apply
+	requestGeneration uint64
+	retry             bool
+	timeoutTimer      *time.Timer
+}
+
+// countCommand records a negotiation command received from the remote, and returns an error
+// if the remote has sent too many of them recently
+func (n *telOptSideNegotiation) countCommand() *TelOptNegotiationLoopError {
+	now := time.Now()
+	if now.Sub(n.windowStart) > telOptNegotiationLoopWindow {
+		n.windowStart = now
+		n.commands = 0
 	}
 
-	postSend, err := transitionFunc(TelOptActive)
-	if err != nil {
-		return err
+	n.commands++
+	if n.commands <= telOptNegotiationLoopLimit {
+		return nil
 	}
 
-	if oldState == TelOptInactive {
-		// Need to send an accept command
-		t.keyboard.WriteCommand(c.agree(), postSend)
-	} else if oldState == TelOptRequested && postSend != nil {
-		// There's no command to write but the postSend event still needs to be run
-		err = postSend()
-		if err != nil {
-			t.encounteredError(err)
-		}
+	return &TelOptNegotiationLoopError{Commands: n.commands}
+}
+
+type telOptNegotiation struct {
+	local  telOptSideNegotiation
+	remote telOptSideNegotiation
+}
+
+func (t *Terminal) telOptNegotiation(code TelOptCode, side TelOptSide) *telOptSideNegotiation {
+	t.optionsLock.RLock()
+	defer t.optionsLock.RUnlock()
+
+	if side == TelOptSideLocal {
+		return &t.negotiations[code].local
 	}
 
-	t.RaiseTelOptEvent(TelOptStateChangeEvent{
-		TelnetOption: option,
-		Side:         side,
-		OldState:     oldState,
-		NewState:     TelOptActive,
-	})
-	return nil
+	return &t.negotiations[code].remote
+}
+
+// negotiationOpCode returns the command used to activate or deactivate one side of a telopt
+func negotiationOpCode(side TelOptSide, activate bool) byte {
+	switch {
+	case side == TelOptSideLocal && activate:
+		return WILL
+	case side == TelOptSideLocal:
+		return WONT
+	case activate:
+		return DO
+	default:
+		return DONT
+	}
 }
@@ -0,0 +1,415 @@
+package telnet
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testTelOptCode TelOptCode = 200
+
+// testTelOpt is a telopt that reports when its postSend for deactivating locally is called. If
+// hold is true, the postSend blocks until the test releases it, so that tests can see what the
+// keyboard has written by the time the telopt changes its semantics.
+type testTelOpt struct {
+	usage       TelOptUsage
+	terminal    *Terminal
+	localState  atomic.Uint32
+	remoteState atomic.Uint32
+
+	hold     bool
+	disabled chan struct{}
+	release  chan struct{}
+}
+
+func newTestTelOpt(usage TelOptUsage) *testTelOpt {
+	return &testTelOpt{
+		usage:    usage,
+		disabled: make(chan struct{}, 1),
+		release:  make(chan struct{}),
+	}
+}
+
+func (o *testTelOpt) Code() TelOptCode              { return testTelOptCode }
+func (o *testTelOpt) String() string                { return "TEST" }
+func (o *testTelOpt) Usage() TelOptUsage            { return o.usage }
+func (o *testTelOpt) Initialize(terminal *Terminal) { o.terminal = terminal }
+func (o *testTelOpt) Terminal() *Terminal           { return o.terminal }
+func (o *testTelOpt) LocalState() TelOptState       { return TelOptState(o.localState.Load()) }
+func (o *testTelOpt) RemoteState() TelOptState      { return TelOptState(o.remoteState.Load()) }
+
+func (o *testTelOpt) TransitionLocalState(newState TelOptState) (func() error, error) {
+	o.localState.Store(uint32(newState))
+	if newState != TelOptInactive {
+		return nil, nil
+	}
+
+	return func() error {
+		select {
+		case o.disabled <- struct{}{}:
+		default:
+		}
+
+		if o.hold {
+			<-o.release
+		}
+		return nil
+	}, nil
+}
+
+func (o *testTelOpt) TransitionRemoteState(newState TelOptState) (func() error, error) {
+	o.remoteState.Store(uint32(newState))
+	return nil, nil
+}
+
+func (o *testTelOpt) Subnegotiate(subnegotiation []byte) error {
+	return nil
+}
+
+func (o *testTelOpt) SubnegotiationString(subnegotiation []byte) (string, error) {
+	return "", nil
+}
+
+// waitForDisabled fails the test unless the telopt's postSend for deactivating locally is called
+func (o *testTelOpt) waitForDisabled(t *testing.T) {
+	t.Helper()
+
+	select {
+	case <-o.disabled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the telopt's postSend was not called")
+	}
+}
+
+func testCommand(opCode byte) []byte {
+	return []byte{IAC, opCode, byte(testTelOptCode)}
+}
+
+// newTestTelOptTerminal creates a terminal with a testTelOpt registered. If usage requests the
+// telopt locally, the WILL that the terminal sends at startup has been received when it returns.
+func newTestTelOptTerminal(t *testing.T, usage TelOptUsage, hold bool) (*Terminal, *testRemote, *testTelOpt) {
+	t.Helper()
+
+	option := newTestTelOpt(usage)
+	option.hold = hold
+	terminal, remote := newTestTerminal(t, TerminalConfig{
+		TelOpts: []TelnetOption{option},
+	})
+	t.Cleanup(func() {
+		close(option.release)
+	})
+
+	if usage&TelOptRequestLocal == TelOptRequestLocal {
+		remote.expect(t, testCommand(WILL))
+	}
+
+	return terminal, remote, option
+}
+
+// sendCommand writes a negotiation command for the test telopt to the terminal
+func (r *testRemote) sendCommand(t *testing.T, opCode byte) {
+	t.Helper()
+
+	_, err := r.conn.Write(testCommand(opCode))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDisablingActiveTelOptSendsQueuedTextFirst(t *testing.T) {
+	terminal, remote, option := newTestTelOptTerminal(t, TelOptAllowLocal, true)
+
+	remote.sendCommand(t, DO)
+	remote.expect(t, testCommand(WILL))
+
+	// The remote stops reading until everything has been queued, so nothing can be written early
+	remote.lock.Lock()
+	keyboard := terminal.Keyboard()
+	keyboard.WriteString("before")
+	err := terminal.DisableTelOptSide(testTelOptCode, TelOptSideLocal)
+	if err != nil {
+		remote.lock.Unlock()
+		t.Fatal(err)
+	}
+	keyboard.WriteString("after")
+	remote.lock.Unlock()
+
+	// The telopt changes its semantics after the WONT and before the text written after it
+	option.waitForDisabled(t)
+	remote.expect(t, concat([]byte("before"), testCommand(WONT)))
+	remote.expectNothing(t)
+
+	option.release <- struct{}{}
+	remote.expect(t, []byte("after"))
+}
+
+func TestDisablingTelOptIsNotHeldByLocks(t *testing.T) {
+	terminal, remote, option := newTestTelOptTerminal(t, TelOptAllowLocal, false)
+
+	remote.sendCommand(t, DO)
+	remote.expect(t, testCommand(WILL))
+
+	keyboard := terminal.Keyboard()
+	keyboard.SetLock("test", time.Minute)
+	keyboard.WriteString("held")
+	err := terminal.DisableTelOptSide(testTelOptCode, TelOptSideLocal)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remote.expect(t, testCommand(WONT))
+	option.waitForDisabled(t)
+	remote.expectNothing(t)
+
+	keyboard.ClearLock("test")
+	remote.expect(t, []byte("held"))
+}
+
+func TestRemoteDisablingTelOptDoesNotWaitForText(t *testing.T) {
+	terminal, remote, option := newTestTelOptTerminal(t, TelOptAllowLocal, true)
+
+	remote.sendCommand(t, DO)
+	remote.expect(t, testCommand(WILL))
+
+	keyboard := terminal.Keyboard()
+	keyboard.SetLock("test", time.Minute)
+	keyboard.WriteString("held")
+
+	// The remote has already stopped using the telopt's semantics, so we answer right away
+	remote.sendCommand(t, DONT)
+	option.waitForDisabled(t)
+	remote.expect(t, testCommand(WONT))
+	option.release <- struct{}{}
+	remote.expectNothing(t)
+
+	keyboard.ClearLock("test")
+	remote.expect(t, []byte("held"))
+}
+
+func TestCancelledTelOptRequestHoldsTextUntilRemoteAgrees(t *testing.T) {
+	terminal, remote, option := newTestTelOptTerminal(t, TelOptRequestLocal, false)
+
+	err := terminal.DisableTelOptSide(testTelOptCode, TelOptSideLocal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	option.waitForDisabled(t)
+
+	terminal.Keyboard().WriteString("held")
+	remote.expectNothing(t)
+
+	// Agreeing to our request turns the telopt on, so the terminal turns it back off before
+	// sending the text
+	remote.sendCommand(t, DO)
+	remote.expect(t, testCommand(WONT))
+	remote.expect(t, []byte("held"))
+
+	if option.LocalState() != TelOptInactive {
+		t.Fatalf("expected the telopt to be inactive, but it is %s", option.LocalState())
+	}
+}
+
+func TestCancelledTelOptRequestReleasesTextWhenRemoteRefuses(t *testing.T) {
+	terminal, remote, option := newTestTelOptTerminal(t, TelOptRequestLocal, false)
+
+	err := terminal.DisableTelOptSide(testTelOptCode, TelOptSideLocal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	option.waitForDisabled(t)
+
+	terminal.Keyboard().WriteString("held")
+	remote.expectNothing(t)
+
+	remote.sendCommand(t, DONT)
+	remote.expect(t, []byte("held"))
+	remote.expectNothing(t)
+}
+
+func TestRenewedTelOptRequestReleasesText(t *testing.T) {
+	terminal, remote, option := newTestTelOptTerminal(t, TelOptRequestLocal, false)
+
+	err := terminal.DisableTelOptSide(testTelOptCode, TelOptSideLocal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	option.waitForDisabled(t)
+
+	terminal.Keyboard().WriteString("held")
+	remote.expectNothing(t)
+
+	// Our original request is still waiting for an answer, so there's nothing to hold text for
+	err = terminal.EnableTelOptSide(testTelOptCode, TelOptSideLocal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote.expect(t, []byte("held"))
+}
+
+// waitForLocalState fails the test unless the telopt's local state becomes the provided state
+func (o *testTelOpt) waitForLocalState(t *testing.T, state TelOptState) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for o.LocalState() != state {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the telopt to be %s, but it is %s", state, o.LocalState())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// newWantNoTestTerminal creates a terminal whose test telopt is active locally, and then disables
+// it, leaving it in the WANTNO state until the remote answers the WONT
+func newWantNoTestTerminal(t *testing.T) (*Terminal, *testRemote, *testTelOpt) {
+	t.Helper()
+
+	terminal, remote, option := newTestTelOptTerminal(t, TelOptAllowLocal, false)
+
+	remote.sendCommand(t, DO)
+	remote.expect(t, testCommand(WILL))
+
+	err := terminal.DisableTelOptSide(testTelOptCode, TelOptSideLocal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote.expect(t, testCommand(WONT))
+	option.waitForDisabled(t)
+
+	return terminal, remote, option
+}
+
+func TestWantNoWithOppositeRequestsAgainOnceRemoteAgrees(t *testing.T) {
+	terminal, remote, option := newWantNoTestTerminal(t)
+
+	// The remote hasn't answered the WONT yet, so the WILL waits for it
+	err := terminal.EnableTelOptSide(testTelOptCode, TelOptSideLocal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	option.waitForLocalState(t, TelOptRequested)
+	remote.expectNothing(t)
+
+	remote.sendCommand(t, DONT)
+	remote.expect(t, testCommand(WILL))
+	option.waitForLocalState(t, TelOptRequested)
+
+	remote.sendCommand(t, DO)
+	option.waitForLocalState(t, TelOptActive)
+	remote.expectNothing(t)
+}
+
+func TestWantNoWithOppositeTakesRefusal(t *testing.T) {
+	terminal, remote, option := newWantNoTestTerminal(t)
+
+	err := terminal.EnableTelOptSide(testTelOptCode, TelOptSideLocal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	option.waitForLocalState(t, TelOptRequested)
+
+	// The remote refusing to turn the telopt off gives us what we were about to ask for
+	remote.sendCommand(t, DO)
+	option.waitForLocalState(t, TelOptActive)
+	remote.expectNothing(t)
+}
+
+func TestWantNoWithOppositeCancelled(t *testing.T) {
+	terminal, remote, option := newWantNoTestTerminal(t)
+
+	err := terminal.EnableTelOptSide(testTelOptCode, TelOptSideLocal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	option.waitForLocalState(t, TelOptRequested)
+
+	err = terminal.DisableTelOptSide(testTelOptCode, TelOptSideLocal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	option.waitForLocalState(t, TelOptInactive)
+
+	// We no longer want the telopt, so there's nothing to ask for once the remote agrees
+	remote.sendCommand(t, DONT)
+	remote.expectNothing(t)
+
+	if option.LocalState() != TelOptInactive {
+		t.Fatalf("expected the telopt to be inactive, but it is %s", option.LocalState())
+	}
+}
+
+func TestRenewedTelOptRequestSettlesWhenRemoteAgrees(t *testing.T) {
+	terminal, remote, option := newTestTelOptTerminal(t, TelOptRequestLocal, false)
+
+	err := terminal.DisableTelOptSide(testTelOptCode, TelOptSideLocal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	option.waitForDisabled(t)
+
+	err = terminal.EnableTelOptSide(testTelOptCode, TelOptSideLocal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	option.waitForLocalState(t, TelOptRequested)
+
+	// We want the telopt again, so the remote agreeing to our first request settles it
+	remote.sendCommand(t, DO)
+	option.waitForLocalState(t, TelOptActive)
+	remote.expectNothing(t)
+}
+
+func TestRemoteNegotiationLoopIsIgnored(t *testing.T) {
+	terminal, remote, option := newTestTelOptTerminal(t, TelOptAllowRemote, false)
+
+	loopErrors := make(chan *TelOptNegotiationLoopError, 10)
+	terminal.RegisterEncounteredErrorHook(func(_ *Terminal, err error) {
+		var loopErr *TelOptNegotiationLoopError
+		if errors.As(err, &loopErr) {
+			loopErrors <- loopErr
+		}
+	})
+
+	var flapping [][]byte
+	var answers [][]byte
+	for i := 0; i < telOptNegotiationLoopLimit; i += 2 {
+		flapping = append(flapping, testCommand(WILL), testCommand(WONT))
+		answers = append(answers, testCommand(DO), testCommand(DONT))
+	}
+	// These are over the limit and are ignored
+	flapping = append(flapping, testCommand(WILL), testCommand(WONT), testCommand(WILL))
+
+	_, err := remote.conn.Write(concat(flapping...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remote.expect(t, concat(answers...))
+	remote.expectNothing(t)
+
+	select {
+	case loopErr := <-loopErrors:
+		if loopErr.Option.Code() != testTelOptCode || loopErr.Side != TelOptSideRemote ||
+			loopErr.Commands != telOptNegotiationLoopLimit+1 {
+			t.Fatalf("unexpected loop error: %v", loopErr)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the negotiation loop was not reported")
+	}
+
+	select {
+	case loopErr := <-loopErrors:
+		t.Fatalf("the negotiation loop was reported more than once: %v", loopErr)
+	default:
+	}
+
+	if option.RemoteState() != TelOptInactive {
+		t.Fatalf("expected the telopt to be inactive, but it is %s", option.RemoteState())
+	}
+
+	// Once the remote calms down, its commands are answered again
+	time.Sleep(telOptNegotiationLoopWindow)
+	remote.sendCommand(t, WILL)
+	remote.expect(t, testCommand(DO))
+}
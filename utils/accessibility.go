@@ -0,0 +1,283 @@
+package utils
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode"
+
+	"github.com/charmbracelet/x/ansi"
+	"github.com/moodclient/telnet"
+)
+
+// minimumArtRun is the number of identical punctuation characters in a row that will be
+// treated as a decorative rule or divider rather than text
+const minimumArtRun = 4
+
+var colorNames = []string{"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white"}
+
+type AccessibilityConfig struct {
+	// Enabled indicates whether the filter should start out active. It can be toggled at
+	// runtime with AccessibilityFilter.SetEnabled.
+	Enabled bool
+	// AnnounceColors indicates that changes to the foreground color should be announced
+	// with a bracketed color name, such as "[red]", rather than silently dropped
+	AnnounceColors bool
+}
+
+// AccessibilityFilter is a printer middleware that rewrites screen-oriented output into linear
+// text that is friendly to screen readers. While it is enabled:
+//
+//   - Escape sequences are removed. Cursor movements that would move output to another row are
+//     replaced with a line break.
+//   - Color changes are removed, or announced if AccessibilityConfig.AnnounceColors is set.
+//   - Box-drawing characters, block elements, and long runs of repeated punctuation (such as
+//     "-----" or "=====") are removed, and lines that are left empty as a result are dropped.
+//   - Carriage returns and backspaces, which are used to draw over existing output, are removed.
+//
+// Commands and prompts are passed through unchanged. While the filter is disabled, all output
+// is passed through unchanged.
+type AccessibilityFilter struct {
+	enabled        atomic.Bool
+	announceColors atomic.Bool
+
+	lock sync.Mutex
+	// lineHasText indicates whether text has been sent since the last line break
+	lineHasText bool
+	// suppressedLine indicates whether text has been removed since the last line break
+	suppressedLine bool
+	lastColor      string
+}
+
+var _ telnet.Middleware = &AccessibilityFilter{}
+
+func NewAccessibilityFilter(config AccessibilityConfig) *AccessibilityFilter {
+	filter := &AccessibilityFilter{}
+	filter.enabled.Store(config.Enabled)
+	filter.announceColors.Store(config.AnnounceColors)
+
+	return filter
+}
+
+// SetEnabled activates or deactivates the filter
+func (f *AccessibilityFilter) SetEnabled(enabled bool) {
+	f.enabled.Store(enabled)
+}
+
+// Enabled indicates whether the filter is currently active
+func (f *AccessibilityFilter) Enabled() bool {
+	return f.enabled.Load()
+}
+
+// SetAnnounceColors modifies whether color changes are announced
+func (f *AccessibilityFilter) SetAnnounceColors(announce bool) {
+	f.announceColors.Store(announce)
+}
+
+func (f *AccessibilityFilter) Handle(terminal *telnet.Terminal, data telnet.TerminalData, next telnet.TerminalDataHandler) {
+	if !f.enabled.Load() {
+		next(terminal, data)
+		return
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	switch d := data.(type) {
+	case telnet.TextData:
+		f.text(terminal, string(d), next)
+	case telnet.ControlCodeData:
+		switch ansi.ControlCode(d) {
+		case ansi.LF:
+			f.lineBreak(terminal, next)
+		case ansi.HT:
+			f.text(terminal, " ", next)
+		case ansi.BEL:
+			next(terminal, data)
+		}
+	case telnet.CsiData:
+		f.csi(terminal, d, next)
+	case telnet.CommandData, telnet.PromptData:
+		next(terminal, data)
+	}
+}
+
+func (f *AccessibilityFilter) lineBreak(terminal *telnet.Terminal, next telnet.TerminalDataHandler) {
+	// Lines that consisted entirely of art are dropped altogether
+	if f.lineHasText || !f.suppressedLine {
+		next(terminal, telnet.ControlCodeData(ansi.LF))
+	}
+
+	f.lineHasText = false
+	f.suppressedLine = false
+}
+
+func (f *AccessibilityFilter) text(terminal *telnet.Terminal, text string, next telnet.TerminalDataHandler) {
+	filtered := removeArt(text)
+	if filtered != text {
+		f.suppressedLine = true
+	}
+
+	if !f.lineHasText {
+		filtered = strings.TrimLeftFunc(filtered, unicode.IsSpace)
+	}
+
+	if filtered == "" {
+		return
+	}
+
+	f.lineHasText = true
+	next(terminal, telnet.TextData(filtered))
+}
+
+func isArtRune(r rune) bool {
+	// Box drawing, block elements, and geometric shapes
+	return r >= 0x2500 && r <= 0x25FF
+}
+
+// removeArt strips box-drawing characters and long runs of repeated punctuation
+func removeArt(text string) string {
+	runes := []rune(text)
+
+	var sb strings.Builder
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		if isArtRune(r) {
+			i++
+			continue
+		}
+
+		if !unicode.IsPunct(r) && !unicode.IsSymbol(r) {
+			sb.WriteRune(r)
+			i++
+			continue
+		}
+
+		runEnd := i + 1
+		for runEnd < len(runes) && runes[runEnd] == r {
+			runEnd++
+		}
+
+		if runEnd-i < minimumArtRun {
+			sb.WriteString(string(runes[i:runEnd]))
+		}
+
+		i = runEnd
+	}
+
+	return sb.String()
+}
+
+func (f *AccessibilityFilter) csi(terminal *telnet.Terminal, csi telnet.CsiData, next telnet.TerminalDataHandler) {
+	if csi.Marker() != 0 || csi.Intermediate() != 0 {
+		return
+	}
+
+	switch csi.Command() {
+	case 'm':
+		if !f.announceColors.Load() {
+			return
+		}
+
+		color := sgrForegroundColor(csi)
+		if color == "" || color == f.lastColor {
+			return
+		}
+
+		f.lastColor = color
+		f.text(terminal, "["+color+"] ", next)
+	case 'H', 'f', 'B', 'E', 'F', 'd', 'J':
+		// Moving to another row- start a new line if anything has been written on this one
+		if f.lineHasText {
+			f.lineBreak(terminal, next)
+		}
+	}
+}
+
+// sgrForegroundColor returns the name of the last foreground color set by an SGR sequence,
+// or the empty string if the sequence does not set the foreground color
+func sgrForegroundColor(csi telnet.CsiData) string {
+	var color string
+
+	if len(csi.Params) == 0 {
+		return "default"
+	}
+
+	for i := 0; i < len(csi.Params); i++ {
+		param := csi.Params[i].Param(0)
+
+		switch {
+		case param == 0 || param == 39:
+			color = "default"
+		case param >= 30 && param <= 37:
+			color = colorNames[param-30]
+		case param >= 90 && param <= 97:
+			color = "bright " + colorNames[param-90]
+		case param == 38 || param == 48:
+			extended, consumed := sgrExtendedColor(csi.Params[i:])
+			if param == 38 {
+				color = extended
+			}
+			i += consumed
+		}
+	}
+
+	return color
+}
+
+// sgrExtendedColor parses a 38 or 48 SGR parameter and the parameters that follow it. It
+// returns the name of the color and the number of parameters after the first that were consumed.
+func sgrExtendedColor(params []ansi.Parameter) (string, int) {
+	if params[0].HasMore() {
+		// Colon-separated form- the color is made up of sub-parameters
+		var subParams []int
+		for i := 1; i < len(params); i++ {
+			subParams = append(subParams, params[i].Param(0))
+			if !params[i].HasMore() {
+				break
+			}
+		}
+
+		return extendedColorName(subParams), len(subParams)
+	}
+
+	if len(params) < 2 {
+		return "", 0
+	}
+
+	length := 1
+	switch params[1].Param(0) {
+	case 5:
+		length = 2
+	case 2:
+		length = 4
+	}
+
+	length = min(length, len(params)-1)
+	subParams := make([]int, 0, length)
+	for i := 1; i <= length; i++ {
+		subParams = append(subParams, params[i].Param(0))
+	}
+
+	return extendedColorName(subParams), length
+}
+
+func extendedColorName(subParams []int) string {
+	if len(subParams) >= 2 && subParams[0] == 5 {
+		index := subParams[1]
+		if index < 8 {
+			return colorNames[index]
+		} else if index < 16 {
+			return "bright " + colorNames[index-8]
+		}
+
+		return "color " + strconv.Itoa(index)
+	}
+
+	if len(subParams) >= 1 && subParams[0] == 2 {
+		return "custom color"
+	}
+
+	return ""
+}
@@ -0,0 +1,393 @@
+package utils
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/moodclient/telnet"
+	"github.com/moodclient/telnet/telopts"
+)
+
+// ErrCapabilitiesNotFound is returned by CapabilityStore.Load when no capabilities have been
+// saved under the requested key
+var ErrCapabilitiesNotFound = errors.New("capabilities not found")
+
+// RemoteCapabilities are the capabilities a remote terminal negotiated during a session. They
+// can be saved at the end of one session and applied as hints at the start of the next, so that
+// output is rendered correctly before negotiation has completed.
+type RemoteCapabilities struct {
+	// TerminalTypes are the terminal types the remote reported with TTYPE, including its MTTS
+	// bitfield if it is an MTTS client
+	TerminalTypes []string `json:"terminalTypes,omitempty"`
+	// Charset is the charset negotiated with CHARSET
+	Charset string `json:"charset,omitempty"`
+	// Width and Height are the size the remote reported with NAWS
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+}
+
+// MTTS returns the MTTS bitfield among the remote's terminal types, if any
+func (c *RemoteCapabilities) MTTS() (flags telopts.MTTSFlags, ok bool) {
+	for _, terminal := range c.TerminalTypes {
+		flags, ok = telopts.ParseMTTS(terminal)
+		if ok {
+			return flags, true
+		}
+	}
+
+	return 0, false
+}
+
+// ApplyToConfig uses the negotiated charset as the config's default charset, which is useful for
+// clients, who know which host they're connecting to before the terminal is created. The default
+// charset is used regardless of TerminalConfig.CharsetUsage, but unlike the hints provided by
+// ApplyHints, it is not replaced by the charset the remote negotiates unless CharsetUsage allows.
+func (c *RemoteCapabilities) ApplyToConfig(config *telnet.TerminalConfig) {
+	if c.Charset != "" {
+		config.DefaultCharsetName = c.Charset
+	}
+}
+
+// ApplyHints provides the capabilities to the terminal as hints, which are used until the remote
+// negotiates its own. Terminal types are passed to TTYPE.SetRemoteTerminalsHint, the size is passed
+// to NAWS.SetRemoteSizeHint, and the charset is used as the negotiated charset, so it is only
+// used when TerminalConfig.CharsetUsage would use a negotiated charset. Capabilities whose telopts
+// are not registered with the terminal are skipped.
+func (c *RemoteCapabilities) ApplyHints(terminal *telnet.Terminal) error {
+	if len(c.TerminalTypes) > 0 {
+		ttype, err := telnet.GetTelOpt[telopts.TTYPE](terminal)
+		if err == nil && ttype != nil {
+			ttype.SetRemoteTerminalsHint(slices.Clone(c.TerminalTypes))
+		}
+	}
+
+	if c.Width > 0 && c.Height > 0 {
+		naws, err := telnet.GetTelOpt[telopts.NAWS](terminal)
+		if err == nil && naws != nil {
+			naws.SetRemoteSizeHint(c.Width, c.Height)
+		}
+	}
+
+	if c.Charset != "" {
+		err := terminal.Charset().SetNegotiatedDecodingCharset(c.Charset)
+		if err != nil {
+			return err
+		}
+
+		return terminal.Charset().SetNegotiatedEncodingCharset(c.Charset)
+	}
+
+	return nil
+}
+
+// CapabilityStore is a storage backend for remote capabilities. Keys are chosen by the consumer,
+// and are usually the remote's host, or the account the remote logged into.
+type CapabilityStore interface {
+	// Load retrieves the capabilities saved under the provided key, or ErrCapabilitiesNotFound
+	Load(key string) (*RemoteCapabilities, error)
+	// Save stores the capabilities under the provided key, replacing any capabilities already
+	// saved under that key
+	Save(key string, capabilities *RemoteCapabilities) error
+}
+
+// MemoryCapabilityStore is a CapabilityStore that keeps capabilities in memory
+type MemoryCapabilityStore struct {
+	lock         sync.Mutex
+	capabilities map[string][]byte
+}
+
+var _ CapabilityStore = &MemoryCapabilityStore{}
+
+func NewMemoryCapabilityStore() *MemoryCapabilityStore {
+	return &MemoryCapabilityStore{
+		capabilities: make(map[string][]byte),
+	}
+}
+
+func (s *MemoryCapabilityStore) Load(key string) (*RemoteCapabilities, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	data, hasCapabilities := s.capabilities[key]
+	if !hasCapabilities {
+		return nil, ErrCapabilitiesNotFound
+	}
+
+	var capabilities RemoteCapabilities
+	err := json.Unmarshal(data, &capabilities)
+	if err != nil {
+		return nil, err
+	}
+
+	return &capabilities, nil
+}
+
+func (s *MemoryCapabilityStore) Save(key string, capabilities *RemoteCapabilities) error {
+	data, err := json.Marshal(capabilities)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.capabilities[key] = data
+	return nil
+}
+
+// FileCapabilityStore is a CapabilityStore that keeps the capabilities for each key in a JSON file
+// within a directory
+type FileCapabilityStore struct {
+	dir string
+}
+
+var _ CapabilityStore = FileCapabilityStore{}
+
+// NewFileCapabilityStore creates a FileCapabilityStore that keeps capabilities in the provided
+// directory. The directory will be created when capabilities are first saved if it does not exist.
+func NewFileCapabilityStore(dir string) FileCapabilityStore {
+	return FileCapabilityStore{dir: dir}
+}
+
+func (s FileCapabilityStore) path(key string) string {
+	// Keys are usually hosts and account names, which can't be trusted as file names
+	return filepath.Join(s.dir, base64.RawURLEncoding.EncodeToString([]byte(key))+".json")
+}
+
+func (s FileCapabilityStore) Load(key string) (*RemoteCapabilities, error) {
+	data, err := os.ReadFile(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrCapabilitiesNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	var capabilities RemoteCapabilities
+	err = json.Unmarshal(data, &capabilities)
+	if err != nil {
+		return nil, err
+	}
+
+	return &capabilities, nil
+}
+
+func (s FileCapabilityStore) Save(key string, capabilities *RemoteCapabilities) error {
+	data, err := json.MarshalIndent(capabilities, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(s.dir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a failed write can't corrupt existing capabilities
+	path := s.path(key)
+	tmpPath := path + ".tmp"
+	err = os.WriteFile(tmpPath, data, 0o644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// CapabilityCache remembers a remote's capabilities across sessions. When it is created, the
+// capabilities saved under its key in a previous session are applied to the terminal as hints,
+// so it should be created as soon as the terminal is. Capabilities are then collected as the
+// remote negotiates them, and saved once initial negotiation has completed, whenever they change
+// after that, and when the terminal shuts down.
+//
+// Servers usually don't know which account a client will log into until after negotiation, so
+// a cache can be created with the client's host as its key and then moved to the account with
+// SetKey once the client has logged in.
+type CapabilityCache struct {
+	terminal     *telnet.Terminal
+	store        CapabilityStore
+	errorHandler func(err error)
+
+	lock         sync.Mutex
+	key          string
+	hints        *RemoteCapabilities
+	capabilities RemoteCapabilities
+	settled      bool
+
+	subscriptions []*telnet.Subscription
+	stopSave      func() bool
+	stopOnce      sync.Once
+}
+
+// NewCapabilityCache loads the capabilities saved under key, applies them to terminal as hints,
+// and begins collecting the remote's capabilities. Errors loading or applying the hints are
+// returned along with the cache, which is usable regardless. Errors saving capabilities
+// automatically are passed to errorHandler, if it is not nil.
+func NewCapabilityCache(terminal *telnet.Terminal, store CapabilityStore, key string, errorHandler func(err error)) (*CapabilityCache, error) {
+	c := &CapabilityCache{
+		terminal:     terminal,
+		store:        store,
+		errorHandler: errorHandler,
+		key:          key,
+	}
+
+	c.subscriptions = append(c.subscriptions,
+		terminal.RegisterTelOptEventHook(c.TelOptEvent),
+		terminal.RegisterNegotiationCompleteHook(c.NegotiationComplete),
+	)
+
+	c.stopSave = context.AfterFunc(terminal.Context(), c.autoSave)
+
+	return c, c.applyHints(key)
+}
+
+func (c *CapabilityCache) applyHints(key string) error {
+	hints, err := c.store.Load(key)
+	if errors.Is(err, ErrCapabilitiesNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	c.hints = hints
+	c.lock.Unlock()
+
+	return hints.ApplyHints(c.terminal)
+}
+
+// TelOptEvent receives telopt events from the terminal and collects the capabilities they carry.
+// It is registered automatically by NewCapabilityCache.
+func (c *CapabilityCache) TelOptEvent(terminal *telnet.Terminal, event telnet.TelOptEvent) {
+	c.lock.Lock()
+
+	switch typed := event.(type) {
+	case telopts.TTYPERemoteTerminalsUpdatedEvent:
+		c.capabilities.TerminalTypes = slices.Clone(typed.RemoteTerminals)
+	case telopts.CHARSETNegotiationSuccessEvent:
+		c.capabilities.Charset = typed.NewCharsetName
+	case telopts.NAWSRemoteSizeChangedEvent:
+		if typed.Hint || typed.Unspecified() {
+			c.lock.Unlock()
+			return
+		}
+
+		c.capabilities.Width = typed.NewRemoteWidth
+		c.capabilities.Height = typed.NewRemoteHeight
+	default:
+		c.lock.Unlock()
+		return
+	}
+
+	settled := c.settled
+	c.lock.Unlock()
+
+	if settled {
+		c.autoSave()
+	}
+}
+
+// NegotiationComplete receives the terminal's NegotiationComplete event and saves the capabilities
+// collected during initial negotiation. It is registered automatically by NewCapabilityCache.
+func (c *CapabilityCache) NegotiationComplete(terminal *telnet.Terminal, event telnet.NegotiationCompleteEvent) {
+	c.lock.Lock()
+	c.settled = true
+	c.lock.Unlock()
+
+	c.autoSave()
+}
+
+func (c *CapabilityCache) autoSave() {
+	err := c.Save()
+	if err != nil && c.errorHandler != nil {
+		c.errorHandler(err)
+	}
+}
+
+// Capabilities returns the capabilities the remote has negotiated in this session so far, with
+// capabilities it has not negotiated filled in from the hints
+func (c *CapabilityCache) Capabilities() RemoteCapabilities {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.mergedCapabilities()
+}
+
+func (c *CapabilityCache) mergedCapabilities() RemoteCapabilities {
+	capabilities := c.capabilities
+	if c.hints == nil {
+		return capabilities
+	}
+
+	if capabilities.TerminalTypes == nil {
+		capabilities.TerminalTypes = c.hints.TerminalTypes
+	}
+	if capabilities.Charset == "" {
+		capabilities.Charset = c.hints.Charset
+	}
+	if capabilities.Width == 0 || capabilities.Height == 0 {
+		capabilities.Width = c.hints.Width
+		capabilities.Height = c.hints.Height
+	}
+
+	return capabilities
+}
+
+// Hints returns the capabilities that were loaded from the store and applied as hints, or nil
+// if none had been saved
+func (c *CapabilityCache) Hints() *RemoteCapabilities {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.hints
+}
+
+// Key returns the key the cache saves capabilities under
+func (c *CapabilityCache) Key() string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.key
+}
+
+// SetKey changes the key the cache saves capabilities under, such as when a client logs into an
+// account. If negotiation has not completed, the capabilities saved under the new key are applied
+// as hints. Otherwise, the capabilities collected so far are saved under the new key.
+func (c *CapabilityCache) SetKey(key string) error {
+	c.lock.Lock()
+	c.key = key
+	settled := c.settled
+	c.lock.Unlock()
+
+	if !settled {
+		return c.applyHints(key)
+	}
+
+	return c.Save()
+}
+
+// Save saves the capabilities collected so far under the cache's key. Capabilities the remote
+// has not negotiated in this session are saved from the hints, so that they aren't forgotten.
+func (c *CapabilityCache) Save() error {
+	c.lock.Lock()
+	key := c.key
+	capabilities := c.mergedCapabilities()
+	c.lock.Unlock()
+
+	return c.store.Save(key, &capabilities)
+}
+
+// Stop stops collecting capabilities without saving them
+func (c *CapabilityCache) Stop() {
+	c.stopOnce.Do(func() {
+		c.stopSave()
+		for _, subscription := range c.subscriptions {
+			subscription.Unregister()
+		}
+	})
+}
@@ -18,17 +18,17 @@ func NewCharacterModeTracker(t *telnet.Terminal) *CharacterModeTracker {
 	t.RegisterTelOptEventHook(tracker.TelOptEvent)
 
 	sga, err := telnet.GetTelOpt[telopts.SUPPRESSGOAHEAD](t)
-	if err == nil {
+	if err == nil && sga != nil {
 		tracker.remoteSuppressGA = sga.RemoteState() == telnet.TelOptActive
 	}
 
 	echo, err := telnet.GetTelOpt[telopts.ECHO](t)
-	if err == nil {
+	if err == nil && echo != nil {
 		tracker.remoteEcho = echo.RemoteState() == telnet.TelOptActive
 	}
 
 	linemode, err := telnet.GetTelOpt[telopts.LINEMODE](t)
-	if err == nil {
+	if err == nil && linemode != nil {
 		tracker.localLineModeNonEdit = linemode.LocalState() == telnet.TelOptActive &&
 			linemode.Mode()&telopts.LineModeEDIT == 0
 	}
@@ -65,8 +65,10 @@ func (t *CharacterModeTracker) TelOptEvent(terminal *telnet.Terminal, data telne
 	}
 }
 
-// IsCharacterMode will return true if both the ECHO and SUPPRESS-GO-AHEAD options are
-// enabled.  Technically this is supposed to be the case when NEITHER or BOTH are enabled,
+// IsCharacterMode will return true if the remote's ECHO and SUPPRESS-GO-AHEAD states indicate
+// character mode according to the terminal's CharacterModePolicy.  With the default
+// CharacterModePolicyMUD, that is the case when both are enabled.  Technically this is supposed
+// to be the case when NEITHER or BOTH are enabled (CharacterModePolicyStrict),
 // as traditionally, "kludge line mode", the line-at-a-time operation you might be familiar
 // with, is supposed to occur when either ECHO or SUPPRESS-GO-AHEAD, but not both, are
 // enabled.  However, MUDs traditionally operate in a line-at-a-time manner and do not
@@ -74,7 +76,7 @@ func (t *CharacterModeTracker) TelOptEvent(terminal *telnet.Terminal, data telne
 // a prompt to clients), resulting in a relatively common expectation that
 // kludge line mode is active when neither telopt is active.
 //
-// As a result, in order to allow the broadest support for the most clients possible,
+// Under the MUD policy, in order to allow the broadest support for the most clients possible,
 // it's recommended that you activate both SUPPRESS-GO-AHEAD and EOR when you want to
 // support line-at-a-time mode and activate both SUPPRESS-GO-AHEAD and ECHO when
 // when you want to support character mode. If line-at-a-time is desired and EOR
@@ -84,5 +86,6 @@ func (t *CharacterModeTracker) TelOptEvent(terminal *telnet.Terminal, data telne
 // BBS's additionally sometimes use LINEMODE which can negotiate whether to use line or character
 // mode in the form of the EDIT flag in MODE
 func (t *CharacterModeTracker) IsCharacterMode() bool {
-	return t.localLineModeNonEdit || (t.remoteEcho && t.remoteSuppressGA)
+	return t.localLineModeNonEdit ||
+		t.terminal.CharacterModePolicy().IsCharacterMode(t.remoteEcho, t.remoteSuppressGA)
 }
@@ -3,6 +3,8 @@ package utils
 import (
 	"context"
 	"log/slog"
+	"sync"
+	"time"
 
 	"github.com/moodclient/telnet"
 )
@@ -17,15 +19,76 @@ type DebugLogConfig struct {
 	OutboundTextLevel      slog.Level
 	TelOptEventLevel       slog.Level
 	TelOptStageChangeLevel slog.Level
+
+	// TextSampleRate, if greater than 1, causes only one in every TextSampleRate text events
+	// (incoming and outbound) to be logged. Commands, errors, and telopt events are always logged.
+	TextSampleRate int
+	// TextBurstLimit, if greater than 0, is the most text events that will be logged in each
+	// TextBurstWindow. Text events beyond that are suppressed until the next window begins.
+	TextBurstLimit int
+	// TextBurstWindow is the length of the window for TextBurstLimit. It defaults to one second.
+	TextBurstWindow time.Duration
 }
 
 type DebugLog struct {
 	logger *slog.Logger
 	config DebugLogConfig
+	text   textSampler
+}
+
+// textSampler decides which text events are logged, according to the sampling and burst
+// settings in DebugLogConfig
+type textSampler struct {
+	lock        sync.Mutex
+	rate        int
+	burstLimit  int
+	burstWindow time.Duration
+
+	seen        uint64
+	windowStart time.Time
+	windowCount int
+	suppressed  int
+}
+
+// allow returns whether the next text event should be logged. If it should, it also returns the
+// number of text events that were suppressed since the last one that was logged.
+func (s *textSampler) allow() (bool, int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.seen++
+	if s.rate > 1 && (s.seen-1)%uint64(s.rate) != 0 {
+		s.suppressed++
+		return false, 0
+	}
+
+	if s.burstLimit > 0 {
+		now := time.Now()
+		if now.Sub(s.windowStart) >= s.burstWindow {
+			s.windowStart = now
+			s.windowCount = 0
+		}
+
+		if s.windowCount >= s.burstLimit {
+			s.suppressed++
+			return false, 0
+		}
+		s.windowCount++
+	}
+
+	suppressed := s.suppressed
+	s.suppressed = 0
+	return true, suppressed
 }
 
 func NewDebugLog(terminal *telnet.Terminal, logger *slog.Logger, config DebugLogConfig) *DebugLog {
 	log := &DebugLog{logger: logger, config: config}
+	log.text.rate = config.TextSampleRate
+	log.text.burstLimit = config.TextBurstLimit
+	log.text.burstWindow = config.TextBurstWindow
+	if log.text.burstWindow <= 0 {
+		log.text.burstWindow = time.Second
+	}
 
 	terminal.RegisterEncounteredErrorHook(log.logError)
 	terminal.RegisterPrinterOutputHook(log.logPrinterOutput)
@@ -44,16 +107,36 @@ func (l *DebugLog) logPrinterOutput(terminal *telnet.Terminal, output telnet.Ter
 	case telnet.CommandData:
 		l.logger.LogAttrs(context.Background(), l.config.IncomingCommandLevel, "Received command", slog.String("command", o.EscapedString(terminal)))
 	default:
-		l.logger.LogAttrs(context.Background(), l.config.IncomingTextLevel, output.EscapedString(terminal))
+		allowed, suppressed := l.text.allow()
+		if !allowed {
+			return
+		}
+
+		l.logger.LogAttrs(context.Background(), l.config.IncomingTextLevel, output.EscapedString(terminal), suppressedAttrs(suppressed)...)
 	}
 }
 
+// suppressedAttrs reports how many text events were left out of the log before this one
+func suppressedAttrs(suppressed int) []slog.Attr {
+	if suppressed == 0 {
+		return nil
+	}
+
+	return []slog.Attr{slog.Int("suppressed", suppressed)}
+}
+
 func (l *DebugLog) logOutboundData(terminal *telnet.Terminal, data telnet.TerminalData) {
 	switch d := data.(type) {
 	case telnet.CommandData:
 		l.logger.LogAttrs(context.Background(), l.config.OutboundCommandLevel, "Sent command", slog.String("command", d.EscapedString(terminal)))
 	default:
-		l.logger.LogAttrs(context.Background(), l.config.OutboundTextLevel, "Sent text", slog.String("contents", d.EscapedString(terminal)))
+		allowed, suppressed := l.text.allow()
+		if !allowed {
+			return
+		}
+
+		l.logger.LogAttrs(context.Background(), l.config.OutboundTextLevel, "Sent text",
+			append([]slog.Attr{slog.String("contents", d.EscapedString(terminal))}, suppressedAttrs(suppressed)...)...)
 	}
 }
 
@@ -64,6 +147,7 @@ func (l *DebugLog) logTelOptEvent(terminal *telnet.Terminal, event telnet.TelOpt
 			slog.String("oldState", typed.OldState.String()),
 			slog.String("newState", typed.NewState.String()),
 			slog.String("side", typed.Side.String()),
+			slog.String("reason", typed.Reason.String()),
 		)
 	default:
 		l.logger.LogAttrs(context.Background(), l.config.TelOptEventLevel, event.String(), slog.String("option", event.Option().String()))
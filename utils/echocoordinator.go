@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"github.com/moodclient/telnet"
+	"github.com/moodclient/telnet/telopts"
+)
+
+const echoCoordinatorKeyboardLock string = "lock.echo-coordinator"
+
+// EchoCoordinator keeps a LineFeed's local echo in step with the remote's side of the ECHO telopt:
+//
+//   - While the remote is echoing, local echo is suppressed, so the user doesn't see everything
+//     they type twice
+//   - While the remote is not echoing, local echo is resumed
+//   - While the remote is deciding whether to echo, neither side can be relied on, so local echo is
+//     suppressed and the keyboard is locked, holding what the user types until the remote answers
+//
+// NewLineFeed creates one for every LineFeed that has a terminal with ECHO registered, so it only
+// needs to be created directly to coordinate a LineFeed that was created before ECHO was registered.
+type EchoCoordinator struct {
+	terminal     *telnet.Terminal
+	lineFeed     *LineFeed
+	subscription *telnet.Subscription
+}
+
+// NewEchoCoordinator begins coordinating the local echo of lineFeed with the remote's side of
+// ECHO, until the terminal shuts down or Stop is called. If the remote is already echoing, or is
+// deciding whether to, local echo is suppressed immediately. Otherwise, the LineFeed's echo is
+// left alone until ECHO changes state.
+func NewEchoCoordinator(terminal *telnet.Terminal, lineFeed *LineFeed) *EchoCoordinator {
+	coordinator := &EchoCoordinator{
+		terminal: terminal,
+		lineFeed: lineFeed,
+	}
+
+	echo, err := telnet.GetTelOpt[telopts.ECHO](terminal)
+	if err == nil && echo != nil && echo.RemoteState() != telnet.TelOptInactive {
+		coordinator.update(echo.RemoteState())
+	}
+
+	coordinator.subscription = terminal.RegisterTelOptEventHook(coordinator.TelOptEvent)
+
+	return coordinator
+}
+
+// TelOptEvent receives telopt events from the terminal. It is registered automatically by
+// NewEchoCoordinator.
+func (c *EchoCoordinator) TelOptEvent(terminal *telnet.Terminal, event telnet.TelOptEvent) {
+	stateChange, isStateChange := event.(telnet.TelOptStateChangeEvent)
+	if !isStateChange || stateChange.Side != telnet.TelOptSideRemote {
+		return
+	}
+
+	_, isEcho := stateChange.TelnetOption.(*telopts.ECHO)
+	if isEcho {
+		c.update(stateChange.NewState)
+	}
+}
+
+func (c *EchoCoordinator) update(remoteState telnet.TelOptState) {
+	switch remoteState {
+	case telnet.TelOptRequested:
+		c.lineFeed.SetSuppressLocalEcho(true)
+		c.terminal.Keyboard().SetLock(echoCoordinatorKeyboardLock, telnet.DefaultKeyboardLock)
+	case telnet.TelOptActive:
+		c.lineFeed.SetSuppressLocalEcho(true)
+		c.terminal.Keyboard().ClearLock(echoCoordinatorKeyboardLock)
+	case telnet.TelOptInactive:
+		c.lineFeed.SetSuppressLocalEcho(false)
+		c.terminal.Keyboard().ClearLock(echoCoordinatorKeyboardLock)
+	}
+}
+
+// Stop stops coordinating the LineFeed's echo. Local echo is left as it is, and the keyboard is
+// unlocked if the coordinator had locked it.
+func (c *EchoCoordinator) Stop() {
+	c.subscription.Unregister()
+	c.terminal.Keyboard().ClearLock(echoCoordinatorKeyboardLock)
+}
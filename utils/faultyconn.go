@@ -0,0 +1,243 @@
+package utils
+
+import (
+	"bytes"
+	"math/rand/v2"
+	"net"
+	"sync"
+
+	"github.com/moodclient/telnet"
+)
+
+// FaultKind is a type of failure that FaultyConn can inject
+type FaultKind byte
+
+const (
+	// FaultTemporaryError causes the operation to fail with a temporary net.Error without
+	// transferring any data
+	FaultTemporaryError FaultKind = iota
+	// FaultShort causes the operation to transfer only part of the data. Short writes transfer
+	// part of the data and then fail with a temporary net.Error, as io.Writer requires.
+	FaultShort
+	// FaultSplitIAC causes the operation to stop immediately after the first IAC byte in the data,
+	// so that a command arrives split across two operations. If there is no IAC byte, the operation
+	// proceeds normally.
+	FaultSplitIAC
+	// FaultClose abruptly closes the connection before the operation takes place
+	FaultClose
+)
+
+// FaultOperation indicates whether a fault applies to reads or writes
+type FaultOperation byte
+
+const (
+	FaultOnRead FaultOperation = iota
+	FaultOnWrite
+)
+
+// ScriptedFault injects a fault into a specific call to Read or Write
+type ScriptedFault struct {
+	Operation FaultOperation
+	// Call is the zero-based index of the call to Read or Write that should fail
+	Call int
+	Kind FaultKind
+}
+
+// FaultyConnConfig determines which faults FaultyConn injects. Faults can be scripted, injected at
+// random, or both. Scripted faults take priority over random faults.
+type FaultyConnConfig struct {
+	Script []ScriptedFault
+
+	// Seed initializes the random number generator used for random faults, so that a failing run
+	// can be reproduced
+	Seed uint64
+	// The following rates are the probability, between 0 and 1, that each call to Read or Write will
+	// suffer the corresponding fault
+	TemporaryErrorRate float64
+	ShortRate          float64
+	SplitIACRate       float64
+	CloseRate          float64
+}
+
+// temporaryError is a net.Error that reports itself as both temporary and a timeout, like
+// a deadline expiring
+type temporaryError struct{}
+
+var _ net.Error = temporaryError{}
+
+func (temporaryError) Error() string   { return "injected temporary error" }
+func (temporaryError) Timeout() bool   { return true }
+func (temporaryError) Temporary() bool { return true }
+
+// FaultyConn wraps a net.Conn and injects failures into reads and writes, in order to exercise
+// the retry & resync behavior of code that uses the connection, such as a Terminal's keyboard
+// and printer.
+type FaultyConn struct {
+	net.Conn
+
+	config FaultyConnConfig
+	script map[FaultOperation]map[int]FaultKind
+
+	randLock sync.Mutex
+	random   *rand.Rand
+
+	readLock  sync.Mutex
+	reads     int
+	pending   []byte
+	readError error
+
+	writeLock sync.Mutex
+	writes    int
+}
+
+var _ net.Conn = &FaultyConn{}
+
+// NewFaultyConn wraps the provided connection in a FaultyConn. The wrapped connection should
+// not be used directly once it has been wrapped.
+func NewFaultyConn(conn net.Conn, config FaultyConnConfig) *FaultyConn {
+	faulty := &FaultyConn{
+		Conn:   conn,
+		config: config,
+		script: map[FaultOperation]map[int]FaultKind{
+			FaultOnRead:  make(map[int]FaultKind),
+			FaultOnWrite: make(map[int]FaultKind),
+		},
+		random: rand.New(rand.NewPCG(config.Seed, config.Seed)),
+	}
+
+	for _, fault := range config.Script {
+		faulty.script[fault.Operation][fault.Call] = fault.Kind
+	}
+
+	return faulty
+}
+
+// nextFault decides which fault, if any, should be injected into the provided call
+func (c *FaultyConn) nextFault(operation FaultOperation, call int) (FaultKind, bool) {
+	kind, scripted := c.script[operation][call]
+	if scripted {
+		return kind, true
+	}
+
+	c.randLock.Lock()
+	defer c.randLock.Unlock()
+
+	rates := []struct {
+		kind FaultKind
+		rate float64
+	}{
+		{FaultClose, c.config.CloseRate},
+		{FaultTemporaryError, c.config.TemporaryErrorRate},
+		{FaultSplitIAC, c.config.SplitIACRate},
+		{FaultShort, c.config.ShortRate},
+	}
+
+	for _, rate := range rates {
+		if rate.rate > 0 && c.random.Float64() < rate.rate {
+			return rate.kind, true
+		}
+	}
+
+	return 0, false
+}
+
+// faultLength returns how much of the provided data should be transferred by a short or split operation
+func (c *FaultyConn) faultLength(kind FaultKind, data []byte) int {
+	switch kind {
+	case FaultShort:
+		if len(data) <= 1 {
+			return len(data)
+		}
+
+		c.randLock.Lock()
+		defer c.randLock.Unlock()
+
+		return 1 + c.random.IntN(len(data)-1)
+	case FaultSplitIAC:
+		index := bytes.IndexByte(data, telnet.IAC)
+		if index < 0 {
+			return len(data)
+		}
+
+		return index + 1
+	default:
+		return len(data)
+	}
+}
+
+func (c *FaultyConn) Read(b []byte) (int, error) {
+	c.readLock.Lock()
+	defer c.readLock.Unlock()
+
+	call := c.reads
+	c.reads++
+
+	kind, hasFault := c.nextFault(FaultOnRead, call)
+	if hasFault {
+		switch kind {
+		case FaultTemporaryError:
+			return 0, temporaryError{}
+		case FaultClose:
+			c.Conn.Close()
+		}
+	}
+
+	if len(c.pending) == 0 {
+		if c.readError != nil {
+			return 0, c.readError
+		}
+
+		buffer := make([]byte, len(b))
+		n, err := c.Conn.Read(buffer)
+		c.pending = buffer[:n]
+		c.readError = err
+
+		if n == 0 {
+			c.readError = nil
+			return 0, err
+		}
+	}
+
+	length := len(c.pending)
+	if hasFault {
+		length = c.faultLength(kind, c.pending)
+	}
+
+	n := copy(b, c.pending[:length])
+	c.pending = c.pending[n:]
+
+	return n, nil
+}
+
+func (c *FaultyConn) Write(b []byte) (int, error) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	call := c.writes
+	c.writes++
+
+	kind, hasFault := c.nextFault(FaultOnWrite, call)
+	if !hasFault {
+		return c.Conn.Write(b)
+	}
+
+	switch kind {
+	case FaultTemporaryError:
+		return 0, temporaryError{}
+	case FaultClose:
+		c.Conn.Close()
+		return c.Conn.Write(b)
+	}
+
+	length := c.faultLength(kind, b)
+	if length == len(b) {
+		return c.Conn.Write(b)
+	}
+
+	n, err := c.Conn.Write(b[:length])
+	if err != nil {
+		return n, err
+	}
+
+	return n, temporaryError{}
+}
@@ -0,0 +1,224 @@
+package utils
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/charmbracelet/x/ansi"
+	"github.com/moodclient/telnet"
+	"github.com/moodclient/telnet/telopts"
+)
+
+// DefaultFormatterWidth is the width used by Formatter when the remote has not reported
+// its size via NAWS
+const DefaultFormatterWidth = 80
+
+// Formatter builds formatted output (headers, wrapped paragraphs, and tables) for servers to send
+// to a client. Output is sized to the width the client reported via NAWS, and characters that
+// cannot be represented in the terminal's current encoding charset are replaced with '?', since
+// the keyboard will refuse to send text that cannot be encoded.
+//
+// Output is accumulated until Send is called, at which point it is written to the keyboard in a
+// single write, so that it cannot be interleaved with other text.
+type Formatter struct {
+	terminal *telnet.Terminal
+	width    int
+	output   strings.Builder
+}
+
+func NewFormatter(terminal *telnet.Terminal) *Formatter {
+	return &Formatter{
+		terminal: terminal,
+	}
+}
+
+// SetWidth overrides the width that output is sized to. A width of 0 will restore the
+// default behavior of using the width reported by NAWS.
+func (f *Formatter) SetWidth(width int) {
+	f.width = width
+}
+
+// Width returns the width that output is currently being sized to
+func (f *Formatter) Width() int {
+	if f.width > 0 {
+		return f.width
+	}
+
+	naws, err := telnet.GetTelOpt[telopts.NAWS](f.terminal)
+	if err == nil && naws != nil && naws.RemoteState() == telnet.TelOptActive {
+		width, _ := naws.GetRemoteSize()
+		if width > 0 {
+			return width
+		}
+	}
+
+	return DefaultFormatterWidth
+}
+
+// representable replaces every rune in the provided text that can't be encoded by the
+// terminal's current encoding charset
+func (f *Formatter) representable(text string) string {
+	charset := f.terminal.Charset()
+
+	var sb strings.Builder
+	for _, r := range text {
+		if r < utf8.RuneSelf {
+			sb.WriteRune(r)
+			continue
+		}
+
+		_, err := charset.Encode(string(r))
+		if err != nil {
+			sb.WriteByte('?')
+			continue
+		}
+
+		sb.WriteRune(r)
+	}
+
+	return sb.String()
+}
+
+func (f *Formatter) writeLine(line string) {
+	f.output.WriteString(f.representable(line))
+	f.output.WriteString("\r\n")
+}
+
+// Line adds a single line of text, truncated to the output width
+func (f *Formatter) Line(text string) {
+	f.writeLine(ansi.Truncate(text, f.Width(), ""))
+}
+
+// Blank adds an empty line
+func (f *Formatter) Blank() {
+	f.output.WriteString("\r\n")
+}
+
+// Header adds a line of text centered within the output width, padded on both sides with the provided
+// fill character. A fill of 0 will pad with spaces and leave the right side of the line empty.
+func (f *Formatter) Header(text string, fill rune) {
+	width := f.Width()
+	text = ansi.Truncate(text, width, "")
+	textWidth := ansi.StringWidth(text)
+
+	left := (width - textWidth) / 2
+	right := width - textWidth - left
+
+	if fill == 0 {
+		f.writeLine(strings.Repeat(" ", left) + text)
+		return
+	}
+
+	fillText := string(fill)
+	f.writeLine(strings.Repeat(fillText, left) + text + strings.Repeat(fillText, right))
+}
+
+// Paragraph adds text that has been word-wrapped to the output width. Newlines in the text
+// are preserved.
+func (f *Formatter) Paragraph(text string) {
+	wrapped := ansi.Wrap(text, f.Width(), "")
+
+	for _, line := range strings.Split(wrapped, "\n") {
+		f.writeLine(strings.TrimRight(line, " \r"))
+	}
+}
+
+// Table adds a table with a header row, with columns separated by two spaces. If the table
+// is wider than the output width, the widest columns are narrowed (and their cells truncated)
+// until it fits.
+func (f *Formatter) Table(headers []string, rows [][]string) {
+	const separator = "  "
+
+	columns := len(headers)
+	for _, row := range rows {
+		columns = max(columns, len(row))
+	}
+
+	if columns == 0 {
+		return
+	}
+
+	widths := make([]int, columns)
+	measure := func(row []string) {
+		for i, cell := range row {
+			widths[i] = max(widths[i], ansi.StringWidth(cell))
+		}
+	}
+
+	measure(headers)
+	for _, row := range rows {
+		measure(row)
+	}
+
+	available := f.Width() - len(separator)*(columns-1)
+	for {
+		total := 0
+		widest := 0
+		for i, width := range widths {
+			total += width
+			if width > widths[widest] {
+				widest = i
+			}
+		}
+
+		if total <= available || widths[widest] <= 1 {
+			break
+		}
+
+		widths[widest]--
+	}
+
+	writeRow := func(row []string) {
+		var sb strings.Builder
+		for i, width := range widths {
+			var cell string
+			if i < len(row) {
+				cell = ansi.Truncate(row[i], width, "")
+			}
+
+			if i > 0 {
+				sb.WriteString(separator)
+			}
+
+			sb.WriteString(cell)
+			if i < len(widths)-1 {
+				sb.WriteString(strings.Repeat(" ", width-ansi.StringWidth(cell)))
+			}
+		}
+
+		f.writeLine(strings.TrimRight(sb.String(), " "))
+	}
+
+	writeRow(headers)
+
+	rules := make([]string, columns)
+	for i, width := range widths {
+		rules[i] = strings.Repeat("-", width)
+	}
+	writeRow(rules)
+
+	for _, row := range rows {
+		writeRow(row)
+	}
+}
+
+// String returns the output that has been accumulated so far
+func (f *Formatter) String() string {
+	return f.output.String()
+}
+
+// Reset discards the output that has been accumulated so far
+func (f *Formatter) Reset() {
+	f.output.Reset()
+}
+
+// Send writes all accumulated output to the terminal's keyboard in a single write, and then
+// resets the formatter
+func (f *Formatter) Send() {
+	if f.output.Len() == 0 {
+		return
+	}
+
+	f.terminal.Keyboard().WriteString(f.output.String())
+	f.output.Reset()
+}
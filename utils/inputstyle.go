@@ -0,0 +1,137 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/moodclient/telnet"
+	"github.com/moodclient/telnet/telopts"
+)
+
+// InputStyle is the way a server wants its client to collect the user's input
+type InputStyle int
+
+const (
+	// InputStyleLine has the client edit and echo a line locally and send it when the user presses enter
+	InputStyleLine InputStyle = iota
+	// InputStyleCharacter has the client send each keystroke as it is typed, and the server echoes them
+	InputStyleCharacter
+	// InputStylePassword has the client collect a line without echoing it
+	InputStylePassword
+)
+
+func (s InputStyle) String() string {
+	switch s {
+	case InputStyleLine:
+		return "Line"
+	case InputStyleCharacter:
+		return "Character"
+	case InputStylePassword:
+		return "Password"
+	default:
+		return fmt.Sprintf("InputStyle(%d)", int(s))
+	}
+}
+
+// InputStyleController negotiates ECHO, SUPPRESS-GO-AHEAD, and LINEMODE on a server terminal to put
+// the client into the InputStyle the server wants:
+//
+//   - InputStyleLine turns ECHO off, and sets LINEMODE's MODE to EDIT
+//   - InputStyleCharacter turns ECHO on, and clears EDIT from LINEMODE's MODE
+//   - InputStylePassword turns ECHO on, sets LINEMODE's MODE to EDIT, and stops the ServerLineReader
+//     from echoing what the client sends
+//
+// SUPPRESS-GO-AHEAD is requested in every style, following the recommendation in
+// CharacterModeTracker.IsCharacterMode. Clients without LINEMODE will send password input a
+// character at a time, which ServerLineReader assembles into a line without echoing it.
+//
+// ECHO must be registered with the terminal, and permitted locally. SUPPRESS-GO-AHEAD and LINEMODE
+// are used if they are registered.
+type InputStyleController struct {
+	terminal *telnet.Terminal
+	lines    *ServerLineReader
+
+	lock  sync.Mutex
+	style InputStyle
+}
+
+// NewInputStyleController creates a controller for a server terminal. lines may be nil if the
+// server doesn't use a ServerLineReader. The style is not changed until SetInputStyle is called.
+func NewInputStyleController(terminal *telnet.Terminal, lines *ServerLineReader) (*InputStyleController, error) {
+	if terminal.Side() != telnet.SideServer {
+		return nil, errors.New("input style can only be controlled by a server terminal")
+	}
+
+	echo, err := telnet.GetTelOpt[telopts.ECHO](terminal)
+	if err != nil {
+		return nil, err
+	}
+
+	if echo == nil {
+		return nil, errors.New("input style can only be controlled when ECHO is registered")
+	}
+
+	return &InputStyleController{
+		terminal: terminal,
+		lines:    lines,
+	}, nil
+}
+
+// InputStyle returns the style most recently passed to SetInputStyle
+func (c *InputStyleController) InputStyle() InputStyle {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.style
+}
+
+// SetInputStyle negotiates the telopts for the provided style. Telopts that are already in the
+// right state are left alone, so it is safe to call this before every prompt.
+func (c *InputStyleController) SetInputStyle(style InputStyle) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.lines != nil {
+		// Stop echoing before ECHO turns on, so that none of the password slips out
+		c.lines.SetEchoSuppressed(style == InputStylePassword)
+	}
+
+	sga, err := telnet.GetTelOpt[telopts.SUPPRESSGOAHEAD](c.terminal)
+	if err == nil && sga != nil {
+		err = c.terminal.EnableTelOptSide(sga.Code(), telnet.TelOptSideLocal)
+		if err != nil {
+			return err
+		}
+	}
+
+	linemode, err := telnet.GetTelOpt[telopts.LINEMODE](c.terminal)
+	if err == nil && linemode != nil {
+		mode := linemode.Mode() | telopts.LineModeEDIT
+		if style == InputStyleCharacter {
+			mode &^= telopts.LineModeEDIT
+		}
+		linemode.SetMode(mode)
+	}
+
+	echo, err := telnet.GetTelOpt[telopts.ECHO](c.terminal)
+	if err != nil {
+		return err
+	}
+
+	if echo == nil {
+		return errors.New("ECHO is not registered")
+	}
+
+	if style == InputStyleLine {
+		err = c.terminal.DisableTelOptSide(echo.Code(), telnet.TelOptSideLocal)
+	} else {
+		err = c.terminal.EnableTelOptSide(echo.Code(), telnet.TelOptSideLocal)
+	}
+	if err != nil {
+		return err
+	}
+
+	c.style = style
+	return nil
+}
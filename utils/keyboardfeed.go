@@ -4,10 +4,10 @@ import (
 	"bufio"
 	"io"
 	"os"
+	"sync/atomic"
 	"time"
 
 	"github.com/moodclient/telnet"
-	"github.com/moodclient/telnet/telopts"
 )
 
 type KeyboardFeed struct {
@@ -17,6 +17,7 @@ type KeyboardFeed struct {
 
 	characterMode *CharacterModeTracker
 	lineFeed      *LineFeed
+	profile       atomic.Pointer[Profile]
 }
 
 func NewKeyboardFeed(terminal *telnet.Terminal, input io.Reader, lineFeed *LineFeed, characterMode *CharacterModeTracker) (*KeyboardFeed, error) {
@@ -59,7 +60,7 @@ loop:
 				break loop
 			}
 
-			text := scanner.Text()
+			text := f.profile.Load().MapKey(scanner.Text())
 
 			if text == "\x7f" {
 				text = "\x08"
@@ -86,20 +87,14 @@ loop:
 	return scanner.Err()
 }
 
-func (f *KeyboardFeed) telOptEvents(terminal *telnet.Terminal, event telnet.TelOptEvent) {
-	switch typed := event.(type) {
-	case telnet.TelOptStateChangeEvent:
-		if typed.Side != telnet.TelOptSideRemote {
-			return
-		}
-
-		_, isEcho := typed.TelnetOption.(*telopts.ECHO)
-		if isEcho && typed.NewState == telnet.TelOptActive {
-			f.lineFeed.SetSuppressLocalEcho(true)
-		} else if isEcho && typed.NewState == telnet.TelOptInactive {
-			f.lineFeed.SetSuppressLocalEcho(false)
-		}
-	}
+// SetProfile provides a profile whose keymap will be applied to keys read from the input.
+// Passing nil will stop keys from being mapped.
+func (f *KeyboardFeed) SetProfile(profile *Profile) {
+	f.profile.Store(profile)
+}
 
+// telOptEvents keeps the LineFeed's character mode up to date. Its local echo is kept up to date
+// by the LineFeed's EchoCoordinator.
+func (f *KeyboardFeed) telOptEvents(terminal *telnet.Terminal, event telnet.TelOptEvent) {
 	f.lineFeed.SetCharacterMode(f.characterMode.IsCharacterMode())
 }
@@ -1,25 +1,51 @@
 package utils
 
 import (
-	"fmt"
 	"slices"
-	"strconv"
 	"strings"
 	"sync"
 
 	"github.com/charmbracelet/x/ansi"
 	"github.com/moodclient/telnet"
+	"github.com/moodclient/telnet/telopts"
 )
 
 type LineFeedConfig struct {
 	MaxLength         int
 	CharacterMode     bool
 	SuppressLocalEcho bool
+
+	// Renderer receives edit operations for the line being typed. If it is nil, edits are
+	// rendered as ANSI sequences and sent to EchoOut.
+	Renderer LineFeedRenderer
+
+	// HistorySize is the number of submitted lines that are kept for recall with the up & down
+	// arrows and with reverse search (ctrl-R). History is disabled when HistorySize is 0.
+	HistorySize int
+
+	// EditCharacters are control characters that edit the line, in addition to backspace and DEL.
+	// When LINEMODE is active, they are replaced by the characters negotiated with SLC.
+	EditCharacters LineFeedEditCharacters
+}
+
+// LineFeedEditCharacters are the control characters that LineFeed uses to edit the line being
+// typed. A character of 0 is not used.
+type LineFeedEditCharacters struct {
+	// EraseCharacter erases the character before the cursor
+	EraseCharacter byte
+	// EraseWord erases the word before the cursor
+	EraseWord byte
+	// EraseLine erases the whole line
+	EraseLine byte
+	// Interrupt discards the line and sends IAC IP
+	Interrupt byte
 }
 
 type LineFeed struct {
-	terminal *telnet.Terminal
-	parser   *telnet.TerminalDataParser
+	terminal     *telnet.Terminal
+	parser       *telnet.TerminalDataParser
+	subscription *telnet.Subscription
+	echo         *EchoCoordinator
 
 	LineOut telnet.TerminalDataHandler
 	EchoOut telnet.TerminalDataHandler
@@ -28,33 +54,183 @@ type LineFeed struct {
 
 	justPushedCR bool
 
-	config LineFeedConfig
+	config   LineFeedConfig
+	renderer LineFeedRenderer
+	// localEditCharacters are the edit characters to use when LINEMODE isn't active
+	localEditCharacters LineFeedEditCharacters
+	profile             *Profile
 
 	cursorPos      int
 	currentLine    []rune
 	visibleIndices []int
+
+	history      []lineFeedEntry
+	historyPos   int
+	historyDraft lineFeedEntry
+
+	searching    bool
+	searchQuery  []rune
+	searchIndex  int
+	searchFailed bool
+	searchSaved  lineFeedEntry
 }
 
 func NewLineFeed(terminal *telnet.Terminal, lineOut, echoOut telnet.TerminalDataHandler, config LineFeedConfig) *LineFeed {
-	return &LineFeed{
+	feed := &LineFeed{
 		terminal: terminal,
 		parser:   telnet.NewTerminalDataParser(),
 
 		LineOut: lineOut,
 		EchoOut: echoOut,
 
-		config: config,
+		config:   config,
+		renderer: config.Renderer,
+
+		localEditCharacters: config.EditCharacters,
+
+		historyPos: -1,
+	}
+
+	if feed.renderer == nil {
+		feed.renderer = &ansiLineFeedRenderer{feed: feed}
+	}
+
+	if terminal != nil {
+		linemode, err := telnet.GetTelOpt[telopts.LINEMODE](terminal)
+		if err == nil && linemode != nil && linemode.LocalState() == telnet.TelOptActive {
+			feed.config.EditCharacters = slcEditCharacters(linemode)
+		}
+
+		feed.subscription = terminal.RegisterTelOptEventHook(feed.TelOptEvent)
+
+		echo, err := telnet.GetTelOpt[telopts.ECHO](terminal)
+		if err == nil && echo != nil {
+			feed.echo = NewEchoCoordinator(terminal, feed)
+		}
+	}
+
+	return feed
+}
+
+// Stop unregisters the LineFeed from the terminal it was created with, and stops coordinating
+// its echo with ECHO. The line being typed is left as it is.
+func (l *LineFeed) Stop() {
+	if l.subscription != nil {
+		l.subscription.Unregister()
+	}
+
+	if l.echo != nil {
+		l.echo.Stop()
+	}
+}
+
+// slcEditCharacters collects the edit characters that have been negotiated with LINEMODE's SLC
+func slcEditCharacters(linemode *telopts.LINEMODE) LineFeedEditCharacters {
+	character := func(function telopts.SLCFunction) byte {
+		entry, supported := linemode.SLC(function)
+		if !supported {
+			return 0
+		}
+
+		return entry.Value
+	}
+
+	return LineFeedEditCharacters{
+		EraseCharacter: character(telopts.SLCEraseCharacter),
+		EraseWord:      character(telopts.SLCEraseWord),
+		EraseLine:      character(telopts.SLCEraseLine),
+		Interrupt:      character(telopts.SLCInterrupt),
+	}
+}
+
+// TelOptEvent keeps the edit characters in step with the characters negotiated by LINEMODE.
+// NewLineFeed registers it with the terminal.
+func (l *LineFeed) TelOptEvent(terminal *telnet.Terminal, event telnet.TelOptEvent) {
+	l.lineLock.Lock()
+	defer l.lineLock.Unlock()
+
+	editCharacters := l.localEditCharacters
+
+	switch typed := event.(type) {
+	case telnet.TelOptStateChangeEvent:
+		linemode, isLinemode := typed.Option().(*telopts.LINEMODE)
+		if !isLinemode || typed.Side != telnet.TelOptSideLocal {
+			return
+		}
+
+		if typed.NewState == telnet.TelOptActive {
+			editCharacters = slcEditCharacters(linemode)
+		} else if typed.NewState != telnet.TelOptInactive {
+			return
+		}
+	case telopts.SLCChangedEvent:
+		linemode, isLinemode := typed.Option().(*telopts.LINEMODE)
+		if !isLinemode || linemode.LocalState() != telnet.TelOptActive {
+			return
+		}
+
+		editCharacters = slcEditCharacters(linemode)
+	default:
+		return
+	}
+
+	l.config.EditCharacters = editCharacters
+}
+
+// EditCharacters returns the control characters currently used to edit the line
+func (l *LineFeed) EditCharacters() LineFeedEditCharacters {
+	l.lineLock.Lock()
+	defer l.lineLock.Unlock()
+
+	return l.config.EditCharacters
+}
+
+// SetEditCharacters changes the control characters used to edit the line. While LINEMODE is
+// active, the characters it negotiated are used instead.
+func (l *LineFeed) SetEditCharacters(editCharacters LineFeedEditCharacters) {
+	l.lineLock.Lock()
+	defer l.lineLock.Unlock()
+
+	l.localEditCharacters = editCharacters
+
+	if l.terminal != nil {
+		linemode, err := telnet.GetTelOpt[telopts.LINEMODE](l.terminal)
+		if err == nil && linemode != nil && linemode.LocalState() == telnet.TelOptActive {
+			return
+		}
+	}
+
+	l.config.EditCharacters = editCharacters
+}
+
+// echoEnabled indicates whether edits should be sent to the renderer
+func (l *LineFeed) echoEnabled() bool {
+	return !l.config.CharacterMode && !l.config.SuppressLocalEcho
+}
+
+// visibleLength returns the number of visible characters in the current line
+func (l *LineFeed) visibleLength() int {
+	return len(l.visibleIndices)
+}
+
+// rawTextFrom returns the text of the current line, including invisible sequences,
+// beginning at the provided visible position
+func (l *LineFeed) rawTextFrom(position int) string {
+	if position >= len(l.visibleIndices) {
+		return ""
 	}
+
+	return string(l.currentLine[l.visibleIndices[position]:])
 }
 
 func (l *LineFeed) insertData(newRunes string, visible bool) {
 	if l.config.MaxLength > 0 && len(l.visibleIndices) >= l.config.MaxLength {
-		l.echo(telnet.TextData(string(rune(ansi.BEL))))
+		l.bell()
 		return
 	} else if l.config.MaxLength > 0 && visible && len(l.visibleIndices)+len(newRunes) > l.config.MaxLength {
 		remainingLength := l.config.MaxLength - len(l.visibleIndices)
 		newRunes = newRunes[:remainingLength]
-		l.echo(telnet.TextData(string(rune(ansi.BEL))))
+		l.bell()
 	}
 
 	// We build a line using 3 components:
@@ -74,6 +250,7 @@ func (l *LineFeed) insertData(newRunes string, visible bool) {
 	// 4. If the new text is visible, we must advance the cursor position
 
 	// Step 1 - insert the runes and also get a rune count while we're at it
+	insertPos := l.cursorPos
 	runeCount := 0
 	cursorLocation := len(l.currentLine)
 	if l.cursorPos < len(l.visibleIndices) {
@@ -100,35 +277,9 @@ func (l *LineFeed) insertData(newRunes string, visible bool) {
 		}
 	}
 
-	if !visible {
-		return
-	}
-
-	// Echoing new text:
-	// 1. if the cursor is at the end, just write the new text
-	if l.cursorPos >= len(l.visibleIndices) {
-		l.echo(telnet.TextData(newRunes))
-		return
-	}
-
-	var update strings.Builder
-	// 2. Otherwise, clear the rest of the line
-	update.WriteString("\x1b[K")
-
-	// - Rewrite the line from the space before the cursor
-	textPos := l.visibleIndices[l.cursorPos-1]
-	for i := textPos; i < len(l.currentLine); i++ {
-		update.WriteRune(l.currentLine[i])
+	if visible && l.echoEnabled() {
+		l.renderer.InsertText(l.terminal, insertPos, newRunes)
 	}
-
-	// - Reset the cursor position
-	writtenSpaces := len(l.visibleIndices) - l.cursorPos
-	update.WriteRune('\x1b')
-	update.WriteRune('[')
-	update.WriteString(strconv.Itoa(writtenSpaces))
-	update.WriteRune('D')
-
-	l.echo(telnet.TextData(update.String()))
 }
 
 func (l *LineFeed) moveCursor(delta int) bool {
@@ -140,15 +291,11 @@ func (l *LineFeed) moveCursor(delta int) bool {
 		l.cursorPos = len(l.visibleIndices)
 	}
 
-	realDelta := l.cursorPos - startPos
-
-	if realDelta > 0 {
-		l.echo(telnet.TextData(fmt.Sprintf("\x1b[%dC", realDelta)))
-	} else if realDelta < 0 {
-		l.echo(telnet.TextData(fmt.Sprintf("\x1b[%dD", -realDelta)))
+	if l.cursorPos != startPos && l.echoEnabled() {
+		l.renderer.MoveCursor(l.terminal, startPos, l.cursorPos)
 	}
 
-	return realDelta != 0
+	return l.cursorPos != startPos
 }
 
 func (l *LineFeed) deleteAtCursor() {
@@ -178,29 +325,9 @@ func (l *LineFeed) deleteAtCursor() {
 		l.visibleIndices[i] -= (nextTextPos - cursorTextPos)
 	}
 
-	// Fixing the echoed line:
-	//
-	// 1. Clear all text from the cursor position
-	// 2. Rewrite all text after cursor
-	// 3. Walk the cursor back by number of positions written
-	var echo strings.Builder
-	// step 1
-	echo.WriteString("\x1b[K")
-
-	// step 2
-	for i := cursorTextPos; i < len(l.currentLine); i++ {
-		echo.WriteRune(l.currentLine[i])
-	}
-
-	visiblePositions := len(l.visibleIndices) - l.cursorPos
-	if visiblePositions > 0 {
-		echo.WriteRune('\x1b')
-		echo.WriteRune('[')
-		echo.WriteString(strconv.Itoa(visiblePositions))
-		echo.WriteRune('D')
+	if l.echoEnabled() {
+		l.renderer.DeleteRange(l.terminal, l.cursorPos, nextCursorPos)
 	}
-
-	l.echo(telnet.TextData(echo.String()))
 }
 
 func (l *LineFeed) Flush(newline bool) {
@@ -214,9 +341,15 @@ func (l *LineFeed) Flush(newline bool) {
 	l.flush(newline)
 }
 
-func (l *LineFeed) echo(data telnet.TerminalData) {
-	if !l.config.CharacterMode && !l.config.SuppressLocalEcho {
-		l.EchoOut(l.terminal, data)
+func (l *LineFeed) bell() {
+	if l.echoEnabled() {
+		l.renderer.Bell(l.terminal)
+	}
+}
+
+func (l *LineFeed) endLine() {
+	if l.echoEnabled() {
+		l.renderer.EndLine(l.terminal)
 	}
 }
 
@@ -225,39 +358,111 @@ func (l *LineFeed) flush(newline bool) {
 		return
 	}
 
+	l.recordHistory()
+
+	line := l.profile.ExpandAliases(string(l.currentLine))
 	if newline {
-		l.currentLine = append(l.currentLine, '\r', '\n')
+		line += "\r\n"
 	}
 
-	l.parser.FireSingle(l.terminal, string(l.currentLine), l.LineOut)
+	l.parser.FireSingle(l.terminal, line, l.LineOut)
 
 	l.cursorPos = 0
 	l.currentLine = l.currentLine[:0]
 	l.visibleIndices = l.visibleIndices[:0]
 }
 
+// editCharacterIn applies a negotiated edit character. It returns false if the control code
+// isn't one of them.
+func (l *LineFeed) editCharacterIn(code byte) bool {
+	if code == 0 {
+		return false
+	}
+
+	editCharacters := l.config.EditCharacters
+	switch code {
+	case editCharacters.EraseCharacter:
+		if l.moveCursor(-1) {
+			l.deleteAtCursor()
+		}
+	case editCharacters.EraseWord:
+		l.eraseWord()
+	case editCharacters.EraseLine:
+		l.eraseLine()
+	case editCharacters.Interrupt:
+		l.eraseLine()
+		l.LineOut(l.terminal, telnet.CommandData{Command: telnet.Command{OpCode: telnet.IP}})
+	default:
+		return false
+	}
+
+	return true
+}
+
+// eraseWord erases the spaces before the cursor, and then the word before them
+func (l *LineFeed) eraseWord() {
+	for _, inWord := range []bool{false, true} {
+		for l.cursorPos > 0 {
+			previous := l.currentLine[l.visibleIndices[l.cursorPos-1]]
+			if (previous != ' ') != inWord {
+				break
+			}
+
+			l.moveCursor(-1)
+			l.deleteAtCursor()
+		}
+	}
+}
+
+func (l *LineFeed) eraseLine() {
+	l.moveCursor(-l.cursorPos)
+	for l.visibleLength() > 0 {
+		l.deleteAtCursor()
+	}
+
+	l.currentLine = l.currentLine[:0]
+}
+
 func (l *LineFeed) controlCodeIn(sequence telnet.ControlCodeData) {
+	if l.editCharacterIn(byte(sequence)) {
+		return
+	}
+
 	switch sequence {
 	case '\r':
 		l.justPushedCR = true
-		l.echo(telnet.ControlCodeData(ansi.ControlCode('\r')))
-		l.echo(telnet.ControlCodeData(ansi.ControlCode('\n')))
+		l.endLine()
 		l.flush(true)
 	case '\n':
 		if !l.justPushedCR {
-			l.echo(telnet.ControlCodeData(ansi.ControlCode('\r')))
-			l.echo(telnet.ControlCodeData(ansi.ControlCode('\n')))
+			l.endLine()
 			l.flush(true)
 		}
 	case ansi.DEL, ansi.BS:
 		if l.moveCursor(-1) {
 			l.deleteAtCursor()
 		}
+	case ctrlR:
+		if l.config.HistorySize > 0 {
+			l.startSearch()
+		}
 	}
 }
 
 func (l *LineFeed) csiSequenceIn(sequence telnet.CsiData) {
 	switch sequence.Cmd.Command() {
+	case 'A':
+		// Cursor up
+		if l.config.HistorySize > 0 {
+			l.historyMove(-1)
+			return
+		}
+	case 'B':
+		// Cursor down
+		if l.config.HistorySize > 0 {
+			l.historyMove(1)
+			return
+		}
 	case 'C':
 		// Cursor forward
 		delta, _ := sequence.Param(0, 1)
@@ -296,6 +501,10 @@ func (l *LineFeed) LineIn(t *telnet.Terminal, data telnet.TerminalData) {
 
 	hadPushedCR := l.justPushedCR
 
+	if l.searching && l.searchIn(data) {
+		return
+	}
+
 	switch d := data.(type) {
 	case telnet.TextData:
 		l.insertData(d.String(), true)
@@ -340,6 +549,15 @@ func (l *LineFeed) SetSuppressLocalEcho(suppress bool) {
 	l.config.SuppressLocalEcho = suppress
 }
 
+// SetProfile provides a profile whose aliases will be expanded in submitted lines.
+// Passing nil will stop alias expansion.
+func (l *LineFeed) SetProfile(profile *Profile) {
+	l.lineLock.Lock()
+	defer l.lineLock.Unlock()
+
+	l.profile = profile
+}
+
 func (l *LineFeed) Text() string {
 	var sb strings.Builder
 
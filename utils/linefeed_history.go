@@ -0,0 +1,234 @@
+package utils
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/x/ansi"
+	"github.com/moodclient/telnet"
+)
+
+// ctrlR begins (or continues) a reverse history search, and ctrlG cancels it
+const (
+	ctrlR = 0x12
+	ctrlG = 0x07
+)
+
+type lineFeedEntry struct {
+	text           string
+	line           []rune
+	visibleIndices []int
+}
+
+func (l *LineFeed) currentEntry() lineFeedEntry {
+	return lineFeedEntry{
+		text:           l.Text(),
+		line:           slices.Clone(l.currentLine),
+		visibleIndices: slices.Clone(l.visibleIndices),
+	}
+}
+
+// recordHistory adds the current line to the history, unless it is empty or
+// identical to the most recent entry
+func (l *LineFeed) recordHistory() {
+	l.historyPos = -1
+
+	if l.config.HistorySize <= 0 || len(l.visibleIndices) == 0 {
+		return
+	}
+
+	entry := l.currentEntry()
+	if len(l.history) > 0 && l.history[len(l.history)-1].text == entry.text {
+		return
+	}
+
+	l.history = append(l.history, entry)
+	if len(l.history) > l.config.HistorySize {
+		l.history = slices.Delete(l.history, 0, len(l.history)-l.config.HistorySize)
+	}
+}
+
+// replaceLine swaps the current line for the provided entry and leaves the cursor
+// at the end of the new line
+func (l *LineFeed) replaceLine(entry lineFeedEntry) {
+	l.clearLine()
+
+	l.currentLine = append(l.currentLine, entry.line...)
+	l.visibleIndices = append(l.visibleIndices, entry.visibleIndices...)
+	l.cursorPos = len(l.visibleIndices)
+
+	if len(l.currentLine) > 0 && l.echoEnabled() {
+		l.renderer.InsertText(l.terminal, 0, string(l.currentLine))
+	}
+}
+
+// clearLine removes all text from the current line
+func (l *LineFeed) clearLine() {
+	oldLength := len(l.visibleIndices)
+	if l.cursorPos > 0 && l.echoEnabled() {
+		l.renderer.MoveCursor(l.terminal, l.cursorPos, 0)
+	}
+
+	l.cursorPos = 0
+	l.currentLine = l.currentLine[:0]
+	l.visibleIndices = l.visibleIndices[:0]
+
+	if oldLength > 0 && l.echoEnabled() {
+		l.renderer.DeleteRange(l.terminal, 0, oldLength)
+	}
+}
+
+// historyMove walks through the history in response to the up & down arrows. A negative delta
+// moves to older entries. The line that was being typed before the user began walking
+// through history is restored when they walk past the newest entry.
+func (l *LineFeed) historyMove(delta int) {
+	if len(l.history) == 0 {
+		return
+	}
+
+	pos := l.historyPos
+	if pos < 0 {
+		pos = len(l.history)
+	}
+
+	newPos := min(max(pos+delta, 0), len(l.history))
+	if newPos == pos {
+		return
+	}
+
+	if l.historyPos < 0 {
+		l.historyDraft = l.currentEntry()
+	}
+
+	if newPos == len(l.history) {
+		l.historyPos = -1
+		l.replaceLine(l.historyDraft)
+		return
+	}
+
+	l.historyPos = newPos
+	l.replaceLine(l.history[newPos])
+}
+
+// History returns the text of all lines in the history, oldest first
+func (l *LineFeed) History() []string {
+	l.lineLock.Lock()
+	defer l.lineLock.Unlock()
+
+	history := make([]string, 0, len(l.history))
+	for _, entry := range l.history {
+		history = append(history, entry.text)
+	}
+
+	return history
+}
+
+func (l *LineFeed) startSearch() {
+	l.searching = true
+	l.searchQuery = l.searchQuery[:0]
+	l.searchIndex = -1
+	l.searchFailed = false
+	l.searchSaved = l.currentEntry()
+
+	l.clearLine()
+	l.renderSearch()
+}
+
+// searchFrom finds the newest history entry at or before the provided index
+// that contains the current query
+func (l *LineFeed) searchFrom(index int) {
+	query := string(l.searchQuery)
+
+	for i := min(index, len(l.history)-1); i >= 0; i-- {
+		if strings.Contains(l.history[i].text, query) {
+			l.searchIndex = i
+			l.searchFailed = false
+			return
+		}
+	}
+
+	l.searchFailed = true
+}
+
+func (l *LineFeed) renderSearch() {
+	if !l.echoEnabled() {
+		return
+	}
+
+	var match string
+	if l.searchIndex >= 0 {
+		match = l.history[l.searchIndex].text
+	}
+
+	l.renderer.SearchUpdate(l.terminal, string(l.searchQuery), match, !l.searchFailed)
+}
+
+// endSearch leaves search mode. If accept is true, the matched history entry (if any)
+// becomes the current line. Otherwise, the line from before the search is restored.
+func (l *LineFeed) endSearch(accept bool) {
+	l.searching = false
+
+	if l.echoEnabled() {
+		l.renderer.SearchEnd(l.terminal)
+	}
+
+	entry := l.searchSaved
+	if accept && l.searchIndex >= 0 {
+		entry = l.history[l.searchIndex]
+	}
+
+	l.replaceLine(entry)
+}
+
+// searchIn handles input while a reverse history search is underway. It returns false
+// if the search has ended and the input should be processed as normal.
+func (l *LineFeed) searchIn(data telnet.TerminalData) bool {
+	switch d := data.(type) {
+	case telnet.TextData:
+		l.searchQuery = append(l.searchQuery, []rune(d.String())...)
+
+		start := l.searchIndex
+		if start < 0 {
+			start = len(l.history) - 1
+		}
+		l.searchFrom(start)
+	case telnet.ControlCodeData:
+		switch ansi.ControlCode(d) {
+		case ctrlR:
+			if len(l.searchQuery) == 0 {
+				return true
+			}
+
+			if l.searchIndex > 0 {
+				l.searchFrom(l.searchIndex - 1)
+			} else {
+				l.searchFailed = true
+			}
+		case ctrlG:
+			l.endSearch(false)
+			return true
+		case ansi.DEL, ansi.BS:
+			if len(l.searchQuery) == 0 {
+				return true
+			}
+
+			l.searchQuery = l.searchQuery[:len(l.searchQuery)-1]
+			l.searchIndex = -1
+			l.searchFailed = false
+			if len(l.searchQuery) > 0 {
+				l.searchFrom(len(l.history) - 1)
+			}
+		default:
+			l.endSearch(true)
+			return false
+		}
+	case telnet.CsiData:
+		l.endSearch(true)
+		return false
+	default:
+		return true
+	}
+
+	l.renderSearch()
+	return true
+}
@@ -0,0 +1,138 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/x/ansi"
+	"github.com/moodclient/telnet"
+)
+
+// LineFeedRenderer receives semantic edit operations from a LineFeed as the user edits
+// the line they are typing. It can be provided via LineFeedConfig.Renderer by consumers that
+// have their own screen model (GUI clients, web frontends, etc.) and would rather not interpret
+// ANSI sequences.  When no renderer is provided, LineFeed renders edits as ANSI sequences
+// sent to its EchoOut handler.
+//
+// All positions are measured in visible characters from the start of the current line.
+// Renderers are not called when local echo is suppressed or the LineFeed is in character mode.
+type LineFeedRenderer interface {
+	// InsertText is called when visible text is inserted into the line at the provided position.
+	// The cursor is left at the end of the inserted text.
+	InsertText(t *telnet.Terminal, position int, text string)
+	// DeleteRange is called when the visible text between start (inclusive) and end (exclusive)
+	// is removed from the line. The cursor is left at start.
+	DeleteRange(t *telnet.Terminal, start int, end int)
+	// MoveCursor is called when the cursor moves from one position to another without editing
+	// the line
+	MoveCursor(t *telnet.Terminal, from int, to int)
+	// Bell is called when the user attempts to type past LineFeedConfig.MaxLength
+	Bell(t *telnet.Terminal)
+	// EndLine is called when the user submits the current line. The next line starts empty,
+	// with the cursor at position 0.
+	EndLine(t *telnet.Terminal)
+
+	// SearchUpdate is called when a reverse history search begins, and whenever its query or
+	// result changes. match is the text of the history entry that matched the query, or the most
+	// recent match if found is false. The current line is cleared before a search begins.
+	SearchUpdate(t *telnet.Terminal, query string, match string, found bool)
+	// SearchEnd is called when a reverse history search ends. The resulting line is sent
+	// to InsertText immediately afterward.
+	SearchEnd(t *telnet.Terminal)
+}
+
+// ansiLineFeedRenderer is the default LineFeedRenderer, which converts edit operations into
+// ANSI sequences and sends them to the LineFeed's EchoOut handler
+type ansiLineFeedRenderer struct {
+	feed *LineFeed
+
+	// searchWidth is the number of columns written by the current search prompt
+	searchWidth int
+}
+
+var _ LineFeedRenderer = &ansiLineFeedRenderer{}
+
+func (r *ansiLineFeedRenderer) out(t *telnet.Terminal, data telnet.TerminalData) {
+	r.feed.EchoOut(t, data)
+}
+
+// redrawTail clears the line from the cursor, rewrites the line from the provided position
+// to the end, and then walks the cursor back to where it started
+func (r *ansiLineFeedRenderer) redrawTail(t *telnet.Terminal, prefix string, position int) {
+	var update strings.Builder
+	update.WriteString("\x1b[K")
+	update.WriteString(prefix)
+	update.WriteString(r.feed.rawTextFrom(position))
+
+	writtenSpaces := r.feed.visibleLength() - position
+	if writtenSpaces > 0 {
+		update.WriteString(fmt.Sprintf("\x1b[%dD", writtenSpaces))
+	}
+
+	r.out(t, telnet.TextData(update.String()))
+}
+
+func (r *ansiLineFeedRenderer) InsertText(t *telnet.Terminal, position int, text string) {
+	end := position + len([]rune(text))
+
+	// If the cursor is at the end, just write the new text
+	if end >= r.feed.visibleLength() {
+		r.out(t, telnet.TextData(text))
+		return
+	}
+
+	r.redrawTail(t, text, end)
+}
+
+func (r *ansiLineFeedRenderer) DeleteRange(t *telnet.Terminal, start int, end int) {
+	r.redrawTail(t, "", start)
+}
+
+func (r *ansiLineFeedRenderer) MoveCursor(t *telnet.Terminal, from int, to int) {
+	if to > from {
+		r.out(t, telnet.TextData(fmt.Sprintf("\x1b[%dC", to-from)))
+	} else if to < from {
+		r.out(t, telnet.TextData(fmt.Sprintf("\x1b[%dD", from-to)))
+	}
+}
+
+func (r *ansiLineFeedRenderer) Bell(t *telnet.Terminal) {
+	r.out(t, telnet.TextData(string(rune(ansi.BEL))))
+}
+
+func (r *ansiLineFeedRenderer) EndLine(t *telnet.Terminal) {
+	r.out(t, telnet.ControlCodeData(ansi.ControlCode('\r')))
+	r.out(t, telnet.ControlCodeData(ansi.ControlCode('\n')))
+}
+
+// clearSearch walks the cursor back to where the search prompt began and clears it
+func (r *ansiLineFeedRenderer) clearSearch(sb *strings.Builder) {
+	if r.searchWidth > 0 {
+		sb.WriteString(fmt.Sprintf("\x1b[%dD", r.searchWidth))
+	}
+	sb.WriteString("\x1b[K")
+	r.searchWidth = 0
+}
+
+func (r *ansiLineFeedRenderer) SearchUpdate(t *telnet.Terminal, query string, match string, found bool) {
+	var sb strings.Builder
+	r.clearSearch(&sb)
+
+	prompt := "(reverse-i-search)`"
+	if !found {
+		prompt = "(failed reverse-i-search)`"
+	}
+
+	searchText := prompt + query + "': " + match
+	sb.WriteString(searchText)
+	r.searchWidth = ansi.StringWidth(searchText)
+
+	r.out(t, telnet.TextData(sb.String()))
+}
+
+func (r *ansiLineFeedRenderer) SearchEnd(t *telnet.Terminal) {
+	var sb strings.Builder
+	r.clearSearch(&sb)
+
+	r.out(t, telnet.TextData(sb.String()))
+}
@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/moodclient/telnet"
+	"github.com/moodclient/telnet/telopts"
+)
+
+// TerminalSizeSource reports the size of a local terminal and notices when it changes
+type TerminalSizeSource interface {
+	// Size returns the width and height of the terminal, in characters
+	Size() (width, height int, err error)
+	// WatchResize blocks until ctx is done, calling onResize whenever the terminal may have
+	// changed size
+	WatchResize(ctx context.Context, onResize func())
+}
+
+// FileSizeSource is a TerminalSizeSource for the terminal attached to a file, usually os.Stdout.
+// Resizes are detected with SIGWINCH on unix systems, and by polling on other systems.
+type FileSizeSource struct {
+	file *os.File
+}
+
+var _ TerminalSizeSource = &FileSizeSource{}
+
+func NewFileSizeSource(file *os.File) *FileSizeSource {
+	return &FileSizeSource{file: file}
+}
+
+func (s *FileSizeSource) Size() (width, height int, err error) {
+	return terminalSize(s.file.Fd())
+}
+
+func (s *FileSizeSource) WatchResize(ctx context.Context, onResize func()) {
+	watchTerminalResize(ctx, onResize)
+}
+
+// NAWSSync keeps a terminal's NAWS telopt up to date with the size of a local terminal. The size is
+// sent to the remote whenever the local terminal is resized, and checked again when NAWS activates,
+// so the first size the remote receives is never stale.
+type NAWSSync struct {
+	naws         *telopts.NAWS
+	source       TerminalSizeSource
+	subscription *telnet.Subscription
+	cancel       context.CancelFunc
+}
+
+// NewNAWSSync begins keeping the terminal's NAWS telopt in sync with source, until the terminal
+// shuts down or Stop is called. The terminal must have NAWS registered.
+func NewNAWSSync(terminal *telnet.Terminal, source TerminalSizeSource) (*NAWSSync, error) {
+	naws, err := telnet.GetTelOpt[telopts.NAWS](terminal)
+	if err != nil {
+		return nil, err
+	}
+
+	if naws == nil {
+		return nil, errors.New("NAWS is not registered with the terminal")
+	}
+
+	ctx, cancel := context.WithCancel(terminal.Context())
+	sync := &NAWSSync{
+		naws:   naws,
+		source: source,
+		cancel: cancel,
+	}
+
+	sync.subscription = terminal.RegisterTelOptEventHook(sync.TelOptEvent)
+	_ = sync.Update()
+
+	go source.WatchResize(ctx, func() {
+		_ = sync.Update()
+	})
+
+	return sync, nil
+}
+
+// Update reads the size of the local terminal and passes it to NAWS, which sends it to the remote
+// if it has changed
+func (s *NAWSSync) Update() error {
+	width, height, err := s.source.Size()
+	if err != nil {
+		return err
+	}
+
+	if width > 0 && height > 0 {
+		s.naws.SetLocalSize(width, height)
+	}
+
+	return nil
+}
+
+// TelOptEvent receives telopt events from the terminal. It is registered automatically by
+// NewNAWSSync.
+func (s *NAWSSync) TelOptEvent(terminal *telnet.Terminal, event telnet.TelOptEvent) {
+	stateChange, isStateChange := event.(telnet.TelOptStateChangeEvent)
+	if !isStateChange || stateChange.Side != telnet.TelOptSideLocal || stateChange.NewState != telnet.TelOptActive {
+		return
+	}
+
+	if stateChange.Option() == telnet.TelnetOption(s.naws) {
+		_ = s.Update()
+	}
+}
+
+// Stop stops watching the local terminal
+func (s *NAWSSync) Stop() {
+	s.cancel()
+	s.subscription.Unregister()
+}
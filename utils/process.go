@@ -0,0 +1,245 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/x/ansi"
+	"github.com/moodclient/telnet"
+	"github.com/moodclient/telnet/telopts"
+)
+
+// Process runs a local command under a pseudo-terminal and connects it to a server terminal, so
+// that the remote client drives the command the way it would on a telnetd. This is useful for
+// testing clients against real shells and for lab environments. Pseudo-terminals are only
+// supported on linux.
+//
+// What the command writes is sent to the client, and what the client types is written to the
+// command. Telnet line endings (CR LF and CR NUL) are passed to the command as a single CR, and
+// IP, EC, and EL are passed as the pseudo-terminal's interrupt, erase, and kill characters. The
+// window size the client reports with NAWS is passed on to the pseudo-terminal, and if the command
+// has no TERM variable, it is set to the terminal type the client reported with TTYPE.
+//
+// The pseudo-terminal echoes what the client types, so the terminal should usually ask the client
+// to let it echo, by registering ECHO and SUPPRESS-GO-AHEAD with telnet.TelOptRequestLocal.
+// When the command exits the terminal is closed, and when the terminal shuts down the command is
+// hung up.
+type Process struct {
+	terminal *telnet.Terminal
+	cmd      *exec.Cmd
+	control  *os.File
+
+	subscriptions []*telnet.Subscription
+	stopHangup    func() bool
+
+	inputLock  sync.Mutex
+	pushedCR   bool
+	stopOnce   sync.Once
+	outputDone chan struct{}
+	done       chan struct{}
+	err        error
+}
+
+// StartProcess starts cmd under a new pseudo-terminal and connects it to terminal. cmd's Stdin,
+// Stdout, and Stderr are replaced with the pseudo-terminal, and it must not have been started.
+func StartProcess(terminal *telnet.Terminal, cmd *exec.Cmd) (*Process, error) {
+	if terminal.Side() != telnet.SideServer {
+		return nil, errors.New("processes can only be connected to a server terminal")
+	}
+
+	control, processSide, err := openPTY()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Process{
+		terminal:   terminal,
+		cmd:        cmd,
+		control:    control,
+		outputDone: make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+
+	naws, err := telnet.GetTelOpt[telopts.NAWS](terminal)
+	if err == nil && naws != nil {
+		p.subscriptions = append(p.subscriptions, naws.OnRemoteSize(p.resize))
+	}
+
+	p.setTerm()
+
+	cmd.Stdin = processSide
+	cmd.Stdout = processSide
+	cmd.Stderr = processSide
+	cmd.SysProcAttr = ptyProcAttr()
+
+	err = cmd.Start()
+	// The process has its own copy of its side of the pseudo-terminal, and ours would keep the
+	// pseudo-terminal open after the process exits
+	_ = processSide.Close()
+	if err != nil {
+		p.stop()
+		_ = control.Close()
+		return nil, err
+	}
+
+	p.subscriptions = append(p.subscriptions, terminal.RegisterPrinterOutputHook(p.PrinterOutput))
+
+	// The client went away, so hang up on the process
+	p.stopHangup = context.AfterFunc(terminal.Context(), func() {
+		_ = p.Stop()
+	})
+
+	go p.copyOutput()
+	go p.wait()
+
+	return p, nil
+}
+
+// ProcessHandler returns a telnet.ServerHandler that runs the command returned by newCmd for each
+// connection, until it exits or the connection closes. Errors starting or running the command are
+// passed to errorHandler, if it is not nil.
+func ProcessHandler(newCmd func(t *telnet.Terminal) *exec.Cmd, errorHandler func(err error)) telnet.ServerHandler {
+	return func(t *telnet.Terminal) {
+		process, err := StartProcess(t, newCmd(t))
+		if err == nil {
+			err = process.Wait()
+		}
+
+		if err != nil && errorHandler != nil {
+			errorHandler(err)
+		}
+	}
+}
+
+func (p *Process) setTerm() {
+	if p.cmd.Env == nil {
+		p.cmd.Env = os.Environ()
+	}
+
+	for _, variable := range p.cmd.Env {
+		if strings.HasPrefix(variable, "TERM=") {
+			return
+		}
+	}
+
+	terminalType := "dumb"
+	ttype, err := telnet.GetTelOpt[telopts.TTYPE](p.terminal)
+	if err == nil && ttype != nil {
+		if selected := ttype.SelectedTerminal(); selected != "" {
+			terminalType = strings.ToLower(selected)
+		} else if terminals := ttype.GetRemoteTerminals(); len(terminals) > 0 {
+			terminalType = strings.ToLower(terminals[0])
+		}
+	}
+
+	p.cmd.Env = append(p.cmd.Env, "TERM="+terminalType)
+}
+
+func (p *Process) resize(width, height int) {
+	_ = setPTYSize(p.control, width, height)
+}
+
+// copyOutput sends what the process writes to the client until the pseudo-terminal closes
+func (p *Process) copyOutput() {
+	defer close(p.outputDone)
+
+	// The keyboard holds on to characters that are split across reads
+	_, _ = io.Copy(p.terminal.Keyboard(), p.control)
+}
+
+func (p *Process) wait() {
+	err := p.cmd.Wait()
+	p.stopHangup()
+
+	// Everything the process wrote is sent before the terminal is closed
+	p.stop()
+	<-p.outputDone
+	_ = p.control.Close()
+
+	p.err = err
+	close(p.done)
+
+	_ = p.terminal.Keyboard().Flush(p.terminal.Context())
+	_ = p.terminal.Close()
+}
+
+// PrinterOutput receives printer output from the terminal and writes it to the process. It is
+// registered automatically by StartProcess.
+func (p *Process) PrinterOutput(t *telnet.Terminal, data telnet.TerminalData) {
+	p.inputLock.Lock()
+	defer p.inputLock.Unlock()
+
+	hadPushedCR := p.pushedCR
+	p.pushedCR = false
+
+	var input string
+	switch d := data.(type) {
+	case telnet.ControlCodeData:
+		switch ansi.ControlCode(d) {
+		case ansi.CR:
+			p.pushedCR = true
+			input = "\r"
+		case ansi.LF, ansi.NUL:
+			if !hadPushedCR {
+				input = d.String()
+			}
+		default:
+			input = d.String()
+		}
+	case telnet.CommandData:
+		switch d.OpCode {
+		case telnet.IP:
+			input = "\x03"
+		case telnet.EC:
+			input = "\x7f"
+		case telnet.EL:
+			input = "\x15"
+		}
+	case telnet.PromptData:
+	default:
+		input = d.String()
+	}
+
+	if input != "" {
+		_, _ = io.WriteString(p.control, input)
+	}
+}
+
+// stop disconnects the process from the terminal
+func (p *Process) stop() {
+	p.stopOnce.Do(func() {
+		for _, subscription := range p.subscriptions {
+			subscription.Unregister()
+		}
+	})
+}
+
+// Wait blocks until the command exits and everything it wrote has been sent to the client, and
+// returns the error from exec.Cmd.Wait
+func (p *Process) Wait() error {
+	<-p.done
+	return p.err
+}
+
+// Stop hangs up the pseudo-terminal, which usually causes the command to exit, and kills the
+// command if it is still running
+func (p *Process) Stop() error {
+	p.stop()
+
+	err := p.control.Close()
+	if p.cmd.Process != nil {
+		_ = p.cmd.Process.Kill()
+	}
+
+	return err
+}
+
+// Command returns the command that the process is running
+func (p *Process) Command() *exec.Cmd {
+	return p.cmd
+}
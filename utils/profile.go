@@ -0,0 +1,275 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/moodclient/telnet"
+)
+
+// ErrProfileNotFound is returned by ProfileStore.Load when no profile has been saved
+// under the requested name
+var ErrProfileNotFound = errors.New("profile not found")
+
+// ProfileTrigger is a user-defined response to incoming text. Pattern is a regular expression.
+// Triggers are carried out by TriggerRunner.
+type ProfileTrigger struct {
+	Name     string `json:"name"`
+	Pattern  string `json:"pattern"`
+	Response string `json:"response"`
+	Disabled bool   `json:"disabled,omitempty"`
+}
+
+// Profile is a set of user-editable client settings.  Profiles can be saved and loaded with a
+// ProfileStore, and are read by KeyboardFeed (Keymap), LineFeed (Aliases), and TriggerRunner
+// (Triggers) once they have been provided via SetProfile.  A profile should not be modified after
+// it has been provided to a feed- provide a modified copy instead.
+type Profile struct {
+	Name string `json:"name"`
+
+	// Keymap maps a single character read from the local keyboard to the text that should
+	// be sent in its place
+	Keymap map[string]string `json:"keymap,omitempty"`
+	// Aliases maps the first word of a submitted line to the text that should replace it
+	Aliases map[string]string `json:"aliases,omitempty"`
+	// Triggers contains the user's triggers
+	Triggers []ProfileTrigger `json:"triggers,omitempty"`
+
+	// DefaultCharset and FallbackCharset are used to populate the corresponding TerminalConfig
+	// fields with ApplyToConfig
+	DefaultCharset  string `json:"defaultCharset,omitempty"`
+	FallbackCharset string `json:"fallbackCharset,omitempty"`
+}
+
+// ApplyToConfig copies the profile's charset preferences into a TerminalConfig, leaving
+// fields that the profile does not specify untouched
+func (p *Profile) ApplyToConfig(config *telnet.TerminalConfig) {
+	if p.DefaultCharset != "" {
+		config.DefaultCharsetName = p.DefaultCharset
+	}
+
+	if p.FallbackCharset != "" {
+		config.FallbackCharsetName = p.FallbackCharset
+	}
+}
+
+// MapKey returns the text that should be sent when the provided key is pressed
+func (p *Profile) MapKey(key string) string {
+	if p == nil {
+		return key
+	}
+
+	mapped, hasMapping := p.Keymap[key]
+	if !hasMapping {
+		return key
+	}
+
+	return mapped
+}
+
+// ExpandAliases replaces the first word of the provided line if it matches an alias
+func (p *Profile) ExpandAliases(line string) string {
+	if p == nil || len(p.Aliases) == 0 {
+		return line
+	}
+
+	word, rest, hasRest := strings.Cut(line, " ")
+	expansion, hasAlias := p.Aliases[word]
+	if !hasAlias {
+		return line
+	}
+
+	if !hasRest {
+		return expansion
+	}
+
+	return expansion + " " + rest
+}
+
+// ProfileStore is a storage backend for profiles
+type ProfileStore interface {
+	// Load retrieves the profile saved under the provided name, or ErrProfileNotFound
+	Load(name string) (*Profile, error)
+	// Save stores the profile under its name, replacing any profile already saved under that name
+	Save(profile *Profile) error
+	// Delete removes the profile saved under the provided name, if any
+	Delete(name string) error
+	// List returns the names of all saved profiles in alphabetical order
+	List() ([]string, error)
+}
+
+// MemoryProfileStore is a ProfileStore that keeps profiles in memory
+type MemoryProfileStore struct {
+	lock     sync.Mutex
+	profiles map[string][]byte
+}
+
+var _ ProfileStore = &MemoryProfileStore{}
+
+func NewMemoryProfileStore() *MemoryProfileStore {
+	return &MemoryProfileStore{
+		profiles: make(map[string][]byte),
+	}
+}
+
+func (s *MemoryProfileStore) Load(name string) (*Profile, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	data, hasProfile := s.profiles[name]
+	if !hasProfile {
+		return nil, ErrProfileNotFound
+	}
+
+	// Profiles are stored serialized so that callers can't modify stored profiles
+	var profile Profile
+	err := json.Unmarshal(data, &profile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &profile, nil
+}
+
+func (s *MemoryProfileStore) Save(profile *Profile) error {
+	data, err := json.Marshal(profile)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.profiles[profile.Name] = data
+	return nil
+}
+
+func (s *MemoryProfileStore) Delete(name string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.profiles, name)
+	return nil
+}
+
+func (s *MemoryProfileStore) List() ([]string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	names := make([]string, 0, len(s.profiles))
+	for name := range s.profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// FileProfileStore is a ProfileStore that keeps each profile in a JSON file within a directory
+type FileProfileStore struct {
+	dir string
+}
+
+var _ ProfileStore = FileProfileStore{}
+
+// NewFileProfileStore creates a FileProfileStore that keeps profiles in the provided directory.
+// The directory will be created when the first profile is saved if it does not exist.
+func NewFileProfileStore(dir string) FileProfileStore {
+	return FileProfileStore{dir: dir}
+}
+
+func (s FileProfileStore) path(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid profile name %q", name)
+	}
+
+	return filepath.Join(s.dir, name+".json"), nil
+}
+
+func (s FileProfileStore) Load(name string) (*Profile, error) {
+	path, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrProfileNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	var profile Profile
+	err = json.Unmarshal(data, &profile)
+	if err != nil {
+		return nil, fmt.Errorf("profile %s: %w", name, err)
+	}
+
+	return &profile, nil
+}
+
+func (s FileProfileStore) Save(profile *Profile) error {
+	path, err := s.path(profile.Name)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(profile, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(s.dir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a failed write can't corrupt an existing profile
+	tmpPath := path + ".tmp"
+	err = os.WriteFile(tmpPath, data, 0o644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+func (s FileProfileStore) Delete(name string) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+func (s FileProfileStore) List() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		name, isProfile := strings.CutSuffix(entry.Name(), ".json")
+		if isProfile && !entry.IsDir() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
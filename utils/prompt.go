@@ -0,0 +1,201 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/charmbracelet/x/ansi"
+	"github.com/moodclient/telnet"
+)
+
+// PromptSource indicates how a PromptSplitter determined that a line was a prompt
+type PromptSource byte
+
+const (
+	// PromptSourceGA indicates that the prompt was terminated with IAC GA
+	PromptSourceGA PromptSource = iota
+	// PromptSourceEOR indicates that the prompt was terminated with IAC EOR
+	PromptSourceEOR
+	// PromptSourceHeuristic indicates that the prompt was not terminated, but was left
+	// waiting at the end of the output for longer than PromptSplitterConfig.HeuristicTimeout
+	PromptSourceHeuristic
+)
+
+// PromptChangedEvent is delivered by PromptSplitter when the remote sends a prompt that
+// differs from the previous one
+type PromptChangedEvent struct {
+	// Text is the text of the prompt with all escape sequences & control codes removed
+	Text string
+	// Raw is the text of the prompt including escape sequences, suitable for printing to a terminal
+	Raw string
+	// Source indicates how the prompt was detected
+	Source PromptSource
+}
+
+// PromptChangedHandler is an event hook type that receives PromptChangedEvents
+type PromptChangedHandler func(t *telnet.Terminal, event PromptChangedEvent)
+
+type PromptSplitterConfig struct {
+	// HeuristicTimeout enables prompt detection for remotes that don't send GA or EOR. When it
+	// is greater than 0, an unterminated line that has received no further output for this long
+	// is treated as a prompt.
+	HeuristicTimeout time.Duration
+	// HeuristicPattern, if not nil, must match the text of an unterminated line for it to be
+	// treated as a prompt by the heuristic. Lines that don't match are sent to LineOut instead.
+	HeuristicPattern *regexp.Regexp
+}
+
+// PromptSplitter separates the remote's prompt from the rest of its output. It should be registered
+// as a printer output hook (or placed in a middleware stack) via its PrinterOutput method.  Output that
+// is not part of a prompt is sent to LineOut, one line at a time, while prompts are delivered to hooks
+// registered with RegisterPromptChangedHook.
+//
+// Prompts are detected when a line is terminated with IAC GA or IAC EOR rather than a newline. Remotes
+// that don't use GA or EOR can be handled with PromptSplitterConfig.HeuristicTimeout. When the heuristic
+// is in use, LineOut and prompt hooks may be called from a timer goroutine.
+type PromptSplitter struct {
+	terminal *telnet.Terminal
+	LineOut  telnet.TerminalDataHandler
+
+	config      PromptSplitterConfig
+	promptHooks *telnet.EventPublisher[PromptChangedEvent]
+
+	prompt atomic.Pointer[PromptChangedEvent]
+
+	lock    sync.Mutex
+	pending []telnet.TerminalData
+	timer   *time.Timer
+}
+
+func NewPromptSplitter(terminal *telnet.Terminal, lineOut telnet.TerminalDataHandler, config PromptSplitterConfig) *PromptSplitter {
+	splitter := &PromptSplitter{
+		terminal:    terminal,
+		LineOut:     lineOut,
+		config:      config,
+		promptHooks: telnet.NewPublisher[PromptChangedEvent, PromptChangedHandler](nil),
+	}
+
+	if config.HeuristicTimeout > 0 {
+		splitter.timer = time.AfterFunc(config.HeuristicTimeout, splitter.heuristicExpired)
+		splitter.timer.Stop()
+	}
+
+	return splitter
+}
+
+// RegisterPromptChangedHook will register an event to be called when the remote's prompt changes
+func (s *PromptSplitter) RegisterPromptChangedHook(hook PromptChangedHandler) *telnet.Subscription {
+	return s.promptHooks.Register(telnet.EventHook[PromptChangedEvent](hook))
+}
+
+// Prompt returns the most recent prompt received from the remote
+func (s *PromptSplitter) Prompt() PromptChangedEvent {
+	prompt := s.prompt.Load()
+	if prompt == nil {
+		return PromptChangedEvent{}
+	}
+
+	return *prompt
+}
+
+func (s *PromptSplitter) PrinterOutput(t *telnet.Terminal, data telnet.TerminalData) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+
+	switch d := data.(type) {
+	case telnet.PromptData:
+		source := PromptSourceGA
+		if telnet.PromptCommands(d) == telnet.PromptCommandEOR {
+			source = PromptSourceEOR
+		}
+
+		s.emitPrompt(source)
+		return
+	case telnet.ControlCodeData:
+		if d == ansi.LF {
+			s.pending = append(s.pending, data)
+			s.flushLine()
+			return
+		}
+	case telnet.CommandData:
+		// Commands aren't part of the line, so there's no need to hold them back
+		s.LineOut(s.terminal, data)
+		return
+	}
+
+	s.pending = append(s.pending, data)
+
+	if s.timer != nil {
+		s.timer.Reset(s.config.HeuristicTimeout)
+	}
+}
+
+func (s *PromptSplitter) heuristicExpired() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.pending) == 0 {
+		return
+	}
+
+	if s.config.HeuristicPattern != nil && !s.config.HeuristicPattern.MatchString(s.pendingText()) {
+		s.flushLine()
+		return
+	}
+
+	s.emitPrompt(PromptSourceHeuristic)
+}
+
+func (s *PromptSplitter) pendingText() string {
+	var sb strings.Builder
+	for _, data := range s.pending {
+		_, isText := data.(telnet.TextData)
+		if isText {
+			sb.WriteString(data.String())
+		}
+	}
+
+	return strings.TrimSpace(sb.String())
+}
+
+func (s *PromptSplitter) flushLine() {
+	for _, data := range s.pending {
+		s.LineOut(s.terminal, data)
+	}
+
+	s.pending = s.pending[:0]
+}
+
+func (s *PromptSplitter) emitPrompt(source PromptSource) {
+	if len(s.pending) == 0 {
+		// Many remotes send GA after all output, not just prompts
+		return
+	}
+
+	var raw strings.Builder
+	for _, data := range s.pending {
+		raw.WriteString(data.String())
+	}
+
+	prompt := PromptChangedEvent{
+		Text:   s.pendingText(),
+		Raw:    raw.String(),
+		Source: source,
+	}
+	s.pending = s.pending[:0]
+
+	oldPrompt := s.prompt.Load()
+	if oldPrompt != nil && prompt.Raw == oldPrompt.Raw {
+		return
+	}
+
+	s.prompt.Store(&prompt)
+	s.promptHooks.Fire(s.terminal, prompt)
+}
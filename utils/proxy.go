@@ -0,0 +1,235 @@
+package utils
+
+import (
+	"sync"
+
+	"github.com/moodclient/telnet"
+	"github.com/moodclient/telnet/telopts"
+)
+
+// Proxy relays a session between two terminals: a server terminal connected to a client (the
+// client-facing side) and a client terminal connected to a downstream server (the server-facing
+// side). Text, control codes, escape sequences, and prompt hints received by either terminal are
+// written to the other, and each terminal encodes them with its own charset. When either terminal
+// shuts down, the other is closed.
+//
+// Telnet commands are not relayed, since each terminal negotiates telopts with its own remote.
+// Instead, the capabilities the client reports to the client-facing side are translated to the
+// server-facing side, so that the downstream server sees the client through the proxy:
+//
+//   - NAWS: window size changes are passed on with NAWS.SetLocalSize
+//   - TTYPE: the client's terminal types are passed on with TTYPE.SetLocalTerminals. If the
+//     downstream server has already cycled through the proxy's terminal types, TTYPE is
+//     renegotiated so that it will ask again.
+//   - CHARSET: a negotiated charset is passed on with CHARSET.SetPreferredCharsets, which asks
+//     the downstream server to use it as well
+//
+// When the client activates one of these telopts, the server-facing side's usage is changed to
+// request it locally, and when the client deactivates it, the server-facing side stops offering it.
+// Telopts that aren't registered with both terminals are ignored. Errors encountered while
+// translating are delivered to hooks registered with RegisterErrorHook.
+type Proxy struct {
+	clientFacing *telnet.Terminal
+	serverFacing *telnet.Terminal
+	errorHooks   *telnet.EventPublisher[error]
+
+	subscriptions []*telnet.Subscription
+	stop          chan struct{}
+	stopOnce      sync.Once
+}
+
+// NewProxy begins relaying between clientFacing, which should be a server terminal, and
+// serverFacing, which should be a client terminal. If the client has already reported its window
+// size and terminal types to clientFacing, they are passed on immediately. Charsets are only passed
+// on when they are negotiated after the proxy is created.
+func NewProxy(clientFacing, serverFacing *telnet.Terminal) *Proxy {
+	p := &Proxy{
+		clientFacing: clientFacing,
+		serverFacing: serverFacing,
+		errorHooks:   telnet.NewPublisher[error, telnet.ErrorHandler](nil),
+		stop:         make(chan struct{}),
+	}
+
+	p.subscriptions = []*telnet.Subscription{
+		clientFacing.RegisterPrinterOutputHook(p.relayTo(serverFacing)),
+		serverFacing.RegisterPrinterOutputHook(p.relayTo(clientFacing)),
+		clientFacing.RegisterTelOptEventHook(p.clientTelOptEvent),
+	}
+
+	p.passCurrentCapabilities()
+
+	go func() {
+		var remaining *telnet.Terminal
+		select {
+		case <-clientFacing.Context().Done():
+			remaining = serverFacing
+		case <-serverFacing.Context().Done():
+			remaining = clientFacing
+		case <-p.stop:
+			return
+		}
+
+		_ = remaining.Close()
+	}()
+
+	return p
+}
+
+// RegisterErrorHook will register an event to be called when the proxy fails to translate a
+// capability to the server-facing terminal. The hook receives the server-facing terminal.
+func (p *Proxy) RegisterErrorHook(hook telnet.ErrorHandler) *telnet.Subscription {
+	return p.errorHooks.Register(telnet.EventHook[error](hook))
+}
+
+func (p *Proxy) encounteredError(err error) {
+	if err != nil {
+		p.errorHooks.Fire(p.serverFacing, err)
+	}
+}
+
+// Stop stops relaying between the terminals. Neither terminal is closed.
+func (p *Proxy) Stop() {
+	p.stopOnce.Do(func() {
+		for _, subscription := range p.subscriptions {
+			subscription.Unregister()
+		}
+
+		close(p.stop)
+	})
+}
+
+func (p *Proxy) relayTo(target *telnet.Terminal) telnet.TerminalDataHandler {
+	return func(t *telnet.Terminal, data telnet.TerminalData) {
+		switch data.(type) {
+		case telnet.CommandData:
+			// Each side handles its own negotiations
+		case telnet.PromptData:
+			target.Keyboard().SendPromptHint()
+		default:
+			target.Keyboard().LineOut(target, data)
+		}
+	}
+}
+
+func (p *Proxy) passCurrentCapabilities() {
+	naws, err := telnet.GetTelOpt[telopts.NAWS](p.clientFacing)
+	if err == nil && naws != nil && naws.RemoteState() == telnet.TelOptActive {
+		width, height := naws.GetRemoteSize()
+		if width > 0 && height > 0 {
+			p.passSize(width, height)
+		}
+	}
+
+	ttype, err := telnet.GetTelOpt[telopts.TTYPE](p.clientFacing)
+	if err == nil && ttype != nil && ttype.RemoteState() == telnet.TelOptActive {
+		terminals := ttype.GetRemoteTerminals()
+		if len(terminals) > 0 {
+			p.passTerminals(terminals)
+		}
+	}
+}
+
+func (p *Proxy) clientTelOptEvent(t *telnet.Terminal, event telnet.TelOptEvent) {
+	switch e := event.(type) {
+	case telopts.NAWSRemoteSizeChangedEvent:
+		p.passSize(e.NewRemoteWidth, e.NewRemoteHeight)
+	case telopts.TTYPERemoteTerminalsUpdatedEvent:
+		p.passTerminals(e.RemoteTerminals)
+	case telopts.CHARSETNegotiationSuccessEvent:
+		p.passCharset(e.NewCharsetName)
+	case telnet.TelOptStateChangeEvent:
+		// The client's NAWS and TTYPE are on the remote side of the client-facing terminal
+		if e.Side != telnet.TelOptSideRemote {
+			return
+		}
+
+		switch e.TelnetOption.(type) {
+		case *telopts.NAWS, *telopts.TTYPE:
+		default:
+			return
+		}
+
+		if e.NewState == telnet.TelOptActive {
+			p.offerLocally(e.TelnetOption.Code())
+		} else if e.NewState == telnet.TelOptInactive {
+			p.withdrawLocally(e.TelnetOption.Code())
+		}
+	}
+}
+
+// offerLocally makes sure the server-facing terminal requests a telopt on its side
+func (p *Proxy) offerLocally(code telnet.TelOptCode) {
+	option := p.serverFacingTelOpt(code)
+	if option == nil {
+		return
+	}
+
+	p.encounteredError(p.serverFacing.SetTelOptUsage(code, option.Usage()|telnet.TelOptRequestLocal))
+}
+
+// withdrawLocally makes sure the server-facing terminal no longer offers a telopt on its side
+func (p *Proxy) withdrawLocally(code telnet.TelOptCode) {
+	option := p.serverFacingTelOpt(code)
+	if option == nil {
+		return
+	}
+
+	p.encounteredError(p.serverFacing.SetTelOptUsage(code, option.Usage()&^telnet.TelOptRequestLocal))
+}
+
+func (p *Proxy) serverFacingTelOpt(code telnet.TelOptCode) telnet.TelnetOption {
+	for _, option := range p.serverFacing.TelOpts() {
+		if option.Code() == code {
+			return option
+		}
+	}
+
+	return nil
+}
+
+func (p *Proxy) passSize(width, height int) {
+	naws, err := telnet.GetTelOpt[telopts.NAWS](p.serverFacing)
+	if err != nil || naws == nil {
+		return
+	}
+
+	// The size will be sent as soon as NAWS is active
+	naws.SetLocalSize(width, height)
+	if naws.LocalState() != telnet.TelOptActive {
+		p.offerLocally(naws.Code())
+	}
+}
+
+func (p *Proxy) passTerminals(terminals []string) {
+	ttype, err := telnet.GetTelOpt[telopts.TTYPE](p.serverFacing)
+	if err != nil || ttype == nil {
+		return
+	}
+
+	ttype.SetLocalTerminals(terminals)
+	if ttype.LocalState() != telnet.TelOptActive {
+		p.offerLocally(ttype.Code())
+		return
+	}
+
+	// The downstream server may already have the old terminal types, so renegotiate TTYPE, which
+	// will restart the cycle
+	p.encounteredError(p.serverFacing.RenegotiateTelOpt(ttype.Code(), telnet.TelOptSideLocal))
+}
+
+func (p *Proxy) passCharset(charsetName string) {
+	charset, err := telnet.GetTelOpt[telopts.CHARSET](p.serverFacing)
+	if err != nil || charset == nil {
+		return
+	}
+
+	err = charset.SetPreferredCharsets([]string{charsetName})
+	if err != nil {
+		p.encounteredError(err)
+		return
+	}
+
+	if charset.LocalState() != telnet.TelOptActive {
+		p.offerLocally(charset.Code())
+	}
+}
@@ -0,0 +1,77 @@
+//go:build linux
+
+package utils
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+func ioctl(file *os.File, request uintptr, arg unsafe.Pointer) error {
+	rawConn, err := file.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var errno syscall.Errno
+	err = rawConn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(arg))
+	})
+	if err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// openPTY opens a new pseudo-terminal, returning its controlling side and the side that the
+// process runs in
+func openPTY() (control *os.File, process *os.File, err error) {
+	control, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var unlock int32
+	err = ioctl(control, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock))
+	if err != nil {
+		_ = control.Close()
+		return nil, nil, err
+	}
+
+	var number uint32
+	err = ioctl(control, syscall.TIOCGPTN, unsafe.Pointer(&number))
+	if err != nil {
+		_ = control.Close()
+		return nil, nil, err
+	}
+
+	process, err = os.OpenFile(fmt.Sprintf("/dev/pts/%d", number), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		_ = control.Close()
+		return nil, nil, err
+	}
+
+	return control, process, nil
+}
+
+func setPTYSize(control *os.File, width, height int) error {
+	size := winsize{
+		Col: uint16(width),
+		Row: uint16(height),
+	}
+
+	return ioctl(control, syscall.TIOCSWINSZ, unsafe.Pointer(&size))
+}
+
+func ptyProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{
+		Setsid:  true,
+		Setctty: true,
+	}
+}
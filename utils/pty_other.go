@@ -0,0 +1,21 @@
+//go:build !linux
+
+package utils
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+func openPTY() (control *os.File, process *os.File, err error) {
+	return nil, nil, errors.New("pseudo-terminals are only supported on linux")
+}
+
+func setPTYSize(control *os.File, width, height int) error {
+	return nil
+}
+
+func ptyProcAttr() *syscall.SysProcAttr {
+	return nil
+}
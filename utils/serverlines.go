@@ -0,0 +1,228 @@
+package utils
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/x/ansi"
+	"github.com/moodclient/telnet"
+	"github.com/moodclient/telnet/telopts"
+)
+
+// DefaultServerLineLength is the longest line that ServerLineReader will assemble, in characters.
+// Additional characters are discarded until the line ends.
+const DefaultServerLineLength = 4096
+
+const serverLineQueueSize = 16
+
+// ServerLineHandler is an event hook type that receives complete lines from a ServerLineReader
+type ServerLineHandler func(t *telnet.Terminal, line string)
+
+// ServerLineReader assembles the output of a server terminal's printer into complete lines of text
+// from the client. Lines may be ended by CR LF, CR NUL, a bare CR, or a bare LF, and the line ending
+// is not included in the line. Backspace, DEL, IAC EC (erase character), and IAC EL (erase line) are
+// applied to the line being assembled, and escape sequences (such as arrow keys) are discarded.
+//
+// When the server has activated ECHO locally, clients stop echoing what the user types and send it
+// one character at a time, so ServerLineReader echoes typed characters and edits back to the client.
+// Use SetEchoSuppressed to stop this while reading passwords.
+//
+// Lines are delivered to hooks registered with RegisterLineHook, and to the channel returned by
+// Lines, if it has been called. Both are delivered from the terminal loop, so hooks should not
+// block, and the channel must be drained.
+type ServerLineReader struct {
+	terminal     *telnet.Terminal
+	subscription *telnet.Subscription
+	lineHooks    *telnet.EventPublisher[string]
+
+	lock           sync.Mutex
+	line           []rune
+	justPushedCR   bool
+	echoSuppressed bool
+	maxLength      int
+
+	channelLock sync.Mutex
+	lines       chan string
+	closed      bool
+}
+
+// NewServerLineReader creates a ServerLineReader and registers it to receive the terminal's printer
+// output
+func NewServerLineReader(terminal *telnet.Terminal) *ServerLineReader {
+	reader := &ServerLineReader{
+		terminal:  terminal,
+		lineHooks: telnet.NewPublisher[string, ServerLineHandler](nil),
+		maxLength: DefaultServerLineLength,
+	}
+
+	reader.subscription = terminal.RegisterPrinterOutputHook(reader.PrinterOutput)
+
+	go func() {
+		<-terminal.Context().Done()
+		reader.closeLines()
+	}()
+
+	return reader
+}
+
+// RegisterLineHook will register an event to be called when a complete line is received
+func (r *ServerLineReader) RegisterLineHook(hook ServerLineHandler) *telnet.Subscription {
+	return r.lineHooks.Register(telnet.EventHook[string](hook))
+}
+
+// Lines returns a channel that receives every complete line from the client, and is closed when the
+// terminal shuts down. The terminal loop blocks while the channel is full.
+func (r *ServerLineReader) Lines() <-chan string {
+	r.channelLock.Lock()
+	defer r.channelLock.Unlock()
+
+	if r.lines == nil {
+		r.lines = make(chan string, serverLineQueueSize)
+		if r.closed {
+			close(r.lines)
+		}
+	}
+
+	return r.lines
+}
+
+func (r *ServerLineReader) closeLines() {
+	r.channelLock.Lock()
+	defer r.channelLock.Unlock()
+
+	r.closed = true
+	if r.lines != nil {
+		close(r.lines)
+	}
+}
+
+// Stop unregisters the reader from the terminal. The line being assembled is discarded.
+func (r *ServerLineReader) Stop() {
+	r.subscription.Unregister()
+}
+
+// SetMaxLength sets the longest line the reader will assemble, in characters. A length of 0 or less
+// restores DefaultServerLineLength.
+func (r *ServerLineReader) SetMaxLength(length int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if length <= 0 {
+		length = DefaultServerLineLength
+	}
+
+	r.maxLength = length
+}
+
+// SetEchoSuppressed prevents the reader from echoing typed characters while ECHO is active, which is
+// how servers read passwords
+func (r *ServerLineReader) SetEchoSuppressed(suppressed bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.echoSuppressed = suppressed
+}
+
+// Text returns the line that is currently being assembled
+func (r *ServerLineReader) Text() string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return string(r.line)
+}
+
+// echoing indicates whether the reader is responsible for echoing input back to the client
+func (r *ServerLineReader) echoing() bool {
+	if r.echoSuppressed {
+		return false
+	}
+
+	echo, err := telnet.GetTelOpt[telopts.ECHO](r.terminal)
+	return err == nil && echo != nil && echo.LocalState() == telnet.TelOptActive
+}
+
+func (r *ServerLineReader) echo(text string) {
+	if text != "" && r.echoing() {
+		r.terminal.Keyboard().WriteString(text)
+	}
+}
+
+func (r *ServerLineReader) insertText(text string) {
+	var inserted strings.Builder
+	for _, char := range text {
+		if len(r.line) >= r.maxLength {
+			break
+		}
+
+		r.line = append(r.line, char)
+		inserted.WriteRune(char)
+	}
+
+	r.echo(inserted.String())
+}
+
+func (r *ServerLineReader) eraseCharacters(count int) {
+	count = min(count, len(r.line))
+	if count == 0 {
+		return
+	}
+
+	r.line = r.line[:len(r.line)-count]
+	r.echo(strings.Repeat("\b \b", count))
+}
+
+func (r *ServerLineReader) endLine() {
+	line := string(r.line)
+	r.line = r.line[:0]
+
+	r.echo("\r\n")
+	r.lineHooks.Fire(r.terminal, line)
+
+	r.channelLock.Lock()
+	defer r.channelLock.Unlock()
+
+	if r.lines == nil || r.closed {
+		return
+	}
+
+	select {
+	case r.lines <- line:
+	case <-r.terminal.Context().Done():
+	}
+}
+
+// PrinterOutput receives printer output from the terminal. It is registered automatically by
+// NewServerLineReader.
+func (r *ServerLineReader) PrinterOutput(t *telnet.Terminal, data telnet.TerminalData) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	hadPushedCR := r.justPushedCR
+	r.justPushedCR = false
+
+	switch d := data.(type) {
+	case telnet.TextData:
+		r.insertText(string(d))
+	case telnet.ControlCodeData:
+		switch ansi.ControlCode(d) {
+		case ansi.CR:
+			r.justPushedCR = true
+			r.endLine()
+		case ansi.LF:
+			if !hadPushedCR {
+				r.endLine()
+			}
+		case ansi.BS, ansi.DEL:
+			r.eraseCharacters(1)
+		case ansi.HT:
+			r.insertText("\t")
+		}
+	case telnet.CommandData:
+		switch d.OpCode {
+		case telnet.EC:
+			r.eraseCharacters(1)
+		case telnet.EL:
+			r.eraseCharacters(len(r.line))
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package utils
+
+import (
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+)
+
+// SimulatedLinkConfig describes the conditions that a SimulatedConn should imitate. Each
+// setting applies separately to each direction of the connection. Settings left at zero
+// are not simulated.
+type SimulatedLinkConfig struct {
+	// BytesPerSecond caps the rate at which data is delivered
+	BytesPerSecond int
+	// Latency delays the delivery of all data
+	Latency time.Duration
+	// Jitter adds a random amount of time up to this value to the latency of each chunk. Data
+	// is never delivered out of order, so jitter will also delay the chunks that follow.
+	Jitter time.Duration
+	// MaxChunkSize splits data into chunks of random sizes up to this value, which are delivered
+	// separately. This imitates links that fragment data, so that commands, escape sequences, and
+	// characters can arrive split across several reads.
+	MaxChunkSize int
+	// QueueSize is the number of chunks that can be waiting for delivery in each direction
+	// before writes block. The default is 256.
+	QueueSize int
+}
+
+type simulatedChunk struct {
+	data      []byte
+	deliverAt time.Time
+}
+
+// linkScheduler decides when each chunk travelling in one direction should arrive
+type linkScheduler struct {
+	config       SimulatedLinkConfig
+	lock         sync.Mutex
+	lastDelivery time.Time
+}
+
+func (s *linkScheduler) split(data []byte) [][]byte {
+	if s.config.MaxChunkSize <= 0 || len(data) <= 1 {
+		return [][]byte{data}
+	}
+
+	var chunks [][]byte
+	for len(data) > 0 {
+		size := min(1+rand.IntN(s.config.MaxChunkSize), len(data))
+		chunks = append(chunks, data[:size])
+		data = data[size:]
+	}
+
+	return chunks
+}
+
+func (s *linkScheduler) schedule(data []byte) []simulatedChunk {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var chunks []simulatedChunk
+	for _, chunk := range s.split(data) {
+		delay := s.config.Latency
+		if s.config.Jitter > 0 {
+			delay += rand.N(s.config.Jitter)
+		}
+
+		// Chunks must arrive in order, and can't start arriving until the previous chunk
+		// has finished
+		deliverAt := time.Now().Add(delay)
+		if deliverAt.Before(s.lastDelivery) {
+			deliverAt = s.lastDelivery
+		}
+
+		if s.config.BytesPerSecond > 0 {
+			deliverAt = deliverAt.Add(time.Duration(len(chunk)) * time.Second / time.Duration(s.config.BytesPerSecond))
+		}
+
+		s.lastDelivery = deliverAt
+		chunks = append(chunks, simulatedChunk{data: chunk, deliverAt: deliverAt})
+	}
+
+	return chunks
+}
+
+// SimulatedConn wraps a net.Conn and imitates a slow or unreliable link, so that client UIs and
+// server pagination logic can be tested against poor network conditions without a real network.
+// Writes are accepted immediately (until the queue fills) and delivered to the wrapped connection
+// on schedule, and data read from the wrapped connection is held back until it is due.
+//
+// Deadlines are passed through to the wrapped connection, so they apply to the underlying transfer
+// rather than to the simulated delivery.
+type SimulatedConn struct {
+	net.Conn
+
+	readSchedule  *linkScheduler
+	writeSchedule *linkScheduler
+
+	reads    chan simulatedChunk
+	writes   chan simulatedChunk
+	readErr  error
+	current  []byte
+	readLock sync.Mutex
+
+	writeLock sync.Mutex
+	writeErr  error
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+var _ net.Conn = &SimulatedConn{}
+
+// NewSimulatedConn wraps the provided connection in a SimulatedConn. The wrapped connection should
+// not be used directly once it has been wrapped.
+func NewSimulatedConn(conn net.Conn, config SimulatedLinkConfig) *SimulatedConn {
+	queueSize := config.QueueSize
+	if queueSize <= 0 {
+		queueSize = 256
+	}
+
+	simulated := &SimulatedConn{
+		Conn:          conn,
+		readSchedule:  &linkScheduler{config: config},
+		writeSchedule: &linkScheduler{config: config},
+		reads:         make(chan simulatedChunk, queueSize),
+		writes:        make(chan simulatedChunk, queueSize),
+		closed:        make(chan struct{}),
+	}
+
+	go simulated.readLoop()
+	go simulated.writeLoop()
+
+	return simulated
+}
+
+// wait blocks until the provided time, and returns false if the connection was closed first
+func (c *SimulatedConn) wait(until time.Time) bool {
+	delay := time.Until(until)
+	if delay <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-c.closed:
+		return false
+	}
+}
+
+func (c *SimulatedConn) readLoop() {
+	defer close(c.reads)
+
+	buffer := make([]byte, 4096)
+	for {
+		n, err := c.Conn.Read(buffer)
+		if n > 0 {
+			data := make([]byte, n)
+			copy(data, buffer[:n])
+
+			for _, chunk := range c.readSchedule.schedule(data) {
+				select {
+				case c.reads <- chunk:
+				case <-c.closed:
+					return
+				}
+			}
+		}
+
+		if err != nil {
+			// readErr is published to Read by closing the reads channel
+			c.readErr = err
+			return
+		}
+	}
+}
+
+func (c *SimulatedConn) writeLoop() {
+	for {
+		select {
+		case <-c.closed:
+			return
+		case chunk := <-c.writes:
+			if !c.wait(chunk.deliverAt) {
+				return
+			}
+
+			_, err := c.Conn.Write(chunk.data)
+			if err != nil {
+				c.writeLock.Lock()
+				c.writeErr = err
+				c.writeLock.Unlock()
+				return
+			}
+		}
+	}
+}
+
+func (c *SimulatedConn) Read(b []byte) (int, error) {
+	c.readLock.Lock()
+	defer c.readLock.Unlock()
+
+	if len(c.current) == 0 {
+		chunk, ok := <-c.reads
+		if !ok {
+			return 0, c.readErr
+		}
+
+		if !c.wait(chunk.deliverAt) {
+			return 0, net.ErrClosed
+		}
+
+		c.current = chunk.data
+	}
+
+	n := copy(b, c.current)
+	c.current = c.current[n:]
+
+	return n, nil
+}
+
+func (c *SimulatedConn) Write(b []byte) (int, error) {
+	c.writeLock.Lock()
+	err := c.writeErr
+	c.writeLock.Unlock()
+
+	if err != nil {
+		return 0, err
+	}
+
+	data := make([]byte, len(b))
+	copy(data, b)
+
+	for _, chunk := range c.writeSchedule.schedule(data) {
+		select {
+		case c.writes <- chunk:
+		case <-c.closed:
+			return 0, net.ErrClosed
+		}
+	}
+
+	return len(b), nil
+}
+
+// Close closes the wrapped connection. Data that has been written but not yet delivered
+// is discarded.
+func (c *SimulatedConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		err = c.Conn.Close()
+	})
+
+	return err
+}
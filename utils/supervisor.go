@@ -0,0 +1,398 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/moodclient/telnet"
+)
+
+// SessionStarter creates the terminal for a supervised session, such as by calling telnet.Dial.
+// It is called again each time the session is restarted. The context is cancelled when the
+// session is removed or the supervisor shuts down.
+type SessionStarter func(ctx context.Context) (*telnet.Terminal, error)
+
+// RestartPolicy decides whether a Supervisor restarts a session after its terminal exits
+type RestartPolicy int
+
+const (
+	// RestartNever leaves the session stopped once its terminal exits
+	RestartNever RestartPolicy = iota
+	// RestartOnFailure restarts the session if its terminal exited with an error, or could not
+	// be started
+	RestartOnFailure
+	// RestartAlways restarts the session whenever its terminal exits
+	RestartAlways
+)
+
+func (p RestartPolicy) String() string {
+	switch p {
+	case RestartNever:
+		return "Never"
+	case RestartOnFailure:
+		return "OnFailure"
+	case RestartAlways:
+		return "Always"
+	default:
+		return fmt.Sprintf("RestartPolicy(%d)", int(p))
+	}
+}
+
+// SupervisorEventKind indicates what happened to a supervised session
+type SupervisorEventKind int
+
+const (
+	// SessionStarted indicates that the session's terminal was created
+	SessionStarted SupervisorEventKind = iota
+	// SessionStartFailed indicates that the session's SessionStarter returned an error
+	SessionStartFailed
+	// SessionError indicates that the session's terminal encountered an error
+	SessionError
+	// SessionExited indicates that the session's terminal has shut down. Err is the error
+	// returned by Terminal.WaitForExit.
+	SessionExited
+	// SessionRestarting indicates that the session will be restarted after Delay
+	SessionRestarting
+	// SessionStopped indicates that the session will not be restarted, because of its restart
+	// policy, because it reached SupervisorConfig.MaxRestarts, or because it was removed
+	SessionStopped
+)
+
+func (k SupervisorEventKind) String() string {
+	switch k {
+	case SessionStarted:
+		return "Started"
+	case SessionStartFailed:
+		return "StartFailed"
+	case SessionError:
+		return "Error"
+	case SessionExited:
+		return "Exited"
+	case SessionRestarting:
+		return "Restarting"
+	case SessionStopped:
+		return "Stopped"
+	default:
+		return fmt.Sprintf("SupervisorEventKind(%d)", int(k))
+	}
+}
+
+// SupervisorEvent is delivered to hooks registered with Supervisor.RegisterEventHook. The hook's
+// terminal is the session's current terminal, which is nil if it hasn't been started.
+type SupervisorEvent struct {
+	Session string
+	Kind    SupervisorEventKind
+	Err     error
+	// Delay is the time until the session restarts, for SessionRestarting events
+	Delay time.Duration
+}
+
+func (e SupervisorEvent) String() string {
+	switch {
+	case e.Kind == SessionRestarting:
+		return fmt.Sprintf("%s: %s in %s", e.Session, e.Kind, e.Delay)
+	case e.Err != nil:
+		return fmt.Sprintf("%s: %s: %s", e.Session, e.Kind, e.Err)
+	default:
+		return fmt.Sprintf("%s: %s", e.Session, e.Kind)
+	}
+}
+
+// SupervisorEventHandler is a hook that receives events from a Supervisor
+type SupervisorEventHandler func(terminal *telnet.Terminal, event SupervisorEvent)
+
+type SupervisorConfig struct {
+	// RestartDelay is the time to wait before the first restart of a session. It doubles with each
+	// consecutive restart, up to MaxRestartDelay. The default is one second.
+	RestartDelay time.Duration
+	// MaxRestartDelay is the longest time to wait before restarting a session. The default is
+	// one minute. A session that ran for longer than this goes back to waiting RestartDelay.
+	MaxRestartDelay time.Duration
+	// MaxRestarts is the number of consecutive restarts after which a session is stopped. If it
+	// is zero, sessions are restarted indefinitely.
+	MaxRestarts int
+	// CloseTimeout is passed to Terminal.CloseWithTimeout when sessions are removed or the
+	// supervisor shuts down. The default is telnet.DefaultCloseTimeout.
+	CloseTimeout time.Duration
+}
+
+// Supervisor owns many terminals, such as the sessions of a multi-session client or the
+// connections of a server. It starts each session, restarts it according to its RestartPolicy
+// when its terminal exits, and delivers the errors and lifecycle events of every session to a
+// single set of hooks.
+type Supervisor struct {
+	config     SupervisorConfig
+	ctx        context.Context
+	cancel     context.CancelFunc
+	eventHooks *telnet.EventPublisher[SupervisorEvent]
+
+	lock     sync.Mutex
+	sessions map[string]*supervisedSession
+	closed   bool
+	running  sync.WaitGroup
+}
+
+type supervisedSession struct {
+	name   string
+	start  SessionStarter
+	policy RestartPolicy
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	lock     sync.Mutex
+	terminal *telnet.Terminal
+}
+
+func (s *supervisedSession) currentTerminal() *telnet.Terminal {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.terminal
+}
+
+// NewSupervisor creates a supervisor. Its sessions are stopped when ctx is cancelled.
+func NewSupervisor(ctx context.Context, config SupervisorConfig) *Supervisor {
+	if config.RestartDelay <= 0 {
+		config.RestartDelay = time.Second
+	}
+
+	if config.MaxRestartDelay <= 0 {
+		config.MaxRestartDelay = time.Minute
+	}
+
+	if config.CloseTimeout <= 0 {
+		config.CloseTimeout = telnet.DefaultCloseTimeout
+	}
+
+	supervisorCtx, cancel := context.WithCancel(ctx)
+
+	return &Supervisor{
+		config:     config,
+		ctx:        supervisorCtx,
+		cancel:     cancel,
+		eventHooks: telnet.NewPublisher[SupervisorEvent, SupervisorEventHandler](nil),
+		sessions:   make(map[string]*supervisedSession),
+	}
+}
+
+// RegisterEventHook will register an event to be called when something happens to any of the
+// supervisor's sessions. Hooks are called from each session's own goroutine, so they may be
+// called concurrently.
+func (s *Supervisor) RegisterEventHook(hook SupervisorEventHandler) *telnet.Subscription {
+	return s.eventHooks.Register(telnet.EventHook[SupervisorEvent](hook))
+}
+
+// Add starts a new session with the provided name, which must be unique among the supervisor's
+// sessions
+func (s *Supervisor) Add(name string, start SessionStarter, policy RestartPolicy) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return errors.New("supervisor has shut down")
+	}
+
+	if _, exists := s.sessions[name]; exists {
+		return fmt.Errorf("supervisor already has a session named %s", name)
+	}
+
+	sessionCtx, cancel := context.WithCancel(s.ctx)
+	session := &supervisedSession{
+		name:   name,
+		start:  start,
+		policy: policy,
+		ctx:    sessionCtx,
+		cancel: cancel,
+	}
+	s.sessions[name] = session
+
+	s.running.Add(1)
+	go s.run(session)
+
+	return nil
+}
+
+// Watch supervises a terminal that was created elsewhere, such as by a telnet.Server's handler.
+// Its events are delivered like any other session's, but it is never restarted.
+func (s *Supervisor) Watch(name string, terminal *telnet.Terminal) error {
+	started := false
+	return s.Add(name, func(ctx context.Context) (*telnet.Terminal, error) {
+		if started {
+			return nil, errors.New("watched terminals can't be restarted")
+		}
+
+		started = true
+		return terminal, nil
+	}, RestartNever)
+}
+
+// Remove closes the session with the provided name and stops supervising it. It returns false if
+// there is no such session.
+func (s *Supervisor) Remove(name string) bool {
+	s.lock.Lock()
+	session, exists := s.sessions[name]
+	delete(s.sessions, name)
+	s.lock.Unlock()
+
+	if !exists {
+		return false
+	}
+
+	s.stopSession(session)
+	return true
+}
+
+// Terminal returns the current terminal of the session with the provided name. It returns nil if
+// there is no such session, or if its terminal hasn't been started.
+func (s *Supervisor) Terminal(name string) *telnet.Terminal {
+	s.lock.Lock()
+	session, exists := s.sessions[name]
+	s.lock.Unlock()
+
+	if !exists {
+		return nil
+	}
+
+	return session.currentTerminal()
+}
+
+// Sessions returns the names of the supervisor's sessions. Sessions that have stopped are
+// included until they are removed.
+func (s *Supervisor) Sessions() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	names := make([]string, 0, len(s.sessions))
+	for name := range s.sessions {
+		names = append(names, name)
+	}
+
+	return names
+}
+
+// Shutdown closes every session and waits for them to stop, or for ctx to be done, in which
+// case ctx's error is returned. No sessions can be added afterwards.
+func (s *Supervisor) Shutdown(ctx context.Context) error {
+	s.lock.Lock()
+	s.closed = true
+	sessions := make([]*supervisedSession, 0, len(s.sessions))
+	for name, session := range s.sessions {
+		sessions = append(sessions, session)
+		delete(s.sessions, name)
+	}
+	s.lock.Unlock()
+
+	var closing sync.WaitGroup
+	for _, session := range sessions {
+		closing.Add(1)
+		go func() {
+			defer closing.Done()
+			s.stopSession(session)
+		}()
+	}
+	closing.Wait()
+	s.cancel()
+
+	stopped := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Supervisor) stopSession(session *supervisedSession) {
+	session.cancel()
+
+	terminal := session.currentTerminal()
+	if terminal != nil {
+		_ = terminal.CloseWithTimeout(s.config.CloseTimeout)
+	}
+}
+
+func (s *Supervisor) fire(session *supervisedSession, event SupervisorEvent) {
+	event.Session = session.name
+	s.eventHooks.Fire(session.currentTerminal(), event)
+}
+
+func (s *Supervisor) run(session *supervisedSession) {
+	defer s.running.Done()
+
+	restarts := 0
+	delay := s.config.RestartDelay
+
+	for {
+		failed, ranFor := s.runOnce(session)
+
+		if session.ctx.Err() != nil {
+			s.fire(session, SupervisorEvent{Kind: SessionStopped})
+			return
+		}
+
+		if ranFor > s.config.MaxRestartDelay {
+			restarts = 0
+			delay = s.config.RestartDelay
+		}
+
+		restart := session.policy == RestartAlways || (session.policy == RestartOnFailure && failed)
+		if !restart || (s.config.MaxRestarts > 0 && restarts >= s.config.MaxRestarts) {
+			s.fire(session, SupervisorEvent{Kind: SessionStopped})
+			return
+		}
+
+		restarts++
+		s.fire(session, SupervisorEvent{Kind: SessionRestarting, Delay: delay})
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-session.ctx.Done():
+			timer.Stop()
+			s.fire(session, SupervisorEvent{Kind: SessionStopped})
+			return
+		}
+
+		delay = min(2*delay, s.config.MaxRestartDelay)
+	}
+}
+
+// runOnce starts the session's terminal and waits for it to exit. It returns whether the
+// terminal failed, and how long it ran.
+func (s *Supervisor) runOnce(session *supervisedSession) (bool, time.Duration) {
+	terminal, err := session.start(session.ctx)
+	if err != nil {
+		s.fire(session, SupervisorEvent{Kind: SessionStartFailed, Err: err})
+		return true, 0
+	}
+
+	started := time.Now()
+	session.lock.Lock()
+	session.terminal = terminal
+	session.lock.Unlock()
+
+	// The session may have been removed while the terminal was starting
+	if session.ctx.Err() != nil {
+		_ = terminal.CloseWithTimeout(s.config.CloseTimeout)
+	}
+
+	s.fire(session, SupervisorEvent{Kind: SessionStarted})
+
+	subscription := terminal.RegisterEncounteredErrorHook(func(_ *telnet.Terminal, err error) {
+		s.fire(session, SupervisorEvent{Kind: SessionError, Err: err})
+	})
+	err = terminal.WaitForExit()
+	subscription.Unregister()
+
+	s.fire(session, SupervisorEvent{Kind: SessionExited, Err: err})
+
+	return err != nil, time.Since(started)
+}
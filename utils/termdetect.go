@@ -0,0 +1,199 @@
+package utils
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/moodclient/telnet/telopts"
+)
+
+// LocalTerminal describes the capabilities of the terminal that the local process
+// is running in, for the purpose of reporting them to the remote via TTYPE.
+type LocalTerminal struct {
+	ClientName   string
+	TerminalType string
+	Capabilities telopts.MTTSFlags
+}
+
+// TTYPEList produces a list of terminals suitable for passing to telopts.RegisterTTYPE.
+// It follows the MTTS cycle: the client name, then the terminal type, then the MTTS
+// bitfield.  If the client name is empty, it will be omitted.
+func (t LocalTerminal) TTYPEList() []string {
+	var terminals []string
+
+	if t.ClientName != "" {
+		terminals = append(terminals, strings.ToUpper(t.ClientName))
+	}
+
+	terminals = append(terminals, t.TerminalType)
+	terminals = append(terminals, t.Capabilities.TerminalType())
+
+	return terminals
+}
+
+// DetectLocalTerminal inspects the current process's environment ($TERM, $COLORTERM, locale
+// variables) and the terminfo database to determine the capabilities of the local terminal.
+func DetectLocalTerminal(clientName string) LocalTerminal {
+	return DetectLocalTerminalFromEnv(clientName, os.Environ())
+}
+
+// DetectLocalTerminalFromEnv works like DetectLocalTerminal, but inspects the provided
+// environment, a slice of "KEY=value" strings, instead of the current process's environment.
+func DetectLocalTerminalFromEnv(clientName string, environ []string) LocalTerminal {
+	env := make(map[string]string)
+	for _, keyValue := range environ {
+		key, value, _ := strings.Cut(keyValue, "=")
+		env[key] = value
+	}
+
+	terminal := LocalTerminal{
+		ClientName: clientName,
+	}
+
+	// Terminfo entries are named case-sensitively, so only the matching below is done in lowercase
+	terminfoName := env["TERM"]
+	term := strings.ToLower(terminfoName)
+	colorTerm := strings.ToLower(env["COLORTERM"])
+
+	switch {
+	case term == "" && env["WT_SESSION"] != "":
+		// Windows Terminal does not set TERM
+		term = "xterm-256color"
+		terminfoName = term
+	case term == "":
+		terminal.TerminalType = "UNKNOWN"
+	}
+
+	if term != "" {
+		terminal.TerminalType = strings.ToUpper(strings.SplitN(term, "-", 2)[0])
+	}
+
+	switch {
+	case term == "dumb" || term == "":
+	case strings.HasPrefix(term, "vt100") || strings.HasPrefix(term, "vt220"):
+		terminal.Capabilities |= telopts.MTTSVT100
+	case strings.HasPrefix(term, "xterm") || strings.HasPrefix(term, "rxvt") ||
+		strings.HasPrefix(term, "alacritty") || strings.HasPrefix(term, "kitty") ||
+		strings.HasPrefix(term, "foot") || strings.HasPrefix(term, "wezterm"):
+		terminal.Capabilities |= telopts.MTTSANSI | telopts.MTTSVT100 |
+			telopts.MTTSMouseTracking | telopts.MTTSOSCColorPalette
+	case strings.HasPrefix(term, "screen") || strings.HasPrefix(term, "tmux"):
+		terminal.Capabilities |= telopts.MTTSANSI | telopts.MTTSVT100 | telopts.MTTSMouseTracking
+	default:
+		terminal.Capabilities |= telopts.MTTSANSI
+	}
+
+	if term != "" && term != "dumb" {
+		colors := terminfoColors(terminfoName, env)
+		if colors >= 256 || strings.HasSuffix(term, "256color") {
+			terminal.Capabilities |= telopts.MTTS256Colors
+		}
+	}
+
+	if colorTerm == "truecolor" || colorTerm == "24bit" {
+		terminal.Capabilities |= telopts.MTTS256Colors | telopts.MTTSTrueColor
+	}
+
+	if isUTF8Locale(env) {
+		terminal.Capabilities |= telopts.MTTSUTF8
+	}
+
+	return terminal
+}
+
+func isUTF8Locale(env map[string]string) bool {
+	for _, key := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		value, hasValue := env[key]
+		if !hasValue || value == "" {
+			continue
+		}
+
+		value = strings.ToLower(value)
+		return strings.Contains(value, "utf-8") || strings.Contains(value, "utf8")
+	}
+
+	// Windows Terminal is always UTF-8
+	return env["WT_SESSION"] != ""
+}
+
+func terminfoDirs(env map[string]string) []string {
+	var dirs []string
+
+	if dir := env["TERMINFO"]; dir != "" {
+		dirs = append(dirs, dir)
+	}
+
+	if home := env["HOME"]; home != "" {
+		dirs = append(dirs, filepath.Join(home, ".terminfo"))
+	}
+
+	if dirList := env["TERMINFO_DIRS"]; dirList != "" {
+		dirs = append(dirs, filepath.SplitList(dirList)...)
+	}
+
+	return append(dirs, "/etc/terminfo", "/lib/terminfo", "/usr/share/terminfo")
+}
+
+// terminfoColors finds the compiled terminfo entry for the provided terminal and returns
+// the value of its "colors" numeric capability, or 0 if it could not be found
+func terminfoColors(term string, env map[string]string) int {
+	for _, dir := range terminfoDirs(env) {
+		// Most systems store entries under their first letter, but macOS uses its hex value
+		for _, subDir := range []string{term[:1], strconv.FormatInt(int64(term[0]), 16)} {
+			data, err := os.ReadFile(filepath.Join(dir, subDir, term))
+			if err == nil {
+				return parseTerminfoColors(data)
+			}
+		}
+	}
+
+	return 0
+}
+
+const (
+	terminfoMagic         = 0432
+	terminfoExtendedMagic = 01036
+	terminfoColorsIndex   = 13
+)
+
+func parseTerminfoColors(data []byte) int {
+	if len(data) < 12 {
+		return 0
+	}
+
+	magic := binary.LittleEndian.Uint16(data[0:])
+	numberSize := 2
+	if magic == terminfoExtendedMagic {
+		numberSize = 4
+	} else if magic != terminfoMagic {
+		return 0
+	}
+
+	namesSize := int(binary.LittleEndian.Uint16(data[2:]))
+	boolCount := int(binary.LittleEndian.Uint16(data[4:]))
+	numCount := int(binary.LittleEndian.Uint16(data[6:]))
+
+	if numCount <= terminfoColorsIndex {
+		return 0
+	}
+
+	offset := 12 + namesSize + boolCount
+	// Numbers are aligned on an even byte boundary
+	if offset%2 != 0 {
+		offset++
+	}
+
+	offset += terminfoColorsIndex * numberSize
+	if offset+numberSize > len(data) {
+		return 0
+	}
+
+	if numberSize == 4 {
+		return int(int32(binary.LittleEndian.Uint32(data[offset:])))
+	}
+
+	return int(int16(binary.LittleEndian.Uint16(data[offset:])))
+}
@@ -0,0 +1,16 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly || windows)
+
+package utils
+
+import (
+	"context"
+	"errors"
+)
+
+func terminalSize(fd uintptr) (width, height int, err error) {
+	return 0, 0, errors.ErrUnsupported
+}
+
+func watchTerminalResize(ctx context.Context, onResize func()) {
+	<-ctx.Done()
+}
@@ -0,0 +1,60 @@
+//go:build windows
+
+package utils
+
+import (
+	"context"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// resizePollInterval is how often the console size is checked, since console resize events
+// are only delivered to programs reading console input
+const resizePollInterval = 500 * time.Millisecond
+
+var procGetConsoleScreenBufferInfo = syscall.NewLazyDLL("kernel32.dll").NewProc("GetConsoleScreenBufferInfo")
+
+type consoleCoord struct {
+	X int16
+	Y int16
+}
+
+type consoleRect struct {
+	Left   int16
+	Top    int16
+	Right  int16
+	Bottom int16
+}
+
+type consoleScreenBufferInfo struct {
+	Size              consoleCoord
+	CursorPosition    consoleCoord
+	Attributes        uint16
+	Window            consoleRect
+	MaximumWindowSize consoleCoord
+}
+
+func terminalSize(fd uintptr) (width, height int, err error) {
+	var info consoleScreenBufferInfo
+	result, _, err := procGetConsoleScreenBufferInfo.Call(fd, uintptr(unsafe.Pointer(&info)))
+	if result == 0 {
+		return 0, 0, err
+	}
+
+	return int(info.Window.Right-info.Window.Left) + 1, int(info.Window.Bottom-info.Window.Top) + 1, nil
+}
+
+func watchTerminalResize(ctx context.Context, onResize func()) {
+	ticker := time.NewTicker(resizePollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			onResize()
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/x/ansi"
+	"github.com/moodclient/telnet"
+)
+
+// maxTriggerLineLength is the longest line that TriggerRunner will match triggers against, in
+// bytes. Additional text is discarded until the line ends.
+const maxTriggerLineLength = 4096
+
+type compiledTrigger struct {
+	pattern  *regexp.Regexp
+	response string
+}
+
+// TriggerRunner carries out the triggers of a Profile. Each trigger's Pattern is a regular
+// expression that is matched against every line of text received from the remote, without its
+// line ending, and against the text before each prompt hint. When a line matches, the trigger's
+// Response is sent to the remote as a line of its own. Disabled triggers are ignored.
+type TriggerRunner struct {
+	terminal     *telnet.Terminal
+	subscription *telnet.Subscription
+
+	lock     sync.Mutex
+	triggers []compiledTrigger
+	line     strings.Builder
+}
+
+// NewTriggerRunner creates a TriggerRunner and registers it to receive the terminal's printer
+// output. It has no triggers until a profile is provided with SetProfile.
+func NewTriggerRunner(terminal *telnet.Terminal) *TriggerRunner {
+	runner := &TriggerRunner{
+		terminal: terminal,
+	}
+
+	runner.subscription = terminal.RegisterPrinterOutputHook(runner.PrinterOutput)
+
+	return runner
+}
+
+// SetProfile replaces the runner's triggers with those of the provided profile. A nil profile
+// removes all triggers. If any enabled trigger's pattern is not a valid regular expression, an
+// error is returned and the runner's triggers are left unchanged.
+func (r *TriggerRunner) SetProfile(profile *Profile) error {
+	var triggers []compiledTrigger
+	if profile != nil {
+		for _, trigger := range profile.Triggers {
+			if trigger.Disabled {
+				continue
+			}
+
+			pattern, err := regexp.Compile(trigger.Pattern)
+			if err != nil {
+				return fmt.Errorf("trigger %s: %w", trigger.Name, err)
+			}
+
+			triggers = append(triggers, compiledTrigger{
+				pattern:  pattern,
+				response: trigger.Response,
+			})
+		}
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.triggers = triggers
+	return nil
+}
+
+// Stop unregisters the runner from the terminal. The line being assembled is discarded.
+func (r *TriggerRunner) Stop() {
+	r.subscription.Unregister()
+}
+
+func (r *TriggerRunner) endLine(t *telnet.Terminal) {
+	line := r.line.String()
+	r.line.Reset()
+
+	for _, trigger := range r.triggers {
+		if trigger.pattern.MatchString(line) {
+			t.Keyboard().WriteString(trigger.response + "\r\n")
+		}
+	}
+}
+
+// PrinterOutput receives printer output from the terminal. It is registered automatically by
+// NewTriggerRunner.
+func (r *TriggerRunner) PrinterOutput(t *telnet.Terminal, data telnet.TerminalData) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	switch d := data.(type) {
+	case telnet.TextData:
+		if r.line.Len() < maxTriggerLineLength {
+			r.line.WriteString(string(d)[:min(len(d), maxTriggerLineLength-r.line.Len())])
+		}
+	case telnet.ControlCodeData:
+		if ansi.ControlCode(d) == ansi.LF {
+			r.endLine(t)
+		}
+	case telnet.PromptData:
+		r.endLine(t)
+	}
+}